@@ -2,8 +2,9 @@ package gobio
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os"
 )
 
@@ -49,18 +50,42 @@ type FormatError struct {
 }
 
 type File struct {
-	file *os.File
+	reader io.ReaderAt
+	size   int64
+	// closer is set when the File owns the underlying reader (e.g. when opened with Open)
+	closer io.Closer
 
 	header  ImageFileHeader
 	IFDList []*ImageFileDirectory
+
+	strict         bool
+	warningHandler WarningHandler
+	diagnostics    *diagnosticLog
+}
+
+// OpenOptions control how a tiff file is parsed.
+type OpenOptions struct {
+	// Strict causes files which violate the TIFF specification to be rejected. Otherwise the parser recovers where it
+	// can (e.g. by skipping unreadable tags). Either way the problems are listed by File.Diagnostics. IFDs whose image
+	// data is valid but not supported by this library are kept in either mode, and reading their data returns an error.
+	Strict bool
+
+	// WarningHandler receives each problem as it is found. If nil, DefaultWarningHandler is used.
+	WarningHandler WarningHandler
 }
 
+// Close closes the underlying file if it was opened by this package. Readers passed to OpenReader are left open.
 func (tiffFile *File) Close() {
-	if tiffFile != nil && tiffFile.file != nil {
-		tiffFile.file.Close()
+	if tiffFile != nil && tiffFile.closer != nil {
+		tiffFile.closer.Close()
 	}
 }
 
+// Size returns the size in bytes of the underlying data.
+func (tiffFile *File) Size() int64 {
+	return tiffFile.size
+}
+
 type TagAccess interface {
 	GetTag(tagID TagID) Tag
 
@@ -83,27 +108,74 @@ type ImageFileDirectory struct {
 	Tags          map[TagID]Tag
 	NextIFDOffset int64
 
+	// SubIFDList holds the child image file directories referenced by the SubIFDs tag
+	SubIFDList []*ImageFileDirectory
+
 	tiffFile   *File
 	dataAccess DataAccess
-
-	getStripOffsets func(*ImageFileDirectory) ([]int64, []int64, error)
-	getTileOffsets  func(*ImageFileDirectory) ([]int64, []int64, error)
+	// index is the position of the IFD in the main chain (or that of the parent for SubIFDs)
+	index int
 
 	PixelSizeXUm float64
 	PixelSizeYUm float64
 }
 
+// Open opens the tiff file at the specified location. The file should be closed with Close once finished with.
 func Open(location string) (*File, error) {
-	var err error
-	var tiffFile File
+	return OpenWithOptions(location, OpenOptions{})
+}
 
-	tiffFile.file, err = os.Open(location)
+// OpenWithOptions opens the tiff file at the specified location, parsing it as described by options. The file should
+// be closed with Close once finished with.
+func OpenWithOptions(location string, options OpenOptions) (*File, error) {
+	file, err := os.Open(location)
 	if err != nil {
 		return nil, err
 	}
 
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	tiffFile, err := OpenReaderWithOptions(file, info.Size(), options)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	tiffFile.closer = file
+
+	return tiffFile, nil
+}
+
+// OpenReader parses the tiff data available through r, which is size bytes long. This allows in-memory buffers
+// (e.g. bytes.Reader) or other storage to be used in place of a file on disk. The reader must remain valid
+// for as long as the File is in use.
+func OpenReader(r io.ReaderAt, size int64) (*File, error) {
+	return OpenReaderWithOptions(r, size, OpenOptions{})
+}
+
+// OpenReaderWithOptions parses the tiff data available through r, which is size bytes long, as described by options.
+func OpenReaderWithOptions(r io.ReaderAt, size int64, options OpenOptions) (*File, error) {
+	var err error
+	var tiffFile File
+
+	tiffFile.reader = r
+	tiffFile.size = size
+
+	tiffFile.strict = options.Strict
+	tiffFile.warningHandler = options.WarningHandler
+	if tiffFile.warningHandler == nil {
+		tiffFile.warningHandler = DefaultWarningHandler
+	}
+	tiffFile.diagnostics = &diagnosticLog{}
+
+	// The header and IFDs are parsed sequentially, all subsequent data access uses ReadAt
+	seeker := io.NewSectionReader(r, 0, size)
+
 	var header ImageFileHeader
-	err = binary.Read(tiffFile.file, binary.LittleEndian, &header.Identifier)
+	err = binary.Read(seeker, binary.LittleEndian, &header.Identifier)
 	if err != nil {
 		return nil, err
 	}
@@ -120,21 +192,23 @@ func Open(location string) (*File, error) {
 		return nil, &FormatError{msg: "Invalid endian specified"}
 	}
 
-	err = binary.Read(tiffFile.file, header.Endian, &header.Version)
+	err = binary.Read(seeker, header.Endian, &header.Version)
 	if err != nil {
 		return nil, err
 	}
 
+	header.File = &tiffFile
+	tiffFile.header = header
+
 	//formats, _ := atomicFormats.Load().(map[uint16]tiffVersion)
 
 	//if version, ok := formats[header.Version]; ok {
-	var ifd *ImageFileDirectory
 	var offset int64
 
 	if header.Version == VersionMarker {
-		offset, err = readIFDOffset(tiffFile.file, header.Endian)
+		offset, err = readIFDOffset(seeker, header.Endian)
 	} else if header.Version == BigTiffMarker {
-		offset, err = readBigIFDOffset(tiffFile.file, header.Endian)
+		offset, err = readBigIFDOffset(seeker, header.Endian)
 	} else {
 		return nil, &FormatError{msg: fmt.Sprintf("Unsupported tiff version: %X", header.Version)}
 	}
@@ -142,26 +216,12 @@ func Open(location string) (*File, error) {
 		return nil, err
 	}
 
-	for offset != 0 {
-		if header.Version == VersionMarker {
-			ifd, err = readIFD(tiffFile.file, header.Endian, offset)
-		} else if header.Version == BigTiffMarker {
-			ifd, err = readBigIFD(tiffFile.file, header.Endian, offset)
-		}
-
-		if err != nil {
-			return nil, err
-		}
-
-		ifd.tiffFile = &tiffFile
-		err = ifd.setUpDataAccess()
-		if err != nil {
-			return nil, err
-		}
-
-		tiffFile.IFDList = append(tiffFile.IFDList, ifd)
-
-		offset = ifd.NextIFDOffset
+	tiffFile.IFDList, err = tiffFile.readIFDChain(seeker, offset, -1, make(map[int64]bool))
+	if err != nil {
+		return nil, err
+	}
+	if len(tiffFile.IFDList) == 0 {
+		return nil, &FormatError{msg: "No readable IFDs found"}
 	}
 	//}
 
@@ -184,6 +244,85 @@ func Open(location string) (*File, error) {
 	return &tiffFile, nil
 }
 
+// readIFDChain reads the image file directory at offset and all those which follow it (via NextIFDOffset), along with
+// any SubIFDs. parent is the index of the IFD which the chain belongs to, or -1 for the main chain. visited records
+// the offsets already read so that loops in the file are not followed.
+func (tiffFile *File) readIFDChain(seeker io.ReadSeeker, offset int64, parent int, visited map[int64]bool) ([]*ImageFileDirectory, error) {
+	var ifdList []*ImageFileDirectory
+	var ifd *ImageFileDirectory
+	var err error
+
+	for position := 0; offset != 0; position++ {
+		// IFDs in the main chain are identified by their position, SubIFDs by the position of their parent
+		index := parent
+		if parent < 0 {
+			index = position
+		}
+
+		if visited[offset] {
+			err = tiffFile.violation(index, 0, offset, ErrIFDLoop)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		visited[offset] = true
+
+		if tiffFile.header.Version == BigTiffMarker {
+			ifd, err = readBigIFD(tiffFile, seeker, index, offset)
+		} else {
+			ifd, err = readIFD(tiffFile, seeker, index, offset)
+		}
+		if err != nil {
+			// Without the IFD there is no way to find the next one, so stop here
+			err = tiffFile.violation(index, 0, offset, err)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+
+		for _, subIFDOffset := range ifd.getSubIFDOffsets() {
+			subIFDs, err := tiffFile.readIFDChain(seeker, subIFDOffset, index, visited)
+			if err != nil {
+				return nil, err
+			}
+
+			ifd.SubIFDList = append(ifd.SubIFDList, subIFDs...)
+		}
+
+		err = ifd.setUpDataAccess()
+		if err != nil {
+			// Violations of the specification have already been reported, so in strict mode they end the read
+			var parseError *ParseError
+			if errors.As(err, &parseError) {
+				return nil, err
+			}
+
+			// A tag required to locate the image data is missing or invalid, which violates the specification.
+			// Otherwise the image data can't be used by this library (e.g. unsupported compression), which is only a
+			// warning.
+			if errors.Is(err, ErrMissingTag) || errors.Is(err, ErrInvalidTagValue) {
+				violationErr := tiffFile.violation(index, 0, offset, err)
+				if violationErr != nil {
+					return nil, violationErr
+				}
+			} else {
+				tiffFile.warn(index, 0, offset, err)
+			}
+
+			// The IFD is kept so that the positions of those which follow are unchanged, but reading its image data
+			// will fail
+			ifd.dataAccess = &unavailableDataAccess{err: err}
+		}
+		ifdList = append(ifdList, ifd)
+
+		offset = ifd.NextIFDOffset
+	}
+
+	return ifdList, nil
+}
+
 func (file File) GetIFDList() []*ImageFileDirectory {
 	return file.IFDList
 }
@@ -192,35 +331,47 @@ func (file File) GetIFD(index int) *ImageFileDirectory {
 	return file.IFDList[index]
 }
 
-func (file File) NumReducedImages() int {
-	numRes := 1
+// reducedImages returns the first image followed by all of the reduced resolution images of it, whether they are
+// stored in the main IFD chain or as SubIFDs.
+func (file File) reducedImages() []*ImageFileDirectory {
+	if len(file.IFDList) == 0 {
+		return nil
+	}
+
+	var images []*ImageFileDirectory
 
-	for i := 1; i < len(file.IFDList); i++ {
-		if file.IFDList[i].IsReducedResolutionImage() {
-			numRes++
+	for i, ifd := range file.IFDList {
+		if i > 0 && !ifd.IsReducedResolutionImage() {
+			continue
+		}
+
+		images = append(images, ifd)
+
+		for _, subIFD := range ifd.SubIFDList {
+			if subIFD.IsReducedResolutionImage() {
+				images = append(images, subIFD)
+			}
 		}
 	}
 
-	return numRes
+	return images
 }
 
-func (file File) GetReducedImage(index int) *ImageFileDirectory {
-	if index == 0 {
-		return file.IFDList[0]
-	}
+// NumReducedImages returns the number of resolutions available, including the full resolution image.
+func (file File) NumReducedImages() int {
+	return len(file.reducedImages())
+}
 
-	curIndex := 0
-	for i := 1; i < len(file.IFDList); i++ {
-		if file.IFDList[i].IsReducedResolutionImage() {
-			curIndex++
-		}
+// GetReducedImage returns the image at the specified resolution, where 0 is the full resolution image. Reduced
+// resolution images may be stored either in the main IFD chain or as SubIFDs.
+func (file File) GetReducedImage(index int) *ImageFileDirectory {
+	images := file.reducedImages()
 
-		if curIndex == index {
-			return file.IFDList[i]
-		}
+	if index < 0 || index >= len(images) {
+		return nil
 	}
 
-	return nil
+	return images[index]
 }
 
 // func Open(path string) (File, error) {
@@ -268,8 +419,19 @@ func (file File) GetReducedImage(index int) *ImageFileDirectory {
 func (ifd *ImageFileDirectory) setUpDataAccess() error {
 	var err error
 
+	// The number and size of the sections depend on the dimensions of the image
+	err = ifd.checkDimensions(ImageWidth, ImageLength)
+	if err != nil {
+		return err
+	}
+
 	// Check if the data is tiled or stripped
 	if ifd.Tags[TileWidth] != nil {
+		err = ifd.checkDimensions(TileWidth, TileLength)
+		if err != nil {
+			return err
+		}
+
 		var dataAccess TileDataAccess
 		ifd.dataAccess = &dataAccess
 
@@ -283,14 +445,20 @@ func (ifd *ImageFileDirectory) setUpDataAccess() error {
 
 		dataAccess.tilesAcross = (dataAccess.imageWidth + (dataAccess.tileWidth - 1)) / dataAccess.tileWidth
 		dataAccess.tilesDown = (dataAccess.imageLength + (dataAccess.tileLength - 1)) / dataAccess.tileLength
+		dataAccess.sectionsPerPlane = dataAccess.tilesAcross * dataAccess.tilesDown
 
-		dataAccess.offsets, dataAccess.byteCounts, err = ifd.getTileOffsets(ifd)
+		dataAccess.offsets, dataAccess.byteCounts, err = ifd.getTileOffsets()
+		if err != nil {
+			return err
+		}
+
+		dataAccess.offsets, dataAccess.byteCounts, err = ifd.checkSections(TileOffsets, dataAccess.offsets, dataAccess.byteCounts, dataAccess.sectionsPerPlane*uint32(dataAccess.planes()))
 		if err != nil {
 			return err
 		}
 
 		return nil
-	} else if ifd.Tags[RowsPerStrip] != nil {
+	} else if ifd.Tags[RowsPerStrip] != nil || ifd.Tags[StripOffsets] != nil {
 		var dataAccess StripDataAccess
 		ifd.dataAccess = &dataAccess
 
@@ -299,10 +467,19 @@ func (ifd *ImageFileDirectory) setUpDataAccess() error {
 			return err
 		}
 
+		if rowsPerStripTag := ifd.Tags[RowsPerStrip]; rowsPerStripTag != nil && rowsPerStripTag.NumItems() == 0 {
+			// Treat as if RowsPerStrip were missing
+			err = ifd.violation(RowsPerStrip, -1, fmt.Errorf("%w: RowsPerStrip has no values", ErrInvalidTagValue))
+			if err != nil {
+				return err
+			}
+		}
+
 		dataAccess.rowsPerStrip = ifd.GetLongTagValue(RowsPerStrip)
 
-		// In exports from ImageJ it seems like RowsPerStrip is set to 0? In this case, there is a single strip
-		if dataAccess.rowsPerStrip == 0 {
+		// In exports from ImageJ it seems like RowsPerStrip is set to 0? In this case, there is a single strip. This is
+		// also the case when RowsPerStrip is not present, or larger than the image.
+		if dataAccess.rowsPerStrip == 0 || dataAccess.rowsPerStrip > dataAccess.imageLength {
 			dataAccess.rowsPerStrip = dataAccess.imageLength
 		}
 
@@ -312,16 +489,76 @@ func (ifd *ImageFileDirectory) setUpDataAccess() error {
 		if (dataAccess.stripsInImage * dataAccess.rowsPerStrip) < dataAccess.imageLength {
 			dataAccess.stripsInImage++
 		}
+		dataAccess.sectionsPerPlane = dataAccess.stripsInImage
 
-		dataAccess.offsets, dataAccess.byteCounts, err = ifd.getStripOffsets(ifd)
+		dataAccess.offsets, dataAccess.byteCounts, err = ifd.getStripOffsets()
+		if err != nil {
+			return err
+		}
+
+		dataAccess.offsets, dataAccess.byteCounts, err = ifd.checkSections(StripOffsets, dataAccess.offsets, dataAccess.byteCounts, dataAccess.sectionsPerPlane*uint32(dataAccess.planes()))
 		if err != nil {
 			return err
 		}
 
 		return nil
 	} else {
-		return &FormatError{msg: "RowsPerStrip and TileWidth metadata not present, so not sure how the data is stored"}
+		return fmt.Errorf("%w: RowsPerStrip and TileWidth metadata not present, so not sure how the data is stored", ErrMissingTag)
+	}
+}
+
+// checkDimensions checks that each of the tags is present and holds a size which is not 0.
+func (ifd *ImageFileDirectory) checkDimensions(tagIDs ...TagID) error {
+	for _, tagID := range tagIDs {
+		tag := ifd.Tags[tagID]
+		if tag == nil {
+			return fmt.Errorf("%w: %s", ErrMissingTag, tagID)
+		}
+		if tag.NumItems() == 0 {
+			return fmt.Errorf("%w: %s has no values", ErrInvalidTagValue, tagID)
+		}
+		if ifd.GetLongTagValue(tagID) == 0 {
+			return fmt.Errorf("%w: %s is 0", ErrInvalidTagValue, tagID)
+		}
 	}
+
+	return nil
+}
+
+// checkSections validates the offsets and byte counts of the sections (strips or tiles) of the image, of which there
+// should be at least expected, returning those which can be used.
+func (ifd *ImageFileDirectory) checkSections(offsetsTag TagID, offsets, byteCounts []int64, expected uint32) ([]int64, []int64, error) {
+	if len(offsets) != len(byteCounts) {
+		err := ifd.violation(offsetsTag, -1, fmt.Errorf("%w: %d offsets but %d byte counts", ErrSectionCount, len(offsets), len(byteCounts)))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(offsets) > len(byteCounts) {
+			offsets = offsets[:len(byteCounts)]
+		} else {
+			byteCounts = byteCounts[:len(offsets)]
+		}
+	}
+
+	if uint32(len(offsets)) < expected {
+		err := ifd.violation(offsetsTag, -1, fmt.Errorf("%w: expected %d sections but found %d", ErrSectionCount, expected, len(offsets)))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Sections beyond the end of the file are kept, reading them will return an error
+	for index := range offsets {
+		if offsets[index] < 0 || byteCounts[index] < 0 || offsets[index]+byteCounts[index] > ifd.tiffFile.size {
+			err := ifd.violation(offsetsTag, offsets[index], fmt.Errorf("%w: section %d (%d bytes)", ErrTruncated, index, byteCounts[index]))
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	return offsets, byteCounts, nil
 }
 
 func (ifd *ImageFileDirectory) PutTag(tag Tag) {
@@ -360,6 +597,71 @@ func (ifd *ImageFileDirectory) GetLongTag(tagID TagID) (*LongTag, bool) {
 	return tag, ok
 }
 
+func (ifd *ImageFileDirectory) GetASCIITag(tagID TagID) (*ASCIITag, bool) {
+	tag, ok := ifd.Tags[tagID].(*ASCIITag)
+	return tag, ok
+}
+
+func (ifd *ImageFileDirectory) GetShortTag(tagID TagID) (*ShortTag, bool) {
+	tag, ok := ifd.Tags[tagID].(*ShortTag)
+	return tag, ok
+}
+
+func (ifd *ImageFileDirectory) GetRationalTag(tagID TagID) (*RationalTag, bool) {
+	tag, ok := ifd.Tags[tagID].(*RationalTag)
+	return tag, ok
+}
+
+func (ifd *ImageFileDirectory) GetSByteTag(tagID TagID) (*SByteTag, bool) {
+	tag, ok := ifd.Tags[tagID].(*SByteTag)
+	return tag, ok
+}
+
+func (ifd *ImageFileDirectory) GetSShortTag(tagID TagID) (*SShortTag, bool) {
+	tag, ok := ifd.Tags[tagID].(*SShortTag)
+	return tag, ok
+}
+
+func (ifd *ImageFileDirectory) GetSLongTag(tagID TagID) (*SLongTag, bool) {
+	tag, ok := ifd.Tags[tagID].(*SLongTag)
+	return tag, ok
+}
+
+func (ifd *ImageFileDirectory) GetSRationalTag(tagID TagID) (*SRationalTag, bool) {
+	tag, ok := ifd.Tags[tagID].(*SRationalTag)
+	return tag, ok
+}
+
+func (ifd *ImageFileDirectory) GetFloatTag(tagID TagID) (*FloatTag, bool) {
+	tag, ok := ifd.Tags[tagID].(*FloatTag)
+	return tag, ok
+}
+
+func (ifd *ImageFileDirectory) GetDoubleTag(tagID TagID) (*DoubleTag, bool) {
+	tag, ok := ifd.Tags[tagID].(*DoubleTag)
+	return tag, ok
+}
+
+func (ifd *ImageFileDirectory) GetIFDTag(tagID TagID) (*IFDTag, bool) {
+	tag, ok := ifd.Tags[tagID].(*IFDTag)
+	return tag, ok
+}
+
+func (ifd *ImageFileDirectory) GetLong8Tag(tagID TagID) (*Long8Tag, bool) {
+	tag, ok := ifd.Tags[tagID].(*Long8Tag)
+	return tag, ok
+}
+
+func (ifd *ImageFileDirectory) GetSLong8Tag(tagID TagID) (*SLong8Tag, bool) {
+	tag, ok := ifd.Tags[tagID].(*SLong8Tag)
+	return tag, ok
+}
+
+func (ifd *ImageFileDirectory) GetIFD8Tag(tagID TagID) (*IFD8Tag, bool) {
+	tag, ok := ifd.Tags[tagID].(*IFD8Tag)
+	return tag, ok
+}
+
 // TODO: REMOVE ERROR FROM THIS FUNCTION
 // Create structure with main tags so that don't need to pass back error on each call - can be done when parsing tags for first time
 func (ifd *ImageFileDirectory) GetShortTagValue(tagID TagID) (uint16, error) {
@@ -368,7 +670,9 @@ func (ifd *ImageFileDirectory) GetShortTagValue(tagID TagID) (uint16, error) {
 
 	shortTag, ok := tag.(*ShortTag)
 
-	if ok {
+	if ok && len(shortTag.Data) == 0 {
+		return 0, fmt.Errorf("%w: %s has no values", ErrInvalidTagValue, tagID)
+	} else if ok {
 		// TODO: Decide what to do when more than 1 value
 		value = shortTag.Data[0]
 	} else {
@@ -385,13 +689,13 @@ func (ifd *ImageFileDirectory) GetLongTagValue(tagID TagID) uint32 {
 
 	longTag, ok := tag.(*LongTag)
 
-	if ok {
+	if ok && len(longTag.Data) > 0 {
 		// TODO: Decide what to do when more than 1 value
 		value = longTag.Data[0]
-	} else {
+	} else if !ok {
 		shortTag, ok := tag.(*ShortTag)
 
-		if ok {
+		if ok && len(shortTag.Data) > 0 {
 			value = uint32(shortTag.Data[0])
 		} else {
 			// TODO: Error
@@ -406,8 +710,9 @@ func (ifd *ImageFileDirectory) GetRationalTagValue(tagID TagID) float64 {
 
 	rationalTag, ok := tag.(*RationalTag)
 
-	if !ok {
+	if !ok || len(rationalTag.Data) == 0 {
 		// TODO: Error
+		return 0
 	}
 
 	// TODO: Decide what to do when more than 1 value
@@ -433,6 +738,11 @@ func (ifd *ImageFileDirectory) GetResolution() (float64, float64, ResolutionUnit
 }
 
 func (ifd *ImageFileDirectory) GetBitsPerSample() (uint16, error) {
+	if !ifd.HasTag(BitsPerSample) {
+		// BitsPerSample defaults to 1 when not present
+		return 1, nil
+	}
+
 	return ifd.GetShortTagValue(BitsPerSample)
 }
 
@@ -440,7 +750,7 @@ func (ifd *ImageFileDirectory) GetSamplesPerPixel() (uint16, error) {
 	samplesPerPixel, err := ifd.GetShortTagValue(SamplesPerPixel)
 
 	if err != nil {
-		log.Printf("[GetSamplesPerPixel] Missing SamplesPerPixel, assuming 1: %v", err)
+		// SamplesPerPixel defaults to 1 when not present
 		samplesPerPixel = 1
 	}
 	return samplesPerPixel, nil
@@ -483,6 +793,93 @@ func (ifd *ImageFileDirectory) GetPredictor() PredictorID {
 	return predictorTypeMap[predictorID]
 }
 
+// GetPlanarConfiguration returns how the samples of each pixel are stored. If PlanarConfiguration is not present the
+// samples are stored together (chunky).
+func (ifd *ImageFileDirectory) GetPlanarConfiguration() (PlanarConfigurationID, error) {
+	if !ifd.HasTag(PlanarConfiguration) {
+		return PlanarConfigurationChunky, nil
+	}
+
+	planarConfiguration, err := ifd.GetShortTagValue(PlanarConfiguration)
+	if err != nil {
+		return 0, err
+	}
+
+	planarConfigurationID, ok := planarConfigurationTypeMap[planarConfiguration]
+	if !ok {
+		return 0, &FormatError{msg: fmt.Sprintf("Unknown PlanarConfiguration %d", planarConfiguration)}
+	}
+
+	return planarConfigurationID, nil
+}
+
+// GetExtraSamples returns the meaning of each sample beyond those required by the PhotometricInterpretation (e.g. the
+// fourth sample of RGBA data). If ExtraSamples is not present, nil is returned.
+func (ifd *ImageFileDirectory) GetExtraSamples() ([]ExtraSampleID, error) {
+	extraSamplesTag, ok := ifd.GetShortTag(ExtraSamples)
+	if !ok {
+		return nil, nil
+	}
+
+	extraSamples := make([]ExtraSampleID, len(extraSamplesTag.Data))
+	for index, value := range extraSamplesTag.Data {
+		extraSample, ok := extraSampleTypeMap[value]
+		if !ok {
+			return nil, &FormatError{msg: fmt.Sprintf("Unknown ExtraSamples value %d", value)}
+		}
+
+		extraSamples[index] = extraSample
+	}
+
+	return extraSamples, nil
+}
+
+// GetSampleFormat returns how the samples of the image should be interpreted. If SampleFormat is not present the
+// samples are unsigned integers. When samples have different formats, the format of the first sample is returned.
+func (ifd *ImageFileDirectory) GetSampleFormat() (SampleFormatID, error) {
+	if !ifd.HasTag(SampleFormat) {
+		return SampleFormatUInt, nil
+	}
+
+	sampleFormat, err := ifd.GetShortTagValue(SampleFormat)
+	if err != nil {
+		return 0, err
+	}
+
+	sampleFormatID, ok := sampleFormatTypeMap[sampleFormat]
+	if !ok {
+		return 0, &FormatError{msg: fmt.Sprintf("Unknown SampleFormat %d", sampleFormat)}
+	}
+
+	return sampleFormatID, nil
+}
+
+// getSubIFDOffsets returns the offsets stored in the SubIFDs tag, if present.
+func (ifd *ImageFileDirectory) getSubIFDOffsets() []int64 {
+	var offsets []int64
+
+	switch tag := ifd.Tags[SubIFDs].(type) {
+	case *IFDTag:
+		for _, offset := range tag.Data {
+			offsets = append(offsets, int64(offset))
+		}
+	case *IFD8Tag:
+		for _, offset := range tag.Data {
+			offsets = append(offsets, int64(offset))
+		}
+	case *LongTag:
+		for _, offset := range tag.Data {
+			offsets = append(offsets, int64(offset))
+		}
+	case *Long8Tag:
+		for _, offset := range tag.Data {
+			offsets = append(offsets, int64(offset))
+		}
+	}
+
+	return offsets
+}
+
 // IsReducedResolutionImage checks whether the reduced resolution bit is set in the NewSubfileType tag
 // TODO: Check the SubfileType tag as well, to support older versions
 func (ifd *ImageFileDirectory) IsReducedResolutionImage() bool {
@@ -525,6 +922,10 @@ func (ifd *ImageFileDirectory) GetData(section *Section) ([]byte, error) {
 	return ifd.dataAccess.GetData(section)
 }
 
+func (ifd *ImageFileDirectory) GetPlaneData(section *Section, plane uint16) ([]byte, error) {
+	return ifd.dataAccess.GetPlaneData(section, plane)
+}
+
 //func (ifd *ImageFileDirectory) GetFullData() ([]byte, error) {
 //	return ifd.dataAccess.GetFullData()
 //}
@@ -0,0 +1,509 @@
+package gobio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AlanRace/go-bio/test/tifftest"
+)
+
+// newGrayStripTIFF creates an uncompressed 8-bit greyscale image stored in strips of rowsPerStrip rows.
+func newGrayStripTIFF(order binary.ByteOrder, bigTIFF bool, width, height, rowsPerStrip int) ([]byte, []byte) {
+	pix := make([]byte, width*height)
+	for i := range pix {
+		pix[i] = byte(i)
+	}
+
+	var strips [][]byte
+	for y := 0; y < height; y += rowsPerStrip {
+		end := y + rowsPerStrip
+		if end > height {
+			end = height
+		}
+		strips = append(strips, pix[y*width:end*width])
+	}
+
+	builder := tifftest.New(order, bigTIFF)
+	builder.AddDirectory().
+		Add(uint16(ImageWidth), tifftest.Long, []uint32{uint32(width)}).
+		Add(uint16(ImageLength), tifftest.Long, []uint32{uint32(height)}).
+		Add(uint16(BitsPerSample), tifftest.Short, []uint16{8}).
+		Add(uint16(Compression), tifftest.Short, []uint16{1}).
+		Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{1}).
+		Add(uint16(SamplesPerPixel), tifftest.Short, []uint16{1}).
+		Add(uint16(RowsPerStrip), tifftest.Long, []uint32{uint32(rowsPerStrip)}).
+		Strips(strips...)
+
+	return builder.Bytes(), pix
+}
+
+func TestOpenReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		order   binary.ByteOrder
+		bigTIFF bool
+	}{
+		{"LittleEndian", binary.LittleEndian, false},
+		{"BigEndian", binary.BigEndian, false},
+		{"BigTIFF", binary.LittleEndian, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, pix := newGrayStripTIFF(test.order, test.bigTIFF, 7, 5, 2)
+
+			tiffFile, err := OpenReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tiffFile.Close()
+
+			if len(tiffFile.IFDList) != 1 {
+				t.Fatalf("expected 1 IFD, found %d", len(tiffFile.IFDList))
+			}
+
+			ifd := tiffFile.GetIFD(0)
+			width, height := ifd.GetImageDimensions()
+			if width != 7 || height != 5 {
+				t.Fatalf("expected dimensions 7 x 5, found %d x %d", width, height)
+			}
+
+			_, stripsDown := ifd.GetSectionGrid()
+			if stripsDown != 3 {
+				t.Fatalf("expected 3 strips, found %d", stripsDown)
+			}
+
+			var got []byte
+			for index := uint32(0); index < stripsDown; index++ {
+				stripData, err := ifd.GetSection(index).GetData()
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, stripData...)
+			}
+
+			if !bytes.Equal(got, pix) {
+				t.Errorf("data mismatch:\n got %v\nwant %v", got, pix)
+			}
+		})
+	}
+}
+
+func TestSectionOffsetTypes(t *testing.T) {
+	pix := make([]byte, 6*4)
+	for i := range pix {
+		pix[i] = byte(i)
+	}
+
+	// BigTIFF allows SHORT, LONG or LONG8 offsets and byte counts, and classic TIFF SHORT or LONG
+	tests := []struct {
+		name     string
+		bigTIFF  bool
+		dataType uint16
+		tiled    bool
+	}{
+		{"Short", false, tifftest.Short, false},
+		{"BigTIFFShort", true, tifftest.Short, false},
+		{"BigTIFFLong", true, tifftest.Long, false},
+		{"BigTIFFLong8", true, tifftest.Long8, false},
+		{"BigTIFFTilesLong", true, tifftest.Long, true},
+	}
+
+	for _, test := range tests {
+		builder := tifftest.New(binary.LittleEndian, test.bigTIFF)
+		directory := builder.AddDirectory().
+			Add(uint16(ImageWidth), tifftest.Long, []uint32{6}).
+			Add(uint16(ImageLength), tifftest.Long, []uint32{4}).
+			Add(uint16(BitsPerSample), tifftest.Short, []uint16{8}).
+			Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(BlackIsZero)}).
+			ChunkType(test.dataType)
+		if test.tiled {
+			tile := make([]byte, 16*16)
+			for y := 0; y < 4; y++ {
+				copy(tile[y*16:], pix[y*6:(y+1)*6])
+			}
+
+			directory.Add(uint16(TileWidth), tifftest.Long, []uint32{16}).
+				Add(uint16(TileLength), tifftest.Long, []uint32{16}).
+				Tiles(tile)
+		} else {
+			directory.Add(uint16(RowsPerStrip), tifftest.Long, []uint32{2}).
+				Strips(pix[:12], pix[12:])
+		}
+		data := builder.Bytes()
+
+		tiffFile, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{Strict: true})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		img, err := tiffFile.GetIFD(0).GetImage()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !bytes.Equal(img.(*image.Gray).Pix, pix) {
+			t.Errorf("%s: data mismatch", test.name)
+		}
+	}
+}
+
+func TestOpenMatchesOpenReader(t *testing.T) {
+	data, pix := newGrayStripTIFF(binary.LittleEndian, false, 4, 4, 4)
+
+	dir, err := ioutil.TempDir("", "gobio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "gray.tif")
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tiffFile, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tiffFile.Close()
+
+	if tiffFile.Size() != int64(len(data)) {
+		t.Errorf("expected size %d, found %d", len(data), tiffFile.Size())
+	}
+
+	stripData, err := tiffFile.GetIFD(0).GetSection(0).GetData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(stripData, pix) {
+		t.Errorf("data mismatch:\n got %v\nwant %v", stripData, pix)
+	}
+}
+
+func TestOpenReaderInvalidHeader(t *testing.T) {
+	data := []byte("XX*\x00\x08\x00\x00\x00")
+
+	_, err := OpenReader(bytes.NewReader(data), int64(len(data)))
+	if err == nil {
+		t.Fatal("expected error for invalid byte order marker")
+	}
+}
+
+// newPyramidLevel creates a directory describing a width x height greyscale image filled with value.
+func newPyramidLevel(directory *tifftest.Directory, width, height int, reduced bool, value byte) *tifftest.Directory {
+	pix := bytes.Repeat([]byte{value}, width*height)
+
+	subfileType := uint32(0)
+	if reduced {
+		subfileType = 1
+	}
+
+	return directory.
+		Add(uint16(NewSubFileType), tifftest.Long, []uint32{subfileType}).
+		Add(uint16(ImageWidth), tifftest.Long, []uint32{uint32(width)}).
+		Add(uint16(ImageLength), tifftest.Long, []uint32{uint32(height)}).
+		Add(uint16(BitsPerSample), tifftest.Short, []uint16{8}).
+		Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(BlackIsZero)}).
+		Add(uint16(SamplesPerPixel), tifftest.Short, []uint16{1}).
+		Add(uint16(RowsPerStrip), tifftest.Long, []uint32{uint32(height)}).
+		Strips(pix)
+}
+
+func TestReducedImages(t *testing.T) {
+	tests := []struct {
+		name    string
+		bigTIFF bool
+		nested  bool
+	}{
+		{"Chained", false, false},
+		{"SubIFDs", false, true},
+		{"BigTIFFChained", true, false},
+		{"BigTIFFSubIFDs", true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			builder := tifftest.New(binary.LittleEndian, test.bigTIFF)
+
+			full := newPyramidLevel(builder.AddDirectory(), 16, 16, false, 1)
+			if test.nested {
+				full.SubIFDs(
+					newPyramidLevel(builder.NewDirectory(), 8, 8, true, 2),
+					newPyramidLevel(builder.NewDirectory(), 4, 4, true, 3))
+			} else {
+				newPyramidLevel(builder.AddDirectory(), 8, 8, true, 2)
+				newPyramidLevel(builder.AddDirectory(), 4, 4, true, 3)
+			}
+
+			// A second (e.g. channel) image with its own pyramid, which must not be included
+			newPyramidLevel(builder.AddDirectory(), 16, 16, false, 4).
+				SubIFDs(newPyramidLevel(builder.NewDirectory(), 8, 8, true, 5))
+
+			data := builder.Bytes()
+
+			tiffFile, err := OpenReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tiffFile.NumReducedImages() != 3 {
+				t.Fatalf("expected 3 resolutions, found %d", tiffFile.NumReducedImages())
+			}
+
+			for index, size := range []uint32{16, 8, 4} {
+				ifd := tiffFile.GetReducedImage(index)
+
+				width, height := ifd.GetImageDimensions()
+				if width != size || height != size {
+					t.Errorf("resolution %d: expected %d x %d, found %d x %d", index, size, size, width, height)
+				}
+
+				stripData, err := ifd.GetSection(0).GetData()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(stripData, bytes.Repeat([]byte{byte(index + 1)}, int(size*size))) {
+					t.Errorf("resolution %d: data mismatch", index)
+				}
+			}
+
+			if tiffFile.GetReducedImage(3) != nil {
+				t.Error("expected nil for a resolution which doesn't exist")
+			}
+		})
+	}
+}
+
+func TestOpenOptions(t *testing.T) {
+	pastEnd := make([]byte, 4)
+	binary.LittleEndian.PutUint32(pastEnd, 0xfffffff0)
+
+	tests := []struct {
+		name   string
+		build  func(builder *tifftest.Builder)
+		want   error
+		numIFD int
+	}{
+		{"TagDataPastEnd", func(builder *tifftest.Builder) {
+			newPyramidLevel(builder.AddDirectory(), 4, 4, false, 1).
+				AddRaw(uint16(ImageDescription), tifftest.ASCII, 100, pastEnd)
+		}, ErrTruncated, 1},
+		{"StripPastEnd", func(builder *tifftest.Builder) {
+			newPyramidLevel(builder.AddDirectory(), 4, 4, false, 1).
+				Strips().
+				Add(uint16(StripOffsets), tifftest.Long, []uint32{8}).
+				AddRaw(uint16(StripByteCounts), tifftest.Long, 1, pastEnd)
+		}, ErrTruncated, 1},
+		{"MissingStrips", func(builder *tifftest.Builder) {
+			newPyramidLevel(builder.AddDirectory(), 4, 4, false, 1).
+				Add(uint16(RowsPerStrip), tifftest.Long, []uint32{1})
+		}, ErrSectionCount, 1},
+		{"MissingTileOffsets", func(builder *tifftest.Builder) {
+			newPyramidLevel(builder.AddDirectory(), 16, 16, false, 1).
+				Remove(uint16(RowsPerStrip)).
+				Add(uint16(TileWidth), tifftest.Long, []uint32{16}).
+				Add(uint16(TileLength), tifftest.Long, []uint32{16})
+		}, ErrMissingTag, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			builder := tifftest.New(binary.LittleEndian, false)
+			test.build(builder)
+			data := builder.Bytes()
+
+			_, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{Strict: true})
+			var parseError *ParseError
+			if !errors.As(err, &parseError) {
+				t.Fatalf("strict: expected ParseError, got %v", err)
+			}
+			if test.want != nil && !errors.Is(err, test.want) {
+				t.Errorf("strict: expected %v, got %v", test.want, err)
+			}
+
+			var warnings []error
+			tiffFile, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{
+				WarningHandler: func(warning error) { warnings = append(warnings, warning) },
+			})
+			if err != nil {
+				t.Fatalf("lenient: %v", err)
+			}
+
+			if len(tiffFile.IFDList) != test.numIFD {
+				t.Errorf("lenient: expected %d IFDs, found %d", test.numIFD, len(tiffFile.IFDList))
+			}
+
+			diagnostics := tiffFile.Diagnostics()
+			if len(diagnostics) == 0 || len(diagnostics) != len(warnings) {
+				t.Fatalf("lenient: expected diagnostics to match warnings, got %v and %v", diagnostics, warnings)
+			}
+			if test.want != nil && !errors.Is(diagnostics[0], test.want) {
+				t.Errorf("lenient: expected %v, got %v", test.want, diagnostics[0])
+			}
+		})
+	}
+}
+
+func TestOpenUnsupportedIFD(t *testing.T) {
+	// The second IFD uses a compression scheme which isn't supported, which is not a violation of the specification
+	builder := tifftest.New(binary.LittleEndian, false)
+	newPyramidLevel(builder.AddDirectory(), 4, 4, false, 1)
+	newPyramidLevel(builder.AddDirectory(), 4, 4, false, 2).
+		Add(uint16(Compression), tifftest.Short, []uint16{99})
+	newPyramidLevel(builder.AddDirectory(), 2, 2, true, 3)
+	data := builder.Bytes()
+
+	for _, strict := range []bool{false, true} {
+		var warnings []error
+		tiffFile, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{
+			Strict:         strict,
+			WarningHandler: func(warning error) { warnings = append(warnings, warning) },
+		})
+		if err != nil {
+			t.Fatalf("strict %v: %v", strict, err)
+		}
+
+		// The IFD keeps its place, so the positions of those which follow are unchanged
+		if len(tiffFile.IFDList) != 3 {
+			t.Fatalf("strict %v: expected 3 IFDs, found %d", strict, len(tiffFile.IFDList))
+		}
+		if len(warnings) != 1 {
+			t.Errorf("strict %v: expected 1 warning, found %v", strict, warnings)
+		}
+
+		_, err = tiffFile.GetIFD(1).GetImage()
+		if err == nil {
+			t.Errorf("strict %v: expected error reading the image data of the unsupported IFD", strict)
+		}
+		if section := tiffFile.GetIFD(1).GetSection(0); section != nil {
+			t.Errorf("strict %v: expected no sections in the unsupported IFD", strict)
+		}
+
+		img, err := tiffFile.GetIFD(2).GetImage()
+		if err != nil {
+			t.Fatalf("strict %v: %v", strict, err)
+		}
+		if value := img.(*image.Gray).Pix[0]; value != 3 {
+			t.Errorf("strict %v: expected the third IFD to hold 3, found %d", strict, value)
+		}
+	}
+}
+
+func TestOpenInvalidDimensions(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(*tifftest.Directory)
+		readable bool
+	}{
+		{"ImageWidth without values", func(directory *tifftest.Directory) {
+			directory.AddRaw(uint16(ImageWidth), tifftest.Long, 0, make([]byte, 4))
+		}, false},
+		{"ImageLength of 0", func(directory *tifftest.Directory) {
+			directory.Add(uint16(ImageLength), tifftest.Long, []uint32{0})
+		}, false},
+		{"TileWidth of 0", func(directory *tifftest.Directory) {
+			directory.Add(uint16(TileWidth), tifftest.Long, []uint32{0}).
+				Add(uint16(TileLength), tifftest.Long, []uint32{4})
+		}, false},
+		// The image is read as a single strip, as when RowsPerStrip is missing
+		{"RowsPerStrip without values", func(directory *tifftest.Directory) {
+			directory.AddRaw(uint16(RowsPerStrip), tifftest.Long, 0, make([]byte, 4))
+		}, true},
+	}
+
+	for _, test := range tests {
+		builder := tifftest.New(binary.LittleEndian, false)
+		test.modify(newPyramidLevel(builder.AddDirectory(), 4, 4, false, 1))
+		data := builder.Bytes()
+
+		_, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{Strict: true})
+		if !errors.Is(err, ErrInvalidTagValue) {
+			t.Errorf("%s: expected ErrInvalidTagValue in strict mode, found %v", test.name, err)
+		}
+
+		var warnings []error
+		tiffFile, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{
+			WarningHandler: func(warning error) { warnings = append(warnings, warning) },
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(warnings) != 1 || !errors.Is(warnings[0], ErrInvalidTagValue) {
+			t.Errorf("%s: expected an ErrInvalidTagValue warning, found %v", test.name, warnings)
+		}
+
+		_, err = tiffFile.GetIFD(0).GetImage()
+		if (err == nil) != test.readable {
+			t.Errorf("%s: unexpected result reading the image: %v", test.name, err)
+		}
+	}
+}
+
+func TestOpenMissingSectionTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		bigTIFF bool
+		modify  func(*tifftest.Directory)
+		err     error
+	}{
+		{"StripByteCounts missing", false, func(directory *tifftest.Directory) {
+			directory.Remove(uint16(StripByteCounts))
+		}, ErrMissingTag},
+		{"BigTIFFStripByteCounts missing", true, func(directory *tifftest.Directory) {
+			directory.Remove(uint16(StripByteCounts))
+		}, ErrMissingTag},
+		{"StripOffsets missing", false, func(directory *tifftest.Directory) {
+			directory.Remove(uint16(StripOffsets))
+		}, ErrMissingTag},
+		{"BigTIFFStripOffsets as DOUBLE", true, func(directory *tifftest.Directory) {
+			directory.ChunkType(tifftest.Double)
+		}, ErrInvalidTagValue},
+	}
+
+	for _, test := range tests {
+		builder := tifftest.New(binary.LittleEndian, test.bigTIFF)
+		test.modify(newPyramidLevel(builder.AddDirectory(), 4, 4, false, 1))
+		data := builder.Bytes()
+
+		_, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{Strict: true})
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v in strict mode, found %v", test.name, test.err, err)
+		}
+
+		var warnings []error
+		tiffFile, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{
+			WarningHandler: func(warning error) { warnings = append(warnings, warning) },
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(warnings) != 1 || !errors.Is(warnings[0], test.err) {
+			t.Errorf("%s: expected a warning wrapping %v, found %v", test.name, test.err, warnings)
+		}
+
+		if _, err = tiffFile.GetIFD(0).GetImage(); err == nil {
+			t.Errorf("%s: expected an error reading the image", test.name)
+		}
+	}
+}
+
+func TestOpenOptionsValidFile(t *testing.T) {
+	data, _ := newGrayStripTIFF(binary.BigEndian, true, 5, 5, 2)
+
+	tiffFile, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tiffFile.Diagnostics()) != 0 {
+		t.Errorf("expected no diagnostics, got %v", tiffFile.Diagnostics())
+	}
+}
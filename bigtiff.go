@@ -2,8 +2,9 @@ package gobio
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
-	"log"
+	"math"
 )
 
 func readBigIFDOffset(reader io.Reader, endian binary.ByteOrder) (int64, error) {
@@ -25,30 +26,40 @@ func readBigIFDOffset(reader io.Reader, endian binary.ByteOrder) (int64, error)
 	return int64(offset), nil
 }
 
-func readBigIFD(seeker io.ReadSeeker, endian binary.ByteOrder, offset int64) (*ImageFileDirectory, error) {
+func readBigIFD(tiffFile *File, seeker io.ReadSeeker, index int, offset int64) (*ImageFileDirectory, error) {
 	var ifd ImageFileDirectory
 	var err error
 
 	var numTags uint64
 	var nextOffset uint64
 
-	ifd.getStripOffsets = getBigStripOffsets
-	ifd.getTileOffsets = getBigTileOffsets
+	endian := tiffFile.header.Endian
 
-	seeker.Seek(offset, io.SeekStart)
+	ifd.tiffFile = tiffFile
+	ifd.index = index
+
+	_, err = seeker.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
 	err = binary.Read(seeker, endian, &numTags)
 	if err != nil {
 		return nil, err
 	}
+	if numTags > uint64(tiffFile.size)/20 {
+		return nil, &FormatError{msg: fmt.Sprintf("IFD has more tags (%d) than can fit in the file", numTags)}
+	}
 	ifd.NumTags = numTags
 
-	//fmt.Printf("Found %d tags\n", numTags)
-
-	err = processBigTags(&ifd, seeker, endian)
+	err = processBigTags(&ifd, seeker, endian, offset+8)
 	if err != nil {
 		return nil, err
 	}
 
+	_, err = seeker.Seek(offset+8+int64(numTags)*20, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
 	err = binary.Read(seeker, endian, &nextOffset)
 	if err != nil {
 		return nil, err
@@ -66,7 +77,8 @@ type bigTagData struct {
 	DataOffset uint64 /* The byte offset to the data items  */
 }
 
-func processBigTags(ifd *ImageFileDirectory, seeker io.ReadSeeker, endian binary.ByteOrder) error {
+// processBigTags reads the tag entries of the IFD, which start at entriesOffset.
+func processBigTags(ifd *ImageFileDirectory, seeker io.ReadSeeker, endian binary.ByteOrder, entriesOffset int64) error {
 	var err error
 	var tags []bigTagData
 	tags = make([]bigTagData, ifd.NumTags)
@@ -76,293 +88,38 @@ func processBigTags(ifd *ImageFileDirectory, seeker io.ReadSeeker, endian binary
 		return err
 	}
 
-	for _, tag := range tags {
-		tagName := TagNameFromID(tag.TagID) //tagNameMap[tagIDMap[tag.TagID]]
-
-		if tagName == "" {
-			log.Printf("Unknown tag id %d\n", tag.TagID)
-		} else {
-			dataType := DataTypeFromID(tag.DataType)
-
-			//fmt.Println(tagName + ": " + dataTypeNameMap[dataType] + "(" + strconv.Itoa(int(dataType)) + ")")
+	for index, tag := range tags {
+		entryOffset := entriesOffset + int64(index)*20
 
-			switch dataType {
-			case Byte, Undefined:
-				byteTag := processBigByteTag(seeker, endian, &tag)
-
-				ifd.PutTag(byteTag)
-			case ASCII:
-				asciiTag := processBigASCIITag(seeker, endian, &tag)
-
-				ifd.PutTag(asciiTag)
-			case Short:
-				shortTag := processBigShortTag(seeker, endian, &tag)
-
-				ifd.PutTag(shortTag)
-			case Long:
-				longTag := processBigLongTag(seeker, endian, &tag)
-
-				ifd.PutTag(longTag)
-			case Rational:
-				rationalTag := processBigRationalTag(seeker, endian, &tag)
-
-				ifd.PutTag(rationalTag)
-			case Long8:
-				long8Tag := processBigLong8Tag(seeker, endian, &tag)
-				//fmt.Println(long8Tag)
-
-				ifd.PutTag(long8Tag)
-			default:
-				log.Printf("Unknown tag type %s\n", DataTypeNameFromID(tag.DataType))
-			}
+		err = processTag(ifd, tag.TagID, tag.DataType, entryOffset, func() (int64, []byte, error) {
+			return readBigTagData(seeker, endian, &tag)
+		})
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func processBigByteTag(seeker io.ReadSeeker, endian binary.ByteOrder, tagData *bigTagData) *ByteTag {
-	var tag ByteTag
-
-	tag.ID = TagFromID(tagData.TagID)
-	tag.DataType = DataTypeFromID(tagData.DataType)
-
-	if tagData.DataCount <= 8 {
-		a := make([]byte, 8)
-		endian.PutUint64(a, tagData.DataOffset)
-
-		tag.Data = a[:tagData.DataCount]
-	} else {
-		tag.Data = make([]byte, tagData.DataCount)
-
-		// TODO: Do something with the error
-		startLocation, _ := seeker.Seek(0, io.SeekCurrent)
-		seeker.Seek(int64(tagData.DataOffset), io.SeekStart)
-		binary.Read(seeker, endian, &tag.Data)
-		// TODO: Do something with the error
-		seeker.Seek(startLocation, io.SeekStart)
+// readBigTagData returns the raw bytes of the values of a tag and the offset they were read from, or -1 if they were
+// stored in the entry. Values which fit in 8 bytes are stored in place of the offset, otherwise they are read from the
+// offset.
+func readBigTagData(seeker io.ReadSeeker, endian binary.ByteOrder, tagData *bigTagData) (int64, []byte, error) {
+	typeSize := uint64(DataTypeFromID(tagData.DataType).Size())
+	if typeSize > 0 && tagData.DataCount > math.MaxInt64/typeSize {
+		return -1, nil, &FormatError{msg: fmt.Sprintf("Invalid count %d", tagData.DataCount)}
 	}
+	size := tagData.DataCount * typeSize
 
-	return &tag
-}
+	if size <= 8 {
+		data := make([]byte, 8)
+		endian.PutUint64(data, tagData.DataOffset)
 
-func processBigASCIITag(seeker io.ReadSeeker, endian binary.ByteOrder, tagData *bigTagData) *ASCIITag {
-	var tag ASCIITag
-
-	tag.ID = TagFromID(tagData.TagID)
-	tag.DataType = DataTypeFromID(tagData.DataType)
-
-	data := make([]byte, tagData.DataCount)
-
-	if tagData.DataCount <= 4 {
-		log.Printf("NOT IMPLEMENTED processBigASCIITag: %v\n", tagData)
-		//tag.data[0] = tagData.DataOffset
-	} else {
-		// TODO: Do something with the error
-		startLocation, _ := seeker.Seek(0, io.SeekCurrent)
-		seeker.Seek(int64(tagData.DataOffset), io.SeekStart)
-		binary.Read(seeker, endian, &data)
-		// TODO: Do something with the error
-		seeker.Seek(startLocation, io.SeekStart)
-
-		tag.Data = string(data)
+		return -1, data[:size], nil
 	}
 
-	return &tag
-}
-
-func processBigRationalTag(seeker io.ReadSeeker, endian binary.ByteOrder, tagData *bigTagData) *RationalTag {
-	var tag RationalTag
-
-	tag.ID = TagFromID(tagData.TagID)
-	tag.DataType = DataTypeFromID(tagData.DataType)
-
-	tag.Data = make([]RationalNumber, tagData.DataCount)
-
-	if tagData.DataCount == 1 {
-		var num RationalNumber
-
-		if endian == binary.BigEndian {
-			num.Denominator = uint32(tagData.DataOffset >> 32)
-			num.Numerator = uint32(tagData.DataOffset & 0xffffffff)
-		} else {
-			num.Numerator = uint32(tagData.DataOffset >> 32)
-			num.Denominator = uint32(tagData.DataOffset & 0xffffffff)
-		}
-
-		tag.Data[0] = num
-	} else {
-		// TODO: Do something with the error
-		startLocation, _ := seeker.Seek(0, io.SeekCurrent)
-		seeker.Seek(int64(tagData.DataOffset), io.SeekStart)
-		binary.Read(seeker, endian, &tag.Data)
-		// TODO: Do something with the error
-		seeker.Seek(startLocation, io.SeekStart)
-	}
-
-	return &tag
-}
-
-func processBigShortTag(seeker io.ReadSeeker, endian binary.ByteOrder, tagData *bigTagData) *ShortTag {
-	// TODO: Update to make work with big (i.e. read in 4 values)
-	var tag ShortTag
-
-	tag.ID = TagFromID(tagData.TagID)
-	tag.DataType = DataTypeFromID(tagData.DataType)
-
-	tag.Data = make([]uint16, tagData.DataCount)
-
-	if tagData.DataCount > 4 {
-		// TODO: Do something with the error
-		startLocation, _ := seeker.Seek(0, io.SeekCurrent)
-		seeker.Seek(int64(tagData.DataOffset), io.SeekStart)
-		binary.Read(seeker, endian, &tag.Data)
-		// TODO: Do something with the error
-		seeker.Seek(startLocation, io.SeekStart)
-	} else {
-
-		if endian == binary.BigEndian {
-			tag.Data[0] = uint16(tagData.DataOffset >> 48)
-
-			if tagData.DataCount > 1 {
-				tag.Data[1] = uint16((tagData.DataOffset >> 32) & 0xffffffffffff)
-			}
-			if tagData.DataCount > 2 {
-				tag.Data[2] = uint16((tagData.DataOffset >> 16) & 0xffffffffffff)
-			}
-			if tagData.DataCount > 3 {
-				tag.Data[2] = uint16(tagData.DataOffset & 0xffffffffffff)
-			}
-		} else {
-			tag.Data[0] = uint16(tagData.DataOffset & 0xffffffffffff)
-
-			if tagData.DataCount > 1 {
-				tag.Data[1] = uint16((tagData.DataOffset >> 16) & 0xffffffffffff)
-			}
-			if tagData.DataCount > 2 {
-				tag.Data[2] = uint16((tagData.DataOffset >> 32) & 0xffffffffffff)
-			}
-			if tagData.DataCount > 3 {
-				tag.Data[3] = uint16(tagData.DataOffset >> 48)
-			}
-		}
-
-		//fmt.Printf("Processing tag %v -> %v (%v) (%v)\n", tag.TagID, tagData.DataOffset, tag.data[0])
-	}
-
-	return &tag
-}
-
-func processBigLongTag(seeker io.ReadSeeker, endian binary.ByteOrder, tagData *bigTagData) *LongTag {
-	var tag LongTag
-
-	tag.ID = TagFromID(tagData.TagID)
-	tag.DataType = DataTypeFromID(tagData.DataType)
-
-	tag.Data = make([]uint32, tagData.DataCount)
-
-	if tagData.DataCount > 2 {
-		// TODO: Do something with the error
-		startLocation, _ := seeker.Seek(0, io.SeekCurrent)
-		seeker.Seek(int64(tagData.DataOffset), io.SeekStart)
-		binary.Read(seeker, endian, &tag.Data)
-		// TODO: Do something with the error
-		seeker.Seek(startLocation, io.SeekStart)
-	} else {
-		if endian == binary.BigEndian {
-			tag.Data[0] = uint32(tagData.DataOffset >> 32)
-
-			if tagData.DataCount == 2 {
-				tag.Data[1] = uint32(tagData.DataOffset & 0xffffffff)
-			}
-		} else {
-			tag.Data[0] = uint32(tagData.DataOffset & 0xffffffff)
-
-			if tagData.DataCount == 2 {
-				tag.Data[1] = uint32(tagData.DataOffset >> 32)
-			}
-		}
-
-		//fmt.Printf("Processing tag %v -> %v (%v) (%v)\n", tag.TagID, tagData.DataOffset, tag.data[0])
-	}
-
-	/*if tagData.DataCount == 1 {
-		tag.Data[0] = tagData.DataOffset
-	} else {
-		// TODO: Do something with the error
-		startLocation, _ := seeker.Seek(0, io.SeekCurrent)
-		seeker.Seek(int64(tagData.DataOffset), io.SeekStart)
-		binary.Read(seeker, endian, &tag.Data)
-		// TODO: Do something with the error
-		seeker.Seek(startLocation, io.SeekStart)
-	}*/
-
-	return &tag
-}
-
-func processBigLong8Tag(seeker io.ReadSeeker, endian binary.ByteOrder, tagData *bigTagData) *Long8Tag {
-	var tag Long8Tag
-
-	tag.ID = TagFromID(tagData.TagID)
-	tag.DataType = DataTypeFromID(tagData.DataType)
-
-	tag.Data = make([]uint64, tagData.DataCount)
-
-	if tagData.DataCount == 1 {
-		tag.Data[0] = tagData.DataOffset
-	} else {
-		// TODO: Do something with the error
-		startLocation, _ := seeker.Seek(0, io.SeekCurrent)
-		seeker.Seek(int64(tagData.DataOffset), io.SeekStart)
-		binary.Read(seeker, endian, &tag.Data)
-		// TODO: Do something with the error
-		seeker.Seek(startLocation, io.SeekStart)
-	}
-
-	return &tag
-}
-
-func getBigStripOffsets(ifd *ImageFileDirectory) ([]int64, []int64, error) {
-	var offsets, counts []int64
-
-	stripOffsetsTag, ok := ifd.Tags[StripOffsets].(*Long8Tag)
-	if !ok {
-		return nil, nil, &FormatError{msg: "Data stored as strips, but StripOffsets appear to be missing"}
-	}
-
-	stripByteCountsTag, ok := ifd.Tags[StripByteCounts].(*Long8Tag)
-	if !ok {
-		return nil, nil, &FormatError{msg: "Data stored as strips, but StripByteCounts appear to be missing"}
-	}
-
-	for index := range stripOffsetsTag.Data {
-		offsets = append(offsets, int64(stripOffsetsTag.Data[index]))
-		counts = append(counts, int64(stripByteCountsTag.Data[index]))
-	}
-
-	return offsets, counts, nil
-}
-
-func getBigTileOffsets(ifd *ImageFileDirectory) ([]int64, []int64, error) {
-	var offsets, counts []int64
-
-	// TODO: Merge this with getTileOffets
-	// Can check the data type for the tag and then cast approppriately. This will allow other types to be used (if they are used?)
-
-	tileOffsetsTag, ok := ifd.Tags[TileOffsets].(*Long8Tag)
-	if !ok {
-		return nil, nil, &FormatError{msg: "Data stored as tiles, but TileOffsets appear to be missing"}
-	}
-
-	tileByteCountsTag, ok := ifd.Tags[TileByteCounts].(*Long8Tag)
-	if !ok {
-		return nil, nil, &FormatError{msg: "Data stored as tiles, but TileByteCounts appear to be missing"}
-	}
-
-	for index := range tileOffsetsTag.Data {
-		offsets = append(offsets, int64(tileOffsetsTag.Data[index]))
-		counts = append(counts, int64(tileByteCountsTag.Data[index]))
-	}
+	data, err := readTagDataAt(seeker, int64(tagData.DataOffset), size)
 
-	return offsets, counts, nil
+	return int64(tagData.DataOffset), data, err
 }
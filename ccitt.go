@@ -0,0 +1,537 @@
+package gobio
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// T4Options flags, see TIFF 6.0 section 11.
+const (
+	t4Options2D           = 1
+	t4OptionsUncompressed = 2
+	t4OptionsFillBits     = 4
+)
+
+// CCITTCompression decodes bilevel data compressed with CCITT modified Huffman (compression 2), T.4 (compression 3)
+// or T.6 (compression 4) coding. White pixels are decoded as 0 bits when the image is WhiteIsZero and 1 bits
+// otherwise, so the data can be read in the same way as uncompressed 1-bit data.
+type CCITTCompression struct {
+	compressionID CompressionID
+
+	// width is the number of pixels in each row of a section
+	width int
+
+	t4Options      uint32
+	reverseBits    bool
+	whiteIsOneBits bool
+}
+
+func newCCITTCompression(dataAccess *baseDataAccess) (*CCITTCompression, error) {
+	compression := &CCITTCompression{
+		compressionID: dataAccess.compressionID,
+		width:         int(dataAccess.imageWidth),
+	}
+
+	if dataAccess.HasTag(TileWidth) {
+		compression.width = int(dataAccess.ifd.GetLongTagValue(TileWidth))
+	}
+
+	if dataAccess.samplesPerPixel != 1 || len(dataAccess.bitsPerSample) == 0 || dataAccess.bitsPerSample[0] != 1 {
+		return nil, &FormatError{msg: fmt.Sprintf("%s compression requires 1-bit bilevel data, found BitsPerSample %v", dataAccess.compressionID, dataAccess.bitsPerSample)}
+	}
+
+	if dataAccess.compressionID == CCITGroup3 && dataAccess.HasTag(T4Options) {
+		compression.t4Options = dataAccess.ifd.GetLongTagValue(T4Options)
+	}
+
+	if dataAccess.HasTag(FillOrder) {
+		fillOrder, err := dataAccess.ifd.GetShortTagValue(FillOrder)
+		if err != nil {
+			return nil, err
+		}
+
+		// FillOrder 2 stores the first bit in the least significant bit of each byte
+		compression.reverseBits = fillOrder == 2
+	}
+
+	compression.whiteIsOneBits = dataAccess.photometricInterpretation == BlackIsZero
+
+	return compression, nil
+}
+
+// Decompress decodes all of the rows in r, returning them packed with 8 pixels per byte and each row starting on a
+// byte boundary.
+func (compression *CCITTCompression) Decompress(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if compression.reverseBits {
+		reversed := make([]byte, len(data))
+		for index, value := range data {
+			reversed[index] = reverseBitsTable[value]
+		}
+		data = reversed
+	}
+
+	decoder := &ccittDecoder{data: data, width: compression.width}
+
+	switch compression.compressionID {
+	case CCIT1D:
+		err = decoder.decodeModifiedHuffman()
+	case CCITGroup3:
+		err = decoder.decodeT4(compression.t4Options)
+	case CCITGroup4:
+		err = decoder.decodeT6()
+	default:
+		err = &FormatError{msg: fmt.Sprintf("Unsupported CCITT compression %s", compression.compressionID)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if compression.whiteIsOneBits {
+		for index := range decoder.output {
+			decoder.output[index] = ^decoder.output[index]
+		}
+	}
+
+	return decoder.output, nil
+}
+
+// reverseBitsTable maps each byte to the byte with the order of its bits reversed.
+var reverseBitsTable = func() [256]byte {
+	var table [256]byte
+
+	for value := range table {
+		for bit := uint(0); bit < 8; bit++ {
+			if value&(1<<bit) != 0 {
+				table[value] |= 0x80 >> bit
+			}
+		}
+	}
+
+	return table
+}()
+
+// ccittCode is a code of up to 13 bits, stored in the least significant bits of bits.
+type ccittCode struct {
+	length uint8
+	bits   uint16
+}
+
+const (
+	ccittModePass = iota
+	ccittModeHorizontal
+	ccittModeVertical0
+	ccittModeVerticalR1
+	ccittModeVerticalR2
+	ccittModeVerticalR3
+	ccittModeVerticalL1
+	ccittModeVerticalL2
+	ccittModeVerticalL3
+	ccittModeExtension
+)
+
+// ccittModeCodes are the 2D coding modes from T.4 table 4.
+var ccittModeCodes = map[int]string{
+	ccittModePass:       "0001",
+	ccittModeHorizontal: "001",
+	ccittModeVertical0:  "1",
+	ccittModeVerticalR1: "011",
+	ccittModeVerticalR2: "000011",
+	ccittModeVerticalR3: "0000011",
+	ccittModeVerticalL1: "010",
+	ccittModeVerticalL2: "000010",
+	ccittModeVerticalL3: "0000010",
+	ccittModeExtension:  "0000001",
+}
+
+// ccittWhiteCodes are the terminating (0-63) and make-up (64-1728) codes for white runs from T.4 tables 2 and 3.
+var ccittWhiteCodes = map[int]string{
+	0: "00110101", 1: "000111", 2: "0111", 3: "1000", 4: "1011", 5: "1100", 6: "1110", 7: "1111",
+	8: "10011", 9: "10100", 10: "00111", 11: "01000", 12: "001000", 13: "000011", 14: "110100", 15: "110101",
+	16: "101010", 17: "101011", 18: "0100111", 19: "0001100", 20: "0001000", 21: "0010111", 22: "0000011", 23: "0000100",
+	24: "0101000", 25: "0101011", 26: "0010011", 27: "0100100", 28: "0011000", 29: "00000010", 30: "00000011", 31: "00011010",
+	32: "00011011", 33: "00010010", 34: "00010011", 35: "00010100", 36: "00010101", 37: "00010110", 38: "00010111", 39: "00101000",
+	40: "00101001", 41: "00101010", 42: "00101011", 43: "00101100", 44: "00101101", 45: "00000100", 46: "00000101", 47: "00001010",
+	48: "00001011", 49: "01010010", 50: "01010011", 51: "01010100", 52: "01010101", 53: "00100100", 54: "00100101", 55: "01011000",
+	56: "01011001", 57: "01011010", 58: "01011011", 59: "01001010", 60: "01001011", 61: "00110010", 62: "00110011", 63: "00110100",
+
+	64: "11011", 128: "10010", 192: "010111", 256: "0110111", 320: "00110110", 384: "00110111", 448: "01100100",
+	512: "01100101", 576: "01101000", 640: "01100111", 704: "011001100", 768: "011001101", 832: "011010010",
+	896: "011010011", 960: "011010100", 1024: "011010101", 1088: "011010110", 1152: "011010111", 1216: "011011000",
+	1280: "011011001", 1344: "011011010", 1408: "011011011", 1472: "010011000", 1536: "010011001", 1600: "010011010",
+	1664: "011000", 1728: "010011011",
+}
+
+// ccittBlackCodes are the terminating (0-63) and make-up (64-1728) codes for black runs from T.4 tables 2 and 3.
+var ccittBlackCodes = map[int]string{
+	0: "0000110111", 1: "010", 2: "11", 3: "10", 4: "011", 5: "0011", 6: "0010", 7: "00011",
+	8: "000101", 9: "000100", 10: "0000100", 11: "0000101", 12: "0000111", 13: "00000100", 14: "00000111", 15: "000011000",
+	16: "0000010111", 17: "0000011000", 18: "0000001000", 19: "00001100111", 20: "00001101000", 21: "00001101100", 22: "00000110111", 23: "00000101000",
+	24: "00000010111", 25: "00000011000", 26: "000011001010", 27: "000011001011", 28: "000011001100", 29: "000011001101", 30: "000001101000", 31: "000001101001",
+	32: "000001101010", 33: "000001101011", 34: "000011010010", 35: "000011010011", 36: "000011010100", 37: "000011010101", 38: "000011010110", 39: "000011010111",
+	40: "000001101100", 41: "000001101101", 42: "000011011010", 43: "000011011011", 44: "000001010100", 45: "000001010101", 46: "000001010110", 47: "000001010111",
+	48: "000001100100", 49: "000001100101", 50: "000001010010", 51: "000001010011", 52: "000000100100", 53: "000000110111", 54: "000000111000", 55: "000000100111",
+	56: "000000101000", 57: "000001011000", 58: "000001011001", 59: "000000101011", 60: "000000101100", 61: "000001011010", 62: "000001100110", 63: "000001100111",
+
+	64: "0000001111", 128: "000011001000", 192: "000011001001", 256: "000001011011", 320: "000000110011", 384: "000000110100",
+	448: "000000110101", 512: "0000001101100", 576: "0000001101101", 640: "0000001001010", 704: "0000001001011",
+	768: "0000001001100", 832: "0000001001101", 896: "0000001110010", 960: "0000001110011", 1024: "0000001110100",
+	1088: "0000001110101", 1152: "0000001110110", 1216: "0000001110111", 1280: "0000001010010", 1344: "0000001010011",
+	1408: "0000001010100", 1472: "0000001010101", 1536: "0000001011010", 1600: "0000001011011", 1664: "0000001100100",
+	1728: "0000001100101",
+}
+
+// ccittExtendedMakeUpCodes are the make-up codes shared by white and black runs from T.4 table 3.
+var ccittExtendedMakeUpCodes = map[int]string{
+	1792: "00000001000", 1856: "00000001100", 1920: "00000001101", 1984: "000000010010", 2048: "000000010011",
+	2112: "000000010100", 2176: "000000010101", 2240: "000000010110", 2304: "000000010111", 2368: "000000011100",
+	2432: "000000011101", 2496: "000000011110", 2560: "000000011111",
+}
+
+var (
+	ccittModeTable  = newCCITTTable(ccittModeCodes)
+	ccittWhiteTable = newCCITTTable(ccittWhiteCodes, ccittExtendedMakeUpCodes)
+	ccittBlackTable = newCCITTTable(ccittBlackCodes, ccittExtendedMakeUpCodes)
+)
+
+// newCCITTTable creates a lookup from code to value for each of the codes.
+func newCCITTTable(codeMaps ...map[int]string) map[ccittCode]int {
+	table := make(map[ccittCode]int)
+
+	for _, codes := range codeMaps {
+		for value, code := range codes {
+			var bits uint16
+			for _, bit := range code {
+				bits = bits<<1 | uint16(bit-'0')
+			}
+
+			table[ccittCode{length: uint8(len(code)), bits: bits}] = value
+		}
+	}
+
+	return table
+}
+
+// ccittDecoder holds the state while decoding a single section.
+type ccittDecoder struct {
+	data   []byte
+	bitPos int
+
+	width int
+
+	// reference and coding hold the positions of the changing elements of the previous and current rows. Rows start
+	// white, so even entries are changes to black and odd entries changes to white.
+	reference []int
+	coding    []int
+
+	output []byte
+}
+
+// peek returns the next n bits (at most 16) without consuming them. Bits beyond the end of the data are 0.
+func (decoder *ccittDecoder) peek(n int) uint16 {
+	var bits uint16
+
+	for bit := decoder.bitPos; bit < decoder.bitPos+n; bit++ {
+		bits <<= 1
+
+		if bit>>3 < len(decoder.data) {
+			bits |= uint16(decoder.data[bit>>3]>>(7-uint(bit&7))) & 1
+		}
+	}
+
+	return bits
+}
+
+// atEnd returns whether only fill (0) bits remain.
+func (decoder *ccittDecoder) atEnd() bool {
+	for bit := decoder.bitPos; bit>>3 < len(decoder.data); bit++ {
+		if decoder.data[bit>>3]&(0x80>>uint(bit&7)) != 0 {
+			return false
+		}
+
+		// Skip whole bytes of fill
+		if bit&7 == 7 {
+			for bit>>3+1 < len(decoder.data) && decoder.data[bit>>3+1] == 0 {
+				bit += 8
+			}
+		}
+	}
+
+	return true
+}
+
+func (decoder *ccittDecoder) alignToByte() {
+	decoder.bitPos = (decoder.bitPos + 7) &^ 7
+}
+
+// readCode consumes the next code found in table.
+func (decoder *ccittDecoder) readCode(table map[ccittCode]int, tableName string) (int, error) {
+	for length := 1; length <= 13; length++ {
+		if value, ok := table[ccittCode{length: uint8(length), bits: decoder.peek(length)}]; ok {
+			decoder.bitPos += length
+
+			return value, nil
+		}
+	}
+
+	return 0, &FormatError{msg: fmt.Sprintf("Invalid CCITT %s code at bit %d", tableName, decoder.bitPos)}
+}
+
+// readEOL consumes an end of line code (000000000001) preceded by any number of fill bits, if present.
+func (decoder *ccittDecoder) readEOL() bool {
+	zeros := 0
+	for decoder.bitPos+zeros < len(decoder.data)*8 && decoder.peekBit(decoder.bitPos+zeros) == 0 {
+		zeros++
+	}
+
+	if zeros < 11 || decoder.bitPos+zeros >= len(decoder.data)*8 {
+		return false
+	}
+
+	decoder.bitPos += zeros + 1
+
+	return true
+}
+
+func (decoder *ccittDecoder) peekBit(bit int) byte {
+	return decoder.data[bit>>3] >> (7 - uint(bit&7)) & 1
+}
+
+// readRun consumes the make-up codes and terminating code of a run of a single colour.
+func (decoder *ccittDecoder) readRun(white bool) (int, error) {
+	table, tableName := ccittBlackTable, "black run"
+	if white {
+		table, tableName = ccittWhiteTable, "white run"
+	}
+
+	run := 0
+	for {
+		length, err := decoder.readCode(table, tableName)
+		if err != nil {
+			return 0, err
+		}
+
+		run += length
+		if length < 64 {
+			return run, nil
+		}
+	}
+}
+
+// addChangingElement records a change of colour at position in the coding row. A change at the same position as the
+// previous change (from a run of length 0) cancels it out.
+func (decoder *ccittDecoder) addChangingElement(position int) error {
+	if position > decoder.width {
+		return &FormatError{msg: fmt.Sprintf("CCITT run extends to %d, beyond the row width %d", position, decoder.width)}
+	}
+	if position == decoder.width {
+		return nil
+	}
+
+	if last := len(decoder.coding) - 1; last >= 0 && decoder.coding[last] == position {
+		decoder.coding = decoder.coding[:last]
+	} else {
+		decoder.coding = append(decoder.coding, position)
+	}
+
+	return nil
+}
+
+// decode1DRow decodes a row of alternating white and black runs.
+func (decoder *ccittDecoder) decode1DRow() error {
+	decoder.coding = decoder.coding[:0]
+
+	white := true
+	for position := 0; position < decoder.width; white = !white {
+		run, err := decoder.readRun(white)
+		if err != nil {
+			return err
+		}
+
+		position += run
+		if err := decoder.addChangingElement(position); err != nil {
+			return err
+		}
+	}
+
+	return decoder.finishRow()
+}
+
+// decode2DRow decodes a row coded relative to the reference (previous) row.
+func (decoder *ccittDecoder) decode2DRow() error {
+	decoder.coding = decoder.coding[:0]
+
+	a0 := -1
+	white := true
+	for a0 < decoder.width {
+		b1, b2 := decoder.findB1B2(a0, white)
+
+		mode, err := decoder.readCode(ccittModeTable, "mode")
+		if err != nil {
+			return err
+		}
+
+		switch mode {
+		case ccittModePass:
+			a0 = b2
+		case ccittModeHorizontal:
+			if a0 < 0 {
+				a0 = 0
+			}
+
+			run1, err := decoder.readRun(white)
+			if err != nil {
+				return err
+			}
+			run2, err := decoder.readRun(!white)
+			if err != nil {
+				return err
+			}
+
+			if err := decoder.addChangingElement(a0 + run1); err != nil {
+				return err
+			}
+			if err := decoder.addChangingElement(a0 + run1 + run2); err != nil {
+				return err
+			}
+
+			a0 += run1 + run2
+		case ccittModeExtension:
+			return &FormatError{msg: "CCITT uncompressed mode is not supported"}
+		default:
+			a1 := b1 + [...]int{0, 1, 2, 3, -1, -2, -3}[mode-ccittModeVertical0]
+			if a1 < 0 || a1 < a0 {
+				return &FormatError{msg: fmt.Sprintf("Invalid CCITT vertical mode at bit %d", decoder.bitPos)}
+			}
+
+			if err := decoder.addChangingElement(a1); err != nil {
+				return err
+			}
+
+			a0 = a1
+			white = !white
+		}
+	}
+
+	return decoder.finishRow()
+}
+
+// findB1B2 returns the first changing element on the reference row to the right of a0 which changes to the opposite
+// colour of a0, along with the following changing element. The row width is used when there is no such element.
+func (decoder *ccittDecoder) findB1B2(a0 int, white bool) (int, int) {
+	for index, position := range decoder.reference {
+		// Even entries are changes to black
+		if position > a0 && (index%2 == 0) == white {
+			if index+1 < len(decoder.reference) {
+				return position, decoder.reference[index+1]
+			}
+
+			return position, decoder.width
+		}
+	}
+
+	return decoder.width, decoder.width
+}
+
+// finishRow writes the coding row to the output, with black pixels as 1 bits, and makes it the reference row.
+func (decoder *ccittDecoder) finishRow() error {
+	row := make([]byte, (decoder.width+7)/8)
+
+	for index := 0; index < len(decoder.coding); index += 2 {
+		end := decoder.width
+		if index+1 < len(decoder.coding) {
+			end = decoder.coding[index+1]
+		}
+
+		for x := decoder.coding[index]; x < end; x++ {
+			row[x>>3] |= 0x80 >> uint(x&7)
+		}
+	}
+
+	decoder.output = append(decoder.output, row...)
+	decoder.reference, decoder.coding = decoder.coding, decoder.reference
+
+	return nil
+}
+
+// decodeModifiedHuffman decodes rows of 1D codes without end of line codes, each starting on a byte boundary.
+func (decoder *ccittDecoder) decodeModifiedHuffman() error {
+	for !decoder.atEnd() {
+		if err := decoder.decode1DRow(); err != nil {
+			return err
+		}
+
+		decoder.alignToByte()
+	}
+
+	return nil
+}
+
+// decodeT4 decodes rows which are each preceded by an end of line code, ending at the return to control (a sequence
+// of end of line codes) or the end of the data.
+func (decoder *ccittDecoder) decodeT4(t4Options uint32) error {
+	if t4Options&t4OptionsUncompressed != 0 {
+		return &FormatError{msg: "CCITT uncompressed mode is not supported"}
+	}
+
+	twoDimensional := t4Options&t4Options2D != 0
+
+	for !decoder.atEnd() {
+		eols := 0
+		for decoder.readEOL() {
+			eols++
+
+			if twoDimensional {
+				break
+			}
+		}
+
+		if decoder.atEnd() || eols > 1 {
+			break
+		}
+
+		oneDimensional := true
+		if twoDimensional {
+			oneDimensional = decoder.peek(1) == 1
+			decoder.bitPos++
+
+			// In 2D coding the return to control is a sequence of end of line codes each followed by a 1 bit
+			if decoder.readEOL() {
+				break
+			}
+		}
+
+		var err error
+		if oneDimensional {
+			err = decoder.decode1DRow()
+		} else {
+			err = decoder.decode2DRow()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeT6 decodes rows coded relative to the previous row, with the first row coded relative to a white row.
+func (decoder *ccittDecoder) decodeT6() error {
+	for !decoder.atEnd() {
+		// End of facsimile block
+		if decoder.readEOL() {
+			break
+		}
+
+		if err := decoder.decode2DRow(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
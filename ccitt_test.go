@@ -0,0 +1,478 @@
+package gobio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AlanRace/go-bio/test/tifftest"
+	"golang.org/x/image/ccitt"
+)
+
+// ccittWriter encodes rows using the same code tables as the decoder. The streams are checked against
+// golang.org/x/image/ccitt to make sure that they follow the specification.
+type ccittWriter struct {
+	data   []byte
+	bitPos int
+}
+
+func (writer *ccittWriter) write(code string) {
+	for _, bit := range code {
+		if writer.bitPos>>3 == len(writer.data) {
+			writer.data = append(writer.data, 0)
+		}
+		if bit == '1' {
+			writer.data[writer.bitPos>>3] |= 0x80 >> uint(writer.bitPos&7)
+		}
+
+		writer.bitPos++
+	}
+}
+
+func (writer *ccittWriter) align() {
+	writer.bitPos = (writer.bitPos + 7) &^ 7
+}
+
+// writeEOL writes an end of line code, with fill bits so that it ends on a byte boundary when alignEOL is set.
+func (writer *ccittWriter) writeEOL(alignEOL bool) {
+	if alignEOL {
+		for (writer.bitPos+12)%8 != 0 {
+			writer.write("0")
+		}
+	}
+
+	writer.write("000000000001")
+}
+
+func (writer *ccittWriter) writeRun(white bool, run int) {
+	codes := ccittBlackCodes
+	if white {
+		codes = ccittWhiteCodes
+	}
+
+	for run >= 2560 {
+		writer.write(ccittExtendedMakeUpCodes[2560])
+		run -= 2560
+	}
+	if makeUp := run / 64 * 64; makeUp > 1728 {
+		writer.write(ccittExtendedMakeUpCodes[makeUp])
+	} else if makeUp > 0 {
+		writer.write(codes[makeUp])
+	}
+
+	writer.write(codes[run%64])
+}
+
+func (writer *ccittWriter) write1DRow(coding []int, width int) {
+	position := 0
+	white := true
+
+	for _, change := range append(coding, width) {
+		writer.writeRun(white, change-position)
+
+		position = change
+		white = !white
+	}
+
+	// A row ending in black needs a final white run of 0 to be complete
+	if len(coding)%2 == 0 && position < width {
+		writer.writeRun(white, width-position)
+	}
+}
+
+func (writer *ccittWriter) write2DRow(reference, coding []int, width int) {
+	decoder := &ccittDecoder{reference: reference, width: width}
+
+	nextChange := func(a0 int) int {
+		for _, position := range coding {
+			if position > a0 {
+				return position
+			}
+		}
+
+		return width
+	}
+
+	a0 := -1
+	white := true
+	for a0 < width {
+		b1, b2 := decoder.findB1B2(a0, white)
+		a1 := nextChange(a0)
+
+		switch {
+		case b2 < a1:
+			writer.write(ccittModeCodes[ccittModePass])
+			a0 = b2
+		case a1-b1 >= -3 && a1-b1 <= 3:
+			writer.write(ccittModeCodes[map[int]int{0: ccittModeVertical0, 1: ccittModeVerticalR1, 2: ccittModeVerticalR2,
+				3: ccittModeVerticalR3, -1: ccittModeVerticalL1, -2: ccittModeVerticalL2, -3: ccittModeVerticalL3}[a1-b1]])
+			a0 = a1
+			white = !white
+		default:
+			a2 := nextChange(a1)
+			if a0 < 0 {
+				a0 = 0
+			}
+
+			writer.write(ccittModeCodes[ccittModeHorizontal])
+			writer.writeRun(white, a1-a0)
+			writer.writeRun(!white, a2-a1)
+			a0 = a2
+		}
+	}
+}
+
+// newCCITTRows creates rows of random runs, including runs longer than the largest make-up codes, along with the
+// changing elements of each row.
+func newCCITTRows(width, height int) ([][]int, []byte) {
+	random := rand.New(rand.NewSource(int64(width)))
+
+	rows := make([][]int, height)
+	for y := range rows {
+		switch {
+		case y == 1:
+			// A blank row
+		case y > 0 && y%3 == 0:
+			// Similar to the previous row, for vertical mode
+			for index, position := range rows[y-1] {
+				position += random.Intn(3) - 1
+				if position > 0 && position < width && (index == 0 || position > rows[y][len(rows[y])-1]) {
+					rows[y] = append(rows[y], position)
+				}
+			}
+		default:
+			maxRun := 20
+			if y%4 == 2 {
+				maxRun = width
+			}
+
+			for position := random.Intn(maxRun); position < width; position += 1 + random.Intn(maxRun) {
+				rows[y] = append(rows[y], position)
+			}
+		}
+	}
+
+	bytesPerRow := (width + 7) / 8
+	packed := make([]byte, height*bytesPerRow)
+	for y, row := range rows {
+		for index := 0; index < len(row); index += 2 {
+			end := width
+			if index+1 < len(row) {
+				end = row[index+1]
+			}
+
+			for x := row[index]; x < end; x++ {
+				packed[y*bytesPerRow+x/8] |= 0x80 >> uint(x%8)
+			}
+		}
+	}
+
+	return rows, packed
+}
+
+func encodeModifiedHuffman(rows [][]int, width int) []byte {
+	writer := &ccittWriter{}
+	for _, row := range rows {
+		writer.write1DRow(row, width)
+		writer.align()
+	}
+
+	return writer.data
+}
+
+func encodeT4(rows [][]int, width int, t4Options uint32) []byte {
+	writer := &ccittWriter{}
+	twoDimensional := t4Options&t4Options2D != 0
+	alignEOL := t4Options&t4OptionsFillBits != 0
+
+	var reference []int
+	for y, row := range rows {
+		writer.writeEOL(alignEOL)
+
+		if !twoDimensional {
+			writer.write1DRow(row, width)
+		} else if y%4 == 0 {
+			writer.write("1")
+			writer.write1DRow(row, width)
+		} else {
+			writer.write("0")
+			writer.write2DRow(reference, row, width)
+		}
+
+		reference = row
+	}
+
+	// Return to control
+	for eol := 0; eol < 6; eol++ {
+		writer.writeEOL(false)
+		if twoDimensional {
+			writer.write("1")
+		}
+	}
+
+	return writer.data
+}
+
+func encodeT6(rows [][]int, width int) []byte {
+	writer := &ccittWriter{}
+
+	var reference []int
+	for _, row := range rows {
+		writer.write2DRow(reference, row, width)
+		reference = row
+	}
+
+	// End of facsimile block
+	writer.writeEOL(false)
+	writer.writeEOL(false)
+
+	return writer.data
+}
+
+func TestCCITTReferenceEncoder(t *testing.T) {
+	const width, height = 2900, 12
+
+	rows, packed := newCCITTRows(width, height)
+
+	// golang.org/x/image/ccitt returns white as 1 bits, with the padding at the end of each row as 0 bits
+	bytesPerRow := (width + 7) / 8
+	inverted := make([]byte, len(packed))
+	for index := range packed {
+		inverted[index] = ^packed[index]
+
+		if index%bytesPerRow == bytesPerRow-1 {
+			inverted[index] &^= byte(1<<uint(8-width%8) - 1)
+		}
+	}
+
+	streams := []struct {
+		name      string
+		subFormat ccitt.SubFormat
+		data      []byte
+	}{
+		{"T.4", ccitt.Group3, encodeT4(rows, width, 0)},
+		{"T.6", ccitt.Group4, encodeT6(rows, width)},
+	}
+
+	for _, stream := range streams {
+		decoded, err := io.ReadAll(ccitt.NewReader(bytes.NewReader(stream.data), ccitt.MSB, stream.subFormat, width, height, nil))
+		if err != nil {
+			t.Fatalf("%s: %v", stream.name, err)
+		}
+
+		if !bytes.Equal(decoded, inverted) {
+			t.Errorf("%s: reference encoder does not match golang.org/x/image/ccitt", stream.name)
+		}
+	}
+}
+
+func TestCCITTCompression(t *testing.T) {
+	const width, height, rowsPerStrip = 2900, 12, 8
+
+	rows, packed := newCCITTRows(width, height)
+	bytesPerRow := (width + 7) / 8
+
+	tests := []struct {
+		name        string
+		compression CompressionID
+		t4Options   uint32
+		encode      func(rows [][]int) []byte
+	}{
+		{"ModifiedHuffman", CCIT1D, 0, func(rows [][]int) []byte { return encodeModifiedHuffman(rows, width) }},
+		{"T.4 1D", CCITGroup3, 0, func(rows [][]int) []byte { return encodeT4(rows, width, 0) }},
+		{"T.4 1D FillBits", CCITGroup3, t4OptionsFillBits, func(rows [][]int) []byte { return encodeT4(rows, width, t4OptionsFillBits) }},
+		{"T.4 2D", CCITGroup3, t4Options2D, func(rows [][]int) []byte { return encodeT4(rows, width, t4Options2D) }},
+		{"T.4 2D FillBits", CCITGroup3, t4Options2D | t4OptionsFillBits, func(rows [][]int) []byte { return encodeT4(rows, width, t4Options2D|t4OptionsFillBits) }},
+		{"T.6", CCITGroup4, 0, func(rows [][]int) []byte { return encodeT6(rows, width) }},
+	}
+
+	for _, test := range tests {
+		for _, fillOrder := range []uint16{1, 2} {
+			strips := [][]byte{test.encode(rows[:rowsPerStrip]), test.encode(rows[rowsPerStrip:])}
+			if fillOrder == 2 {
+				for _, strip := range strips {
+					for index := range strip {
+						strip[index] = reverseBitsTable[strip[index]]
+					}
+				}
+			}
+
+			builder := tifftest.New(binary.LittleEndian, false)
+			directory := builder.AddDirectory().
+				Add(uint16(ImageWidth), tifftest.Long, []uint32{width}).
+				Add(uint16(ImageLength), tifftest.Long, []uint32{height}).
+				Add(uint16(BitsPerSample), tifftest.Short, []uint16{1}).
+				Add(uint16(Compression), tifftest.Short, []uint16{uint16(test.compression)}).
+				Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(WhiteIsZero)}).
+				Add(uint16(FillOrder), tifftest.Short, []uint16{fillOrder}).
+				Add(uint16(RowsPerStrip), tifftest.Long, []uint32{rowsPerStrip}).
+				Strips(strips...)
+			if test.compression == CCITGroup3 {
+				directory.Add(uint16(T4Options), tifftest.Long, []uint32{test.t4Options})
+			}
+			data := builder.Bytes()
+
+			tiffFile, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{Strict: true})
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			ifd := tiffFile.GetIFD(0)
+
+			for index, wantRows := range [][]byte{packed[:rowsPerStrip*bytesPerRow], packed[rowsPerStrip*bytesPerRow:]} {
+				got, err := ifd.GetData(ifd.GetSection(uint32(index)))
+				if err != nil {
+					t.Fatalf("%s FillOrder %d strip %d: %v", test.name, fillOrder, index, err)
+				}
+
+				if !bytes.Equal(got, wantRows) {
+					t.Errorf("%s FillOrder %d strip %d: decoded data is incorrect", test.name, fillOrder, index)
+				}
+			}
+
+			img, err := ifd.GetImage()
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+
+			gray := img.(*image.Gray)
+			for x := 0; x < width; x++ {
+				wantWhite := packed[x/8]&(0x80>>uint(x%8)) == 0
+				if (gray.GrayAt(x, 0).Y == 255) != wantWhite {
+					t.Fatalf("%s: pixel (%d, 0) has the wrong colour", test.name, x)
+				}
+			}
+		}
+	}
+}
+
+func TestCCITTLibTIFFFixture(t *testing.T) {
+	// Written by libtiff 4.5.0 as T.4 with 2D coding and fill bits (T4Options 5), FillOrder 2 and 16 rows per strip.
+	// Black pixels follow the formula below.
+	const width, height = 301, 40
+	black := func(x, y int) bool {
+		return (x/7+y/3)%3 == 0 || (x*x+4*y*y)%97 < 20
+	}
+
+	data, err := os.ReadFile(filepath.Join("testdata", "ccitt-g3-2d-fill-lsb.tif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tiffFile, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifd := tiffFile.GetIFD(0)
+
+	compression, err := ifd.GetCompression()
+	if err != nil || compression != CCITGroup3 {
+		t.Fatalf("expected %v compression, found %v (%v)", CCITGroup3, compression, err)
+	}
+	if value := ifd.GetLongTagValue(T4Options); value != t4Options2D|t4OptionsFillBits {
+		t.Fatalf("expected T4Options %d, found %d", t4Options2D|t4OptionsFillBits, value)
+	}
+	if value, err := ifd.GetShortTagValue(FillOrder); err != nil || value != 2 {
+		t.Fatalf("expected FillOrder 2, found %d (%v)", value, err)
+	}
+
+	img, err := ifd.GetImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, width, height) {
+		t.Fatalf("expected bounds (0,0)-(%d,%d), found %v", width, height, img.Bounds())
+	}
+
+	gray := img.(*image.Gray)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if (gray.GrayAt(x, y).Y == 0) != black(x, y) {
+				t.Fatalf("pixel (%d, %d) has the wrong colour", x, y)
+			}
+		}
+	}
+}
+
+func TestCCITTBlackIsZero(t *testing.T) {
+	const width, height = 50, 6
+
+	rows, packed := newCCITTRows(width, height)
+
+	builder := tifftest.New(binary.LittleEndian, false)
+	builder.AddDirectory().
+		Add(uint16(ImageWidth), tifftest.Long, []uint32{width}).
+		Add(uint16(ImageLength), tifftest.Long, []uint32{height}).
+		Add(uint16(BitsPerSample), tifftest.Short, []uint16{1}).
+		Add(uint16(Compression), tifftest.Short, []uint16{uint16(CCITGroup4)}).
+		Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(BlackIsZero)}).
+		Add(uint16(RowsPerStrip), tifftest.Long, []uint32{height}).
+		Strips(encodeT6(rows, width))
+	data := builder.Bytes()
+
+	ifd := openBytes(t, data).GetIFD(0)
+
+	img, err := ifd.GetImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// White pixels are 1 bits, so that they are still decoded as white
+	gray := img.(*image.Gray)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			wantWhite := packed[y*((width+7)/8)+x/8]&(0x80>>uint(x%8)) == 0
+			if (gray.GrayAt(x, y).Y == 255) != wantWhite {
+				t.Fatalf("pixel (%d, %d) has the wrong colour", x, y)
+			}
+		}
+	}
+}
+
+func TestCCITTDefaultBitsPerSample(t *testing.T) {
+	const width, height = 50, 6
+
+	rows, packed := newCCITTRows(width, height)
+
+	// Fax images often leave out BitsPerSample, which then defaults to 1
+	for _, compression := range []CompressionID{Uncompressed, CCITGroup4} {
+		strip := packed
+		if compression == CCITGroup4 {
+			strip = encodeT6(rows, width)
+		}
+
+		builder := tifftest.New(binary.LittleEndian, false)
+		builder.AddDirectory().
+			Add(uint16(ImageWidth), tifftest.Long, []uint32{width}).
+			Add(uint16(ImageLength), tifftest.Long, []uint32{height}).
+			Add(uint16(Compression), tifftest.Short, []uint16{uint16(compression)}).
+			Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(WhiteIsZero)}).
+			Add(uint16(RowsPerStrip), tifftest.Long, []uint32{height}).
+			Strips(strip)
+		data := builder.Bytes()
+
+		tiffFile, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{Strict: true})
+		if err != nil {
+			t.Fatalf("%s: %v", compression, err)
+		}
+
+		img, err := tiffFile.GetIFD(0).GetImage()
+		if err != nil {
+			t.Fatalf("%s: %v", compression, err)
+		}
+
+		gray := img.(*image.Gray)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				wantWhite := packed[y*((width+7)/8)+x/8]&(0x80>>uint(x%8)) == 0
+				if (gray.GrayAt(x, y).Y == 255) != wantWhite {
+					t.Fatalf("%s: pixel (%d, %d) has the wrong colour", compression, x, y)
+				}
+			}
+		}
+	}
+}
@@ -2,13 +2,17 @@ package gobio
 
 import (
 	"bufio"
+	"bytes"
 	"compress/zlib"
 	"image"
+	"image/jpeg"
 	"io"
 	"io/ioutil"
+	"sync"
 
+	"github.com/klauspost/compress/zstd"
 	"golang.org/x/image/tiff/lzw"
-	//"github.com/AlanRace/go-bio/libjpeg"
+	"golang.org/x/image/webp"
 )
 
 var compressionNameMap = map[CompressionID]string{
@@ -62,31 +66,66 @@ func init() {
 	AddCompression(LZW, "LZW", func(dataAccess TagAccess) (CompressionMethod, error) {
 		return &LZWCompression{}, nil
 	})
-	// AddCompression(JPEG, "JPEG", func(dataAccess TagAccess) (CompressionMethod, error) {
-	// 	if dataAccess.GetTag(JPEGTables) != nil {
-	// 		tablesTag, ok := dataAccess.GetByteTag(JPEGTables) //.(*ByteTag)
-	// 		if !ok {
-	// 			return nil, &FormatError{msg: "JPEGTables not recorded as byte"}
-	// 		}
+	AddCompression(JPEG, "JPEG", func(dataAccess TagAccess) (CompressionMethod, error) {
+		compression := &JPEGCompression{}
 
-	// 		r := bytes.NewReader(tablesTag.Data)
+		if dataAccess.GetTag(JPEGTables) != nil {
+			tablesTag, ok := dataAccess.GetByteTag(JPEGTables)
+			if !ok {
+				return nil, &FormatError{msg: "JPEGTables not recorded as byte"}
+			}
 
-	// 		ljpeg, err := libjpeg.NewJPEGFromHeader(r)
-	// 		if err != nil {
-	// 			return nil, fmt.Errorf("Failed parsing JPEG header (libjpeg) %w", err)
-	// 		}
+			err := compression.SetTables(tablesTag.Data)
+			if err != nil {
+				return nil, err
+			}
+		}
 
-	// 		return &JPEGCompression{ljpeg: ljpeg}, nil
-	// 	}
+		if photometricTag, ok := dataAccess.GetTag(PhotometricInterpretation).(*ShortTag); ok && len(photometricTag.Data) > 0 {
+			compression.SetPhotometricInterpretation(photometricInterpretationTypeMap[photometricTag.Data[0]])
+		}
+
+		return compression, nil
+	})
+	AddCompression(OJPEG, "OJPEG", func(dataAccess TagAccess) (CompressionMethod, error) {
+		baseDataAccess, ok := dataAccess.(*baseDataAccess)
+		if !ok {
+			return nil, &FormatError{msg: "OJPEG requires access to the file to read the JPEG tables"}
+		}
 
-	// 	return &JPEGCompression{ljpeg: libjpeg.NewJPEG()}, nil
-	// })
+		return newOJPEGCompression(baseDataAccess)
+	})
+	for _, ccittID := range []CompressionID{CCIT1D, CCITGroup3, CCITGroup4} {
+		AddCompression(ccittID, compressionNameMap[ccittID], func(dataAccess TagAccess) (CompressionMethod, error) {
+			baseDataAccess, ok := dataAccess.(*baseDataAccess)
+			if !ok {
+				return nil, &FormatError{msg: "CCITT compression requires access to the image dimensions"}
+			}
+
+			return newCCITTCompression(baseDataAccess)
+		})
+	}
 	AddCompression(PackBits, "PackBits", func(dataAccess TagAccess) (CompressionMethod, error) {
 		return &PackBitsCompression{}, nil
 	})
+	AddCompression(LERC, "LERC", func(dataAccess TagAccess) (CompressionMethod, error) {
+		baseDataAccess, ok := dataAccess.(*baseDataAccess)
+		if !ok {
+			return nil, &FormatError{msg: "LERC requires access to the byte order of the file"}
+		}
+
+		return newLERCCompression(baseDataAccess)
+	})
+	AddCompression(Zstd, "Zstd", func(dataAccess TagAccess) (CompressionMethod, error) {
+		return &ZstdCompression{}, nil
+	})
+	AddCompression(WebP, "WebP", func(dataAccess TagAccess) (CompressionMethod, error) {
+		return &WebPCompression{}, nil
+	})
 }
 
-// CompressionMethod is an interface for decompressing a io.Reader.
+// CompressionMethod is an interface for decompressing a io.Reader. A single CompressionMethod is shared by all sections
+// of an ImageFileDirectory, so implementations must be safe for concurrent use by multiple goroutines.
 type CompressionMethod interface {
 }
 
@@ -147,6 +186,53 @@ func (*PackBitsCompression) Decompress(r io.Reader) ([]byte, error) {
 	return unpackBits(r)
 }
 
+// ZstdCompression performs Zstandard decompression of data (compression 50000).
+type ZstdCompression struct {
+}
+
+// zstdDecoder is shared by all ZstdCompressions, as DecodeAll can be used concurrently.
+var zstdDecoder struct {
+	once    sync.Once
+	decoder *zstd.Decoder
+	err     error
+}
+
+// zstdDecompress decompresses a complete Zstandard stream.
+func zstdDecompress(data []byte) ([]byte, error) {
+	zstdDecoder.once.Do(func() {
+		zstdDecoder.decoder, zstdDecoder.err = zstd.NewReader(nil)
+	})
+	if zstdDecoder.err != nil {
+		return nil, zstdDecoder.err
+	}
+
+	return zstdDecoder.decoder.DecodeAll(data, nil)
+}
+
+// Decompress decompresses an io.Reader using the Zstandard algorithm.
+func (*ZstdCompression) Decompress(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return zstdDecompress(data)
+}
+
+// WebPCompression decodes WebP compressed sections (compression 50001), each of which is a complete WebP image.
+type WebPCompression struct {
+}
+
+// Decompress decodes the WebP image in r. Lossy images are returned as image.YCbCr (or image.NYCbCrA when there is an
+// alpha channel) and lossless images as image.NRGBA.
+func (*WebPCompression) Decompress(r io.Reader) (image.Image, error) {
+	return webp.Decode(r)
+}
+
+// SetPhotometricInterpretation has no effect, as WebP always records RGB(A) data.
+func (*WebPCompression) SetPhotometricInterpretation(interpretation PhotometricInterpretationID) {
+}
+
 type byteReader interface {
 	io.Reader
 	io.ByteReader
@@ -196,32 +282,82 @@ func unpackBits(r io.Reader) ([]byte, error) {
 	}
 }
 
-// // JPEGCompression
-// type JPEGCompression struct {
-// 	//header *jpeg.JPEGHeader
-
-// 	ljpeg *libjpeg.JPEG
-// }
-
-// func NewJPEGCompression(ljpeg *libjpeg.JPEG) *JPEGCompression {
-// 	return &JPEGCompression{ljpeg: ljpeg}
-// }
-
-// // Decompress decompresses an io.Reader using the JPEG algorithm.
-// func (compression *JPEGCompression) Decompress(r io.Reader) (image.Image, error) {
-// 	return compression.ljpeg.DecodeBody(r)
-// }
-
-// func (compression *JPEGCompression) SetPhotometricInterpretation(interpretation PhotometricInterpretationID) {
-// 	switch interpretation {
-// 	case RGB:
-// 		compression.ljpeg.RGBColourSpace()
-// 	case YCbCr:
-// 		compression.ljpeg.YCbCrColourSpace()
-// 	default:
-// 		panic("Unsupported photometric interpretation !" + interpretation.String())
-// 	}
-// }
+// JPEGCompression decodes JPEG compressed sections (compression 7). Sections are usually stored as abbreviated JPEG
+// streams, which rely on the quantisation and Huffman tables stored once in the JPEGTables tag.
+type JPEGCompression struct {
+	// tables holds the contents of JPEGTables, without the SOI and EOI markers
+	tables []byte
+
+	// adobeMarker is set when an Adobe marker, recording colourTransform, should be added to each section
+	adobeMarker     bool
+	colourTransform byte
+}
+
+var (
+	jpegSOI = []byte{0xff, 0xd8}
+	jpegEOI = []byte{0xff, 0xd9}
+)
+
+// SetTables sets the tables (e.g. from the JPEGTables tag) shared by every section. tables must be a JPEG stream
+// containing only tables.
+func (compression *JPEGCompression) SetTables(tables []byte) error {
+	if len(tables) < 4 || !bytes.HasPrefix(tables, jpegSOI) {
+		return &FormatError{msg: "JPEGTables does not contain a valid JPEG stream"}
+	}
+
+	tables = tables[2:]
+	// Some writers pad JPEGTables, so find the last EOI
+	if end := bytes.LastIndex(tables, jpegEOI); end >= 0 {
+		tables = tables[:end]
+	}
+
+	compression.tables = tables
+
+	return nil
+}
+
+// Decompress decompresses an io.Reader using the JPEG algorithm, after merging the tables into the JPEG stream.
+func (compression *JPEGCompression) Decompress(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(data, jpegSOI) {
+		return nil, &FormatError{msg: "JPEG compressed section does not start with SOI"}
+	}
+
+	var stream bytes.Buffer
+	stream.Grow(len(compression.tables) + len(data) + 16)
+
+	stream.Write(jpegSOI)
+	if compression.adobeMarker {
+		writeAdobeMarker(&stream, compression.colourTransform)
+	}
+	stream.Write(compression.tables)
+	stream.Write(data[2:])
+
+	return jpeg.Decode(&stream)
+}
+
+// writeAdobeMarker writes an Adobe APP14 marker recording the colour transform of the components (0 for RGB, 1 for
+// YCbCr), which image/jpeg would otherwise guess from the component IDs.
+func writeAdobeMarker(stream *bytes.Buffer, colourTransform byte) {
+	stream.Write([]byte{0xff, 0xee, 0x00, 0x0e, 'A', 'd', 'o', 'b', 'e', 0x00, 0x64, 0x00, 0x00, 0x00, 0x00, colourTransform})
+}
+
+// SetPhotometricInterpretation describes the colour space of the components in the JPEG stream. RGB data is stored
+// without a colour transform, whereas YCbCr data is converted to RGB when decoded.
+func (compression *JPEGCompression) SetPhotometricInterpretation(interpretation PhotometricInterpretationID) {
+	switch interpretation {
+	case RGB:
+		compression.adobeMarker, compression.colourTransform = true, 0
+	case YCbCr:
+		compression.adobeMarker, compression.colourTransform = true, 1
+	default:
+		compression.adobeMarker = false
+	}
+}
 
 // Decompress decompresses the data supplied in the io.Reader using the compression method dictated by CompressionID.
 /*func (compressionID CompressionID) Decompress(r io.Reader, ifd *ImageFileDirectory) ([]byte, error) {
@@ -0,0 +1,331 @@
+package gobio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/AlanRace/go-bio/test/tifftest"
+	"github.com/klauspost/compress/zstd"
+)
+
+// splitJPEG separates a JPEG stream into a tables-only stream, as stored in JPEGTables, and an abbreviated stream
+// without the tables, as stored in each section.
+func splitJPEG(t *testing.T, data []byte) ([]byte, []byte) {
+	t.Helper()
+
+	tables := []byte{0xff, 0xd8}
+	abbreviated := []byte{0xff, 0xd8}
+
+	for offset := 2; offset < len(data); {
+		if data[offset] != 0xff {
+			t.Fatalf("expected marker at offset %d", offset)
+		}
+
+		marker := data[offset+1]
+		if marker == 0xda {
+			// The scan continues to the end of the stream
+			abbreviated = append(abbreviated, data[offset:]...)
+			break
+		}
+
+		end := offset + 2 + int(binary.BigEndian.Uint16(data[offset+2:]))
+		if marker == 0xdb || marker == 0xc4 {
+			tables = append(tables, data[offset:end]...)
+		} else {
+			abbreviated = append(abbreviated, data[offset:end]...)
+		}
+
+		offset = end
+	}
+
+	return append(tables, 0xff, 0xd9), abbreviated
+}
+
+// newJPEGTile encodes a tile with a gradient, so that different tiles have different content.
+func newJPEGTile(t *testing.T, size, seed int) (image.Image, []byte) {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 8), G: uint8(y*8 + seed), B: uint8(seed * 40), A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return decoded, buf.Bytes()
+}
+
+func TestJPEGCompression(t *testing.T) {
+	const tileSize = 16
+
+	var tiles [][]byte
+	var want []image.Image
+	var tables []byte
+	for seed := 0; seed < 2; seed++ {
+		decoded, data := newJPEGTile(t, tileSize, seed)
+
+		var tile []byte
+		tables, tile = splitJPEG(t, data)
+
+		tiles = append(tiles, tile)
+		want = append(want, decoded)
+	}
+
+	for _, photometric := range []PhotometricInterpretationID{YCbCr, RGB} {
+		t.Run(photometric.String(), func(t *testing.T) {
+			builder := tifftest.New(binary.LittleEndian, false)
+			builder.AddDirectory().
+				Add(uint16(ImageWidth), tifftest.Long, []uint32{2 * tileSize}).
+				Add(uint16(ImageLength), tifftest.Long, []uint32{tileSize}).
+				Add(uint16(BitsPerSample), tifftest.Short, []uint16{8, 8, 8}).
+				Add(uint16(Compression), tifftest.Short, []uint16{uint16(JPEG)}).
+				Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(photometric)}).
+				Add(uint16(SamplesPerPixel), tifftest.Short, []uint16{3}).
+				Add(uint16(TileWidth), tifftest.Long, []uint32{tileSize}).
+				Add(uint16(TileLength), tifftest.Long, []uint32{tileSize}).
+				Add(uint16(JPEGTables), tifftest.Undefined, tables).
+				Tiles(tiles...)
+			data := builder.Bytes()
+
+			tiffFile, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{Strict: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ifd := tiffFile.GetIFD(0)
+
+			for index, wantTile := range want {
+				img, err := ifd.GetSection(uint32(index)).GetImage()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// image/jpeg encodes as YCbCr, so when the data is declared as RGB the components are returned as
+				// they are stored
+				ycbcr := wantTile.(*image.YCbCr)
+				for y := 0; y < tileSize; y++ {
+					for x := 0; x < tileSize; x++ {
+						wantColour := color.RGBAModel.Convert(wantTile.At(x, y))
+						if photometric == RGB {
+							c := ycbcr.YCbCrAt(x, y)
+							wantColour = color.RGBA{R: c.Y, G: c.Cb, B: c.Cr, A: 255}
+						}
+
+						if got := color.RGBAModel.Convert(img.At(x, y)); got != wantColour {
+							t.Fatalf("tile %d pixel (%d, %d): got %v, want %v", index, x, y, got, wantColour)
+						}
+					}
+				}
+			}
+
+			// Decoding the full image composites the tiles
+			if _, err := ifd.GetImage(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestZstdCompression(t *testing.T) {
+	const width, height, rowsPerStrip = 13, 7, 4
+
+	want := make([]uint16, width*height)
+	for index := range want {
+		want[index] = uint16(index*997 + index/width*31)
+	}
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each strip is stored with horizontal differencing applied before compression
+	var strips [][]byte
+	for row := 0; row < height; row += rowsPerStrip {
+		var strip []byte
+		for y := row; y < row+rowsPerStrip && y < height; y++ {
+			var previous uint16
+			for x := 0; x < width; x++ {
+				value := want[y*width+x]
+				strip = binary.LittleEndian.AppendUint16(strip, value-previous)
+				previous = value
+			}
+		}
+
+		strips = append(strips, encoder.EncodeAll(strip, nil))
+	}
+
+	builder := tifftest.New(binary.LittleEndian, false)
+	builder.AddDirectory().
+		Add(uint16(ImageWidth), tifftest.Long, []uint32{width}).
+		Add(uint16(ImageLength), tifftest.Long, []uint32{height}).
+		Add(uint16(BitsPerSample), tifftest.Short, []uint16{16}).
+		Add(uint16(Compression), tifftest.Short, []uint16{uint16(Zstd)}).
+		Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(BlackIsZero)}).
+		Add(uint16(RowsPerStrip), tifftest.Long, []uint32{rowsPerStrip}).
+		Add(uint16(Predictor), tifftest.Short, []uint16{uint16(PredictorHorizontal)}).
+		Strips(strips...)
+
+	img, err := openBytes(t, builder.Bytes()).GetIFD(0).GetImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gray := img.(*image.Gray16)
+	for index, value := range want {
+		if got := gray.Gray16At(index%width, index/width).Y; got != value {
+			t.Fatalf("pixel %d = %d, want %d", index, got, value)
+		}
+	}
+}
+
+// webpBitWriter writes the least significant bits first, as used by lossless WebP.
+type webpBitWriter struct {
+	data  []byte
+	nBits uint
+}
+
+func (writer *webpBitWriter) write(value uint32, n uint) {
+	for bit := uint(0); bit < n; bit++ {
+		if writer.nBits%8 == 0 {
+			writer.data = append(writer.data, 0)
+		}
+
+		writer.data[len(writer.data)-1] |= byte(value>>bit&1) << (writer.nBits % 8)
+		writer.nBits++
+	}
+}
+
+// newLosslessWebP encodes an image in which each channel takes at most two values, so that every prefix code can be
+// written as a simple code.
+func newLosslessWebP(img *image.NRGBA) []byte {
+	bounds := img.Bounds()
+
+	var writer webpBitWriter
+	writer.write(0x2f, 8)
+	writer.write(uint32(bounds.Dx()-1), 14)
+	writer.write(uint32(bounds.Dy()-1), 14)
+	writer.write(0, 1)
+	writer.write(0, 3)
+
+	// No transforms, colour cache or meta prefix codes
+	writer.write(0, 1)
+	writer.write(0, 1)
+	writer.write(0, 1)
+
+	// Prefix codes for green, red, blue and alpha, followed by distance
+	var symbols [4][]uint32
+	for channel, offset := range []int{1, 0, 2, 3} {
+		used := make(map[uint32]bool)
+		for index := offset; index < len(img.Pix); index += 4 {
+			used[uint32(img.Pix[index])] = true
+		}
+
+		for value := uint32(0); value < 256; value++ {
+			if used[value] {
+				symbols[channel] = append(symbols[channel], value)
+			}
+		}
+	}
+
+	for _, channelSymbols := range append(symbols[:], []uint32{0}) {
+		writer.write(1, 1)
+		writer.write(uint32(len(channelSymbols)-1), 1)
+		writer.write(1, 1)
+		for _, symbol := range channelSymbols {
+			writer.write(symbol, 8)
+		}
+	}
+
+	// With two symbols, the smaller is coded as 0
+	for index := 0; index < len(img.Pix); index += 4 {
+		for channel, offset := range []int{1, 0, 2, 3} {
+			if len(symbols[channel]) == 2 {
+				if uint32(img.Pix[index+offset]) == symbols[channel][0] {
+					writer.write(0, 1)
+				} else {
+					writer.write(1, 1)
+				}
+			}
+		}
+	}
+
+	if len(writer.data)%2 == 1 {
+		writer.data = append(writer.data, 0)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(12+len(writer.data)))
+	buf.WriteString("WEBPVP8L")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(writer.data)))
+	buf.Write(writer.data)
+
+	return buf.Bytes()
+}
+
+func TestWebPCompression(t *testing.T) {
+	const tileSize = 16
+
+	var tiles [][]byte
+	var want []*image.NRGBA
+	for tile := 0; tile < 2; tile++ {
+		img := image.NewNRGBA(image.Rect(0, 0, tileSize, tileSize))
+		for y := 0; y < tileSize; y++ {
+			for x := 0; x < tileSize; x++ {
+				img.SetNRGBA(x, y, color.NRGBA{
+					R: [2]uint8{30, 220}[(x+y+tile)&1],
+					G: [2]uint8{5, 128}[x/3&1],
+					B: [2]uint8{60, 61}[y&1],
+					A: 255,
+				})
+			}
+		}
+
+		tiles = append(tiles, newLosslessWebP(img))
+		want = append(want, img)
+	}
+
+	builder := tifftest.New(binary.LittleEndian, false)
+	builder.AddDirectory().
+		Add(uint16(ImageWidth), tifftest.Long, []uint32{2 * tileSize}).
+		Add(uint16(ImageLength), tifftest.Long, []uint32{tileSize}).
+		Add(uint16(BitsPerSample), tifftest.Short, []uint16{8, 8, 8}).
+		Add(uint16(Compression), tifftest.Short, []uint16{uint16(WebP)}).
+		Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(RGB)}).
+		Add(uint16(SamplesPerPixel), tifftest.Short, []uint16{3}).
+		Add(uint16(TileWidth), tifftest.Long, []uint32{tileSize}).
+		Add(uint16(TileLength), tifftest.Long, []uint32{tileSize}).
+		Tiles(tiles...)
+
+	ifd := openBytes(t, builder.Bytes()).GetIFD(0)
+
+	img, err := ifd.GetImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index, wantTile := range want {
+		for y := 0; y < tileSize; y++ {
+			for x := 0; x < tileSize; x++ {
+				wantColour := color.RGBAModel.Convert(wantTile.At(x, y))
+				if got := color.RGBAModel.Convert(img.At(index*tileSize+x, y)); got != wantColour {
+					t.Fatalf("tile %d pixel (%d, %d): got %v, want %v", index, x, y, got, wantColour)
+				}
+			}
+		}
+	}
+}
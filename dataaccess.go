@@ -5,10 +5,12 @@ import (
 	"encoding/binary"
 	"fmt"
 	"image"
+	"image/color"
 	"io"
-	"sync"
+	"math"
 
 	tiffimage "github.com/AlanRace/go-bio/image"
+	tiffcolor "github.com/AlanRace/go-bio/image/color"
 )
 
 type DataAccess interface {
@@ -19,11 +21,13 @@ type DataAccess interface {
 	//GetDataIndexAt(x uint32, y uint32) uint32
 	GetCompressedData(section *Section) ([]byte, error)
 	GetData(section *Section) ([]byte, error)
+	GetPlaneData(section *Section, plane uint16) ([]byte, error)
 	GetImage(section *Section) (image.Image, error)
 
 	//GetFullData() ([]byte, error)
 
 	GetPhotometricInterpretation() PhotometricInterpretationID
+	GetPlanarConfiguration() PlanarConfigurationID
 	GetPredictor() PredictorID
 	GetSamplesPerPixel() uint16
 
@@ -37,8 +41,6 @@ type baseDataAccess struct {
 	tiffFile *File
 	ifd      *ImageFileDirectory
 
-	mux sync.Mutex
-
 	imageWidth  uint32
 	imageLength uint32
 
@@ -52,8 +54,13 @@ type baseDataAccess struct {
 	bitsPerSample   []uint16
 	samplesPerPixel uint16
 
-	offsets    []int64
-	byteCounts []int64
+	planarConfiguration PlanarConfigurationID
+
+	// sectionsPerPlane is the number of strips or tiles needed to cover the image. When the data is planar, offsets
+	// and byteCounts hold the sections of each plane in turn.
+	sectionsPerPlane uint32
+	offsets          []int64
+	byteCounts       []int64
 }
 
 func (dataAccess *baseDataAccess) initialiseDataAccess(ifd *ImageFileDirectory) error {
@@ -77,12 +84,23 @@ func (dataAccess *baseDataAccess) initialiseDataAccess(ifd *ImageFileDirectory)
 		return err
 	}
 
-	bitsPerSampleTag, ok := ifd.Tags[BitsPerSample].(*ShortTag)
-	if !ok {
-		return &FormatError{msg: "BitsPerSample tag appears to be missing"}
+	dataAccess.planarConfiguration, err = ifd.GetPlanarConfiguration()
+	if err != nil {
+		return err
 	}
 
-	dataAccess.bitsPerSample = bitsPerSampleTag.Data
+	switch bitsPerSampleTag := ifd.Tags[BitsPerSample].(type) {
+	case nil:
+		// BitsPerSample defaults to 1 for every sample when not present, as in many bilevel (fax) images
+		dataAccess.bitsPerSample = make([]uint16, dataAccess.samplesPerPixel)
+		for index := range dataAccess.bitsPerSample {
+			dataAccess.bitsPerSample[index] = 1
+		}
+	case *ShortTag:
+		dataAccess.bitsPerSample = bitsPerSampleTag.Data
+	default:
+		return fmt.Errorf("%w: BitsPerSample should be SHORT, found %v", ErrInvalidTagValue, bitsPerSampleTag)
+	}
 
 	createFunction := compressionFuncMap[dataAccess.compressionID]
 	if createFunction != nil {
@@ -121,6 +139,19 @@ func (dataAccess *baseDataAccess) GetPhotometricInterpretation() PhotometricInte
 	return dataAccess.photometricInterpretation
 }
 
+func (dataAccess *baseDataAccess) GetPlanarConfiguration() PlanarConfigurationID {
+	return dataAccess.planarConfiguration
+}
+
+// planes returns the number of planes the data is stored in, which is 1 unless the data is planar.
+func (dataAccess *baseDataAccess) planes() uint16 {
+	if dataAccess.planarConfiguration == PlanarConfigurationPlanar {
+		return dataAccess.samplesPerPixel
+	}
+
+	return 1
+}
+
 func (dataAccess *baseDataAccess) GetPredictor() PredictorID {
 	return dataAccess.predictor
 }
@@ -133,34 +164,160 @@ func (dataAccess *baseDataAccess) GetImageDimensions() (uint32, uint32) {
 	return dataAccess.imageWidth, dataAccess.imageLength
 }
 
-// GetCompressedData returns the data as it is found in the file, without decompression
+// GetCompressedData returns the data as it is found in the file, without decompression. When the data is planar this
+// is the data of the first plane. Data is read with ReadAt so this is safe to call from multiple goroutines at once.
 func (dataAccess *baseDataAccess) GetCompressedData(section *Section) ([]byte, error) {
-	var byteData []byte
+	return dataAccess.readSection(section.Index)
+}
+
+// readSection reads the data stored at the specified index in the offsets.
+func (dataAccess *baseDataAccess) readSection(index uint32) ([]byte, error) {
+	if int(index) >= len(dataAccess.offsets) || int(index) >= len(dataAccess.byteCounts) {
+		return nil, &FormatError{msg: fmt.Sprintf("No offset recorded for section %d (%d offsets, %d byte counts)", index, len(dataAccess.offsets), len(dataAccess.byteCounts))}
+	}
 
-	offset := dataAccess.offsets[section.Index]
-	dataSize := dataAccess.byteCounts[section.Index]
+	offset := dataAccess.offsets[index]
+	dataSize := dataAccess.byteCounts[index]
 
-	//log.Printf("About to read %d bytes from %d (%d)\n", dataSize, offset, int64(offset))
+	if offset < 0 || dataSize < 0 || offset+dataSize > dataAccess.tiffFile.size {
+		return nil, &FormatError{msg: fmt.Sprintf("Section %d (offset %d, %d bytes) lies outside of the file (%d bytes)", index, offset, dataSize, dataAccess.tiffFile.size)}
+	}
+
+	return dataAccess.readAt(offset, dataSize)
+}
+
+// readAt reads size bytes from the file, starting at offset.
+func (dataAccess *baseDataAccess) readAt(offset, size int64) ([]byte, error) {
+	if offset < 0 || size < 0 || offset+size > dataAccess.tiffFile.size {
+		return nil, fmt.Errorf("%w: %d bytes at offset %d", ErrTruncated, size, offset)
+	}
 
-	byteData = make([]byte, dataSize)
+	byteData := make([]byte, size)
+
+	n, err := dataAccess.tiffFile.reader.ReadAt(byteData, offset)
+	// ReadAt is permitted to return io.EOF when the read finishes exactly at the end of the data
+	if err != nil && !(err == io.EOF && n == len(byteData)) {
+		return nil, err
+	}
+
+	return byteData, nil
+}
+
+// GetData returns the uncompressed data of the section, with the samples of each pixel stored together. When the data
+// is planar, the planes are interleaved.
+func (dataAccess *baseDataAccess) GetData(section *Section) ([]byte, error) {
+	if dataAccess.planarConfiguration != PlanarConfigurationPlanar {
+		return dataAccess.decompressSection(section.Index, 0, int(dataAccess.samplesPerPixel))
+	}
+
+	planes := make([][]byte, dataAccess.samplesPerPixel)
+	for plane := range planes {
+		var err error
+
+		planes[plane], err = dataAccess.decompressSection(uint32(plane)*dataAccess.sectionsPerPlane+section.Index, plane, 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return dataAccess.interleavePlanes(planes)
+}
+
+// GetPlaneData returns the uncompressed data of a single sample (e.g. the red channel) of the section. When the data
+// is chunky, the samples are extracted from the pixels.
+func (dataAccess *baseDataAccess) GetPlaneData(section *Section, plane uint16) ([]byte, error) {
+	if plane >= dataAccess.samplesPerPixel {
+		return nil, &FormatError{msg: fmt.Sprintf("Plane %d requested, but there are only %d samples per pixel", plane, dataAccess.samplesPerPixel)}
+	}
+
+	if dataAccess.planarConfiguration == PlanarConfigurationPlanar {
+		return dataAccess.decompressSection(uint32(plane)*dataAccess.sectionsPerPlane+section.Index, int(plane), 1)
+	}
 
-	dataAccess.mux.Lock()
-	_, err := dataAccess.tiffFile.file.Seek(int64(offset), io.SeekStart)
+	data, err := dataAccess.GetData(section)
 	if err != nil {
 		return nil, err
 	}
-	err = binary.Read(dataAccess.tiffFile.file, dataAccess.tiffFile.header.Endian, &byteData)
+
+	sampleSizes, err := dataAccess.sampleSizes()
 	if err != nil {
 		return nil, err
 	}
-	dataAccess.mux.Unlock()
 
-	//log.Printf("GetCompressedData(%d): offset = %d, dataSize = %d. Returned size = %d\n", index, offset, dataSize, len(byteData))
+	pixelSize := 0
+	sampleOffset := 0
+	for sample, size := range sampleSizes {
+		if sample < int(plane) {
+			sampleOffset += size
+		}
+		pixelSize += size
+	}
+
+	sampleSize := sampleSizes[plane]
+	numPixels := len(data) / pixelSize
+	planeData := make([]byte, numPixels*sampleSize)
 
-	return byteData, nil
+	for pixel := 0; pixel < numPixels; pixel++ {
+		copy(planeData[pixel*sampleSize:(pixel+1)*sampleSize], data[pixel*pixelSize+sampleOffset:])
+	}
+
+	return planeData, nil
 }
 
-func (dataAccess *baseDataAccess) GetData(section *Section) ([]byte, error) {
+// sampleSizes returns the size in bytes of each sample in a pixel.
+func (dataAccess *baseDataAccess) sampleSizes() ([]int, error) {
+	if len(dataAccess.bitsPerSample) < int(dataAccess.samplesPerPixel) {
+		return nil, &FormatError{msg: fmt.Sprintf("BitsPerSample %v does not describe all %d samples", dataAccess.bitsPerSample, dataAccess.samplesPerPixel)}
+	}
+
+	sizes := make([]int, dataAccess.samplesPerPixel)
+	for sample := range sizes {
+		if dataAccess.bitsPerSample[sample]%8 != 0 {
+			return nil, &FormatError{msg: fmt.Sprintf("Unable to separate samples with BitsPerSample %v", dataAccess.bitsPerSample)}
+		}
+
+		sizes[sample] = int(dataAccess.bitsPerSample[sample] / 8)
+	}
+
+	return sizes, nil
+}
+
+// interleavePlanes combines the data of each plane so that the samples of each pixel are stored together.
+func (dataAccess *baseDataAccess) interleavePlanes(planes [][]byte) ([]byte, error) {
+	sampleSizes, err := dataAccess.sampleSizes()
+	if err != nil {
+		return nil, err
+	}
+
+	// Use the smallest plane, in case one is truncated
+	numPixels := -1
+	pixelSize := 0
+	for plane, data := range planes {
+		if numPixels < 0 || len(data)/sampleSizes[plane] < numPixels {
+			numPixels = len(data) / sampleSizes[plane]
+		}
+		pixelSize += sampleSizes[plane]
+	}
+
+	data := make([]byte, numPixels*pixelSize)
+
+	sampleOffset := 0
+	for plane, planeData := range planes {
+		sampleSize := sampleSizes[plane]
+
+		for pixel := 0; pixel < numPixels; pixel++ {
+			copy(data[pixel*pixelSize+sampleOffset:], planeData[pixel*sampleSize:(pixel+1)*sampleSize])
+		}
+
+		sampleOffset += sampleSize
+	}
+
+	return data, nil
+}
+
+// decompressSection returns the uncompressed data stored at the specified index in the offsets, after reversing any
+// predictor. The data holds samplesPerPixel samples for each pixel, starting with the sample firstSample.
+func (dataAccess *baseDataAccess) decompressSection(index uint32, firstSample, samplesPerPixel int) ([]byte, error) {
 	var data []byte
 	var err error
 
@@ -169,7 +326,7 @@ func (dataAccess *baseDataAccess) GetData(section *Section) ([]byte, error) {
 		var r io.Reader
 		var byteData []byte
 
-		byteData, err = dataAccess.GetCompressedData(section)
+		byteData, err = dataAccess.readSection(index)
 		if err != nil {
 			return nil, err
 		}
@@ -177,7 +334,7 @@ func (dataAccess *baseDataAccess) GetData(section *Section) ([]byte, error) {
 
 		data, err = compression.Decompress(r)
 	case NoCompression:
-		data, err = dataAccess.GetCompressedData(section)
+		data, err = dataAccess.readSection(index)
 	default:
 		return nil, fmt.Errorf("can't use GetData when compression type is %T. Use GetImage instead", compression)
 	}
@@ -186,42 +343,40 @@ func (dataAccess *baseDataAccess) GetData(section *Section) ([]byte, error) {
 		return nil, err
 	}
 
-	// Check whether we need to apply predictor
-	if dataAccess.HasTag(Predictor) {
-		switch dataAccess.GetPredictor() {
-		case PredictorHorizontal:
-			for y := 0; y < int(section.Height); y++ {
-				for x := 1; x < int(section.Width); x++ {
-					index := (y * int(section.Width)) + x
-
-					samplesPerPixel := int(dataAccess.samplesPerPixel)
-
-					for k := 0; k < samplesPerPixel; k++ {
-						data[index*samplesPerPixel+k] += data[(index-1)*samplesPerPixel+k]
-					}
-				}
-			}
-		}
+	err = dataAccess.reversePredictor(data, firstSample, samplesPerPixel)
+	if err != nil {
+		return nil, err
 	}
 
-	return data, err
+	return data, nil
 }
 
-func (dataAccess *baseDataAccess) PixelSizeInBytes() uint32 {
-	var size uint32
+// BitsPerPixel returns the total number of bits used to store the samples of a pixel.
+func (dataAccess *baseDataAccess) BitsPerPixel() uint32 {
+	var bits uint32
 	var sampleIndex uint16
 
-	//fmt.Printf("BitsPerSample: %v\n", dataAccess.bitsPerSample)
-
-	for sampleIndex = 0; sampleIndex < dataAccess.samplesPerPixel; sampleIndex++ {
-		size += uint32(dataAccess.bitsPerSample[sampleIndex] / 8)
+	for sampleIndex = 0; sampleIndex < dataAccess.samplesPerPixel && int(sampleIndex) < len(dataAccess.bitsPerSample); sampleIndex++ {
+		bits += uint32(dataAccess.bitsPerSample[sampleIndex])
 	}
 
-	return size
+	return bits
+}
+
+// PixelSizeInBytes returns the number of bytes used to store a pixel, rounded up to a whole byte. Pixels smaller than
+// a byte are packed together, so use BytesPerRow to determine the size of data.
+func (dataAccess *baseDataAccess) PixelSizeInBytes() uint32 {
+	return (dataAccess.BitsPerPixel() + 7) / 8
+}
+
+// BytesPerRow returns the number of bytes used to store a row of width pixels. Rows always start on a byte boundary,
+// so rows of pixels smaller than a byte are padded. The size is calculated with 64 bits so that it can't overflow.
+func (dataAccess *baseDataAccess) BytesPerRow(width uint32) uint64 {
+	return (uint64(width)*uint64(dataAccess.BitsPerPixel()) + 7) / 8
 }
 
-func (dataAccess *baseDataAccess) ImageSizeInBytes() uint32 {
-	return dataAccess.imageWidth * dataAccess.imageLength * dataAccess.PixelSizeInBytes()
+func (dataAccess *baseDataAccess) ImageSizeInBytes() uint64 {
+	return uint64(dataAccess.imageLength) * dataAccess.BytesPerRow(dataAccess.imageWidth)
 }
 
 type subImager interface {
@@ -232,8 +387,15 @@ type subImager interface {
 // this is returned (after cropping to the size of the section). If compression only supports binary, then the PhotometricInterpretation
 // in the tiff header is taken into account.
 func (dataAccess *baseDataAccess) GetImage(section *Section) (image.Image, error) {
+	var img image.Image
+	var err error
+
 	switch compression := dataAccess.compression.(type) {
 	case ImageDecompressor:
+		if dataAccess.planarConfiguration == PlanarConfigurationPlanar {
+			return nil, &FormatError{msg: fmt.Sprintf("Unable to create an image from planar data compressed with %s", dataAccess.compressionID)}
+		}
+
 		var r io.Reader
 		byteData, err := dataAccess.GetCompressedData(section)
 		if err != nil {
@@ -246,86 +408,542 @@ func (dataAccess *baseDataAccess) GetImage(section *Section) (image.Image, error
 					jcompression.SetPhotometricInterpretation(dataAccess.GetPhotometricInterpretation())
 				}*/
 
-		img, err := compression.Decompress(r)
+		if sectionCompression, ok := compression.(sectionDecompressor); ok {
+			// Tiles are always stored at the full tile size, whereas the final strip only contains the remaining rows
+			width, height := dataAccess.ifd.GetSectionDimensions()
+			if !dataAccess.ifd.IsTiled() {
+				height = section.Height
+			}
 
+			img, err = sectionCompression.decompressSection(r, int(width), int(height))
+		} else {
+			img, err = compression.Decompress(r)
+		}
+		if err != nil {
+			return nil, err
+		}
+	default:
+		img, err = dataAccess.decodeImage(section)
 		if err != nil {
 			return nil, err
 		}
+	}
+
+	return cropToSection(img, section)
+}
 
-		if img.Bounds().Max.X == int(section.Width) && img.Bounds().Max.Y == int(section.Height) {
-			return img, nil
+// cropToSection removes any padding (e.g. in tiles on the right and bottom edges of the image) so that the returned
+// image is the same size as the section.
+func cropToSection(img image.Image, section *Section) (image.Image, error) {
+	if img.Bounds().Max.X == int(section.Width) && img.Bounds().Max.Y == int(section.Height) {
+		return img, nil
+	}
+
+	if img.Bounds().Max.X < int(section.Width) || img.Bounds().Max.Y < int(section.Height) {
+		return nil, &FormatError{msg: fmt.Sprintf("image section at index %d is smaller (%v) than the section (%d x %d)", section.Index, img.Bounds(), section.Width, section.Height)}
+	}
+
+	simg, ok := img.(subImager)
+	if !ok {
+		return nil, fmt.Errorf("image section at index %d is not same size as section and cannot be cropped (%T)", section.Index, img)
+	}
+
+	return simg.SubImage(image.Rect(0, 0, int(section.Width), int(section.Height))), nil
+}
+
+// storedDimensions returns the width and height of the data stored for a section. Tiles are always stored at the full
+// tile size, even when they extend beyond the edge of the image, whereas the final strip may only contain the remaining rows.
+func (dataAccess *baseDataAccess) storedDimensions(section *Section, dataSize int) (int, int, error) {
+	width, height := dataAccess.ifd.GetSectionDimensions()
+
+	bytesPerRow := dataAccess.BytesPerRow(width)
+	if bytesPerRow == 0 {
+		return 0, 0, &FormatError{msg: fmt.Sprintf("Unable to determine the size of a row of data for section %d", section.Index)}
+	}
+
+	// The image is only as large as the data, so a corrupt width or height can't cause a huge allocation
+	rows := uint64(dataSize) / bytesPerRow
+	if rows > uint64(height) {
+		rows = uint64(height)
+	}
+
+	if rows == 0 || rows < uint64(section.Height) {
+		return 0, 0, &FormatError{msg: fmt.Sprintf("Insufficient data for section %d: found %d bytes, expected at least %d", section.Index, dataSize, uint64(section.Height)*bytesPerRow)}
+	}
+
+	return int(width), int(rows), nil
+}
+
+// decodeImage creates an image from the uncompressed data of a section, based on the PhotometricInterpretation.
+func (dataAccess *baseDataAccess) decodeImage(section *Section) (image.Image, error) {
+	switch dataAccess.GetPhotometricInterpretation() {
+	case BlackIsZero, WhiteIsZero, PaletteColour:
+		if dataAccess.photometricInterpretation != PaletteColour && dataAccess.samplesPerPixel > 1 {
+			return dataAccess.decodeExtraSamples(section, 1)
 		}
 
-		simg, ok := img.(subImager)
-		if !ok {
-			return nil, fmt.Errorf("image section at index %d is not same size as section and cannot be cropped (%T)", section.Index, img)
+		fullData, err := dataAccess.GetData(section)
+		if err != nil {
+			return nil, err
 		}
 
-		return simg.SubImage(image.Rect(0, 0, int(section.Width), int(section.Height))), nil
+		width, height, err := dataAccess.storedDimensions(section, len(fullData))
+		if err != nil {
+			return nil, err
+		}
+		rect := image.Rect(0, 0, width, height)
 
-	default:
 		switch dataAccess.GetPhotometricInterpretation() {
-		case BlackIsZero:
-			fullData, err := dataAccess.GetData(section)
+		case PaletteColour:
+			return dataAccess.decodePaletted(fullData, rect)
+		case WhiteIsZero:
+			img, err := dataAccess.decodeGray(fullData, rect)
 			if err != nil {
 				return nil, err
 			}
 
-			switch dataAccess.bitsPerSample[0] {
-			case 8:
-				greyImage := image.NewGray(image.Rect(0, 0, int(section.Width), int(section.Height)))
-				greyImage.Pix = fullData
+			return invertGray(img)
+		}
 
-				return greyImage, nil
-			case 32:
-				buf := bytes.NewBuffer(fullData)
+		return dataAccess.decodeGray(fullData, rect)
+	case RGB:
+		if dataAccess.bitsPerSample[0] != 8 && dataAccess.bitsPerSample[0] != 16 {
+			return nil, &FormatError{msg: fmt.Sprintf("[GetImage>RGB] Unsupported BitsPerSample: %v", dataAccess.bitsPerSample)}
+		}
 
-				width, height := dataAccess.ifd.GetSectionDimensions()
+		sampleFormat, err := dataAccess.ifd.GetSampleFormat()
+		if err != nil {
+			return nil, err
+		}
+		if sampleFormat != SampleFormatUInt && sampleFormat != SampleFormatUndefined {
+			return nil, &FormatError{msg: fmt.Sprintf("[GetImage>RGB] Unsupported SampleFormat: %v", sampleFormat)}
+		}
 
-				greyImage := tiffimage.NewGrayFloat32(image.Rect(0, 0, int(width), int(height)))
+		if dataAccess.samplesPerPixel > 3 {
+			return dataAccess.decodeExtraSamples(section, 3)
+		}
 
-				err = binary.Read(buf, binary.LittleEndian, &greyImage.Pix)
+		fullData, err := dataAccess.GetData(section)
+		if err != nil {
+			return nil, err
+		}
 
-				// Need to update MaxValue to allow conversion to other colour formats
-				maxValue := float32(0)
-				for i := 0; i < len(greyImage.Pix); i++ {
-					if maxValue < greyImage.Pix[i] {
-						maxValue = greyImage.Pix[i]
-					}
-				}
-				greyImage.MaxValue = maxValue
+		width, height, err := dataAccess.storedDimensions(section, len(fullData))
+		if err != nil {
+			return nil, err
+		}
+
+		if dataAccess.bitsPerSample[0] == 16 {
+			return dataAccess.decodeRGB16(fullData, image.Rect(0, 0, width, height))
+		}
+
+		if dataAccess.GetSamplesPerPixel() != 3 {
+			return nil, &FormatError{msg: fmt.Sprintf("[GetImage>RGB] Unsupported SamplesPerPixel for RGB: %d", dataAccess.GetSamplesPerPixel())}
+		}
+
+		rgbImg := tiffimage.NewRGB(image.Rect(0, 0, width, height))
+		copy(rgbImg.Pix, fullData)
+		return rgbImg, nil
+	case CMYK, CIELab:
+		fullData, err := dataAccess.GetData(section)
+		if err != nil {
+			return nil, err
+		}
 
-				return greyImage, err
-			default:
-				return nil, &FormatError{msg: fmt.Sprintf("[GetImage>BlackIsZero] Unsupported BitsPerSample: %v", dataAccess.bitsPerSample)}
+		width, height, err := dataAccess.storedDimensions(section, len(fullData))
+		if err != nil {
+			return nil, err
+		}
+
+		if dataAccess.GetPhotometricInterpretation() == CMYK {
+			return dataAccess.decodeCMYK(fullData, image.Rect(0, 0, width, height))
+		}
+
+		return dataAccess.decodeCIELab(fullData, image.Rect(0, 0, width, height))
+	case YCbCr:
+		return dataAccess.decodeYCbCr(section)
+	default:
+		return nil, &FormatError{msg: "[GetImage] Unsupported PhotometricInterpretation: " + photometricInterpretationNameMap[dataAccess.GetPhotometricInterpretation()]}
+	}
+}
+
+// decodeGray creates a greyscale image from uncompressed data, using the SampleFormat and BitsPerSample to determine
+// the image type. Multi-byte samples are stored with the byte order of the file.
+func (dataAccess *baseDataAccess) decodeGray(data []byte, rect image.Rectangle) (image.Image, error) {
+	endian := dataAccess.tiffFile.header.Endian
+
+	sampleFormat, err := dataAccess.ifd.GetSampleFormat()
+	if err != nil {
+		return nil, err
+	}
+
+	bitsPerSample := dataAccess.bitsPerSample[0]
+
+	switch sampleFormat {
+	case SampleFormatUInt, SampleFormatUndefined:
+		switch bitsPerSample {
+		case 1, 2, 4:
+			// Scale the values to the full range of image.Gray
+			scale := 255 / uint8(1<<bitsPerSample-1)
+
+			greyImage := image.NewGray(rect)
+			unpackSamples(greyImage.Pix, data, rect.Dx(), rect.Dy(), int(bitsPerSample))
+			for i := range greyImage.Pix {
+				greyImage.Pix[i] *= scale
 			}
-		case RGB:
-			if dataAccess.bitsPerSample[0] != 8 {
-				return nil, &FormatError{msg: fmt.Sprintf("[GetImage>RGB] Unsupported BitsPerSample: %v", dataAccess.bitsPerSample)}
-			} else {
-				fullData, err := dataAccess.GetData(section)
-				if err != nil {
-					return nil, err
+
+			return greyImage, nil
+		case 8:
+			greyImage := image.NewGray(rect)
+			copy(greyImage.Pix, data)
+
+			return greyImage, nil
+		case 16:
+			// image.Gray16 always stores values as big endian
+			greyImage := image.NewGray16(rect)
+			for i := 0; i < len(greyImage.Pix); i += 2 {
+				binary.BigEndian.PutUint16(greyImage.Pix[i:], endian.Uint16(data[i:]))
+			}
+
+			return greyImage, nil
+		case 32:
+			greyImage := tiffimage.NewGray32(rect)
+			for i := range greyImage.Pix {
+				greyImage.Pix[i] = endian.Uint32(data[i*4:])
+			}
+
+			return greyImage, nil
+		}
+	case SampleFormatInt:
+		switch bitsPerSample {
+		case 8:
+			// There is no signed 8-bit image type, so use the 16-bit one
+			greyImage := tiffimage.NewGrayInt16(rect)
+			for i := range greyImage.Pix {
+				greyImage.Pix[i] = int16(int8(data[i]))
+			}
+
+			return greyImage, nil
+		case 16:
+			greyImage := tiffimage.NewGrayInt16(rect)
+			for i := range greyImage.Pix {
+				greyImage.Pix[i] = int16(endian.Uint16(data[i*2:]))
+			}
+
+			return greyImage, nil
+		case 32:
+			greyImage := tiffimage.NewGrayInt32(rect)
+			for i := range greyImage.Pix {
+				greyImage.Pix[i] = int32(endian.Uint32(data[i*4:]))
+			}
+
+			return greyImage, nil
+		}
+	case SampleFormatFloat:
+		switch bitsPerSample {
+		case 32:
+			greyImage := tiffimage.NewGrayFloat32(rect)
+
+			// Need to update MaxValue to allow conversion to other colour formats
+			maxValue := float32(0)
+			for i := range greyImage.Pix {
+				greyImage.Pix[i] = math.Float32frombits(endian.Uint32(data[i*4:]))
+
+				if maxValue < greyImage.Pix[i] {
+					maxValue = greyImage.Pix[i]
 				}
+			}
+			greyImage.MaxValue = maxValue
 
-				switch dataAccess.GetSamplesPerPixel() {
-				case 3:
-					rgbImg := tiffimage.NewRGB(image.Rect(0, 0, int(section.Width), int(section.Height)))
-					rgbImg.Pix = fullData
-					return rgbImg, nil
-				case 4:
-					rgbImg := image.NewRGBA(image.Rect(0, 0, int(section.Width), int(section.Height)))
-					rgbImg.Pix = fullData
-					return rgbImg, nil
-				default:
-					return nil, &FormatError{msg: fmt.Sprintf("[GetImage>RGB] Unsupported SamplesPerPixel for RGB: %d", dataAccess.GetSamplesPerPixel())}
+			return greyImage, nil
+		case 64:
+			greyImage := tiffimage.NewGrayFloat64(rect)
+
+			maxValue := float64(0)
+			for i := range greyImage.Pix {
+				greyImage.Pix[i] = math.Float64frombits(endian.Uint64(data[i*8:]))
+
+				if maxValue < greyImage.Pix[i] {
+					maxValue = greyImage.Pix[i]
 				}
 			}
-		default:
-			return nil, &FormatError{msg: "[GetImage] Unsupported PhotometricInterpretation: " + photometricInterpretationNameMap[dataAccess.GetPhotometricInterpretation()]}
+			greyImage.MaxValue = maxValue
+
+			return greyImage, nil
 		}
 	}
 
+	return nil, &FormatError{msg: fmt.Sprintf("[GetImage>BlackIsZero] Unsupported BitsPerSample %v for SampleFormat %v", dataAccess.bitsPerSample, sampleFormat)}
+}
+
+// unpackSamples expands samples of 1, 2 or 4 bits into one byte each. Each row of the packed data starts on a byte
+// boundary, with the first sample in the most significant bits.
+func unpackSamples(dst []byte, data []byte, width, height, bitsPerSample int) {
+	stride := (width*bitsPerSample + 7) / 8
+	samplesPerByte := 8 / bitsPerSample
+	mask := byte(1<<uint(bitsPerSample) - 1)
+
+	for y := 0; y < height; y++ {
+		row := data[y*stride:]
+
+		for x := 0; x < width; x++ {
+			shift := uint(8 - bitsPerSample*(x%samplesPerByte+1))
+			dst[y*width+x] = (row[x/samplesPerByte] >> shift) & mask
+		}
+	}
+}
+
+// decodeRGB16 creates an image from uncompressed 16-bit RGB data, stored with the byte order of the file.
+func (dataAccess *baseDataAccess) decodeRGB16(data []byte, rect image.Rectangle) (image.Image, error) {
+	endian := dataAccess.tiffFile.header.Endian
+
+	if dataAccess.GetSamplesPerPixel() != 3 {
+		return nil, &FormatError{msg: fmt.Sprintf("[GetImage>RGB] Unsupported SamplesPerPixel for RGB: %d", dataAccess.GetSamplesPerPixel())}
+	}
+
+	rgbImg := image.NewRGBA64(rect)
+
+	for pixel := 0; pixel < rect.Dx()*rect.Dy(); pixel++ {
+		src := data[pixel*6:]
+		dst := rgbImg.Pix[pixel*8:]
+
+		for sample := 0; sample < 3; sample++ {
+			binary.BigEndian.PutUint16(dst[sample*2:], endian.Uint16(src[sample*2:]))
+		}
+		binary.BigEndian.PutUint16(dst[6:], 0xffff)
+	}
+
+	return rgbImg, nil
+}
+
+// decodeExtraSamples creates an image from uncompressed 8 or 16-bit data with more samples than the colourSamples
+// required by the PhotometricInterpretation. When the only extra sample is alpha, image.RGBA (associated alpha) or
+// image.NRGBA (unassociated alpha), or their 64-bit equivalents, is returned. Otherwise all of the samples are
+// kept in a MultiChannel image.
+func (dataAccess *baseDataAccess) decodeExtraSamples(section *Section, colourSamples int) (image.Image, error) {
+	bytesPerSample, alpha, err := dataAccess.extraSampleLayout(colourSamples)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := dataAccess.GetData(section)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height, err := dataAccess.storedDimensions(section, len(data))
+	if err != nil {
+		return nil, err
+	}
+	rect := image.Rect(0, 0, width, height)
+
+	channels := int(dataAccess.samplesPerPixel)
+	pixelSize := channels * bytesPerSample
+	numPixels := width * height
+	data = data[:numPixels*pixelSize]
+
+	// The image types store 16-bit values as big endian
+	if bytesPerSample == 2 && dataAccess.tiffFile.header.Endian != binary.BigEndian {
+		for i := 0; i < len(data); i += 2 {
+			data[i], data[i+1] = data[i+1], data[i]
+		}
+	}
+
+	if dataAccess.photometricInterpretation == WhiteIsZero {
+		for pixel := 0; pixel < numPixels; pixel++ {
+			for i := 0; i < bytesPerSample; i++ {
+				data[pixel*pixelSize+i] = ^data[pixel*pixelSize+i]
+			}
+		}
+	}
+
+	var img image.Image
+	var pix []uint8
+
+	switch {
+	case alpha == ExtraSampleAssociatedAlpha && bytesPerSample == 1:
+		rgba := image.NewRGBA(rect)
+		img, pix = rgba, rgba.Pix
+	case alpha == ExtraSampleUnassociatedAlpha && bytesPerSample == 1:
+		nrgba := image.NewNRGBA(rect)
+		img, pix = nrgba, nrgba.Pix
+	case alpha == ExtraSampleAssociatedAlpha:
+		rgba := image.NewRGBA64(rect)
+		img, pix = rgba, rgba.Pix
+	case alpha == ExtraSampleUnassociatedAlpha:
+		nrgba := image.NewNRGBA64(rect)
+		img, pix = nrgba, nrgba.Pix
+	default:
+		multiChannel := tiffimage.NewMultiChannel(rect, channels, bytesPerSample, colourSamples)
+		copy(multiChannel.Pix, data)
+
+		return multiChannel, nil
+	}
+
+	// Greyscale values are copied to each of red, green and blue
+	for pixel := 0; pixel < numPixels; pixel++ {
+		src := data[pixel*pixelSize:]
+		dst := pix[pixel*4*bytesPerSample:]
+
+		for channel := 0; channel < 3; channel++ {
+			sample := channel
+			if sample >= colourSamples {
+				sample = colourSamples - 1
+			}
+
+			copy(dst[channel*bytesPerSample:(channel+1)*bytesPerSample], src[sample*bytesPerSample:])
+		}
+		copy(dst[3*bytesPerSample:4*bytesPerSample], src[colourSamples*bytesPerSample:])
+	}
+
+	return img, nil
+}
+
+// extraSampleLayout returns the size in bytes of each sample of data with extra samples, and the type of the extra
+// sample when there is only one (otherwise ExtraSampleUnspecified).
+func (dataAccess *baseDataAccess) extraSampleLayout(colourSamples int) (int, ExtraSampleID, error) {
+	extraSamples, err := dataAccess.ifd.GetExtraSamples()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sampleFormat, err := dataAccess.ifd.GetSampleFormat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sampleSizes, err := dataAccess.sampleSizes()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	bytesPerSample := sampleSizes[0]
+	for _, size := range sampleSizes {
+		if size != bytesPerSample || (size != 1 && size != 2) || (sampleFormat != SampleFormatUInt && sampleFormat != SampleFormatUndefined) {
+			return 0, 0, &FormatError{msg: fmt.Sprintf("[GetImage>ExtraSamples] Unsupported BitsPerSample %v for SampleFormat %v", dataAccess.bitsPerSample, sampleFormat)}
+		}
+	}
+
+	alpha := ExtraSampleUnspecified
+	if int(dataAccess.samplesPerPixel) == colourSamples+1 && len(extraSamples) > 0 {
+		alpha = extraSamples[0]
+	}
+
+	return bytesPerSample, alpha, nil
+}
+
+// colorModel returns the colour model of the image that ReadRegion returns, worked out from the tags in the same way
+// as decodeImage chooses the image type, but without reading any data. Compressions which decode to an image (e.g.
+// JPEG) are assumed to give the same type as uncompressed data.
+func (dataAccess *baseDataAccess) colorModel() (color.Model, error) {
+	photometricInterpretation := dataAccess.GetPhotometricInterpretation()
+
+	switch photometricInterpretation {
+	case BlackIsZero, WhiteIsZero:
+		if dataAccess.samplesPerPixel > 1 {
+			return dataAccess.extraSamplesColorModel(1)
+		}
+
+		model, err := dataAccess.grayColorModel()
+		if err != nil {
+			return nil, err
+		}
+		if photometricInterpretation == WhiteIsZero && model != color.GrayModel && model != color.Gray16Model {
+			return nil, &FormatError{msg: fmt.Sprintf("[GetImage>WhiteIsZero] Unable to invert BitsPerSample %v", dataAccess.bitsPerSample)}
+		}
+
+		return model, nil
+	case PaletteColour:
+		// image.Paletted uses its palette as the colour model
+		return dataAccess.palette()
+	case RGB:
+		if dataAccess.bitsPerSample[0] != 8 && dataAccess.bitsPerSample[0] != 16 {
+			return nil, &FormatError{msg: fmt.Sprintf("[GetImage>RGB] Unsupported BitsPerSample: %v", dataAccess.bitsPerSample)}
+		}
+
+		sampleFormat, err := dataAccess.ifd.GetSampleFormat()
+		if err != nil {
+			return nil, err
+		}
+		if sampleFormat != SampleFormatUInt && sampleFormat != SampleFormatUndefined {
+			return nil, &FormatError{msg: fmt.Sprintf("[GetImage>RGB] Unsupported SampleFormat: %v", sampleFormat)}
+		}
+
+		if dataAccess.samplesPerPixel > 3 {
+			return dataAccess.extraSamplesColorModel(3)
+		}
+		if dataAccess.GetSamplesPerPixel() != 3 {
+			return nil, &FormatError{msg: fmt.Sprintf("[GetImage>RGB] Unsupported SamplesPerPixel for RGB: %d", dataAccess.GetSamplesPerPixel())}
+		}
+
+		if dataAccess.bitsPerSample[0] == 16 {
+			return color.RGBA64Model, nil
+		}
+
+		return tiffcolor.RGBModel, nil
+	case CMYK:
+		return color.CMYKModel, nil
+	case CIELab, YCbCr:
+		// CIELab is converted to RGB, as is YCbCr when stitching sections together
+		return tiffcolor.RGBModel, nil
+	default:
+		return nil, &FormatError{msg: "[GetImage] Unsupported PhotometricInterpretation: " + photometricInterpretationNameMap[photometricInterpretation]}
+	}
+}
+
+// grayColorModel returns the colour model of the image that decodeGray creates.
+func (dataAccess *baseDataAccess) grayColorModel() (color.Model, error) {
+	sampleFormat, err := dataAccess.ifd.GetSampleFormat()
+	if err != nil {
+		return nil, err
+	}
+
+	var img image.Image
+
+	switch bitsPerSample := dataAccess.bitsPerSample[0]; {
+	case (sampleFormat == SampleFormatUInt || sampleFormat == SampleFormatUndefined) &&
+		(bitsPerSample == 1 || bitsPerSample == 2 || bitsPerSample == 4 || bitsPerSample == 8):
+		img = &image.Gray{}
+	case (sampleFormat == SampleFormatUInt || sampleFormat == SampleFormatUndefined) && bitsPerSample == 16:
+		img = &image.Gray16{}
+	case (sampleFormat == SampleFormatUInt || sampleFormat == SampleFormatUndefined) && bitsPerSample == 32:
+		img = &tiffimage.Gray32{}
+	case sampleFormat == SampleFormatInt && (bitsPerSample == 8 || bitsPerSample == 16):
+		img = &tiffimage.GrayInt16{}
+	case sampleFormat == SampleFormatInt && bitsPerSample == 32:
+		img = &tiffimage.GrayInt32{}
+	case sampleFormat == SampleFormatFloat && bitsPerSample == 32:
+		img = &tiffimage.GrayFloat32{}
+	case sampleFormat == SampleFormatFloat && bitsPerSample == 64:
+		img = &tiffimage.GrayFloat64{}
+	default:
+		return nil, &FormatError{msg: fmt.Sprintf("[GetImage>BlackIsZero] Unsupported BitsPerSample %v for SampleFormat %v", dataAccess.bitsPerSample, sampleFormat)}
+	}
+
+	return img.ColorModel(), nil
+}
+
+// extraSamplesColorModel returns the colour model of the image that decodeExtraSamples creates.
+func (dataAccess *baseDataAccess) extraSamplesColorModel(colourSamples int) (color.Model, error) {
+	bytesPerSample, alpha, err := dataAccess.extraSampleLayout(colourSamples)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case alpha == ExtraSampleAssociatedAlpha && bytesPerSample == 1:
+		return color.RGBAModel, nil
+	case alpha == ExtraSampleUnassociatedAlpha && bytesPerSample == 1:
+		return color.NRGBAModel, nil
+	case alpha == ExtraSampleAssociatedAlpha:
+		return color.RGBA64Model, nil
+	case alpha == ExtraSampleUnassociatedAlpha:
+		return color.NRGBA64Model, nil
+	}
+
+	multiChannel := &tiffimage.MultiChannel{ColourChannels: colourSamples}
+
+	return multiChannel.ColorModel(), nil
 }
 
 // TODO: Remove for GetImage
@@ -397,7 +1015,7 @@ func (dataAccess *StripDataAccess) GetSectionAt(x int64, y int64) *Section {
 }
 
 func (dataAccess *StripDataAccess) GetSection(index uint32) *Section {
-	if index > dataAccess.stripsInImage {
+	if index >= dataAccess.stripsInImage {
 		return nil
 	}
 
@@ -438,9 +1056,9 @@ func (dataAccess *StripDataAccess) GetSection(index uint32) *Section {
 	return &section
 }*/
 
-func (dataAccess *StripDataAccess) GetStripInBytes() uint32 {
+func (dataAccess *StripDataAccess) GetStripInBytes() uint64 {
 	//fmt.Printf("Width: %d, RowsPerStrip %d, PixelSize %v\n", dataAccess.imageWidth, dataAccess.rowsPerStrip, dataAccess.PixelSizeInBytes())
-	return dataAccess.imageWidth * dataAccess.rowsPerStrip * dataAccess.PixelSizeInBytes()
+	return uint64(dataAccess.rowsPerStrip) * dataAccess.BytesPerRow(dataAccess.imageWidth)
 }
 
 /*func (dataAccess *StripDataAccess) GetFullData() ([]byte, error) {
@@ -525,6 +1143,10 @@ func (dataAccess *TileDataAccess) GetSectionAt(x int64, y int64) *Section {
 }
 
 func (dataAccess *TileDataAccess) GetSection(index uint32) *Section {
+	if index >= dataAccess.tilesAcross*dataAccess.tilesDown {
+		return nil
+	}
+
 	var section Section
 	section.dataAccess = dataAccess
 	section.X = index % dataAccess.tilesAcross
@@ -532,15 +1154,18 @@ func (dataAccess *TileDataAccess) GetSection(index uint32) *Section {
 
 	section.Index = index
 
+	// Tiles on the right and bottom edges can extend beyond the image
 	if section.X == dataAccess.tilesAcross-1 {
 		section.Width = dataAccess.imageWidth % dataAccess.tileWidth
-	} else {
+	}
+	if section.Width == 0 {
 		section.Width = dataAccess.tileWidth
 	}
 
 	if section.Y == dataAccess.tilesDown-1 {
 		section.Height = dataAccess.imageLength % dataAccess.tileLength
-	} else {
+	}
+	if section.Height == 0 {
 		section.Height = dataAccess.tileLength
 	}
 
@@ -596,10 +1221,83 @@ func (dataAccess *TileDataAccess) GetFullData() ([]byte, error) {
 	return dataAccess.createImage(fullData)
 }*/
 
+// unavailableDataAccess stands in for the data access of an IFD whose image data can't be read by this library, for
+// example because the compression is not supported. It has no sections, and returns err whenever data is requested.
+type unavailableDataAccess struct {
+	err error
+}
+
+func (dataAccess *unavailableDataAccess) GetCompressedData(section *Section) ([]byte, error) {
+	return nil, dataAccess.err
+}
+
+func (dataAccess *unavailableDataAccess) GetData(section *Section) ([]byte, error) {
+	return nil, dataAccess.err
+}
+
+func (dataAccess *unavailableDataAccess) GetPlaneData(section *Section, plane uint16) ([]byte, error) {
+	return nil, dataAccess.err
+}
+
+func (dataAccess *unavailableDataAccess) GetImage(section *Section) (image.Image, error) {
+	return nil, dataAccess.err
+}
+
+func (dataAccess *unavailableDataAccess) colorModel() (color.Model, error) {
+	return nil, dataAccess.err
+}
+
+func (dataAccess *unavailableDataAccess) GetPhotometricInterpretation() PhotometricInterpretationID {
+	return 0
+}
+
+func (dataAccess *unavailableDataAccess) GetPlanarConfiguration() PlanarConfigurationID {
+	return 0
+}
+
+func (dataAccess *unavailableDataAccess) GetPredictor() PredictorID {
+	return PredictorNone
+}
+
+func (dataAccess *unavailableDataAccess) GetSamplesPerPixel() uint16 {
+	return 0
+}
+
+func (dataAccess *unavailableDataAccess) GetSection(index uint32) *Section {
+	return nil
+}
+
+func (dataAccess *unavailableDataAccess) GetSectionAt(x, y int64) *Section {
+	return nil
+}
+
+func (dataAccess *unavailableDataAccess) GetSectionDimensions() (uint32, uint32) {
+	return 0, 0
+}
+
+func (dataAccess *unavailableDataAccess) GetSectionGrid() (uint32, uint32) {
+	return 0, 0
+}
+
+// Bounds returns the region of the image covered by the section, in pixel coordinates of the full image.
+func (section *Section) Bounds() image.Rectangle {
+	width, height := section.dataAccess.GetSectionDimensions()
+
+	x := int(section.X) * int(width)
+	y := int(section.Y) * int(height)
+
+	return image.Rect(x, y, x+int(section.Width), y+int(section.Height))
+}
+
 func (section *Section) GetData() ([]byte, error) {
 	return section.dataAccess.GetData(section)
 }
 
+// GetPlaneData returns the uncompressed data of a single sample of the section, see DataAccess.GetPlaneData.
+func (section *Section) GetPlaneData(plane uint16) ([]byte, error) {
+	return section.dataAccess.GetPlaneData(section, plane)
+}
+
 func (section *Section) GetImage() (image.Image, error) {
 	return section.dataAccess.GetImage(section)
 }
@@ -0,0 +1,535 @@
+package gobio
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"reflect"
+	"sync"
+	"testing"
+
+	tiffimage "github.com/AlanRace/go-bio/image"
+	"github.com/AlanRace/go-bio/test/tifftest"
+)
+
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+
+	w := zlib.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+
+	return buf.Bytes()
+}
+
+// TestConcurrentGetImage decodes every section of one ImageFileDirectory from many goroutines at once. Run with
+// -race to check that the read path does not share state between goroutines.
+func TestConcurrentGetImage(t *testing.T) {
+	const width, height, rowsPerStrip = 64, 64, 4
+
+	pix := make([]byte, width*height)
+	for i := range pix {
+		pix[i] = byte(i * 7)
+	}
+
+	var strips [][]byte
+	for y := 0; y < height; y += rowsPerStrip {
+		strips = append(strips, deflate(pix[y*width:(y+rowsPerStrip)*width]))
+	}
+
+	builder := tifftest.New(binary.LittleEndian, false)
+	builder.AddDirectory().
+		Add(uint16(ImageWidth), tifftest.Long, []uint32{width}).
+		Add(uint16(ImageLength), tifftest.Long, []uint32{height}).
+		Add(uint16(BitsPerSample), tifftest.Short, []uint16{8}).
+		Add(uint16(Compression), tifftest.Short, []uint16{uint16(AdobeDeflate)}).
+		Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(BlackIsZero)}).
+		Add(uint16(SamplesPerPixel), tifftest.Short, []uint16{1}).
+		Add(uint16(RowsPerStrip), tifftest.Long, []uint32{rowsPerStrip}).
+		Strips(strips...)
+	data := builder.Bytes()
+
+	tiffFile, err := OpenReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifd := tiffFile.GetIFD(0)
+	_, numSections := ifd.GetSectionGrid()
+
+	const numGoroutines = 16
+	const iterations = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, numGoroutines)
+
+	for g := 0; g < numGoroutines; g++ {
+		wg.Add(1)
+
+		go func(g int) {
+			defer wg.Done()
+
+			for iteration := 0; iteration < iterations; iteration++ {
+				for i := uint32(0); i < numSections; i++ {
+					// Visit the sections in a different order in each goroutine
+					index := (i + uint32(g)) % numSections
+					section := ifd.GetSection(index)
+
+					img, err := section.GetImage()
+					if err != nil {
+						errs <- err
+						return
+					}
+
+					grayImg, ok := img.(*image.Gray)
+					if !ok {
+						t.Errorf("expected *image.Gray, got %T", img)
+						return
+					}
+
+					start := int(index) * rowsPerStrip * width
+					if !bytes.Equal(grayImg.Pix, pix[start:start+rowsPerStrip*width]) {
+						t.Errorf("section %d decoded incorrectly", index)
+						return
+					}
+				}
+			}
+		}(g)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestGetCompressedDataOutOfRange(t *testing.T) {
+	byteCount := make([]byte, 4)
+	binary.LittleEndian.PutUint32(byteCount, 1000)
+
+	builder := tifftest.New(binary.LittleEndian, false)
+	builder.AddDirectory().
+		Add(uint16(ImageWidth), tifftest.Long, []uint32{4}).
+		Add(uint16(ImageLength), tifftest.Long, []uint32{4}).
+		Add(uint16(BitsPerSample), tifftest.Short, []uint16{8}).
+		Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(BlackIsZero)}).
+		Add(uint16(RowsPerStrip), tifftest.Long, []uint32{4}).
+		Add(uint16(StripOffsets), tifftest.Long, []uint32{8}).
+		AddRaw(uint16(StripByteCounts), tifftest.Long, 1, byteCount)
+	data := builder.Bytes()
+
+	tiffFile, err := OpenReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tiffFile.GetIFD(0).GetSection(0).GetData()
+	if err == nil {
+		t.Fatal("expected an error when the strip extends past the end of the file")
+	}
+
+	// A failed read must not prevent subsequent reads
+	_, err = tiffFile.GetIFD(0).GetSection(0).GetData()
+	if err == nil {
+		t.Fatal("expected an error when the strip extends past the end of the file")
+	}
+}
+
+func TestCorruptImageWidth(t *testing.T) {
+	tests := []struct {
+		name          string
+		photometric   PhotometricInterpretationID
+		bitsPerSample []uint16
+	}{
+		{"RGB16", RGB, []uint16{16, 16, 16}},
+		{"Palette", PaletteColour, []uint16{8}},
+		{"Gray", BlackIsZero, []uint16{8}},
+	}
+
+	for _, test := range tests {
+		// The width overflows 32 bits when multiplied by the bits per pixel, and the strip holds far less than a row
+		builder := tifftest.New(binary.LittleEndian, false)
+		builder.AddDirectory().
+			Add(uint16(ImageWidth), tifftest.Long, []uint32{0x8000000a}).
+			Add(uint16(ImageLength), tifftest.Long, []uint32{1}).
+			Add(uint16(BitsPerSample), tifftest.Short, test.bitsPerSample).
+			Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(test.photometric)}).
+			Add(uint16(SamplesPerPixel), tifftest.Short, []uint16{uint16(len(test.bitsPerSample))}).
+			Add(uint16(RowsPerStrip), tifftest.Long, []uint32{1}).
+			Add(uint16(ColorMap), tifftest.Short, make([]uint16, 3*256)).
+			Strips(make([]byte, 1000))
+		data := builder.Bytes()
+
+		tiffFile, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{WarningHandler: func(error) {}})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if _, err := tiffFile.GetIFD(0).GetSection(0).GetImage(); err == nil {
+			t.Errorf("%s: expected an error for a row larger than the data", test.name)
+		}
+		if _, err := tiffFile.GetIFD(0).GetImage(); err == nil {
+			t.Errorf("%s: expected an error for a row larger than the data", test.name)
+		}
+	}
+}
+
+// newSampleTIFF creates an uncompressed single strip image from values, which are encoded with the supplied byte
+// order. sampleFormat is omitted when 0.
+func newSampleTIFF(order binary.ByteOrder, width, height int, photometric PhotometricInterpretationID, bitsPerSample []uint16, sampleFormat uint16, values interface{}) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, order, values)
+
+	builder := tifftest.New(order, false)
+	directory := builder.AddDirectory().
+		Add(uint16(ImageWidth), tifftest.Long, []uint32{uint32(width)}).
+		Add(uint16(ImageLength), tifftest.Long, []uint32{uint32(height)}).
+		Add(uint16(BitsPerSample), tifftest.Short, bitsPerSample).
+		Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(photometric)}).
+		Add(uint16(SamplesPerPixel), tifftest.Short, []uint16{uint16(len(bitsPerSample))}).
+		Add(uint16(RowsPerStrip), tifftest.Long, []uint32{uint32(height)}).
+		Strips(buf.Bytes())
+
+	if sampleFormat != 0 {
+		formats := make([]uint16, len(bitsPerSample))
+		for i := range formats {
+			formats[i] = sampleFormat
+		}
+		directory.Add(uint16(SampleFormat), tifftest.Short, formats)
+	}
+
+	return builder.Bytes()
+}
+
+func TestDecodeSamples(t *testing.T) {
+	rect := image.Rect(0, 0, 3, 2)
+
+	gray16 := image.NewGray16(rect)
+	for i, value := range []uint16{0, 1, 0x0102, 0x8000, 0xfffe, 0xffff} {
+		gray16.Pix[i*2] = uint8(value >> 8)
+		gray16.Pix[i*2+1] = uint8(value)
+	}
+
+	grayInt16 := tiffimage.NewGrayInt16(rect)
+	copy(grayInt16.Pix, []int16{-32768, -1, 0, 1, 0x0102, 32767})
+
+	gray32 := tiffimage.NewGray32(rect)
+	copy(gray32.Pix, []uint32{0, 1, 0x01020304, 0x80000000, 0xfffffffe, 0xffffffff})
+
+	grayInt8 := tiffimage.NewGrayInt16(rect)
+	copy(grayInt8.Pix, []int16{-128, -1, 0, 1, 0x12, 127})
+
+	grayInt32 := tiffimage.NewGrayInt32(rect)
+	copy(grayInt32.Pix, []int32{-2147483648, -1, 0, 1, 0x01020304, 2147483647})
+
+	grayFloat32 := tiffimage.NewGrayFloat32(rect)
+	copy(grayFloat32.Pix, []float32{-1.5, 0, 0.25, 1, 100, 3.5})
+	grayFloat32.MaxValue = 100
+
+	grayFloat64 := tiffimage.NewGrayFloat64(rect)
+	copy(grayFloat64.Pix, []float64{-1.5, 0, 0.25, 1, 1e100, 3.5})
+	grayFloat64.MaxValue = 1e100
+
+	rgba64 := image.NewRGBA64(rect)
+	rgb16Values := make([]uint16, 0, 3*6)
+	for i := 0; i < 6; i++ {
+		c := color.RGBA64{R: uint16(i * 0x0101), G: uint16(0x8000 + i), B: uint16(0xffff - i), A: 0xffff}
+		rgba64.SetRGBA64(i%3, i/3, c)
+		rgb16Values = append(rgb16Values, c.R, c.G, c.B)
+	}
+
+	tests := []struct {
+		name          string
+		photometric   PhotometricInterpretationID
+		bitsPerSample []uint16
+		sampleFormat  uint16
+		values        interface{}
+		want          image.Image
+	}{
+		{"Uint16", BlackIsZero, []uint16{16}, 0, []uint16{0, 1, 0x0102, 0x8000, 0xfffe, 0xffff}, gray16},
+		{"Int16", BlackIsZero, []uint16{16}, 2, grayInt16.Pix, grayInt16},
+		{"Int8", BlackIsZero, []uint16{8}, 2, []int8{-128, -1, 0, 1, 0x12, 127}, grayInt8},
+		{"Uint32", BlackIsZero, []uint16{32}, 1, gray32.Pix, gray32},
+		{"Uint32Default", BlackIsZero, []uint16{32}, 0, gray32.Pix, gray32},
+		{"Undefined32", BlackIsZero, []uint16{32}, 4, gray32.Pix, gray32},
+		{"Int32", BlackIsZero, []uint16{32}, 2, grayInt32.Pix, grayInt32},
+		{"Float32", BlackIsZero, []uint16{32}, 3, grayFloat32.Pix, grayFloat32},
+		{"Float64", BlackIsZero, []uint16{64}, 3, grayFloat64.Pix, grayFloat64},
+		{"RGB16", RGB, []uint16{16, 16, 16}, 0, rgb16Values, rgba64},
+	}
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for _, test := range tests {
+			t.Run(order.String()+"/"+test.name, func(t *testing.T) {
+				data := newSampleTIFF(order, rect.Dx(), rect.Dy(), test.photometric, test.bitsPerSample, test.sampleFormat, test.values)
+
+				tiffFile, err := OpenReader(bytes.NewReader(data), int64(len(data)))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				img, err := tiffFile.GetIFD(0).GetImage()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !reflect.DeepEqual(img, test.want) {
+					t.Errorf("got %v, want %v", img, test.want)
+				}
+			})
+		}
+	}
+}
+
+func TestGetSampleFormat(t *testing.T) {
+	tests := []struct {
+		sampleFormat uint16
+		want         SampleFormatID
+		wantErr      bool
+	}{
+		{0, SampleFormatUInt, false},
+		{1, SampleFormatUInt, false},
+		{2, SampleFormatInt, false},
+		{3, SampleFormatFloat, false},
+		{4, SampleFormatUndefined, false},
+		{7, 0, true},
+	}
+
+	for _, test := range tests {
+		data := newSampleTIFF(binary.LittleEndian, 1, 1, BlackIsZero, []uint16{32}, test.sampleFormat, []uint32{0})
+
+		tiffFile, err := OpenReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sampleFormat, err := tiffFile.GetIFD(0).GetSampleFormat()
+		if (err != nil) != test.wantErr {
+			t.Errorf("SampleFormat %d: unexpected error %v", test.sampleFormat, err)
+		}
+		if sampleFormat != test.want {
+			t.Errorf("SampleFormat %d: got %v, want %v", test.sampleFormat, sampleFormat, test.want)
+		}
+	}
+}
+
+// newPlanarRGB creates a 4x3 RGB image, where the value of each sample identifies the pixel and the channel.
+func newPlanarRGB() (*tiffimage.RGB, [3][]byte) {
+	const width, height = 4, 3
+
+	img := tiffimage.NewRGB(image.Rect(0, 0, width, height))
+	var planes [3][]byte
+
+	for pixel := 0; pixel < width*height; pixel++ {
+		for sample := 0; sample < 3; sample++ {
+			value := byte(sample*64 + pixel)
+
+			img.Pix[pixel*3+sample] = value
+			planes[sample] = append(planes[sample], value)
+		}
+	}
+
+	return img, planes
+}
+
+func TestPlanarConfiguration(t *testing.T) {
+	want, planes := newPlanarRGB()
+
+	newDirectory := func(builder *tifftest.Builder) *tifftest.Directory {
+		return builder.AddDirectory().
+			Add(uint16(ImageWidth), tifftest.Long, []uint32{4}).
+			Add(uint16(ImageLength), tifftest.Long, []uint32{3}).
+			Add(uint16(BitsPerSample), tifftest.Short, []uint16{8, 8, 8}).
+			Add(uint16(Compression), tifftest.Short, []uint16{uint16(AdobeDeflate)}).
+			Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(RGB)}).
+			Add(uint16(SamplesPerPixel), tifftest.Short, []uint16{3}).
+			Add(uint16(PlanarConfiguration), tifftest.Short, []uint16{uint16(PlanarConfigurationPlanar)})
+	}
+
+	// Two strips per plane, with the final strip containing a single row
+	strips := tifftest.New(binary.LittleEndian, false)
+	var stripData [][]byte
+	for _, plane := range planes {
+		stripData = append(stripData, deflate(plane[:8]), deflate(plane[8:]))
+	}
+	newDirectory(strips).
+		Add(uint16(RowsPerStrip), tifftest.Long, []uint32{2}).
+		Strips(stripData...)
+
+	// 2x2 tiles, padded on the right and bottom edges
+	tiles := tifftest.New(binary.BigEndian, false)
+	var tileData [][]byte
+	for _, plane := range planes {
+		for tileY := 0; tileY < 2; tileY++ {
+			for tileX := 0; tileX < 2; tileX++ {
+				tile := make([]byte, 4)
+				for y := 0; y < 2; y++ {
+					for x := 0; x < 2; x++ {
+						if tileY*2+y < 3 {
+							tile[y*2+x] = plane[(tileY*2+y)*4+tileX*2+x]
+						}
+					}
+				}
+				tileData = append(tileData, deflate(tile))
+			}
+		}
+	}
+	newDirectory(tiles).
+		Add(uint16(TileWidth), tifftest.Long, []uint32{2}).
+		Add(uint16(TileLength), tifftest.Long, []uint32{2}).
+		Tiles(tileData...)
+
+	tests := []struct {
+		name    string
+		builder *tifftest.Builder
+		// green is the data of the green plane of the first section
+		green []byte
+	}{
+		{"Strips", strips, []byte{64, 65, 66, 67, 68, 69, 70, 71}},
+		{"Tiles", tiles, []byte{64, 65, 68, 69}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := test.builder.Bytes()
+
+			tiffFile, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{Strict: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ifd := tiffFile.GetIFD(0)
+
+			img, err := ifd.GetImage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(img.(*tiffimage.RGB).Pix, want.Pix) {
+				t.Errorf("got %v, want %v", img.(*tiffimage.RGB).Pix, want.Pix)
+			}
+
+			planeData, err := ifd.GetPlaneData(ifd.GetSection(0), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(planeData, test.green) {
+				t.Errorf("green plane: got %v, want %v", planeData, test.green)
+			}
+
+			if _, err := ifd.GetPlaneData(ifd.GetSection(0), 3); err == nil {
+				t.Error("expected an error when requesting a plane which doesn't exist")
+			}
+		})
+	}
+}
+
+func TestGetPlaneDataChunky(t *testing.T) {
+	want, planes := newPlanarRGB()
+
+	data := newSampleTIFF(binary.LittleEndian, 4, 3, RGB, []uint16{8, 8, 8}, 0, want.Pix)
+
+	tiffFile, err := OpenReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifd := tiffFile.GetIFD(0)
+
+	for plane := range planes {
+		planeData, err := ifd.GetPlaneData(ifd.GetSection(0), uint16(plane))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(planeData, planes[plane]) {
+			t.Errorf("plane %d: got %v, want %v", plane, planeData, planes[plane])
+		}
+	}
+}
+
+func TestSubByteSamples(t *testing.T) {
+	// A 10x2 image, so that each row of 1-bit data is padded
+	rect := image.Rect(0, 0, 10, 2)
+
+	mask := image.NewGray(rect)
+	copy(mask.Pix, []uint8{
+		255, 0, 0, 0, 0, 0, 0, 255, 255, 0,
+		0, 255, 255, 255, 255, 255, 255, 0, 0, 255,
+	})
+
+	invertedMask := image.NewGray(rect)
+	for i := range mask.Pix {
+		invertedMask.Pix[i] = 255 - mask.Pix[i]
+	}
+
+	gray2 := image.NewGray(rect)
+	copy(gray2.Pix, []uint8{
+		0, 85, 170, 255, 0, 85, 170, 255, 0, 85,
+		255, 170, 85, 0, 255, 170, 85, 0, 255, 170,
+	})
+
+	gray4 := image.NewGray(rect)
+	for i := range gray4.Pix {
+		gray4.Pix[i] = uint8(i%16) * 17
+	}
+
+	colorMap := make([]uint16, 3*16)
+	palette := make(color.Palette, 16)
+	for i := range palette {
+		colorMap[i], colorMap[16+i], colorMap[32+i] = uint16(i*0x1000), uint16(0xffff-i), uint16(i)
+		palette[i] = color.RGBA64{R: colorMap[i], G: colorMap[16+i], B: colorMap[32+i], A: 0xffff}
+	}
+	paletted := image.NewPaletted(rect, palette)
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(i % 16)
+	}
+
+	packed4 := []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23}
+
+	tests := []struct {
+		name          string
+		photometric   PhotometricInterpretationID
+		bitsPerSample uint16
+		data          []byte
+		colorMap      []uint16
+		want          image.Image
+	}{
+		{"BlackIsZero1", BlackIsZero, 1, []byte{0x81, 0x80, 0x7e, 0x40}, nil, mask},
+		{"WhiteIsZero1", WhiteIsZero, 1, []byte{0x81, 0x80, 0x7e, 0x40}, nil, invertedMask},
+		{"BlackIsZero2", BlackIsZero, 2, []byte{0x1b, 0x1b, 0x10, 0xe4, 0xe4, 0xe0}, nil, gray2},
+		{"BlackIsZero4", BlackIsZero, 4, packed4, nil, gray4},
+		{"Palette4", PaletteColour, 4, packed4, colorMap, paletted},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			builder := tifftest.New(binary.LittleEndian, false)
+			directory := builder.AddDirectory().
+				Add(uint16(ImageWidth), tifftest.Long, []uint32{uint32(rect.Dx())}).
+				Add(uint16(ImageLength), tifftest.Long, []uint32{uint32(rect.Dy())}).
+				Add(uint16(BitsPerSample), tifftest.Short, []uint16{test.bitsPerSample}).
+				Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(test.photometric)}).
+				Add(uint16(RowsPerStrip), tifftest.Long, []uint32{uint32(rect.Dy())}).
+				Strips(test.data)
+			if test.colorMap != nil {
+				directory.Add(uint16(ColorMap), tifftest.Short, test.colorMap)
+			}
+			data := builder.Bytes()
+
+			tiffFile, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{Strict: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img, err := tiffFile.GetIFD(0).GetImage()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(img, test.want) {
+				t.Errorf("got %v, want %v", img, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package gobio
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+)
+
+func init() {
+	// Classic TIFF and BigTIFF, in both byte orders
+	image.RegisterFormat("tiff", "II\x2a\x00", Decode, DecodeConfig)
+	image.RegisterFormat("tiff", "MM\x00\x2a", Decode, DecodeConfig)
+	image.RegisterFormat("bigtiff", "II\x2b\x00", Decode, DecodeConfig)
+	image.RegisterFormat("bigtiff", "MM\x00\x2b", Decode, DecodeConfig)
+}
+
+// openAll reads the whole of r, as TIFF data can be stored anywhere in the file, and parses it. Warnings are discarded,
+// as the image package has no way to report them.
+func openAll(r io.Reader) (*ImageFileDirectory, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	tiffFile, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{WarningHandler: func(error) {}})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tiffFile.GetIFDList()) == 0 {
+		return nil, &FormatError{msg: "File does not contain any images"}
+	}
+
+	return tiffFile.GetIFD(0), nil
+}
+
+// Decode reads a TIFF or BigTIFF file from r and returns the image described by its first ImageFileDirectory. The
+// whole file is read into memory, so for large (e.g. whole slide) images use Open and ReadRegion instead.
+func Decode(r io.Reader) (image.Image, error) {
+	ifd, err := openAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return ifd.GetImage()
+}
+
+// DecodeConfig returns the colour model and dimensions of the first image in a TIFF or BigTIFF file. The colour model
+// is worked out from the tags (PhotometricInterpretation, BitsPerSample, SampleFormat, SamplesPerPixel and
+// ExtraSamples) without decompressing any image data.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	ifd, err := openAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	dataAccess, ok := ifd.dataAccess.(interface{ colorModel() (color.Model, error) })
+	if !ok {
+		return image.Config{}, &FormatError{msg: "Unable to determine the colour model of the image"}
+	}
+
+	model, err := dataAccess.colorModel()
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	width, height := ifd.GetImageDimensions()
+
+	return image.Config{ColorModel: model, Width: int(width), Height: int(height)}, nil
+}
@@ -0,0 +1,187 @@
+package gobio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+
+	tiffimage "github.com/AlanRace/go-bio/image"
+	"github.com/AlanRace/go-bio/test/tifftest"
+)
+
+func TestImageDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		order   binary.ByteOrder
+		bigTIFF bool
+		format  string
+	}{
+		{"little endian TIFF", binary.LittleEndian, false, "tiff"},
+		{"big endian TIFF", binary.BigEndian, false, "tiff"},
+		{"little endian BigTIFF", binary.LittleEndian, true, "bigtiff"},
+		{"big endian BigTIFF", binary.BigEndian, true, "bigtiff"},
+	}
+
+	for _, test := range tests {
+		data, pix := newGrayStripTIFF(test.order, test.bigTIFF, 13, 9, 4)
+
+		config, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if format != test.format {
+			t.Errorf("%s: expected format %s, found %s", test.name, test.format, format)
+		}
+		if config.Width != 13 || config.Height != 9 {
+			t.Errorf("%s: expected 13 x 9, found %d x %d", test.name, config.Width, config.Height)
+		}
+
+		img, format, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if format != test.format {
+			t.Errorf("%s: expected format %s, found %s", test.name, test.format, format)
+		}
+
+		gray, ok := img.(*image.Gray)
+		if !ok {
+			t.Fatalf("%s: expected *image.Gray, found %T", test.name, img)
+		}
+		if config.ColorModel != gray.ColorModel() {
+			t.Errorf("%s: colour model of the configuration does not match the image", test.name)
+		}
+		if !bytes.Equal(gray.Pix, pix) {
+			t.Errorf("%s: pixels are incorrect", test.name)
+		}
+	}
+
+	// The colour model is that of the image type Decode returns
+	data, _ := newTiledTIFF(21, 18, 16, 16, 3, RGB)
+	config, err := DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ColorModel != tiffimage.NewRGB(image.Rectangle{}).ColorModel() {
+		t.Errorf("expected RGB colour model")
+	}
+
+	_, _, err = image.DecodeConfig(bytes.NewReader(data[:6]))
+	if err == nil {
+		t.Errorf("expected error for truncated file")
+	}
+}
+
+func TestDecodeConfigColorModel(t *testing.T) {
+	tests := []struct {
+		name          string
+		photometric   PhotometricInterpretationID
+		bitsPerSample []uint16
+		addTags       func(*tifftest.Directory)
+	}{
+		{"Gray4", BlackIsZero, []uint16{4}, nil},
+		{"Gray16", BlackIsZero, []uint16{16}, nil},
+		{"WhiteIsZero16", WhiteIsZero, []uint16{16}, nil},
+		{"Gray32", BlackIsZero, []uint16{32}, nil},
+		{"Int8", BlackIsZero, []uint16{8}, func(directory *tifftest.Directory) {
+			directory.Add(uint16(SampleFormat), tifftest.Short, []uint16{uint16(SampleFormatInt)})
+		}},
+		{"Float64", BlackIsZero, []uint16{64}, func(directory *tifftest.Directory) {
+			directory.Add(uint16(SampleFormat), tifftest.Short, []uint16{uint16(SampleFormatFloat)})
+		}},
+		{"GrayAlpha", BlackIsZero, []uint16{8, 8}, func(directory *tifftest.Directory) {
+			directory.Add(uint16(ExtraSamples), tifftest.Short, []uint16{uint16(ExtraSampleUnassociatedAlpha)})
+		}},
+		{"RGB", RGB, []uint16{8, 8, 8}, nil},
+		{"RGB16", RGB, []uint16{16, 16, 16}, nil},
+		{"RGBA", RGB, []uint16{8, 8, 8, 8}, func(directory *tifftest.Directory) {
+			directory.Add(uint16(ExtraSamples), tifftest.Short, []uint16{uint16(ExtraSampleAssociatedAlpha)})
+		}},
+		{"RGBA64", RGB, []uint16{16, 16, 16, 16}, func(directory *tifftest.Directory) {
+			directory.Add(uint16(ExtraSamples), tifftest.Short, []uint16{uint16(ExtraSampleUnassociatedAlpha)})
+		}},
+		{"MultiChannel", RGB, []uint16{8, 8, 8, 8, 8}, nil},
+		{"CMYK", CMYK, []uint16{8, 8, 8, 8}, nil},
+		{"CIELab", CIELab, []uint16{8, 8, 8}, nil},
+		{"YCbCr", YCbCr, []uint16{8, 8, 8}, func(directory *tifftest.Directory) {
+			directory.Add(uint16(YCbCrSubSampling), tifftest.Short, []uint16{1, 1})
+		}},
+	}
+
+	for _, test := range tests {
+		bitsPerPixel := 0
+		for _, bits := range test.bitsPerSample {
+			bitsPerPixel += int(bits)
+		}
+
+		builder := tifftest.New(binary.LittleEndian, false)
+		directory := builder.AddDirectory().
+			Add(uint16(ImageWidth), tifftest.Long, []uint32{4}).
+			Add(uint16(ImageLength), tifftest.Long, []uint32{2}).
+			Add(uint16(BitsPerSample), tifftest.Short, test.bitsPerSample).
+			Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(test.photometric)}).
+			Add(uint16(SamplesPerPixel), tifftest.Short, []uint16{uint16(len(test.bitsPerSample))}).
+			Add(uint16(RowsPerStrip), tifftest.Long, []uint32{2}).
+			Strips(make([]byte, 4*2*bitsPerPixel/8))
+		if test.addTags != nil {
+			test.addTags(directory)
+		}
+		data := builder.Bytes()
+
+		config, err := DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		img, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if config.ColorModel != img.ColorModel() {
+			t.Errorf("%s: colour model of the configuration does not match the %T image", test.name, img)
+		}
+	}
+}
+
+func TestDecodeConfigWithoutData(t *testing.T) {
+	// The strip is not valid Deflate data, but DecodeConfig only reads the tags
+	builder := tifftest.New(binary.LittleEndian, false)
+	builder.AddDirectory().
+		Add(uint16(ImageWidth), tifftest.Long, []uint32{5}).
+		Add(uint16(ImageLength), tifftest.Long, []uint32{3}).
+		Add(uint16(BitsPerSample), tifftest.Short, []uint16{16}).
+		Add(uint16(Compression), tifftest.Short, []uint16{uint16(AdobeDeflate)}).
+		Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(BlackIsZero)}).
+		Add(uint16(SamplesPerPixel), tifftest.Short, []uint16{1}).
+		Add(uint16(RowsPerStrip), tifftest.Long, []uint32{3}).
+		Add(65000, tifftest.Short, []uint16{1}).
+		Strips([]byte{1, 2, 3, 4})
+	data := builder.Bytes()
+
+	// Warnings, such as for the unknown tag, are not reported by the image package decoders
+	defaultWarningHandler := DefaultWarningHandler
+	defer func() { DefaultWarningHandler = defaultWarningHandler }()
+
+	var warnings []error
+	DefaultWarningHandler = func(warning error) {
+		warnings = append(warnings, warning)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 5 || config.Height != 3 || config.ColorModel != color.Gray16Model {
+		t.Errorf("unexpected configuration %d x %d with %v", config.Width, config.Height, config.ColorModel)
+	}
+
+	if _, _, err := image.Decode(bytes.NewReader(data)); err == nil {
+		t.Error("expected an error decoding invalid Deflate data")
+	}
+
+	if len(warnings) != 0 {
+		t.Errorf("expected no warnings, found %v", warnings)
+	}
+}
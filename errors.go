@@ -0,0 +1,162 @@
+package gobio
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrUnknownTag is reported when a tag ID is not recognised. The tag is still kept, with values decoded according
+	// to its data type.
+	ErrUnknownTag = errors.New("unknown tag")
+	// ErrUnknownDataType is reported when a tag uses a data type which is not defined by the TIFF or BigTIFF specification.
+	ErrUnknownDataType = errors.New("unknown data type")
+	// ErrIFDLoop is reported when an IFD offset points back to an IFD which has already been read.
+	ErrIFDLoop = errors.New("IFD has already been read")
+	// ErrMissingTag is reported when a tag required to read the image is not present.
+	ErrMissingTag = errors.New("missing tag")
+	// ErrInvalidTagValue is reported when a tag required to read the image has no values, or a value which is not
+	// allowed (e.g. an ImageWidth of 0).
+	ErrInvalidTagValue = errors.New("invalid tag value")
+	// ErrTruncated is reported when data referenced by the file lies beyond the end of the file.
+	ErrTruncated = errors.New("data extends past the end of the file")
+	// ErrSectionCount is reported when the number of strip or tile offsets doesn't match the number expected.
+	ErrSectionCount = errors.New("unexpected number of sections")
+)
+
+// ParseError describes a problem found while parsing a tiff file, along with where in the file it was found. Use
+// errors.Is or errors.As to inspect the underlying error.
+type ParseError struct {
+	// IFD is the position of the image file directory in the chain of IFDs in the file (SubIFDs report the position
+	// of their parent), or -1 if the problem is not associated with an IFD. This matches the index in File.IFDList.
+	IFD int
+	// Tag is the ID of the tag being processed, or 0 if the problem is not associated with a tag.
+	Tag TagID
+	// Offset is the position in the file of the data being processed, or -1 if not known.
+	Offset int64
+
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	var location []string
+
+	if e.IFD >= 0 {
+		location = append(location, fmt.Sprintf("IFD %d", e.IFD))
+	}
+	if e.Tag != 0 {
+		if name := tagNameMap[e.Tag]; name != "" {
+			location = append(location, fmt.Sprintf("tag %s (%d)", name, e.Tag))
+		} else {
+			location = append(location, fmt.Sprintf("tag %d", e.Tag))
+		}
+	}
+	if e.Offset >= 0 {
+		location = append(location, fmt.Sprintf("offset %d", e.Offset))
+	}
+
+	if len(location) == 0 {
+		return e.Err.Error()
+	}
+
+	return strings.Join(location, ", ") + ": " + e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// WarningHandler is called with each problem found which does not prevent the file from being read. Warnings are
+// *ParseError values. They are generated while the file is opened, on the goroutine opening it; decoding images
+// reports problems as errors instead.
+type WarningHandler func(warning error)
+
+// DefaultWarningHandler receives the warnings generated while a file is opened, unless OpenOptions specifies a
+// WarningHandler. By default warnings are logged.
+var DefaultWarningHandler WarningHandler = func(warning error) {
+	log.Println(warning)
+}
+
+// SetWarningHandler sets the function which receives any further warnings about the file. A nil handler discards
+// warnings. As all warnings are generated while the file is opened, use OpenOptions.WarningHandler to receive them
+// and Diagnostics to list them afterwards.
+func (tiffFile *File) SetWarningHandler(handler WarningHandler) {
+	tiffFile.warningHandler = handler
+}
+
+// diagnosticLog collects the problems found in a file. It is shared by pointer so that File can be copied.
+type diagnosticLog struct {
+	mutex   sync.Mutex
+	entries []*ParseError
+}
+
+func (diagnostics *diagnosticLog) add(parseError *ParseError) {
+	diagnostics.mutex.Lock()
+	defer diagnostics.mutex.Unlock()
+
+	diagnostics.entries = append(diagnostics.entries, parseError)
+}
+
+// Diagnostics returns every problem found while reading the file, including those which were recovered from.
+func (tiffFile *File) Diagnostics() []*ParseError {
+	if tiffFile.diagnostics == nil {
+		return nil
+	}
+
+	tiffFile.diagnostics.mutex.Lock()
+	defer tiffFile.diagnostics.mutex.Unlock()
+
+	diagnostics := make([]*ParseError, len(tiffFile.diagnostics.entries))
+	copy(diagnostics, tiffFile.diagnostics.entries)
+
+	return diagnostics
+}
+
+// warn records a problem which does not violate the specification (e.g. a tag which is not recognised) and reports it
+// to the warning handler of the file.
+func (tiffFile *File) warn(ifd int, tag TagID, offset int64, err error) {
+	parseError := newParseError(ifd, tag, offset, err)
+	tiffFile.diagnostics.add(parseError)
+
+	if tiffFile.warningHandler != nil {
+		tiffFile.warningHandler(parseError)
+	}
+}
+
+// violation records a problem which violates the specification. In strict mode the error is returned and should be
+// passed back to the caller, otherwise it is reported as a warning, nil is returned and the caller should recover.
+func (tiffFile *File) violation(ifd int, tag TagID, offset int64, err error) error {
+	if tiffFile.strict {
+		return newParseError(ifd, tag, offset, err)
+	}
+
+	tiffFile.warn(ifd, tag, offset, err)
+
+	return nil
+}
+
+// warn records a problem with the image file directory, see File.warn.
+func (ifd *ImageFileDirectory) warn(tag TagID, offset int64, err error) {
+	if ifd.tiffFile != nil {
+		ifd.tiffFile.warn(ifd.index, tag, offset, err)
+	}
+}
+
+// violation records a problem with the image file directory, see File.violation.
+func (ifd *ImageFileDirectory) violation(tag TagID, offset int64, err error) error {
+	if ifd.tiffFile == nil {
+		return nil
+	}
+
+	return ifd.tiffFile.violation(ifd.index, tag, offset, err)
+}
+
+// newParseError adds the location to err, unless it already describes where it occurred.
+func newParseError(ifd int, tag TagID, offset int64, err error) *ParseError {
+	if parseError, ok := err.(*ParseError); ok {
+		return parseError
+	}
+
+	return &ParseError{IFD: ifd, Tag: tag, Offset: offset, Err: err}
+}
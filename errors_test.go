@@ -0,0 +1,158 @@
+package gobio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/AlanRace/go-bio/test/tifftest"
+)
+
+// collectWarnings replaces DefaultWarningHandler and returns the collected warnings along with a function to restore
+// the original handler.
+func collectWarnings() (*[]*ParseError, func()) {
+	var warnings []*ParseError
+
+	defaultHandler := DefaultWarningHandler
+	DefaultWarningHandler = func(warning error) {
+		warnings = append(warnings, warning.(*ParseError))
+	}
+
+	return &warnings, func() { DefaultWarningHandler = defaultHandler }
+}
+
+func TestUnknownTagWarnings(t *testing.T) {
+	warnings, restore := collectWarnings()
+	defer restore()
+
+	builder := tifftest.New(binary.BigEndian, false)
+	newPyramidLevel(builder.AddDirectory(), 4, 4, false, 0)
+	newPyramidLevel(builder.AddDirectory(), 2, 2, true, 0).
+		Add(65000, tifftest.Short, []uint16{1}).
+		AddRaw(uint16(Software), 99, 1, []byte{0, 0, 0, 0})
+	data := builder.Bytes()
+
+	tiffFile, err := OpenReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*warnings) != 2 {
+		t.Fatalf("expected 2 warnings, got %v", *warnings)
+	}
+
+	// The entries are sorted by tag, so Software comes first
+	unknownType, unknownTag := (*warnings)[0], (*warnings)[1]
+
+	if !errors.Is(unknownType, ErrUnknownDataType) || unknownType.IFD != 1 || unknownType.Tag != Software {
+		t.Errorf("unexpected warning for unknown data type: %v", unknownType)
+	}
+	if !errors.Is(unknownTag, ErrUnknownTag) || unknownTag.IFD != 1 || unknownTag.Tag != 65000 {
+		t.Errorf("unexpected warning for unknown tag: %v", unknownTag)
+	}
+
+	if shortTag, ok := tiffFile.GetIFD(1).GetTag(65000).(*ShortTag); !ok || shortTag.Data[0] != 1 {
+		t.Errorf("expected the unknown tag to be kept, found %v", tiffFile.GetIFD(1).GetTag(65000))
+	}
+
+	// The offset should point at the entry in the IFD
+	entry := data[unknownTag.Offset : unknownTag.Offset+2]
+	if binary.BigEndian.Uint16(entry) != 65000 {
+		t.Errorf("offset %d does not point to the tag entry", unknownTag.Offset)
+	}
+
+	// Warnings generated after opening go to the handler set on the file
+	*warnings = nil
+	var fileWarnings []error
+	tiffFile.SetWarningHandler(func(warning error) {
+		fileWarnings = append(fileWarnings, warning)
+	})
+	tiffFile.GetIFD(1).warn(0, -1, ErrMissingTag)
+
+	if len(*warnings) != 0 || len(fileWarnings) != 1 {
+		t.Errorf("expected warning to be sent to the file handler only")
+	}
+}
+
+func TestUnknownTagsKept(t *testing.T) {
+	warnings, restore := collectWarnings()
+	defer restore()
+
+	// Private tags, such as those written by microscope vendors, keep their decoded values
+	builder := tifftest.New(binary.LittleEndian, true)
+	newPyramidLevel(builder.AddDirectory(), 4, 4, false, 0).
+		Add(65001, tifftest.Double, []float64{0.25, -1.5}).
+		Add(65002, tifftest.SRational, []int32{-1, 3})
+	data := builder.Bytes()
+
+	tiffFile, err := OpenReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifd := tiffFile.GetIFD(0)
+
+	doubleTag, ok := ifd.GetTag(65001).(*DoubleTag)
+	if !ok || !reflect.DeepEqual(doubleTag.Data, []float64{0.25, -1.5}) {
+		t.Errorf("unexpected DOUBLE tag: %v", ifd.GetTag(65001))
+	}
+
+	srationalTag, ok := ifd.GetTag(65002).(*SRationalTag)
+	if !ok || !reflect.DeepEqual(srationalTag.Data, []SRationalNumber{{Numerator: -1, Denominator: 3}}) {
+		t.Errorf("unexpected SRATIONAL tag: %v", ifd.GetTag(65002))
+	}
+
+	// The tags are still listed as unknown
+	if len(*warnings) != 2 || len(tiffFile.Diagnostics()) != 2 {
+		t.Fatalf("expected 2 warnings, got %v", *warnings)
+	}
+	for _, warning := range *warnings {
+		if !errors.Is(warning, ErrUnknownTag) {
+			t.Errorf("unexpected warning: %v", warning)
+		}
+	}
+}
+
+func TestIFDLoop(t *testing.T) {
+	warnings, restore := collectWarnings()
+	defer restore()
+
+	data, _ := newGrayStripTIFF(binary.LittleEndian, false, 4, 4, 4)
+
+	// Point the next IFD offset of the only IFD back at itself
+	ifdOffset := binary.LittleEndian.Uint32(data[4:])
+	numTags := binary.LittleEndian.Uint16(data[ifdOffset:])
+	binary.LittleEndian.PutUint32(data[int(ifdOffset)+2+int(numTags)*12:], ifdOffset)
+
+	tiffFile, err := OpenReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tiffFile.IFDList) != 1 {
+		t.Errorf("expected 1 IFD, found %d", len(tiffFile.IFDList))
+	}
+	if len(*warnings) != 1 || !errors.Is((*warnings)[0], ErrIFDLoop) || (*warnings)[0].Offset != int64(ifdOffset) {
+		t.Errorf("expected IFD loop warning, got %v", *warnings)
+	}
+}
+
+func TestParseErrorLocation(t *testing.T) {
+	builder := tifftest.New(binary.LittleEndian, false)
+	newPyramidLevel(builder.AddDirectory(), 4, 4, false, 0)
+	builder.AddDirectory().
+		Add(uint16(ImageWidth), tifftest.Long, []uint32{4}).
+		Add(uint16(ImageLength), tifftest.Long, []uint32{4})
+	data := builder.Bytes()
+
+	_, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{Strict: true})
+
+	var parseError *ParseError
+	if !errors.As(err, &parseError) {
+		t.Fatalf("expected ParseError, got %v", err)
+	}
+	if parseError.IFD != 1 || parseError.Tag != 0 || parseError.Offset <= 0 {
+		t.Errorf("unexpected location in error: %v", parseError)
+	}
+}
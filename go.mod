@@ -4,6 +4,7 @@ go 1.13
 
 require (
 	github.com/hhrutter/lzw v0.0.0-20190829144645-6f07a24e8650
+	github.com/klauspost/compress v1.11.13
 	github.com/pixiv/go-libjpeg v0.0.0-20190822045933-3da21a74767d
 	golang.org/x/image v0.0.0-20190622003408-7e034cad6442
 	golang.org/x/text v0.3.0
@@ -402,7 +402,7 @@ func (p *GrayFloat32) SubImage(r image.Rectangle) image.Image {
 	// either r1 or r2 if the intersection is empty. Without explicitly checking for
 	// this, the Pix[i:] expression below can panic.
 	if r.Empty() {
-		return &GrayFloat32{}
+		return &GrayFloat32{MaxValue: p.MaxValue}
 	}
 
 	i := p.PixOffset(r.Min.X, r.Min.Y)
@@ -411,6 +411,8 @@ func (p *GrayFloat32) SubImage(r image.Rectangle) image.Image {
 		Pix:    p.Pix[i:],
 		Stride: p.Stride,
 		Rect:   r,
+
+		MaxValue: p.MaxValue,
 	}
 }
 
@@ -422,3 +424,372 @@ func NewGrayFloat32(r image.Rectangle) *GrayFloat32 {
 		Rect:   r,
 	}
 }
+
+// GrayInt16 is an image of signed 16-bit values. When converted to other colour models the values are offset so that
+// -32768 is black and 32767 is white.
+type GrayInt16 struct {
+	Pix    []int16
+	Stride int
+	Rect   image.Rectangle
+}
+
+func (p *GrayInt16) ColorModel() color.Model { return color.Gray16Model }
+
+func (p *GrayInt16) Bounds() image.Rectangle { return p.Rect }
+
+func (p *GrayInt16) At(x, y int) color.Color {
+	return p.Gray16At(x, y)
+}
+
+func (p *GrayInt16) Gray16At(x, y int) color.Gray16 {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return color.Gray16{}
+	}
+
+	i := p.PixOffset(x, y)
+
+	return color.Gray16{Y: uint16(int32(p.Pix[i]) + 32768)}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (p *GrayInt16) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x - p.Rect.Min.X)
+}
+
+func (p *GrayInt16) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+
+	i := p.PixOffset(x, y)
+	c1 := color.Gray16Model.Convert(c).(color.Gray16)
+
+	p.Pix[i] = int16(int32(c1.Y) - 32768)
+}
+
+func (p *GrayInt16) SetGrayInt16(x, y int, value int16) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+
+	i := p.PixOffset(x, y)
+	p.Pix[i] = value
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *GrayInt16) SubImage(r image.Rectangle) image.Image {
+
+	r = r.Intersect(p.Rect)
+
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the Pix[i:] expression below can panic.
+	if r.Empty() {
+		return &GrayInt16{}
+	}
+
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+
+	return &GrayInt16{
+		Pix:    p.Pix[i:],
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+// NewGrayInt16 returns a new GrayInt16 image with the given bounds.
+func NewGrayInt16(r image.Rectangle) *GrayInt16 {
+	return &GrayInt16{
+		Pix:    make([]int16, pixelBufferLength(1, r, "GrayInt16")),
+		Stride: r.Dx(),
+		Rect:   r,
+	}
+}
+
+// GrayInt32 is an image of signed 32-bit values. When converted to other colour models the values are offset so that
+// the most negative value is black and the most positive is white.
+type GrayInt32 struct {
+	Pix    []int32
+	Stride int
+	Rect   image.Rectangle
+}
+
+func (p *GrayInt32) ColorModel() color.Model { return color.Gray16Model }
+
+func (p *GrayInt32) Bounds() image.Rectangle { return p.Rect }
+
+func (p *GrayInt32) At(x, y int) color.Color {
+	return p.Gray16At(x, y)
+}
+
+func (p *GrayInt32) Gray16At(x, y int) color.Gray16 {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return color.Gray16{}
+	}
+
+	i := p.PixOffset(x, y)
+
+	return color.Gray16{Y: uint16((uint32(p.Pix[i]) ^ 0x80000000) >> 16)}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (p *GrayInt32) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x - p.Rect.Min.X)
+}
+
+func (p *GrayInt32) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+
+	i := p.PixOffset(x, y)
+	c1 := color.Gray16Model.Convert(c).(color.Gray16)
+
+	p.Pix[i] = int32((uint32(c1.Y)<<16 | uint32(c1.Y)) ^ 0x80000000)
+}
+
+func (p *GrayInt32) SetGrayInt32(x, y int, value int32) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+
+	i := p.PixOffset(x, y)
+	p.Pix[i] = value
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *GrayInt32) SubImage(r image.Rectangle) image.Image {
+
+	r = r.Intersect(p.Rect)
+
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the Pix[i:] expression below can panic.
+	if r.Empty() {
+		return &GrayInt32{}
+	}
+
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+
+	return &GrayInt32{
+		Pix:    p.Pix[i:],
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+// NewGrayInt32 returns a new GrayInt32 image with the given bounds.
+func NewGrayInt32(r image.Rectangle) *GrayInt32 {
+	return &GrayInt32{
+		Pix:    make([]int32, pixelBufferLength(1, r, "GrayInt32")),
+		Stride: r.Dx(),
+		Rect:   r,
+	}
+}
+
+type GrayFloat64 struct {
+	Pix    []float64
+	Stride int
+	Rect   image.Rectangle
+
+	MaxValue float64
+}
+
+func (p *GrayFloat64) ColorModel() color.Model { return color.Gray16Model }
+
+func (p *GrayFloat64) Bounds() image.Rectangle { return p.Rect }
+
+func (p *GrayFloat64) At(x, y int) color.Color {
+	return p.Gray16At(x, y)
+}
+
+func (p *GrayFloat64) Gray16At(x, y int) color.Gray16 {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return color.Gray16{}
+	}
+
+	i := p.PixOffset(x, y)
+
+	return color.Gray16{Y: uint16((p.Pix[i] / p.MaxValue) * 65535)}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (p *GrayFloat64) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x - p.Rect.Min.X)
+}
+
+func (p *GrayFloat64) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+
+	i := p.PixOffset(x, y)
+	c1 := color.Gray16Model.Convert(c).(color.Gray16)
+
+	p.Pix[i] = (float64(c1.Y) / 65535) * p.MaxValue
+}
+
+func (p *GrayFloat64) SetGrayFloat64(x, y int, value float64) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+
+	i := p.PixOffset(x, y)
+	p.Pix[i] = value
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *GrayFloat64) SubImage(r image.Rectangle) image.Image {
+
+	r = r.Intersect(p.Rect)
+
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the Pix[i:] expression below can panic.
+	if r.Empty() {
+		return &GrayFloat64{MaxValue: p.MaxValue}
+	}
+
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+
+	return &GrayFloat64{
+		Pix:    p.Pix[i:],
+		Stride: p.Stride,
+		Rect:   r,
+
+		MaxValue: p.MaxValue,
+	}
+}
+
+// NewGrayFloat64 returns a new GrayFloat64 image with the given bounds.
+func NewGrayFloat64(r image.Rectangle) *GrayFloat64 {
+	return &GrayFloat64{
+		Pix:    make([]float64, pixelBufferLength(1, r, "GrayFloat64")),
+		Stride: r.Dx(),
+		Rect:   r,
+	}
+}
+
+// MultiChannel is an in-memory image with any number of 8 or 16-bit channels per pixel. 16-bit values are stored in
+// big-endian order, as in image.Gray16. At interprets the first ColourChannels channels as either a grey (1) or RGB
+// (3) colour, ignoring the remaining channels.
+type MultiChannel struct {
+	Pix    []uint8
+	Stride int
+	Rect   image.Rectangle
+
+	Channels        int
+	BytesPerChannel int
+	ColourChannels  int
+}
+
+func (p *MultiChannel) ColorModel() color.Model {
+	if p.ColourChannels == 3 {
+		return color.RGBA64Model
+	}
+
+	return color.Gray16Model
+}
+
+func (p *MultiChannel) Bounds() image.Rectangle { return p.Rect }
+
+func (p *MultiChannel) At(x, y int) color.Color {
+	if p.ColourChannels == 3 {
+		return color.RGBA64{R: p.ChannelAt(x, y, 0), G: p.ChannelAt(x, y, 1), B: p.ChannelAt(x, y, 2), A: 0xffff}
+	}
+
+	return color.Gray16{Y: p.ChannelAt(x, y, 0)}
+}
+
+// ChannelAt returns the value of a channel of the pixel at (x, y), scaled to 16 bits.
+func (p *MultiChannel) ChannelAt(x, y, channel int) uint16 {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return 0
+	}
+
+	i := p.PixOffset(x, y) + channel*p.BytesPerChannel
+	if p.BytesPerChannel == 1 {
+		return uint16(p.Pix[i]) * 0x101
+	}
+
+	return uint16(p.Pix[i])<<8 | uint16(p.Pix[i+1])
+}
+
+// SetChannel sets the value of a channel of the pixel at (x, y), where value is scaled to 16 bits.
+func (p *MultiChannel) SetChannel(x, y, channel int, value uint16) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+
+	i := p.PixOffset(x, y) + channel*p.BytesPerChannel
+	if p.BytesPerChannel == 1 {
+		p.Pix[i] = uint8(value >> 8)
+		return
+	}
+
+	p.Pix[i] = uint8(value >> 8)
+	p.Pix[i+1] = uint8(value)
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (p *MultiChannel) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*p.Channels*p.BytesPerChannel
+}
+
+// Set sets the colour channels of the pixel at (x, y). Other channels are left unchanged.
+func (p *MultiChannel) Set(x, y int, c color.Color) {
+	if p.ColourChannels == 3 {
+		c1 := color.RGBA64Model.Convert(c).(color.RGBA64)
+
+		p.SetChannel(x, y, 0, c1.R)
+		p.SetChannel(x, y, 1, c1.G)
+		p.SetChannel(x, y, 2, c1.B)
+		return
+	}
+
+	p.SetChannel(x, y, 0, color.Gray16Model.Convert(c).(color.Gray16).Y)
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *MultiChannel) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to be inside
+	// either r1 or r2 if the intersection is empty. Without explicitly checking for
+	// this, the Pix[i:] expression below can panic.
+	if r.Empty() {
+		return &MultiChannel{Channels: p.Channels, BytesPerChannel: p.BytesPerChannel, ColourChannels: p.ColourChannels}
+	}
+
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+
+	return &MultiChannel{
+		Pix:             p.Pix[i:],
+		Stride:          p.Stride,
+		Rect:            r,
+		Channels:        p.Channels,
+		BytesPerChannel: p.BytesPerChannel,
+		ColourChannels:  p.ColourChannels,
+	}
+}
+
+// NewMultiChannel returns a new MultiChannel image with the given bounds, number of channels and bytes per channel
+// (1 or 2). colourChannels is the number of channels (1 or 3) which describe the colour of each pixel.
+func NewMultiChannel(r image.Rectangle, channels, bytesPerChannel, colourChannels int) *MultiChannel {
+	return &MultiChannel{
+		Pix:             make([]uint8, pixelBufferLength(channels*bytesPerChannel, r, "MultiChannel")),
+		Stride:          channels * bytesPerChannel * r.Dx(),
+		Rect:            r,
+		Channels:        channels,
+		BytesPerChannel: bytesPerChannel,
+		ColourChannels:  colourChannels,
+	}
+}
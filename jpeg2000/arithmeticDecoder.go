@@ -0,0 +1,210 @@
+package jpeg2000
+
+// Section C.3 Arithmetic decoding procedure
+
+type qeEntry struct {
+	qe        uint32
+	nmps      uint8
+	nlps      uint8
+	switchMPS bool
+}
+
+// Table C.2 Qe values and probability estimation
+var qeTable = [47]qeEntry{
+	{0x5601, 1, 1, true},
+	{0x3401, 2, 6, false},
+	{0x1801, 3, 9, false},
+	{0x0AC1, 4, 12, false},
+	{0x0521, 5, 29, false},
+	{0x0221, 38, 33, false},
+	{0x5601, 7, 6, true},
+	{0x5401, 8, 14, false},
+	{0x4801, 9, 14, false},
+	{0x3801, 10, 14, false},
+	{0x3001, 11, 17, false},
+	{0x2401, 12, 18, false},
+	{0x1C01, 13, 20, false},
+	{0x1601, 29, 21, false},
+	{0x5601, 15, 14, true},
+	{0x5401, 16, 14, false},
+	{0x5101, 17, 15, false},
+	{0x4801, 18, 16, false},
+	{0x3801, 19, 17, false},
+	{0x3401, 20, 18, false},
+	{0x3001, 21, 19, false},
+	{0x2801, 22, 19, false},
+	{0x2401, 23, 20, false},
+	{0x2201, 24, 21, false},
+	{0x1C01, 25, 22, false},
+	{0x1801, 26, 23, false},
+	{0x1601, 27, 24, false},
+	{0x1401, 28, 25, false},
+	{0x1201, 29, 26, false},
+	{0x1101, 30, 27, false},
+	{0x0AC1, 31, 28, false},
+	{0x09C1, 32, 29, false},
+	{0x08A1, 33, 30, false},
+	{0x0521, 34, 31, false},
+	{0x0441, 35, 32, false},
+	{0x02A1, 36, 33, false},
+	{0x0221, 37, 34, false},
+	{0x0141, 38, 35, false},
+	{0x0111, 39, 36, false},
+	{0x0085, 40, 37, false},
+	{0x0049, 41, 38, false},
+	{0x0025, 42, 39, false},
+	{0x0015, 43, 40, false},
+	{0x0009, 44, 41, false},
+	{0x0005, 45, 42, false},
+	{0x0001, 45, 43, false},
+	{0x5601, 46, 46, false},
+}
+
+// context is the state of one arithmetic coding context: the index into qeTable and the more probable symbol.
+type context struct {
+	index uint8
+	mps   uint8
+}
+
+// arithmeticDecoder is the MQ decoder used for code-block data. Bytes beyond the end of the data are read as 0xFF, as
+// required when a terminated segment is decoded.
+type arithmeticDecoder struct {
+	data []byte
+	bp   int
+
+	c  uint32
+	a  uint32
+	ct uint32
+}
+
+func (decoder *arithmeticDecoder) byteAt(position int) byte {
+	if position < len(decoder.data) {
+		return decoder.data[position]
+	}
+
+	return 0xFF
+}
+
+// init prepares to decode data (INITDEC).
+func (decoder *arithmeticDecoder) init(data []byte) {
+	decoder.data = data
+	decoder.bp = 0
+	decoder.c = uint32(decoder.byteAt(0)) << 16
+	decoder.byteIn()
+	decoder.c <<= 7
+	decoder.ct -= 7
+	decoder.a = 0x8000
+}
+
+// byteIn reads the next byte into the C register (BYTEIN), skipping the bit stuffed after 0xFF.
+func (decoder *arithmeticDecoder) byteIn() {
+	if decoder.byteAt(decoder.bp) == 0xFF {
+		if decoder.byteAt(decoder.bp+1) > 0x8F {
+			decoder.c += 0xFF00
+			decoder.ct = 8
+		} else {
+			decoder.bp++
+			decoder.c += uint32(decoder.byteAt(decoder.bp)) << 9
+			decoder.ct = 7
+		}
+	} else {
+		decoder.bp++
+		decoder.c += uint32(decoder.byteAt(decoder.bp)) << 8
+		decoder.ct = 8
+	}
+}
+
+// decode returns the next decision coded with cx (DECODE).
+func (decoder *arithmeticDecoder) decode(cx *context) uint8 {
+	entry := &qeTable[cx.index]
+	var d uint8
+
+	decoder.a -= entry.qe
+	if decoder.c>>16 < entry.qe {
+		// LPS_EXCHANGE
+		if decoder.a < entry.qe {
+			d = cx.mps
+			cx.index = entry.nmps
+		} else {
+			d = 1 - cx.mps
+			if entry.switchMPS {
+				cx.mps = 1 - cx.mps
+			}
+			cx.index = entry.nlps
+		}
+		decoder.a = entry.qe
+	} else {
+		decoder.c -= entry.qe << 16
+		if decoder.a&0x8000 != 0 {
+			return cx.mps
+		}
+
+		// MPS_EXCHANGE
+		if decoder.a < entry.qe {
+			d = 1 - cx.mps
+			if entry.switchMPS {
+				cx.mps = 1 - cx.mps
+			}
+			cx.index = entry.nlps
+		} else {
+			d = cx.mps
+			cx.index = entry.nmps
+		}
+	}
+
+	// RENORMD
+	for {
+		if decoder.ct == 0 {
+			decoder.byteIn()
+		}
+
+		decoder.a <<= 1
+		decoder.c <<= 1
+		decoder.ct--
+
+		if decoder.a&0x8000 != 0 {
+			break
+		}
+	}
+
+	return d
+}
+
+// rawDecoder reads the bits of a segment coded without the arithmetic coder, as used by the selective arithmetic
+// coding bypass. A 0 bit is stuffed after each 0xFF byte.
+type rawDecoder struct {
+	data []byte
+	bp   int
+
+	c  byte
+	ct uint
+}
+
+func (decoder *rawDecoder) init(data []byte) {
+	decoder.data = data
+	decoder.bp = 0
+	decoder.c = 0
+	decoder.ct = 0
+}
+
+func (decoder *rawDecoder) decode() uint8 {
+	if decoder.ct == 0 {
+		previous := decoder.c
+
+		if decoder.bp < len(decoder.data) {
+			decoder.c = decoder.data[decoder.bp]
+			decoder.bp++
+		} else {
+			decoder.c = 0xFF
+		}
+
+		if previous == 0xFF {
+			decoder.ct = 7
+		} else {
+			decoder.ct = 8
+		}
+	}
+
+	decoder.ct--
+	return (decoder.c >> decoder.ct) & 1
+}
@@ -0,0 +1,408 @@
+package jpeg2000
+
+// Annex D Coefficient bit modelling
+
+// Context labels, with zero coding using labels 0 to 8, sign coding 9 to 13 and magnitude refinement 14 to 16.
+const (
+	contextRefinementStart = 14
+	contextRunLength       = 17
+	contextUniform         = 18
+	contextCount           = 19
+)
+
+const (
+	flagSignificant uint8 = 1 << iota
+	flagNegative
+	flagVisited
+	flagRefined
+)
+
+// Coding pass types, in the order they occur within a bit-plane after the first cleanup pass.
+const (
+	significancePropagationPass = iota
+	magnitudeRefinementPass
+	cleanupPass
+)
+
+// Table D.1 contexts for the zero coding of the LL and LH sub-bands, indexed by the number of significant horizontal,
+// vertical and diagonal neighbours.
+func zeroCodingContextLL(h, v, d int) uint8 {
+	switch h {
+	case 2:
+		return 8
+	case 1:
+		if v >= 1 {
+			return 7
+		}
+		if d >= 1 {
+			return 6
+		}
+		return 5
+	}
+
+	switch v {
+	case 2:
+		return 4
+	case 1:
+		return 3
+	}
+
+	if d >= 2 {
+		return 2
+	}
+	return uint8(d)
+}
+
+// Table D.1 contexts for the zero coding of the HH sub-band.
+func zeroCodingContextHH(h, v, d int) uint8 {
+	hv := h + v
+
+	switch {
+	case d >= 3:
+		return 8
+	case d == 2:
+		if hv >= 1 {
+			return 7
+		}
+		return 6
+	case d == 1:
+		if hv >= 2 {
+			return 5
+		}
+		return uint8(3 + hv)
+	}
+
+	if hv >= 2 {
+		return 2
+	}
+	return uint8(hv)
+}
+
+// Table D.3 contexts and XOR bits for sign coding, indexed by the horizontal and vertical contributions plus one.
+var signContexts = [3][3]struct {
+	context uint8
+	xorBit  uint8
+}{
+	// H = -1
+	{{13, 1}, {12, 1}, {11, 1}},
+	// H = 0
+	{{10, 1}, {9, 0}, {10, 0}},
+	// H = 1
+	{{11, 0}, {12, 0}, {13, 0}},
+}
+
+// bitModel decodes the coding passes of a code-block into the magnitude and sign of each coefficient.
+type bitModel struct {
+	width  int
+	height int
+	stride int
+
+	// flags has a border of one sample around the code-block, so that neighbours can be read without bounds checks.
+	flags []uint8
+	// magnitudes holds twice the reconstructed magnitude of each coefficient, so that the mid-point of the interval
+	// remaining after the decoded bit-planes is an integer.
+	magnitudes []int32
+
+	subbandType      string
+	verticallyCausal bool
+
+	contexts [contextCount]context
+	mq       arithmeticDecoder
+	raw      rawDecoder
+	useRaw   bool
+}
+
+func newBitModel(width, height int, subbandType string, style *CodingStyle) *bitModel {
+	var model bitModel
+
+	model.width = width
+	model.height = height
+	model.stride = width + 2
+	model.flags = make([]uint8, (width+2)*(height+2))
+	model.magnitudes = make([]int32, width*height)
+	model.subbandType = subbandType
+	model.verticallyCausal = style.VerticallyCasualContext
+	model.resetContexts()
+
+	return &model
+}
+
+// resetContexts sets the contexts to the initial states of Table D.7.
+func (model *bitModel) resetContexts() {
+	for i := range model.contexts {
+		model.contexts[i] = context{}
+	}
+
+	model.contexts[0].index = 4
+	model.contexts[contextRunLength].index = 3
+	model.contexts[contextUniform].index = 46
+}
+
+func (model *bitModel) decodeBit(label uint8) uint8 {
+	if model.useRaw {
+		return model.raw.decode()
+	}
+
+	return model.mq.decode(&model.contexts[label])
+}
+
+// neighbours counts the significant horizontal, vertical and diagonal neighbours of the coefficient at (x, y). With
+// vertically causal context formation the row below a stripe is treated as insignificant.
+func (model *bitModel) neighbours(x, y int) (int, int, int) {
+	index := (y+1)*model.stride + x + 1
+	flags := model.flags
+	stride := model.stride
+
+	significant := func(i int) int {
+		return int(flags[i] & flagSignificant)
+	}
+
+	h := significant(index-1) + significant(index+1)
+	v := significant(index - stride)
+	d := significant(index-stride-1) + significant(index-stride+1)
+
+	if !model.verticallyCausal || y%4 != 3 {
+		v += significant(index + stride)
+		d += significant(index+stride-1) + significant(index+stride+1)
+	}
+
+	return h, v, d
+}
+
+func (model *bitModel) zeroCodingContext(x, y int) uint8 {
+	h, v, d := model.neighbours(x, y)
+
+	switch model.subbandType {
+	case "HL":
+		return zeroCodingContextLL(v, h, d)
+	case "HH":
+		return zeroCodingContextHH(h, v, d)
+	}
+
+	return zeroCodingContextLL(h, v, d)
+}
+
+// contribution returns the sign contribution of the neighbour with the given flags (Table D.2).
+func contribution(flags uint8) int {
+	if flags&flagSignificant == 0 {
+		return 0
+	}
+	if flags&flagNegative != 0 {
+		return -1
+	}
+	return 1
+}
+
+func clampContribution(value int) int {
+	if value > 1 {
+		return 1
+	}
+	if value < -1 {
+		return -1
+	}
+	return value
+}
+
+// decodeSign decodes the sign of the coefficient at (x, y) as it becomes significant, and reports whether it is
+// negative.
+func (model *bitModel) decodeSign(x, y int) bool {
+	if model.useRaw {
+		return model.raw.decode() == 1
+	}
+
+	index := (y+1)*model.stride + x + 1
+
+	h := clampContribution(contribution(model.flags[index-1]) + contribution(model.flags[index+1]))
+	vertical := contribution(model.flags[index-model.stride])
+	if !model.verticallyCausal || y%4 != 3 {
+		vertical += contribution(model.flags[index+model.stride])
+	}
+	v := clampContribution(vertical)
+
+	entry := signContexts[h+1][v+1]
+
+	return model.mq.decode(&model.contexts[entry.context])^entry.xorBit == 1
+}
+
+// setSignificant records that the coefficient at (x, y) became significant in bit-plane p.
+func (model *bitModel) setSignificant(x, y int, negative bool, p uint) {
+	index := (y+1)*model.stride + x + 1
+
+	model.flags[index] |= flagSignificant
+	if negative {
+		model.flags[index] |= flagNegative
+	}
+
+	model.magnitudes[y*model.width+x] = 3 << p
+}
+
+// significancePropagation decodes a significance propagation pass of bit-plane p (Section D.3.1).
+func (model *bitModel) significancePropagation(p uint) {
+	for y0 := 0; y0 < model.height; y0 += 4 {
+		for x := 0; x < model.width; x++ {
+			for y := y0; y < y0+4 && y < model.height; y++ {
+				index := (y+1)*model.stride + x + 1
+				if model.flags[index]&flagSignificant != 0 {
+					continue
+				}
+
+				label := model.zeroCodingContext(x, y)
+				if label == 0 {
+					continue
+				}
+
+				if model.decodeBit(label) == 1 {
+					model.setSignificant(x, y, model.decodeSign(x, y), p)
+				}
+
+				model.flags[index] |= flagVisited
+			}
+		}
+	}
+}
+
+// magnitudeRefinement decodes a magnitude refinement pass of bit-plane p (Section D.3.3).
+func (model *bitModel) magnitudeRefinement(p uint) {
+	for y0 := 0; y0 < model.height; y0 += 4 {
+		for x := 0; x < model.width; x++ {
+			for y := y0; y < y0+4 && y < model.height; y++ {
+				index := (y+1)*model.stride + x + 1
+				flags := model.flags[index]
+				if flags&flagSignificant == 0 || flags&flagVisited != 0 {
+					continue
+				}
+
+				// Table D.4 contexts for magnitude refinement
+				label := uint8(contextRefinementStart)
+				if flags&flagRefined != 0 {
+					label += 2
+				} else if h, v, d := model.neighbours(x, y); h+v+d > 0 {
+					label++
+				}
+
+				bit := model.decodeBit(label)
+				// Move the reconstruction to the mid-point of the upper or lower half of the interval
+				if bit == 1 {
+					model.magnitudes[y*model.width+x] += 1 << p
+				} else {
+					model.magnitudes[y*model.width+x] -= 1 << p
+				}
+
+				model.flags[index] |= flagRefined
+			}
+		}
+	}
+}
+
+// cleanup decodes a cleanup pass of bit-plane p (Section D.3.4), and then clears the flags of the coefficients visited
+// by the significance propagation pass.
+func (model *bitModel) cleanup(p uint, segmentationSymbols bool) {
+	for y0 := 0; y0 < model.height; y0 += 4 {
+		for x := 0; x < model.width; x++ {
+			y := y0
+
+			if y0+4 <= model.height && model.canUseRunLength(x, y0) {
+				if model.decodeBit(contextRunLength) == 0 {
+					continue
+				}
+
+				run := int(model.decodeBit(contextUniform)) << 1
+				run |= int(model.decodeBit(contextUniform))
+
+				y = y0 + run
+				model.setSignificant(x, y, model.decodeSign(x, y), p)
+				y++
+			}
+
+			for ; y < y0+4 && y < model.height; y++ {
+				index := (y+1)*model.stride + x + 1
+				if model.flags[index]&(flagSignificant|flagVisited) != 0 {
+					continue
+				}
+
+				if model.decodeBit(model.zeroCodingContext(x, y)) == 1 {
+					model.setSignificant(x, y, model.decodeSign(x, y), p)
+				}
+			}
+		}
+	}
+
+	if segmentationSymbols {
+		// Section D.5 the segmentation symbol 1010 follows each cleanup pass
+		for i := 0; i < 4; i++ {
+			model.decodeBit(contextUniform)
+		}
+	}
+
+	for i := range model.flags {
+		model.flags[i] &^= flagVisited
+	}
+}
+
+// canUseRunLength reports whether the column of four coefficients starting at (x, y0) is decoded in run-length mode,
+// which requires that none is significant or visited and that all have insignificant neighbourhoods.
+func (model *bitModel) canUseRunLength(x, y0 int) bool {
+	for y := y0; y < y0+4; y++ {
+		index := (y+1)*model.stride + x + 1
+		if model.flags[index]&(flagSignificant|flagVisited) != 0 {
+			return false
+		}
+
+		if h, v, d := model.neighbours(x, y); h+v+d > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// decode runs the first numPasses coding passes held in the segments, starting with the cleanup pass of the most
+// significant of numBitPlanes bit-planes.
+func (model *bitModel) decode(segments []*segment, numPasses uint32, numBitPlanes int, style *CodingStyle) {
+	passType := cleanupPass
+	plane := numBitPlanes - 1
+	passIndex := uint32(0)
+
+	for _, segment := range segments {
+		if plane < 0 || passIndex >= numPasses {
+			break
+		}
+
+		// Section D.6 in bypass mode the significance propagation and magnitude refinement passes after the first
+		// four bit-planes are not arithmetic coded.
+		model.useRaw = style.ArithmeticCodingBypass && passIndex >= 10 && passType != cleanupPass
+		if model.useRaw {
+			model.raw.init(segment.data)
+		} else {
+			model.mq.init(segment.data)
+		}
+
+		for i := uint32(0); i < segment.passes && plane >= 0 && passIndex < numPasses; i++ {
+			switch passType {
+			case significancePropagationPass:
+				model.significancePropagation(uint(plane))
+			case magnitudeRefinementPass:
+				model.magnitudeRefinement(uint(plane))
+			case cleanupPass:
+				model.cleanup(uint(plane), style.SegmentationSymbols)
+			}
+
+			if style.ResetContextProbabilties {
+				model.resetContexts()
+			}
+
+			passIndex++
+			if passType == cleanupPass {
+				passType = significancePropagationPass
+				plane--
+			} else {
+				passType++
+			}
+		}
+	}
+}
+
+// isNegative reports whether the coefficient at (x, y) is negative.
+func (model *bitModel) isNegative(x, y int) bool {
+	return model.flags[(y+1)*model.stride+x+1]&flagNegative != 0
+}
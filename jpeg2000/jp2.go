@@ -0,0 +1,838 @@
+package jpeg2000
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// Annex I JP2 file format syntax, with the boxes of the JPX extended file format (ISO/IEC 15444-2 Annex M) that are
+// needed to find the codestream.
+
+// Box types, Table I.2
+const (
+	signatureBox                uint32 = 0x6a502020 // 'jP  '
+	fileTypeBox                 uint32 = 0x66747970 // 'ftyp'
+	jp2HeaderBox                uint32 = 0x6a703268 // 'jp2h'
+	imageHeaderBox              uint32 = 0x69686472 // 'ihdr'
+	colourSpecificationBox      uint32 = 0x636f6c72 // 'colr'
+	paletteBox                  uint32 = 0x70636c72 // 'pclr'
+	componentMappingBox         uint32 = 0x636d6170 // 'cmap'
+	resolutionBox               uint32 = 0x72657320 // 'res '
+	captureResolutionBox        uint32 = 0x72657363 // 'resc'
+	defaultDisplayResolutionBox uint32 = 0x72657364 // 'resd'
+	contiguousCodestreamBox     uint32 = 0x6a703263 // 'jp2c'
+	xmlBox                      uint32 = 0x786d6c20 // 'xml '
+	fragmentTableBox            uint32 = 0x6674626c // 'ftbl'
+)
+
+const (
+	// signatureBoxLength is the length of the Signature box, whose contents are signatureBoxContents.
+	signatureBoxLength   = 12
+	signatureBoxContents = 0x0d0a870a
+	// compressionTypeJPEG2000 is the only compression type of the Image Header box.
+	compressionTypeJPEG2000 = 7
+	// Bit depths in the Image Header and Palette boxes are coded as in Ssiz, with the depth minus one in the low bits
+	// and a flag for signed values, or variableBitDepth when the components differ.
+	bitDepthMask       = 0x7f
+	bitDepthSignedFlag = 0x80
+	variableBitDepth   = 0xff
+)
+
+// FileType is the content of the File Type box (Section I.5.2).
+type FileType struct {
+	// Brand is the recommended reader for the file, "jp2 " for a JP2 file and "jpx " for a JPX file.
+	Brand string
+	// MinorVersion is the minor version of the brand.
+	MinorVersion uint32
+	// Compatibility lists the readers that can interpret the file.
+	Compatibility []string
+}
+
+// isCompatible reports whether the file can be read by a JP2 reader, or a JPX reader restricted to a single
+// contiguous codestream.
+func (fileType *FileType) isCompatible() bool {
+	brands := append([]string{fileType.Brand}, fileType.Compatibility...)
+	for _, brand := range brands {
+		switch brand {
+		case "jp2 ", "jpx ", "jpxb":
+			return true
+		}
+	}
+
+	return false
+}
+
+// ImageHeader is the content of the Image Header box (Section I.5.3.1).
+type ImageHeader struct {
+	Height        uint32
+	Width         uint32
+	NumComponents uint16
+	// BitDepth and Signed describe every component, unless VariableBitDepth is set when the components differ and
+	// their sizes are given by the codestream.
+	BitDepth         uint8
+	Signed           bool
+	VariableBitDepth bool
+	// CompressionType is 7 for JPEG 2000.
+	CompressionType uint8
+	// UnknownColourSpace is set when the colour space of the image is not known for certain.
+	UnknownColourSpace bool
+	// IntellectualProperty is set when the file holds intellectual property rights information.
+	IntellectualProperty bool
+}
+
+// ColourMethod is the method used by a Colour Specification box to give the colour space of the image.
+type ColourMethod uint8
+
+// Colour specification methods, Table I.9 and Table M.22
+const (
+	EnumeratedColourMethod ColourMethod = 1
+	RestrictedICCMethod    ColourMethod = 2
+	AnyICCMethod           ColourMethod = 3
+	VendorColourMethod     ColourMethod = 4
+)
+
+// ColourSpace is an enumerated colour space.
+type ColourSpace uint32
+
+// Enumerated colour spaces, Table I.10 and Table M.25
+const (
+	BilevelColourSpace   ColourSpace = 0
+	YCbCr1ColourSpace    ColourSpace = 1
+	CMYKColourSpace      ColourSpace = 12
+	CIELabColourSpace    ColourSpace = 14
+	SRGBColourSpace      ColourSpace = 16
+	GreyscaleColourSpace ColourSpace = 17
+	SYCCColourSpace      ColourSpace = 18
+)
+
+// ColourSpecification is the content of a Colour Specification box (Section I.5.3.3).
+type ColourSpecification struct {
+	Method        ColourMethod
+	Precedence    int8
+	Approximation uint8
+	// ColourSpace is set for the enumerated method.
+	ColourSpace ColourSpace
+	// Profile holds the ICC profile for the ICC methods, and the vendor UUID followed by the vendor parameters for the
+	// vendor method.
+	Profile []byte
+}
+
+// PaletteColumn is one column of a palette, giving a value of a generated component for each entry.
+type PaletteColumn struct {
+	BitDepth uint8
+	Signed   bool
+	Values   []int32
+}
+
+// Palette is the content of the Palette box (Section I.5.3.4).
+type Palette struct {
+	NumEntries uint16
+	Columns    []PaletteColumn
+}
+
+// MappingType gives how a channel of the image is formed from a component of the codestream.
+type MappingType uint8
+
+// Component mapping types, Table I.14
+const (
+	DirectMapping  MappingType = 0
+	PaletteMapping MappingType = 1
+)
+
+// ComponentMapping maps a component of the codestream, directly or through a column of the palette, to a channel of
+// the image (Section I.5.3.5).
+type ComponentMapping struct {
+	Component     uint16
+	Type          MappingType
+	PaletteColumn uint8
+}
+
+// Resolution is the content of a Capture Resolution or Default Display Resolution box (Section I.5.3.7), giving the
+// number of grid points per metre as (numerator / denominator) * 10^exponent.
+type Resolution struct {
+	VerticalNumerator     uint16
+	VerticalDenominator   uint16
+	HorizontalNumerator   uint16
+	HorizontalDenominator uint16
+	VerticalExponent      int8
+	HorizontalExponent    int8
+}
+
+// PixelsPerMetre returns the horizontal and vertical resolution in grid points per metre.
+func (resolution *Resolution) PixelsPerMetre() (float64, float64) {
+	value := func(numerator, denominator uint16, exponent int8) float64 {
+		if denominator == 0 {
+			return 0
+		}
+
+		return float64(numerator) / float64(denominator) * math.Pow10(int(exponent))
+	}
+
+	return value(resolution.HorizontalNumerator, resolution.HorizontalDenominator, resolution.HorizontalExponent),
+		value(resolution.VerticalNumerator, resolution.VerticalDenominator, resolution.VerticalExponent)
+}
+
+// MicronsPerPixel returns the horizontal and vertical size of a grid point in micrometres, or 0 when the resolution is
+// not defined.
+func (resolution *Resolution) MicronsPerPixel() (float64, float64) {
+	x, y := resolution.PixelsPerMetre()
+
+	micrometres := func(pixelsPerMetre float64) float64 {
+		if pixelsPerMetre == 0 {
+			return 0
+		}
+
+		return 1e6 / pixelsPerMetre
+	}
+
+	return micrometres(x), micrometres(y)
+}
+
+// JP2Header is the content of the JP2 Header box (Section I.5.3).
+type JP2Header struct {
+	ImageHeader          ImageHeader
+	ColourSpecifications []ColourSpecification
+	// Palette and ComponentMapping are set for images whose channels are generated from the palette.
+	Palette          *Palette
+	ComponentMapping []ComponentMapping
+	// CaptureResolution is the resolution at which the image was captured, and DisplayResolution the resolution at
+	// which it should be displayed. Either is nil when not given.
+	CaptureResolution *Resolution
+	DisplayResolution *Resolution
+}
+
+// ColourSpace returns the enumerated colour space of the image, from the Colour Specification box with the highest
+// precedence that uses the enumerated method.
+func (header *JP2Header) ColourSpace() (ColourSpace, bool) {
+	found := false
+	var best ColourSpecification
+
+	for _, specification := range header.ColourSpecifications {
+		if specification.Method != EnumeratedColourMethod {
+			continue
+		}
+
+		if !found || specification.Precedence > best.Precedence {
+			best = specification
+			found = true
+		}
+	}
+
+	return best.ColourSpace, found
+}
+
+// File is a JP2 or JPX file, holding the boxes that describe the image and the main header of its codestream.
+type File struct {
+	FileType FileType
+	Header   JP2Header
+	// XML holds the content of each XML box.
+	XML []string
+	// Codestream holds the header of the first contiguous codestream.
+	Codestream Header
+}
+
+// isJP2 reports whether data starts with the JP2 Signature box.
+func isJP2(data []byte) bool {
+	return len(data) >= signatureBoxLength &&
+		binary.BigEndian.Uint32(data) == signatureBoxLength &&
+		binary.BigEndian.Uint32(data[4:]) == signatureBox &&
+		binary.BigEndian.Uint32(data[8:]) == signatureBoxContents
+}
+
+// DecodeFile reads the boxes of a JP2 or JPX file from r, along with the headers of its codestream. The image can then
+// be decoded with Decode.
+func DecodeFile(r io.Reader) (*File, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var file File
+
+	err = file.read(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &file, nil
+}
+
+// readHeaderBoxes reads the boxes of a JP2 or JPX file from r up to the first Contiguous Codestream box, which is cut
+// short after the main header of the codestream. The result can be parsed with File.read without reading the tiles.
+func readHeaderBoxes(r io.Reader) ([]byte, error) {
+	var data bytes.Buffer
+
+	header := make([]byte, 16)
+	for {
+		_, err := io.ReadFull(r, header[:8])
+		if err == io.EOF {
+			// There is no codestream, which File.read reports
+			return data.Bytes(), nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		length := int64(binary.BigEndian.Uint32(header))
+		boxType := binary.BigEndian.Uint32(header[4:])
+		headerLength := int64(8)
+
+		if length == 1 {
+			_, err = io.ReadFull(r, header[8:])
+			if err != nil {
+				return nil, io.ErrUnexpectedEOF
+			}
+
+			length = int64(binary.BigEndian.Uint64(header[8:]))
+			headerLength = 16
+		}
+
+		contents := r
+		if length != 0 {
+			if length < headerLength {
+				return nil, FormatError("invalid box length")
+			}
+
+			contents = io.LimitReader(r, length-headerLength)
+		}
+
+		if boxType == contiguousCodestreamBox {
+			mainHeader, err := readMainHeader(contents)
+			if err != nil {
+				return nil, err
+			}
+
+			binary.BigEndian.PutUint32(header, uint32(8+len(mainHeader)))
+			data.Write(header[:8])
+			data.Write(mainHeader)
+
+			return data.Bytes(), nil
+		}
+
+		data.Write(header[:headerLength])
+
+		if length == 0 {
+			// The box extends to the end of the file
+			_, err = data.ReadFrom(r)
+		} else {
+			_, err = io.CopyN(&data, r, length-headerLength)
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readBox returns the type and contents of the box starting at position, and the position of the next box (Section
+// I.4).
+func readBox(data []byte, position int) (uint32, []byte, int, error) {
+	if position+8 > len(data) {
+		return 0, nil, 0, io.ErrUnexpectedEOF
+	}
+
+	length := uint64(binary.BigEndian.Uint32(data[position:]))
+	boxType := binary.BigEndian.Uint32(data[position+4:])
+	headerLength := uint64(8)
+
+	switch length {
+	case 0:
+		// The box extends to the end of the file
+		length = uint64(len(data) - position)
+	case 1:
+		if position+16 > len(data) {
+			return 0, nil, 0, io.ErrUnexpectedEOF
+		}
+
+		length = binary.BigEndian.Uint64(data[position+8:])
+		headerLength = 16
+	}
+
+	if length < headerLength {
+		return 0, nil, 0, FormatError("invalid box length")
+	}
+	if length > uint64(len(data)-position) {
+		return 0, nil, 0, io.ErrUnexpectedEOF
+	}
+
+	end := position + int(length)
+
+	return boxType, data[position+int(headerLength) : end], end, nil
+}
+
+// read parses the boxes of the file, including the codestream of the first Contiguous Codestream box. Boxes after the
+// codestream, such as XML boxes, are also read.
+func (file *File) read(data []byte) error {
+	if !isJP2(data) {
+		return FormatError("missing JP2 signature box")
+	}
+
+	position := signatureBoxLength
+	fileTypeFound := false
+	headerFound := false
+	codestreamFound := false
+	fragmented := false
+
+	for position < len(data) {
+		boxType, contents, next, err := readBox(data, position)
+		if err != nil {
+			return err
+		}
+
+		// Section I.5.2 the File Type box immediately follows the Signature box
+		if !fileTypeFound && boxType != fileTypeBox {
+			return FormatError("missing file type box")
+		}
+
+		switch boxType {
+		case fileTypeBox:
+			if fileTypeFound {
+				return FormatError("repeated file type box")
+			}
+			fileTypeFound = true
+
+			err = file.FileType.read(contents)
+			if err == nil && !file.FileType.isCompatible() {
+				err = UnsupportedError("file type " + file.FileType.Brand)
+			}
+		case jp2HeaderBox:
+			if headerFound {
+				return FormatError("repeated JP2 header box")
+			}
+			headerFound = true
+
+			err = file.Header.read(contents)
+		case xmlBox:
+			file.XML = append(file.XML, string(contents))
+		case fragmentTableBox:
+			fragmented = true
+		case contiguousCodestreamBox:
+			if !headerFound {
+				return FormatError("missing JP2 header box")
+			}
+
+			// Only the first codestream is decoded
+			if !codestreamFound {
+				codestreamFound = true
+
+				err = file.Codestream.readCodestream(contents)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+
+		position = next
+	}
+
+	if codestreamFound {
+		return file.validate()
+	}
+	if fragmented {
+		return UnsupportedError("fragmented codestream")
+	}
+
+	return FormatError("missing contiguous codestream box")
+}
+
+// validate checks that the header boxes agree with the codestream.
+func (file *File) validate() error {
+	header := &file.Header
+
+	if header.ImageHeader.NumComponents != file.Codestream.GetNumComponents() {
+		return FormatError("number of components differs from codestream")
+	}
+
+	for _, mapping := range header.ComponentMapping {
+		if mapping.Component >= header.ImageHeader.NumComponents {
+			return FormatError("component mapping of missing component")
+		}
+
+		if mapping.Type == PaletteMapping && (header.Palette == nil || int(mapping.PaletteColumn) >= len(header.Palette.Columns)) {
+			return FormatError("component mapping of missing palette column")
+		}
+	}
+
+	if header.Palette != nil && header.ComponentMapping == nil {
+		return FormatError("palette without component mapping")
+	}
+
+	return nil
+}
+
+func (fileType *FileType) read(contents []byte) error {
+	if len(contents) < 8 || len(contents)%4 != 0 {
+		return FormatError("invalid file type box")
+	}
+
+	fileType.Brand = string(contents[:4])
+	fileType.MinorVersion = binary.BigEndian.Uint32(contents[4:])
+	fileType.Compatibility = nil
+	for i := 8; i < len(contents); i += 4 {
+		fileType.Compatibility = append(fileType.Compatibility, string(contents[i:i+4]))
+	}
+
+	return nil
+}
+
+// read parses the boxes of the JP2 Header box, skipping those that are not needed to decode the image.
+func (header *JP2Header) read(contents []byte) error {
+	imageHeaderFound := false
+
+	for position := 0; position < len(contents); {
+		boxType, box, next, err := readBox(contents, position)
+		if err != nil {
+			return err
+		}
+
+		// Section I.5.3 the Image Header box is the first in the JP2 Header box
+		if !imageHeaderFound && boxType != imageHeaderBox {
+			return FormatError("missing image header box")
+		}
+
+		switch boxType {
+		case imageHeaderBox:
+			if imageHeaderFound {
+				return FormatError("repeated image header box")
+			}
+			imageHeaderFound = true
+
+			err = header.ImageHeader.read(box)
+		case colourSpecificationBox:
+			var specification ColourSpecification
+			err = specification.read(box)
+			header.ColourSpecifications = append(header.ColourSpecifications, specification)
+		case paletteBox:
+			header.Palette = &Palette{}
+			err = header.Palette.read(box)
+		case componentMappingBox:
+			header.ComponentMapping, err = readComponentMapping(box)
+		case resolutionBox:
+			err = header.readResolution(box)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		position = next
+	}
+
+	if !imageHeaderFound {
+		return FormatError("missing image header box")
+	}
+
+	return nil
+}
+
+func (imageHeader *ImageHeader) read(contents []byte) error {
+	if len(contents) != 14 {
+		return FormatError("invalid image header box")
+	}
+
+	imageHeader.Height = binary.BigEndian.Uint32(contents)
+	imageHeader.Width = binary.BigEndian.Uint32(contents[4:])
+	imageHeader.NumComponents = binary.BigEndian.Uint16(contents[8:])
+
+	bitDepth := contents[10]
+	if bitDepth == variableBitDepth {
+		imageHeader.VariableBitDepth = true
+	} else {
+		imageHeader.BitDepth = bitDepth&bitDepthMask + 1
+		imageHeader.Signed = bitDepth&bitDepthSignedFlag != 0
+	}
+
+	imageHeader.CompressionType = contents[11]
+	imageHeader.UnknownColourSpace = contents[12] != 0
+	imageHeader.IntellectualProperty = contents[13] != 0
+
+	if imageHeader.NumComponents == 0 {
+		return FormatError("image header box without components")
+	}
+	if imageHeader.CompressionType != compressionTypeJPEG2000 {
+		return UnsupportedError("compression type other than JPEG 2000")
+	}
+
+	return nil
+}
+
+func (specification *ColourSpecification) read(contents []byte) error {
+	if len(contents) < 3 {
+		return FormatError("invalid colour specification box")
+	}
+
+	specification.Method = ColourMethod(contents[0])
+	specification.Precedence = int8(contents[1])
+	specification.Approximation = contents[2]
+
+	if specification.Method == EnumeratedColourMethod {
+		// Colour spaces such as CIELab are followed by parameters, which are ignored
+		if len(contents) < 7 {
+			return FormatError("invalid colour specification box")
+		}
+
+		specification.ColourSpace = ColourSpace(binary.BigEndian.Uint32(contents[3:]))
+	} else {
+		specification.Profile = contents[3:]
+	}
+
+	return nil
+}
+
+func (palette *Palette) read(contents []byte) error {
+	if len(contents) < 3 {
+		return FormatError("invalid palette box")
+	}
+
+	palette.NumEntries = binary.BigEndian.Uint16(contents)
+	numColumns := int(contents[2])
+	if palette.NumEntries == 0 || palette.NumEntries > 1024 || numColumns == 0 || len(contents) < 3+numColumns {
+		return FormatError("invalid palette box")
+	}
+
+	palette.Columns = make([]PaletteColumn, numColumns)
+	entrySize := 0
+	for i := range palette.Columns {
+		column := &palette.Columns[i]
+		column.BitDepth = contents[3+i]&bitDepthMask + 1
+		column.Signed = contents[3+i]&bitDepthSignedFlag != 0
+		column.Values = make([]int32, palette.NumEntries)
+
+		if column.BitDepth > 16 {
+			return UnsupportedError("palette bit depth above 16")
+		}
+		entrySize += int(column.BitDepth+7) / 8
+	}
+
+	position := 3 + numColumns
+	if len(contents) < position+entrySize*int(palette.NumEntries) {
+		return FormatError("short palette box")
+	}
+
+	// Each entry holds a value of each column, in the fewest bytes that hold the bit depth of the column
+	for entry := 0; entry < int(palette.NumEntries); entry++ {
+		for i := range palette.Columns {
+			column := &palette.Columns[i]
+
+			var value uint32
+			if column.BitDepth > 8 {
+				value = uint32(binary.BigEndian.Uint16(contents[position:]))
+				position += 2
+			} else {
+				value = uint32(contents[position])
+				position++
+			}
+
+			value &= 1<<column.BitDepth - 1
+			if column.Signed && value&(1<<(column.BitDepth-1)) != 0 {
+				column.Values[entry] = int32(value) - 1<<column.BitDepth
+			} else {
+				column.Values[entry] = int32(value)
+			}
+		}
+	}
+
+	return nil
+}
+
+func readComponentMapping(contents []byte) ([]ComponentMapping, error) {
+	if len(contents) == 0 || len(contents)%4 != 0 {
+		return nil, FormatError("invalid component mapping box")
+	}
+
+	mapping := make([]ComponentMapping, len(contents)/4)
+	for i := range mapping {
+		mapping[i].Component = binary.BigEndian.Uint16(contents[i*4:])
+		mapping[i].Type = MappingType(contents[i*4+2])
+		mapping[i].PaletteColumn = contents[i*4+3]
+
+		if mapping[i].Type != DirectMapping && mapping[i].Type != PaletteMapping {
+			return nil, FormatError("invalid component mapping type")
+		}
+	}
+
+	return mapping, nil
+}
+
+// readResolution parses the Capture Resolution and Default Display Resolution boxes held in the Resolution box.
+func (header *JP2Header) readResolution(contents []byte) error {
+	for position := 0; position < len(contents); {
+		boxType, box, next, err := readBox(contents, position)
+		if err != nil {
+			return err
+		}
+
+		if boxType == captureResolutionBox || boxType == defaultDisplayResolutionBox {
+			var resolution Resolution
+
+			err = binary.Read(bytes.NewReader(box), binary.BigEndian, &resolution)
+			if err != nil || len(box) != 10 {
+				return FormatError("invalid resolution box")
+			}
+
+			if boxType == captureResolutionBox {
+				header.CaptureResolution = &resolution
+			} else {
+				header.DisplayResolution = &resolution
+			}
+		}
+
+		position = next
+	}
+
+	return nil
+}
+
+// Decode decodes the codestream of the file, mapping its components through the palette when there is one and
+// converting sYCC images to RGB.
+func (file *File) Decode() (image.Image, error) {
+	return file.DecodeWithOptions(nil)
+}
+
+// DecodeWithOptions decodes the part of the image selected by options, as Decode does.
+func (file *File) DecodeWithOptions(options *DecodeOptions) (image.Image, error) {
+	codestream := &file.Codestream
+
+	window, err := codestream.newDecodeWindow(options)
+	if err != nil {
+		return nil, err
+	}
+
+	planes, err := codestream.decodeComponents(window)
+	if err != nil {
+		return nil, err
+	}
+
+	components := codestream.Components
+	if file.Header.Palette != nil {
+		planes, components = file.Header.applyPalette(planes, components)
+	}
+
+	img := codestream.buildImage(planes, components, window)
+
+	if colourSpace, ok := file.Header.ColourSpace(); ok && colourSpace == SYCCColourSpace {
+		convertSYCC(img)
+	}
+
+	return img, nil
+}
+
+// Config returns the colour model and dimensions of the image that Decode returns, without decoding it.
+func (file *File) Config() image.Config {
+	components := file.Codestream.Components
+	if file.Header.Palette != nil {
+		components = file.Header.channelComponents(components)
+	}
+
+	return file.Codestream.config(components)
+}
+
+// applyPalette returns the channels of the image given by the component mapping, where the components mapped through
+// the palette are replaced by the palette column of their value (Section I.5.3.4).
+func (header *JP2Header) applyPalette(planes []*componentPlane, components []componentSize) ([]*componentPlane, []componentSize) {
+	channelPlanes := make([]*componentPlane, len(header.ComponentMapping))
+
+	for i, mapping := range header.ComponentMapping {
+		plane := planes[mapping.Component]
+
+		if mapping.Type == PaletteMapping {
+			column := header.Palette.Columns[mapping.PaletteColumn]
+			last := int32(len(column.Values) - 1)
+
+			mapped := *plane
+			mapped.samples = make([]int32, len(plane.samples))
+			for j, index := range plane.samples {
+				if index < 0 {
+					index = 0
+				} else if index > last {
+					index = last
+				}
+
+				mapped.samples[j] = column.Values[index]
+			}
+
+			plane = &mapped
+		}
+
+		channelPlanes[i] = plane
+	}
+
+	return channelPlanes, header.channelComponents(components)
+}
+
+// channelComponents returns the sizes of the channels given by the component mapping, where those mapped through the
+// palette take the bit depth and signedness of their palette column.
+func (header *JP2Header) channelComponents(components []componentSize) []componentSize {
+	channels := make([]componentSize, len(header.ComponentMapping))
+
+	for i, mapping := range header.ComponentMapping {
+		channels[i] = components[mapping.Component]
+
+		if mapping.Type == PaletteMapping {
+			column := header.Palette.Columns[mapping.PaletteColumn]
+			channels[i].BitDepth = column.BitDepth
+			channels[i].Signed = column.Signed
+		}
+	}
+
+	return channels
+}
+
+// convertSYCC converts an image whose first three channels hold sYCC (IEC 61966-2-1 Amendment 1) to RGB.
+func convertSYCC(img image.Image) {
+	convert := func(y, cb, cr, maximum float64) (float64, float64, float64) {
+		clamp := func(value float64) float64 {
+			return math.Max(0, math.Min(maximum, math.Floor(value+0.5)))
+		}
+
+		middle := (maximum + 1) / 2
+		cb -= middle
+		cr -= middle
+
+		return clamp(y + 1.402*cr), clamp(y - 0.344136*cb - 0.714136*cr), clamp(y + 1.772*cb)
+	}
+
+	bounds := img.Bounds()
+
+	switch img := img.(type) {
+	case *image.RGBA:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := img.RGBAAt(x, y)
+				r, g, b := convert(float64(c.R), float64(c.G), float64(c.B), 0xff)
+				img.SetRGBA(x, y, color.RGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: c.A})
+			}
+		}
+	case *image.RGBA64:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := img.RGBA64At(x, y)
+				r, g, b := convert(float64(c.R), float64(c.G), float64(c.B), 0xffff)
+				img.SetRGBA64(x, y, color.RGBA64{R: uint16(r), G: uint16(g), B: uint16(b), A: c.A})
+			}
+		}
+	case *image.NRGBA:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := img.NRGBAAt(x, y)
+				r, g, b := convert(float64(c.R), float64(c.G), float64(c.B), 0xff)
+				img.SetNRGBA(x, y, color.NRGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: c.A})
+			}
+		}
+	case *image.NRGBA64:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := img.NRGBA64At(x, y)
+				r, g, b := convert(float64(c.R), float64(c.G), float64(c.B), 0xffff)
+				img.SetNRGBA64(x, y, color.NRGBA64{R: uint16(r), G: uint16(g), B: uint16(b), A: c.A})
+			}
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package jpeg2000
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testBox returns a box of the given type holding the concatenated contents.
+func testBox(boxType string, contents ...[]byte) []byte {
+	data := bytes.Join(contents, nil)
+
+	box := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint32(box, uint32(8+len(data)))
+	copy(box[4:], boxType)
+
+	return append(box, data...)
+}
+
+func TestDecodeJP2(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "rgb.jp2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := DecodeFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if file.FileType.Brand != "jp2 " || len(file.FileType.Compatibility) != 1 || file.FileType.Compatibility[0] != "jp2 " {
+		t.Errorf("unexpected file type %+v", file.FileType)
+	}
+
+	imageHeader := file.Header.ImageHeader
+	if imageHeader.Width != 29 || imageHeader.Height != 21 || imageHeader.NumComponents != 3 {
+		t.Errorf("expected 29 x 21 image with 3 components, found %d x %d with %d", imageHeader.Width, imageHeader.Height,
+			imageHeader.NumComponents)
+	}
+	if imageHeader.BitDepth != 8 || imageHeader.Signed || imageHeader.VariableBitDepth {
+		t.Errorf("expected unsigned 8 bit components, found %+v", imageHeader)
+	}
+
+	if colourSpace, ok := file.Header.ColourSpace(); !ok || colourSpace != SRGBColourSpace {
+		t.Errorf("expected sRGB colour space, found %d", colourSpace)
+	}
+	if file.Header.CaptureResolution != nil || file.Header.Palette != nil {
+		t.Errorf("unexpected capture resolution or palette")
+	}
+
+	// Decode recognises the signature box
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Bounds() != image.Rect(0, 0, 29, 21) {
+		t.Fatalf("expected bounds (0,0)-(29,21), found %v", img.Bounds())
+	}
+	for y := 0; y < 21; y++ {
+		for x := 0; x < 29; x++ {
+			for c, value := range testPixel(img, x, y)[:3] {
+				if value != testSample(x, y, c) {
+					t.Fatalf("component %d of (%d, %d): expected %d, found %d", c, x, y, testSample(x, y, c), value)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodePalette(t *testing.T) {
+	// gray.j2k, whose samples index a palette of 8 bit red, 8 bit green and signed 12 bit blue columns, with a capture
+	// resolution of 2 micrometres, a display resolution of 72 dpi and an XML box
+	file, err := os.Open(filepath.Join("testdata", "palette.jp2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	jp2, err := DecodeFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := jp2.Header
+	if header.Palette == nil || header.Palette.NumEntries != 256 || len(header.Palette.Columns) != 3 {
+		t.Fatalf("unexpected palette %+v", header.Palette)
+	}
+	if column := header.Palette.Columns[2]; column.BitDepth != 12 || !column.Signed || column.Values[0] != -2048 {
+		t.Errorf("expected signed 12 bit column starting at -2048, found %d bit signed %v starting at %d",
+			column.BitDepth, column.Signed, column.Values[0])
+	}
+	if len(header.ComponentMapping) != 3 || header.ComponentMapping[2] != (ComponentMapping{0, PaletteMapping, 2}) {
+		t.Errorf("unexpected component mapping %+v", header.ComponentMapping)
+	}
+
+	if header.CaptureResolution == nil || header.DisplayResolution == nil {
+		t.Fatalf("expected capture and display resolutions")
+	}
+	if x, y := header.CaptureResolution.MicronsPerPixel(); x != 2 || y != 2 {
+		t.Errorf("expected 2 x 2 micrometre pixels, found %g x %g", x, y)
+	}
+	if x, y := header.DisplayResolution.PixelsPerMetre(); x != 2835 || y != 2835 {
+		t.Errorf("expected 2835 x 2835 pixels per metre, found %g x %g", x, y)
+	}
+
+	if len(jp2.XML) != 1 || !bytes.Contains([]byte(jp2.XML[0]), []byte(`magnification="20"`)) {
+		t.Errorf("unexpected XML %q", jp2.XML)
+	}
+
+	img, err := jp2.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rgba, ok := img.(*image.RGBA64)
+	if !ok {
+		t.Fatalf("expected *image.RGBA64, found %T", img)
+	}
+
+	for y := 0; y < 29; y++ {
+		for x := 0; x < 37; x++ {
+			index := testSample(x, y, 0)
+			expected := color.RGBA64{R: uint16(index * 257), G: uint16((255 - index) * 257),
+				B: uint16((index*16*0xffff + 4095/2) / 4095), A: 0xffff}
+
+			if found := rgba.RGBA64At(x, y); found != expected {
+				t.Fatalf("(%d, %d): expected %v, found %v", x, y, expected, found)
+			}
+		}
+	}
+}
+
+func TestDecodeFileBoxes(t *testing.T) {
+	codestream, err := os.ReadFile(filepath.Join("testdata", "gray.j2k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := testBox("jP  ", []byte{0x0d, 0x0a, 0x87, 0x0a})
+	fileType := testBox("ftyp", []byte("jpx \x00\x00\x00\x00jpx jp2 "))
+	imageHeader := testBox("ihdr", []byte{0, 0, 0, 29, 0, 0, 0, 37, 0, 1, 7, 7, 0, 0})
+	jp2Header := testBox("jp2h", imageHeader, testBox("colr", []byte{1, 0, 0, 0, 0, 0, 17}))
+
+	// A JPX file whose codestream box has an extended length
+	extended := make([]byte, 16)
+	binary.BigEndian.PutUint32(extended, 1)
+	copy(extended[4:], "jp2c")
+	binary.BigEndian.PutUint64(extended[8:], uint64(16+len(codestream)))
+	data := bytes.Join([][]byte{signature, fileType, jp2Header, testBox("uuid", make([]byte, 20)), extended, codestream}, nil)
+
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 37, 29) {
+		t.Errorf("expected bounds (0,0)-(37,29), found %v", img.Bounds())
+	}
+
+	// A codestream box of length 0 extends to the end of the file
+	last := testBox("jp2c", codestream)
+	binary.BigEndian.PutUint32(last, 0)
+	data = bytes.Join([][]byte{signature, fileType, jp2Header, last}, nil)
+
+	file, err := DecodeFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.FileType.Brand != "jpx " || file.Codestream.GetImageWidth() != 37 {
+		t.Errorf("unexpected file type %+v and codestream width %d", file.FileType, file.Codestream.GetImageWidth())
+	}
+	if colourSpace, _ := file.Header.ColourSpace(); colourSpace != GreyscaleColourSpace {
+		t.Errorf("expected greyscale colour space, found %d", colourSpace)
+	}
+
+	// Boxes after the codestream are still read
+	data = bytes.Join([][]byte{signature, fileType, jp2Header, testBox("xml ", []byte("<first/>")),
+		testBox("jp2c", codestream), testBox("xml ", []byte("<second/>"))}, nil)
+
+	file, err = DecodeFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.XML) != 2 || file.XML[0] != "<first/>" || file.XML[1] != "<second/>" {
+		t.Errorf("expected XML boxes before and after the codestream, found %q", file.XML)
+	}
+
+	tests := []struct {
+		name        string
+		data        [][]byte
+		unsupported bool
+	}{
+		{"missing file type", [][]byte{signature, jp2Header, testBox("jp2c", codestream)}, false},
+		{"incompatible file type", [][]byte{signature, testBox("ftyp", []byte("mjp2\x00\x00\x00\x00mjp2")), jp2Header,
+			testBox("jp2c", codestream)}, true},
+		{"missing JP2 header", [][]byte{signature, fileType, testBox("jp2c", codestream)}, false},
+		{"missing image header", [][]byte{signature, fileType, testBox("jp2h", testBox("colr", []byte{1, 0, 0, 0, 0, 0, 17})),
+			testBox("jp2c", codestream)}, false},
+		{"missing codestream", [][]byte{signature, fileType, jp2Header}, false},
+		{"fragmented codestream", [][]byte{signature, fileType, jp2Header, testBox("ftbl", testBox("flst"))}, true},
+		{"palette without mapping", [][]byte{signature, fileType, testBox("jp2h", imageHeader,
+			testBox("pclr", []byte{0, 1, 1, 7, 0xff})), testBox("jp2c", codestream)}, false},
+		// The length of the codestream box is beyond the end of the file
+		{"truncated box", [][]byte{signature, fileType, jp2Header, testBox("jp2c", codestream)[:100]}, false},
+	}
+
+	for _, test := range tests {
+		_, err := DecodeFile(bytes.NewReader(bytes.Join(test.data, nil)))
+		if err == nil {
+			t.Errorf("%s: expected error", test.name)
+			continue
+		}
+
+		if _, ok := err.(UnsupportedError); ok != test.unsupported {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+	}
+}
+
+func TestConvertSYCC(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	img.SetRGBA(0, 0, color.RGBA{128, 128, 128, 0xff})
+	// Red, and a colour that is clamped
+	img.SetRGBA(1, 0, color.RGBA{76, 85, 255, 0xff})
+	img.SetRGBA(2, 0, color.RGBA{255, 255, 255, 0x80})
+
+	convertSYCC(img)
+
+	expected := []color.RGBA{{128, 128, 128, 0xff}, {254, 0, 0, 0xff}, {255, 121, 255, 0x80}}
+	for x, c := range expected {
+		if found := img.RGBAAt(x, 0); found != c {
+			t.Errorf("pixel %d: expected %v, found %v", x, c, found)
+		}
+	}
+}
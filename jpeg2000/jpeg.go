@@ -3,12 +3,9 @@ package jpeg2000
 import (
 	"bytes"
 	"encoding/binary"
-	"encoding/hex"
-	"fmt"
+	"image"
 	"io"
 	"io/ioutil"
-	"log"
-	"math"
 
 	"golang.org/x/text/encoding/charmap"
 )
@@ -52,90 +49,38 @@ const (
 	COM uint8 = 0x64
 )
 
+// A FormatError reports that the input is not a valid JPEG 2000 codestream.
+type FormatError string
+
+func (e FormatError) Error() string { return "invalid JPEG 2000 format: " + string(e) }
+
+// An UnsupportedError reports that the input uses a valid but unimplemented JPEG 2000 feature.
+type UnsupportedError string
+
+func (e UnsupportedError) Error() string { return "unsupported JPEG 2000 feature: " + string(e) }
+
+// tileHeader holds the coding style, quantization and region of interest marker segments that apply to a tile. The
+// main header is stored in the same way, and is used wherever a tile does not override it.
 type tileHeader struct {
 	index      uint16
-	length     uint32
-	dataSize   uint32
 	partIndex  uint8
 	partsCount uint8
 
 	COD *CodingStyle
+	COC map[uint16]*CodingStyle
 	QCD *Quantization
+	QCC map[uint16]*Quantization
+	RGN map[uint16]uint8
+	// POC holds the progression order changes, in the order in which they were read.
+	POC []ProgressionOrderChange
 }
 
-type packetIterator interface {
-	nextPacket() *packet
-}
-
-type layerResolutionComponentPositionIterator struct {
-	header                      *Header
-	layersCount                 uint16
-	componentsCount             uint16
-	maxDecompositionLevelsCount uint8
-
-	tile *tile
-
-	l uint16
-	r uint8
-	i uint16
-	k uint32
-}
-
-type packet struct {
-	layerNumber uint16
-	codeblocks  []*codeblock
-}
-
-func (iterator *layerResolutionComponentPositionIterator) nextPacket() *packet {
-	// Section B.12.1.1 Layer-resolution-component-position
-	for ; iterator.l < iterator.layersCount; iterator.l++ {
-		for ; iterator.r <= iterator.maxDecompositionLevelsCount; iterator.r++ {
-			for ; iterator.i < iterator.componentsCount; iterator.i++ {
-				component := iterator.tile.components[iterator.i]
-
-				if iterator.r > component.codingStyleParameters.NumberOfLevels {
-					continue
-				}
-
-				resolution := component.resolutions[iterator.r]
-				numPrecincts := resolution.precinctParameters.numPrecincts
-
-				for iterator.k < numPrecincts {
-					packet := resolution.createPacket(iterator.k, iterator.l)
-					iterator.k++
-					return packet
-				}
-				iterator.k = 0
-			}
-			iterator.i = 0
-		}
-		iterator.r = 0
-	}
-
-	log.Println("Out of packets!")
-
-	return nil
-}
-
-func newLayerResolutionComponentPositionIterator(header *Header) *layerResolutionComponentPositionIterator {
-	var iterator layerResolutionComponentPositionIterator
-	iterator.header = header
-
-	//var siz = context.SIZ;
-	//var tileIndex = context.currentTile.index;
-	//var tile = context.tiles[tileIndex];
-	iterator.tile = header.tiles[header.currentTile.index]
-	iterator.layersCount = iterator.tile.codingStyleDefaultParameters.NumberOfLayers
-	iterator.componentsCount = header.Size.Csiz
-
-	for q := uint16(0); q < iterator.componentsCount; q++ {
-		iterator.maxDecompositionLevelsCount = maxuint8(iterator.maxDecompositionLevelsCount, iterator.tile.components[q].codingStyleParameters.NumberOfLevels)
-	}
-
-	return &iterator
+func newTileHeader() *tileHeader {
+	return &tileHeader{COC: make(map[uint16]*CodingStyle), QCC: make(map[uint16]*Quantization), RGN: make(map[uint16]uint8)}
 }
 
 type tile struct {
+	index      uint16
 	tx0        uint32
 	ty0        uint32
 	tx1        uint32
@@ -144,7 +89,12 @@ type tile struct {
 	height     uint32
 	components []*tileComponent
 
+	header *tileHeader
+	// data is the concatenated packet data of all of the tile-parts of the tile.
+	data []byte
+
 	codingStyleDefaultParameters *CodingStyle
+	progressionOrderChanges      []ProgressionOrderChange
 	packetsIterator              packetIterator
 }
 
@@ -158,6 +108,7 @@ type tileComponent struct {
 
 	quantizationParameters *Quantization
 	codingStyleParameters  *CodingStyle
+	roiShift               uint8
 
 	subbands    []*subbandStruct
 	resolutions []*resolution
@@ -168,7 +119,12 @@ type Header struct {
 	Components []componentSize
 	COD        CodingStyle
 	QCD        Quantization
+	// POC holds the progression order changes of the main header, which apply to each tile without its own.
+	POC []ProgressionOrderChange
+	// Comments holds the text of the COM marker segments found in the main header.
+	Comments []string
 
+	main        *tileHeader
 	tiles       []*tile
 	currentTile *tileHeader
 }
@@ -205,21 +161,48 @@ func minuint8(x, y uint8) uint8 {
 	return y
 }
 
+func minuint16(x, y uint16) uint16 {
+	if x < y {
+		return x
+	}
+
+	return y
+}
+
+func minuint64(x, y uint64) uint64 {
+	if x < y {
+		return x
+	}
+
+	return y
+}
+
+// ceilDiv returns ceil(x / y).
+func ceilDiv(x, y uint32) uint32 {
+	return uint32((uint64(x) + uint64(y) - 1) / uint64(y))
+}
+
+// ceilDivPow2 returns ceil(x / 2^n).
+func ceilDivPow2(x uint32, n uint8) uint32 {
+	return uint32((uint64(x) + (1 << n) - 1) >> n)
+}
+
 // Implementation modified from https://github.com/mozilla/pdf.js/blob/master/src/core/jpx.js
 
 func (header *Header) calculateTileGrids() {
 	// Section B.3 Division into tile and tile-components
-	numXtiles := uint32(math.Ceil(float64(header.Size.Xsiz-header.Size.XTOsiz) / float64(header.Size.XTsiz)))
-	numYtiles := uint32(math.Ceil(float64(header.Size.Ysiz-header.Size.YTOsiz) / float64(header.Size.YTsiz)))
+	numXtiles := ceilDiv(header.Size.Xsiz-header.Size.XTOsiz, header.Size.XTsiz)
+	numYtiles := ceilDiv(header.Size.Ysiz-header.Size.YTOsiz, header.Size.YTsiz)
 
 	for q := uint32(0); q < numYtiles; q++ {
 		for p := uint32(0); p < numXtiles; p++ {
 			var tile tile
 
+			tile.index = uint16(len(header.tiles))
 			tile.tx0 = maxuint32(header.Size.XTOsiz+p*header.Size.XTsiz, header.Size.XOsiz)
 			tile.ty0 = maxuint32(header.Size.YTOsiz+q*header.Size.YTsiz, header.Size.YOsiz)
-			tile.tx1 = maxuint32(header.Size.XTOsiz+(p+1)*header.Size.XTsiz, header.Size.Xsiz)
-			tile.ty1 = maxuint32(header.Size.YTOsiz+(q+1)*header.Size.YTsiz, header.Size.Ysiz)
+			tile.tx1 = minuint32(header.Size.XTOsiz+(p+1)*header.Size.XTsiz, header.Size.Xsiz)
+			tile.ty1 = minuint32(header.Size.YTOsiz+(q+1)*header.Size.YTsiz, header.Size.Ysiz)
 			tile.width = tile.tx1 - tile.tx0
 			tile.height = tile.ty1 - tile.ty0
 
@@ -231,10 +214,13 @@ func (header *Header) calculateTileGrids() {
 		for j := uint32(0); j < uint32(len(header.tiles)); j++ {
 			var tileComponent tileComponent
 
-			tileComponent.tcx0 = uint32(math.Ceil(float64(header.tiles[j].tx0) / float64(header.Components[i].XRsiz)))
-			tileComponent.tcy0 = uint32(math.Ceil(float64(header.tiles[j].ty0) / float64(header.Components[i].YRsiz)))
-			tileComponent.tcx1 = uint32(math.Ceil(float64(header.tiles[j].tx1) / float64(header.Components[i].XRsiz)))
-			tileComponent.tcy1 = uint32(math.Ceil(float64(header.tiles[j].ty1) / float64(header.Components[i].YRsiz)))
+			xrsiz := uint32(header.Components[i].XRsiz)
+			yrsiz := uint32(header.Components[i].YRsiz)
+
+			tileComponent.tcx0 = ceilDiv(header.tiles[j].tx0, xrsiz)
+			tileComponent.tcy0 = ceilDiv(header.tiles[j].ty0, yrsiz)
+			tileComponent.tcx1 = ceilDiv(header.tiles[j].tx1, xrsiz)
+			tileComponent.tcy1 = ceilDiv(header.tiles[j].ty1, yrsiz)
 			tileComponent.width = tileComponent.tcx1 - tileComponent.tcx0
 			tileComponent.height = tileComponent.tcy1 - tileComponent.tcy0
 
@@ -243,24 +229,63 @@ func (header *Header) calculateTileGrids() {
 	}
 }
 
-func (header *Header) initialiseTile() {
-	for c := 0; c < int(header.Size.Csiz); c++ {
-		component := header.tiles[header.currentTile.index].components[c]
+// initialiseTile resolves the coding style, quantization and region of interest shift of each component of the tile.
+// Section A.6 gives the order of precedence: tile-part COC, tile-part COD, main COC and then main COD, with the same
+// order for QCC and QCD. Progression order changes in the tile-part headers replace those of the main header.
+func (header *Header) initialiseTile(tile *tile) error {
+	main := header.main
+	tileHeader := tile.header
+	if tileHeader == nil {
+		tileHeader = newTileHeader()
+	}
 
-		// TODO: Handle QCC and COC
-		//var qcdOrQcc =
-		//  context.currentTile.QCC[c] !== undefined
-		//    ? context.currentTile.QCC[c]
-		//    : context.currentTile.QCD;
-		component.quantizationParameters = header.currentTile.QCD
-		//var codOrCoc =
-		//  context.currentTile.COC[c] !== undefined
-		//    ? context.currentTile.COC[c]
-		//    : context.currentTile.COD;
-		component.codingStyleParameters = header.currentTile.COD
+	cod := tileHeader.COD
+	if cod == nil {
+		cod = main.COD
+	}
+	if cod == nil {
+		return FormatError("missing COD marker segment")
 	}
 
-	header.tiles[header.currentTile.index].codingStyleDefaultParameters = header.currentTile.COD
+	for c, component := range tile.components {
+		index := uint16(c)
+
+		coc := tileHeader.COC[index]
+		if coc == nil && tileHeader.COD == nil {
+			coc = main.COC[index]
+		}
+		component.codingStyleParameters = cod.withComponentStyle(coc)
+
+		qcd := tileHeader.QCC[index]
+		if qcd == nil {
+			qcd = tileHeader.QCD
+		}
+		if qcd == nil {
+			qcd = main.QCC[index]
+		}
+		if qcd == nil {
+			qcd = main.QCD
+		}
+		if qcd == nil {
+			return FormatError("missing QCD marker segment")
+		}
+		component.quantizationParameters = qcd
+
+		if shift, ok := tileHeader.RGN[index]; ok {
+			component.roiShift = shift
+		} else {
+			component.roiShift = main.RGN[index]
+		}
+	}
+
+	tile.codingStyleDefaultParameters = cod
+
+	tile.progressionOrderChanges = tileHeader.POC
+	if tile.progressionOrderChanges == nil {
+		tile.progressionOrderChanges = main.POC
+	}
+
+	return nil
 }
 
 type resolution struct {
@@ -273,25 +298,7 @@ type resolution struct {
 
 	subbands           []*subbandStruct
 	precinctParameters *precinctParameters
-}
-
-func (res *resolution) createPacket(precinctNumber uint32, layerNumber uint16) *packet {
-	var precinctCodeblocks []*codeblock
-	// Section B.10.8 Order of info in packet
-	subbands := res.subbands
-
-	// sub-bands already ordered in 'LL', 'HL', 'LH', and 'HH' sequence
-	for i := 0; i < len(subbands); i++ {
-		codeblocks := subbands[i].codeblocks
-
-		for j := 0; j < len(codeblocks); j++ {
-			if codeblocks[j].precinctNumber != precinctNumber {
-				continue
-			}
-			precinctCodeblocks = append(precinctCodeblocks, codeblocks[j])
-		}
-	}
-	return &packet{layerNumber: layerNumber, codeblocks: precinctCodeblocks}
+	precincts          []*precinctStruct
 }
 
 type subbandStruct struct {
@@ -301,13 +308,23 @@ type subbandStruct struct {
 	tbx1        uint32
 	tby1        uint32
 
+	// level is the number of decomposition levels nb between the tile-component and the sub-band.
+	level      uint8
 	resolution *resolution
+	component  *tileComponent
 
 	codeblockParameters *codeblockParameters
-	precincts           []*precinctStruct
 	codeblocks          []*codeblock
 }
 
+// Section F.3.1 sub-band orientations, giving the offsets xob and yob of each sub-band.
+var subbandOffsets = map[string][2]uint32{
+	"LL": {0, 0},
+	"HL": {1, 0},
+	"LH": {0, 1},
+	"HH": {1, 1},
+}
+
 type blockDimensions struct {
 	PPx uint8
 	PPy uint8
@@ -324,9 +341,8 @@ func (header *Header) getBlocksDimensions(component *tileComponent, resolutionLe
 		result.PPx = 15
 		result.PPy = 15
 	} else {
-		log.Printf("getBlocksDimensions: EntropyCoder not suuported")
-		//result.PPx = codOrCoc. precinctsSizes[r].PPx;
-		//result.PPy = codOrCoc.precinctsSizes[r].PPy;
+		result.PPx = codOrCoc.PrecinctSizes[resolutionLevel].PPx
+		result.PPy = codOrCoc.PrecinctSizes[resolutionLevel].PPy
 	}
 	// calculate codeblock size as described in section B.7
 	if resolutionLevel > 0 {
@@ -341,27 +357,28 @@ func (header *Header) getBlocksDimensions(component *tileComponent, resolutionLe
 }
 
 type precinctParameters struct {
+	PPx                     uint8
+	PPy                     uint8
 	precinctWidth           uint32
 	precinctHeight          uint32
+	precinctX0              uint32
+	precinctY0              uint32
 	numPrecinctsWide        uint32
 	numPrecinctsHigh        uint32
 	numPrecincts            uint32
-	precinctWidthInSubband  uint32
-	precinctHeightInSubband uint32
+	precinctWidthInSubband  uint8
+	precinctHeightInSubband uint8
 }
 
 func (res *resolution) buildPrecincts(header *Header, blockDims *blockDimensions) {
 	var precinctParameters precinctParameters
 
 	// Section B.6 Division resolution to precincts
+	precinctParameters.PPx = blockDims.PPx
+	precinctParameters.PPy = blockDims.PPy
 	precinctParameters.precinctWidth = 1 << blockDims.PPx
 	precinctParameters.precinctHeight = 1 << blockDims.PPy
 
-	// Jasper introduces codeblock groups for mapping each subband codeblocks
-	// to precincts. Precinct partition divides a resolution according to width
-	// and height parameters. The subband that belongs to the resolution level
-	// has a different size than the level, unless it is the zero resolution.
-
 	// From Jasper documentation: jpeg2000.pdf, section K: Tier-2 coding:
 	// The precinct partitioning for a particular subband is derived from a
 	// partitioning of its parent LL band (i.e., the LL band at the next higher
@@ -371,76 +388,91 @@ func (res *resolution) buildPrecincts(header *Header, blockDims *blockDimensions
 	// level. This is accomplished by using the coordinate transformation
 	// (u, v) = (ceil(x/2), ceil(y/2)) where (x, y) and (u, v) are the
 	// coordinates of a point in the LL band and child subband, respectively.
-	var toAdd int32
-
 	if res.resLevel == 0 {
-		toAdd = 0
+		precinctParameters.precinctWidthInSubband = blockDims.PPx
+		precinctParameters.precinctHeightInSubband = blockDims.PPy
 	} else {
-		toAdd = -1
+		precinctParameters.precinctWidthInSubband = blockDims.PPx - 1
+		precinctParameters.precinctHeightInSubband = blockDims.PPy - 1
 	}
 
-	precinctParameters.precinctWidthInSubband = 1 << uint32(int32(blockDims.PPx)+toAdd)
-	precinctParameters.precinctHeightInSubband = 1 << uint32(int32(blockDims.PPy)+toAdd)
+	precinctParameters.precinctX0 = res.trx0 >> blockDims.PPx
+	precinctParameters.precinctY0 = res.try0 >> blockDims.PPy
 
 	if res.trx1 > res.trx0 {
-		precinctParameters.numPrecinctsWide = uint32(math.Ceil(float64(res.trx1)/float64(precinctParameters.precinctWidth)) - math.Floor(float64(res.trx0)/float64(precinctParameters.precinctWidth)))
+		precinctParameters.numPrecinctsWide = ceilDivPow2(res.trx1, blockDims.PPx) - precinctParameters.precinctX0
 	}
 
 	if res.try1 > res.try0 {
-		precinctParameters.numPrecinctsHigh = uint32(math.Ceil(float64(res.try1)/float64(precinctParameters.precinctHeight)) - math.Floor(float64(res.try0)/float64(precinctParameters.precinctHeight)))
+		precinctParameters.numPrecinctsHigh = ceilDivPow2(res.try1, blockDims.PPy) - precinctParameters.precinctY0
 	}
 
 	precinctParameters.numPrecincts = precinctParameters.numPrecinctsWide * precinctParameters.numPrecinctsHigh
 
 	res.precinctParameters = &precinctParameters
+
+	res.precincts = make([]*precinctStruct, precinctParameters.numPrecincts)
+	for k := range res.precincts {
+		res.precincts[k] = &precinctStruct{index: uint32(k), resolution: res}
+	}
 }
 
 type codeblock struct {
-	cbx  uint32
-	cby  uint32
+	cbx uint32
+	cby uint32
+	// tbx0, tby0, tbx1 and tby1 give the area of the sub-band covered by the code-block.
 	tbx0 uint32
 	tby0 uint32
 	tbx1 uint32
 	tby1 uint32
 
-	tbx0_ uint32
-	tby0_ uint32
-	tbx1_ uint32
-	tby1_ uint32
-
-	precinct       *precinctStruct
-	precinctNumber uint32
-	subbandType    string
-	Lblock         uint16
+	subband  *subbandStruct
+	precinct *precinctStruct
+	Lblock   uint32
 
-	data []*codeblockData
+	segments []*segment
+	// passes is the number of coding passes in the decoded layers.
+	passes uint32
 
 	included bool
+	// skipped is set when the code-block is outside the decoded region or resolution levels, and its data is not kept.
+	skipped bool
 
-	zeroBitPlanes byte
+	zeroBitPlanes uint8
 }
 
-type codeblockData struct {
-	data         []byte
-	start        uint32
-	end          uint32
-	codingPasses uint16
+// segment is a run of coding passes of a code-block that is terminated as a unit, see Section D.4.1.
+type segment struct {
+	data      []byte
+	passes    uint32
+	maxPasses uint32
 }
 
 type codeblockParameters struct {
-	codeblockWidth   uint8
-	codeblockHeight  uint8
-	numcodeblockwide uint32
-	numcodeblockhigh uint32
+	codeblockWidth  uint8
+	codeblockHeight uint8
 }
 
 type precinctStruct struct {
+	index      uint32
+	resolution *resolution
+	// layersRead is the number of layers whose packets have been given by the packet iterator.
+	layersRead uint16
+
+	subbands []*precinctSubband
+}
+
+// precinctSubband is the part of a sub-band that falls within a precinct, with the code-blocks it contains and the tag
+// trees used to code them in packet headers.
+type precinctSubband struct {
 	cbxMin uint32
 	cbyMin uint32
 	cbxMax uint32
 	cbyMax uint32
 
-	inclusionTree     *inclusionTree
+	codeblocks []*codeblock
+
+	inclusionTree     *tagTree
 	zeroBitPlanesTree *tagTree
 }
 
@@ -448,222 +480,137 @@ func (subband *subbandStruct) buildCodeblocks(header *Header, blockDims *blockDi
 	// Section B.7 Division sub-band into code-blocks
 	xcb := blockDims.xcb
 	ycb := blockDims.ycb
-	codeblockWidth := uint32(1 << xcb)
-	codeblockHeight := uint32(1 << ycb)
-	cbx0 := subband.tbx0 >> xcb
-	cby0 := subband.tby0 >> ycb
-	cbx1 := (subband.tbx1 + codeblockWidth - 1) >> xcb
-	cby1 := (subband.tby1 + codeblockHeight - 1) >> ycb
 	precinctParameters := subband.resolution.precinctParameters
-
-	var codeblocks []*codeblock
-	var precincts []*precinctStruct
-
-	for j := cby0; j < cby1; j++ {
-		for i := cbx0; i < cbx1; i++ {
-			var codeblock codeblock
-			codeblock.cbx = i
-			codeblock.cby = j
-			codeblock.tbx0 = codeblockWidth * i
-			codeblock.tby0 = codeblockHeight * j
-			codeblock.tbx1 = codeblockWidth * (i + 1)
-			codeblock.tby1 = codeblockHeight * (j + 1)
-
-			codeblock.tbx0_ = maxuint32(subband.tbx0, codeblock.tbx0)
-			codeblock.tby0_ = maxuint32(subband.tby0, codeblock.tby0)
-			codeblock.tbx1_ = minuint32(subband.tbx1, codeblock.tbx1)
-			codeblock.tby1_ = minuint32(subband.tby1, codeblock.tby1)
-
-			// Calculate precinct number for this codeblock, codeblock position
-			// should be relative to its subband, use actual dimension and position
-			// See comment about codeblock group width and height
-			pi := uint32(math.Floor(float64(codeblock.tbx0_-subband.tbx0) / float64(precinctParameters.precinctWidthInSubband)))
-			pj := uint32(math.Floor(float64(codeblock.tby0_-subband.tby0) / float64(precinctParameters.precinctHeightInSubband)))
-
-			codeblock.precinctNumber = pi + pj*precinctParameters.numPrecinctsWide
-			codeblock.subbandType = subband.subbandType
-			codeblock.Lblock = 3
-
-			if codeblock.tbx1_ <= codeblock.tbx0_ || codeblock.tby1_ <= codeblock.tby0_ {
-				continue
-			}
-			codeblocks = append(codeblocks, &codeblock)
-
-			// building precinct for the sub-band
-			var precinct *precinctStruct
-
-			if codeblock.precinctNumber < uint32(len(precincts)) {
-				precinct = precincts[codeblock.precinctNumber]
-
-				if i < precinct.cbxMin {
-					precinct.cbxMin = i
-				} else if i > precinct.cbxMax {
-					precinct.cbxMax = i
-				}
-				if j < precinct.cbyMin {
-					precinct.cbxMin = j
-				} else if j > precinct.cbyMax {
-					precinct.cbyMax = j
+	ppx := precinctParameters.precinctWidthInSubband
+	ppy := precinctParameters.precinctHeightInSubband
+
+	subband.codeblockParameters = &codeblockParameters{codeblockWidth: xcb, codeblockHeight: ycb}
+
+	for k, precinct := range subband.resolution.precincts {
+		// The precinct partition of the sub-band has the same indices as that of the resolution level.
+		px := precinctParameters.precinctX0 + uint32(k)%precinctParameters.numPrecinctsWide
+		py := precinctParameters.precinctY0 + uint32(k)/precinctParameters.numPrecinctsWide
+
+		x0 := maxuint32(px<<ppx, subband.tbx0)
+		y0 := maxuint32(py<<ppy, subband.tby0)
+		x1 := minuint32((px+1)<<ppx, subband.tbx1)
+		y1 := minuint32((py+1)<<ppy, subband.tby1)
+
+		var part precinctSubband
+
+		if x1 > x0 && y1 > y0 {
+			part.cbxMin = x0 >> xcb
+			part.cbyMin = y0 >> ycb
+			part.cbxMax = ceilDivPow2(x1, xcb)
+			part.cbyMax = ceilDivPow2(y1, ycb)
+
+			for j := part.cbyMin; j < part.cbyMax; j++ {
+				for i := part.cbxMin; i < part.cbxMax; i++ {
+					var codeblock codeblock
+					codeblock.cbx = i
+					codeblock.cby = j
+					codeblock.tbx0 = maxuint32(i<<xcb, x0)
+					codeblock.tby0 = maxuint32(j<<ycb, y0)
+					codeblock.tbx1 = minuint32((i+1)<<xcb, x1)
+					codeblock.tby1 = minuint32((j+1)<<ycb, y1)
+					codeblock.subband = subband
+					codeblock.precinct = precinct
+					codeblock.Lblock = 3
+
+					part.codeblocks = append(part.codeblocks, &codeblock)
+					subband.codeblocks = append(subband.codeblocks, &codeblock)
 				}
-			} else {
-				precinct = new(precinctStruct)
-				precinct.cbxMin = i
-				precinct.cbyMin = j
-				precinct.cbxMax = i
-				precinct.cbyMax = j
-
-				precincts = append(precincts, precinct)
 			}
 
-			codeblock.precinct = precinct
+			width := part.cbxMax - part.cbxMin
+			height := part.cbyMax - part.cbyMin
+			part.inclusionTree = newTagTree(width, height)
+			part.zeroBitPlanesTree = newTagTree(width, height)
 		}
-	}
 
-	var codeblockParameters codeblockParameters
-	codeblockParameters.codeblockWidth = xcb
-	codeblockParameters.codeblockHeight = ycb
-	codeblockParameters.numcodeblockwide = cbx1 - cbx0 + 1
-	codeblockParameters.numcodeblockhigh = cby1 - cby0 + 1
-
-	subband.codeblockParameters = &codeblockParameters
-	subband.codeblocks = codeblocks
-	subband.precincts = precincts
+		precinct.subbands = append(precinct.subbands, &part)
+	}
 }
 
-func (header *Header) buildPackets() {
-	siz := header.Size
-	tileIndex := header.currentTile.index
-	tile := header.tiles[tileIndex]
-	componentsCount := int(siz.Csiz)
-
+// buildPackets creates the resolution levels, sub-bands, precincts and code-blocks of each component of the tile, and the
+// iterator giving the order of the packets in the tile.
+func (header *Header) buildPackets(tile *tile) error {
 	// Creating resolutions and sub-bands for each component
-	for c := 0; c < componentsCount; c++ {
-		component := tile.components[c]
+	for _, component := range tile.components {
 		decompositionLevelsCount := component.codingStyleParameters.NumberOfLevels
 
 		// Section B.5 Resolution levels and sub-bands
 		var resolutions []*resolution
 		var subbands []*subbandStruct
 
-		fmt.Printf("decompositionLevelsCount = %d\n", decompositionLevelsCount)
-
 		for r := uint8(0); r <= decompositionLevelsCount; r++ {
 			blocksDimensions := header.getBlocksDimensions(component, r)
 
-			fmt.Printf("BlockDimensions = %v\n", blocksDimensions)
 			var resolution resolution
 
-			scale := 1 << (decompositionLevelsCount - r)
-			resolution.trx0 = uint32(math.Ceil(float64(component.tcx0) / float64(scale)))
-			resolution.try0 = uint32(math.Ceil(float64(component.tcy0) / float64(scale)))
-			resolution.trx1 = uint32(math.Ceil(float64(component.tcx1) / float64(scale)))
-			resolution.try1 = uint32(math.Ceil(float64(component.tcy1) / float64(scale)))
+			scale := decompositionLevelsCount - r
+			resolution.trx0 = ceilDivPow2(component.tcx0, scale)
+			resolution.try0 = ceilDivPow2(component.tcy0, scale)
+			resolution.trx1 = ceilDivPow2(component.tcx1, scale)
+			resolution.try1 = ceilDivPow2(component.tcy1, scale)
 			resolution.resLevel = r
 
 			resolution.buildPrecincts(header, blocksDimensions)
 			resolutions = append(resolutions, &resolution)
 
-			var subband *subbandStruct
+			var subbandTypes []string
+			var level uint8
 			if r == 0 {
 				// one sub-band (LL) with last decomposition
-
-				subband = new(subbandStruct)
-				subband.subbandType = "LL"
-				subband.tbx0 = uint32(math.Ceil(float64(component.tcx0) / float64(scale)))
-				subband.tby0 = uint32(math.Ceil(float64(component.tcy0) / float64(scale)))
-				subband.tbx1 = uint32(math.Ceil(float64(component.tcx1) / float64(scale)))
-				subband.tby1 = uint32(math.Ceil(float64(component.tcy1) / float64(scale)))
-				subband.resolution = &resolution
-				subband.buildCodeblocks(header, blocksDimensions)
-
-				subbands = append(subbands, subband)
-				resolution.subbands = append(resolution.subbands, subband)
+				subbandTypes = []string{"LL"}
+				level = decompositionLevelsCount
 			} else {
-				var bscale = 1 << (decompositionLevelsCount - r + 1)
 				// three sub-bands (HL, LH and HH) with rest of decompositions
+				subbandTypes = []string{"HL", "LH", "HH"}
+				level = decompositionLevelsCount - r + 1
+			}
 
-				subband = new(subbandStruct)
-				subband.subbandType = "HL"
-				subband.tbx0 = uint32(math.Ceil(float64(component.tcx0)/float64(bscale) - 0.5))
-				subband.tby0 = uint32(math.Ceil(float64(component.tcy0) / float64(bscale)))
-				subband.tbx1 = uint32(math.Ceil(float64(component.tcx1)/float64(bscale) - 0.5))
-				subband.tby1 = uint32(math.Ceil(float64(component.tcy1) / float64(bscale)))
-				subband.resolution = &resolution
-				subband.buildCodeblocks(header, blocksDimensions)
-				subbands = append(subbands, subband)
-				resolution.subbands = append(resolution.subbands, subband)
+			for _, subbandType := range subbandTypes {
+				offsets := subbandOffsets[subbandType]
 
-				subband = new(subbandStruct)
-				subband.subbandType = "LH"
-				subband.tbx0 = uint32(math.Ceil(float64(component.tcx0) / float64(bscale)))
-				subband.tby0 = uint32(math.Ceil(float64(component.tcy0)/float64(bscale) - 0.5))
-				subband.tbx1 = uint32(math.Ceil(float64(component.tcx1) / float64(bscale)))
-				subband.tby1 = uint32(math.Ceil(float64(component.tcy1)/float64(bscale) - 0.5))
+				subband := new(subbandStruct)
+				subband.subbandType = subbandType
+				subband.level = level
+				subband.tbx0 = subbandCoordinate(component.tcx0, offsets[0], level)
+				subband.tby0 = subbandCoordinate(component.tcy0, offsets[1], level)
+				subband.tbx1 = subbandCoordinate(component.tcx1, offsets[0], level)
+				subband.tby1 = subbandCoordinate(component.tcy1, offsets[1], level)
 				subband.resolution = &resolution
+				subband.component = component
 				subband.buildCodeblocks(header, blocksDimensions)
-				subbands = append(subbands, subband)
-				resolution.subbands = append(resolution.subbands, subband)
 
-				subband = new(subbandStruct)
-				subband.subbandType = "HH"
-				subband.tbx0 = uint32(math.Ceil(float64(component.tcx0)/float64(bscale) - 0.5))
-				subband.tby0 = uint32(math.Ceil(float64(component.tcy0)/float64(bscale) - 0.5))
-				subband.tbx1 = uint32(math.Ceil(float64(component.tcx1)/float64(bscale) - 0.5))
-				subband.tby1 = uint32(math.Ceil(float64(component.tcy1)/float64(bscale) - 0.5))
-				subband.resolution = &resolution
-				subband.buildCodeblocks(header, blocksDimensions)
 				subbands = append(subbands, subband)
 				resolution.subbands = append(resolution.subbands, subband)
 			}
-
-			fmt.Println(resolution)
-			fmt.Println(resolution.precinctParameters)
-			fmt.Println(resolution.subbands[0])
-			fmt.Println(resolution.subbands[0].codeblocks[0])
-			fmt.Println(resolution.subbands[0].precincts[0])
 		}
 
 		component.resolutions = resolutions
 		component.subbands = subbands
 	}
 
-	switch tile.codingStyleDefaultParameters.ProgressionOrder {
-	case 0:
-		tile.packetsIterator = newLayerResolutionComponentPositionIterator(header)
-	default:
-		log.Printf("Unsupported ProgressionOrder = %d\n", tile.codingStyleDefaultParameters.ProgressionOrder)
-	}
-	// Generate the packets sequence
-	/*		var progressionOrder = tile.codingStyleDefaultParameters.progressionOrder;
-			switch (progressionOrder) {
-			  case 0:
-				tile.packetsIterator = new LayerResolutionComponentPositionIterator(
-				  context
-				);
-				break;
-			  case 1:
-				tile.packetsIterator = new ResolutionLayerComponentPositionIterator(
-				  context
-				);
-				break;
-			  case 2:
-				tile.packetsIterator = new ResolutionPositionComponentLayerIterator(
-				  context
-				);
-				break;
-			  case 3:
-				tile.packetsIterator = new PositionComponentResolutionLayerIterator(
-				  context
-				);
-				break;
-			  case 4:
-				tile.packetsIterator = new ComponentPositionResolutionLayerIterator(
-				  context
-				);
-				break;
-			  default:
-				throw new JpxError(`Unsupported progression order ${progressionOrder}`);
-			}*/
+	tile.packetsIterator = newPacketIterator(header, tile)
+
+	return nil
+}
+
+// subbandCoordinate applies Equation B-15 to a tile-component coordinate, giving the coordinate in the sub-band with
+// offset ob at decomposition level nb.
+func subbandCoordinate(coordinate, ob uint32, nb uint8) uint32 {
+	if nb == 0 {
+		return coordinate
+	}
+
+	offset := uint64(ob) << (nb - 1)
+	if uint64(coordinate) <= offset {
+		return 0
+	}
+
+	return uint32((uint64(coordinate) - offset + (1 << nb) - 1) >> nb)
 }
 
 func (header *Header) GetImageWidth() uint32 {
@@ -765,15 +712,16 @@ type componentSize struct {
 
 func (component *componentSize) calculateComponentDimensions(siz *size) {
 	// Section B.2 Component mapping
-	component.x0 = uint32(math.Ceil(float64(siz.XOsiz) / float64(component.XRsiz)))
-	component.x1 = uint32(math.Ceil(float64(siz.Xsiz) / float64(component.XRsiz)))
-	component.y0 = uint32(math.Ceil(float64(siz.YOsiz) / float64(component.YRsiz)))
-	component.y1 = uint32(math.Ceil(float64(siz.Ysiz) / float64(component.YRsiz)))
+	component.x0 = ceilDiv(siz.XOsiz, uint32(component.XRsiz))
+	component.x1 = ceilDiv(siz.Xsiz, uint32(component.XRsiz))
+	component.y0 = ceilDiv(siz.YOsiz, uint32(component.YRsiz))
+	component.y1 = ceilDiv(siz.Ysiz, uint32(component.YRsiz))
 	component.width = component.x1 - component.x0
 	component.height = component.y1 - component.y0
 }
 
 type CodingStyle struct {
+	// EntropyCoder is set when the precinct sizes are given in PrecinctSizes rather than being the maximum.
 	EntropyCoder bool
 	SOPMarker    bool
 	EPHMarker    bool
@@ -794,6 +742,39 @@ type CodingStyle struct {
 	SegmentationSymbols      bool
 
 	ReversableFilter bool
+
+	// PrecinctSizes gives the precinct size of each resolution level, starting with the lowest.
+	PrecinctSizes []PrecinctSize
+}
+
+// PrecinctSize is the width and height of the precincts of a resolution level, as exponents of two.
+type PrecinctSize struct {
+	PPx uint8
+	PPy uint8
+}
+
+// withComponentStyle returns the coding style with the component specific parameters replaced by those of coc, if it
+// is not nil.
+func (style *CodingStyle) withComponentStyle(coc *CodingStyle) *CodingStyle {
+	if coc == nil {
+		return style
+	}
+
+	result := *style
+	result.EntropyCoder = coc.EntropyCoder
+	result.NumberOfLevels = coc.NumberOfLevels
+	result.Xcb = coc.Xcb
+	result.Ycb = coc.Ycb
+	result.ArithmeticCodingBypass = coc.ArithmeticCodingBypass
+	result.ResetContextProbabilties = coc.ResetContextProbabilties
+	result.TerminateOnCodingPass = coc.TerminateOnCodingPass
+	result.VerticallyCasualContext = coc.VerticallyCasualContext
+	result.PredictableTermination = coc.PredictableTermination
+	result.SegmentationSymbols = coc.SegmentationSymbols
+	result.ReversableFilter = coc.ReversableFilter
+	result.PrecinctSizes = coc.PrecinctSizes
+
+	return &result
 }
 
 type ProgressionOrder uint8
@@ -826,267 +807,684 @@ type QuantizationStepSize struct {
 	Exponent uint16
 }
 
-func Decode(r io.Reader) error {
-	var header Header
-	var buf []byte
-	var err error
-	var n int
-	done := false
+// Quantization styles, Table A.28
+const (
+	noQuantization              uint8 = 0
+	scalarDerivedQuantization   uint8 = 1
+	scalarExpoundedQuantization uint8 = 2
+)
 
-	buf = make([]byte, 4028)
+// stepSize returns the quantization step size of the sub-band with the given index, where the sub-bands are numbered in
+// the order LL, then HL, LH and HH of each resolution level. For derived quantization the step size is calculated from
+// that of the LL sub-band using Equation E-5.
+func (quantization *Quantization) stepSize(index int, level uint8, levels uint8) (QuantizationStepSize, error) {
+	if quantization.QuantizationStyle == scalarDerivedQuantization {
+		if len(quantization.SPqcd) == 0 {
+			return QuantizationStepSize{}, FormatError("missing quantization step size")
+		}
 
-	n, err = r.Read(buf[:2])
+		stepSize := quantization.SPqcd[0]
+		exponent := int(stepSize.Exponent) - int(levels) + int(level)
+		if exponent < 0 {
+			return QuantizationStepSize{}, FormatError("invalid quantization exponent")
+		}
+		stepSize.Exponent = uint16(exponent)
+
+		return stepSize, nil
+	}
+
+	if index >= len(quantization.SPqcd) {
+		return QuantizationStepSize{}, FormatError("missing quantization step size")
+	}
+
+	return quantization.SPqcd[index], nil
+}
+
+func init() {
+	image.RegisterFormat("j2k", "\xff\x4f\xff\x51", Decode, DecodeConfig)
+	image.RegisterFormat("jp2", "\x00\x00\x00\x0cjP  \x0d\x0a\x87\x0a", Decode, DecodeConfig)
+}
+
+// Decode reads a JPEG 2000 image from r and returns it as an image.Image. The image is either a raw codestream or a
+// JP2 or JPX file holding one.
+func Decode(r io.Reader) (image.Image, error) {
+	return DecodeWithOptions(r, nil)
+}
+
+// DecodeHeader reads the main header of a JPEG 2000 codestream from r, so that the size, components and coding style
+// of the image can be inspected without reading or decoding its tiles.
+func DecodeHeader(r io.Reader) (*Header, error) {
+	data, err := readMainHeader(r)
 	if err != nil {
-		return err
-	}
-
-	for n > 0 {
-		// Process data
-		if buf[0] == Marker {
-			switch buf[1] {
-			case EOC:
-				done = true
-			case SOC:
-			case SIZ:
-				type cSize struct {
-					Ssiz  uint8
-					XRsiz uint8
-					YRsiz uint8
-				}
+		return nil, err
+	}
 
-				err = binary.Read(r, binary.BigEndian, &header.Size)
-				if err != nil {
-					return err
-				}
+	var header Header
 
-				fmt.Println(header.Size)
+	err = header.readCodestream(data)
+	if err != nil {
+		return nil, err
+	}
 
-				var components []cSize
-				components = make([]cSize, header.Size.Csiz)
+	return &header, nil
+}
 
-				err = binary.Read(r, binary.BigEndian, &components)
-				if err != nil {
-					return err
-				}
+// DecodeCodestream reads a JPEG 2000 codestream from r, including its tiles, so that the header can be inspected
+// before the image is decoded with Header.Decode. Unlike Decode, r must hold a raw codestream rather than a JP2 file.
+func DecodeCodestream(r io.Reader) (*Header, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
 
-				header.Components = make([]componentSize, header.Size.Csiz)
+	var header Header
 
-				for i := range components {
-					header.Components[i].BitDepth = (components[i].Ssiz & 0x7F) + 1
-					header.Components[i].Signed = (components[i].Ssiz >> 7) == 1
-					header.Components[i].XRsiz = components[i].XRsiz
-					header.Components[i].YRsiz = components[i].YRsiz
+	err = header.readCodestream(data)
+	if err != nil {
+		return nil, err
+	}
 
-					header.Components[i].calculateComponentDimensions(&header.Size)
-				}
+	return &header, nil
+}
 
-				header.calculateTileGrids()
-			case COD:
-				type codStruct struct {
-					Lcod uint16
-					Scod uint8
-					//SGcod uint32
-					ProgressionOrder        uint8
-					NumberOfLayers          uint16
-					ComponentTransformation uint8
-
-					NumberOfLevels        uint8
-					CodeBlockWidth        uint8
-					CodeBlockHeight       uint8
-					CodeBlockStyle        uint8
-					WaveletTransformation uint8
-				}
+// Decode decodes the image of a codestream read with DecodeCodestream. A header from DecodeHeader holds no tiles, so
+// can't be decoded.
+func (header *Header) Decode() (image.Image, error) {
+	return header.DecodeWithOptions(nil)
+}
 
-				var codS codStruct
-				err = binary.Read(r, binary.BigEndian, &codS)
-				if err != nil {
-					return err
-				}
+// DecodeWithOptions decodes the part of the image selected by options, as Decode does.
+func (header *Header) DecodeWithOptions(options *DecodeOptions) (image.Image, error) {
+	return header.decodeImage(options)
+}
 
-				header.COD.EntropyCoder = (codS.Scod & 0x1) == 1
-				header.COD.SOPMarker = ((codS.Scod >> 1) & 0x1) == 1
-				header.COD.EPHMarker = ((codS.Scod >> 2) & 0x1) == 1
-
-				header.COD.ProgressionOrder = progressionOrderMap[codS.ProgressionOrder]
-				header.COD.NumberOfLayers = codS.NumberOfLayers
-				header.COD.MultipleComponentTransformation = codS.ComponentTransformation == 1
-
-				header.COD.NumberOfLevels = codS.NumberOfLevels
-				header.COD.Xcb = codS.CodeBlockWidth + 2
-				header.COD.Ycb = codS.CodeBlockHeight + 2
-
-				header.COD.ArithmeticCodingBypass = (codS.CodeBlockStyle & 0x1) == 1
-				header.COD.ResetContextProbabilties = ((codS.CodeBlockStyle >> 1) & 0x1) == 1
-				header.COD.TerminateOnCodingPass = ((codS.CodeBlockStyle >> 2) & 0x1) == 1
-				header.COD.VerticallyCasualContext = ((codS.CodeBlockStyle >> 3) & 0x1) == 1
-				header.COD.PredictableTermination = ((codS.CodeBlockStyle >> 4) & 0x1) == 1
-				header.COD.SegmentationSymbols = ((codS.CodeBlockStyle >> 5) & 0x1) == 1
-
-				header.COD.ReversableFilter = codS.WaveletTransformation == 1
-
-				//fmt.Println(cod)
-				// TODO: Missing Precinct size
-			case QCD:
-				type qcdStruct struct {
-					Lqcd uint16
-					Sqcd uint8
-				}
+// readMainHeader reads the codestream from r up to the SOT marker of the first tile-part, which ends the main header.
+func readMainHeader(r io.Reader) ([]byte, error) {
+	data := make([]byte, 2)
 
-				var qcd qcdStruct
-				err = binary.Read(r, binary.BigEndian, &qcd)
-				if err != nil {
-					return err
-				}
+	_, err := io.ReadFull(r, data)
+	if err != nil {
+		return nil, err
+	}
+	if data[0] != Marker || data[1] != SOC {
+		return nil, FormatError("missing SOC marker")
+	}
 
-				header.QCD.QuantizationStyle = qcd.Sqcd & 0x1F
-				header.QCD.GuardBits = qcd.Sqcd >> 5
+	marker := make([]byte, 4)
+	for {
+		_, err = io.ReadFull(r, marker[:2])
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		if marker[0] != Marker {
+			return nil, FormatError("expected marker")
+		}
+		if marker[1] == SOT || marker[1] == EOC {
+			return data, nil
+		}
 
-				if header.QCD.QuantizationStyle > 0 {
-					// Size of SPqcd is uint16
-					var SPqcd []uint16
-					SPqcd = make([]uint16, header.COD.NumberOfLevels)
+		_, err = io.ReadFull(r, marker[2:])
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
 
-					err = binary.Read(r, binary.BigEndian, &SPqcd)
-					if err != nil {
-						return err
-					}
+		length := int(binary.BigEndian.Uint16(marker[2:]))
+		if length < 2 {
+			return nil, FormatError("invalid marker segment length")
+		}
 
-					header.QCD.SPqcd = make([]QuantizationStepSize, header.COD.NumberOfLevels)
+		segment := make([]byte, length-2)
+		_, err = io.ReadFull(r, segment)
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
 
-					for i := range SPqcd {
-						header.QCD.SPqcd[i].Mantissa = SPqcd[i] & 0x7FF
-						header.QCD.SPqcd[i].Exponent = SPqcd[i] >> 11
-					}
-				} else {
-					// Size of SPqcd is uint8
-					log.Printf("Size of SPqcd is uint8 - not handling this")
-				}
-			case COM:
-				type comment struct {
-					Lcom uint16
-					Rcom uint16
-				}
+		data = append(append(data, marker...), segment...)
+	}
+}
 
-				var com comment
-				err = binary.Read(r, binary.BigEndian, &com)
-				if err != nil {
-					return err
-				}
+// DecodeConfig returns the colour model and dimensions of a JPEG 2000 image without decoding it. The image is either a
+// raw codestream or a JP2 or JPX file holding one. Only the boxes before the codestream and its main header are read.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	signature := make([]byte, signatureBoxLength)
 
-				var text []byte
-				text = make([]byte, com.Lcom)
-				err = binary.Read(r, binary.BigEndian, &text)
-				if err != nil {
-					return err
-				}
+	n, err := io.ReadFull(r, signature)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return image.Config{}, err
+	}
+	signature = signature[:n]
+	r = io.MultiReader(bytes.NewReader(signature), r)
 
-				var commentString string
+	if isJP2(signature) {
+		data, err := readHeaderBoxes(r)
+		if err != nil {
+			return image.Config{}, err
+		}
 
-				if com.Rcom == 0 {
-					commentString = string(text)
-				} else {
-					decodedBytes, _ := ioutil.ReadAll(charmap.ISO8859_15.NewDecoder().Reader(bytes.NewReader(text)))
-					commentString = string(decodedBytes)
-				}
+		var file File
 
-				fmt.Println(commentString)
-			case SOT:
-				type sotStruct struct {
-					Lsot  uint16
-					Isot  uint16
-					Psot  uint32
-					TPsot uint8
-					TNsot uint8
-				}
+		err = file.read(data)
+		if err != nil {
+			return image.Config{}, err
+		}
 
-				var sot sotStruct
-				err = binary.Read(r, binary.BigEndian, &sot)
-				if err != nil {
-					return err
-				}
+		return file.Config(), nil
+	}
 
-				var tHeader tileHeader
-				tHeader.index = sot.Isot
-				tHeader.length = sot.Psot
-				tHeader.dataSize = sot.Psot - uint32(sot.Lsot)
-				tHeader.partIndex = sot.TPsot
-				tHeader.partsCount = sot.TNsot
+	header, err := DecodeHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
 
-				if tHeader.partIndex == 0 {
-					tHeader.COD = &header.COD
-					tHeader.QCD = &header.QCD
+	return header.config(header.Components), nil
+}
 
-					log.Println("JPEG2000: Not handling COC or QCC")
-				}
+// config returns the colour model and dimensions of the image built from the components.
+func (header *Header) config(components []componentSize) image.Config {
+	return image.Config{ColorModel: imageColorModel(components), Width: int(header.GetImageWidth()),
+		Height: int(header.GetImageHeight())}
+}
 
-				header.currentTile = &tHeader
+// readMarkerSegment returns the parameters of the marker segment whose length field starts at position, and the
+// position of the next marker.
+func readMarkerSegment(data []byte, position int) ([]byte, int, error) {
+	if position+2 > len(data) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
 
-				/*dataSize := sot.Psot - uint32(sot.Lsot)
-				var data []byte
-				data = make([]byte, dataSize)
+	length := int(binary.BigEndian.Uint16(data[position:]))
+	if length < 2 {
+		return nil, 0, FormatError("invalid marker segment length")
+	}
+	if position+length > len(data) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
 
-				err = binary.Read(r, binary.BigEndian, &data)
-				if err != nil {
-					return err
-				}
+	return data[position+2 : position+length], position + length, nil
+}
 
-				// Strip off the start markers
-				log.Println("JPEG2000: Assuming next marker is SOD and all defaults are used")
-				data = data[2:]
+// readCodestream parses the main header and tile-parts of a codestream, storing the packet data of each tile ready for
+// decoding.
+func (header *Header) readCodestream(data []byte) error {
+	if len(data) < 2 || data[0] != Marker || data[1] != SOC {
+		return FormatError("missing SOC marker")
+	}
 
-				fmt.Println(hex.EncodeToString(data))*/
+	header.main = newTileHeader()
+	position := 2
+	sizFound := false
 
-				// If Layer-resolution level-component-position progression
-				//for each l = 0,..., L-1
-				//	for each r = 0,..., Nmax
-				//		for each i = 0,..., Csiz-1
-				//			for each k = 0,..., numprecincts-1
-				//				packet for component i, resolution level r, layer l, and precinct k.
+	for position < len(data) {
+		if position+2 > len(data) || data[position] != Marker {
+			return FormatError("expected marker")
+		}
+		marker := data[position+1]
+		markerPosition := position
+		position += 2
 
-				//done = true
-			case SOD:
-				if header.currentTile.partIndex == 0 {
-					header.initialiseTile()
-					header.buildPackets()
-				}
+		if marker == EOC {
+			break
+		}
 
-				var data []byte
-				data = make([]byte, header.currentTile.dataSize-4)
-				// TODO: is -4 correct? had -2 for the marker before, but when this is set, there is the EOC marker included
+		segment, next, err := readMarkerSegment(data, position)
+		if err != nil {
+			return err
+		}
 
-				err = binary.Read(r, binary.BigEndian, &data)
-				if err != nil {
-					log.Println(err)
-					return err
-				}
+		switch marker {
+		case SIZ:
+			if sizFound {
+				return FormatError("repeated SIZ marker segment")
+			}
+			sizFound = true
 
-				fmt.Println(hex.EncodeToString(data))
-				header.parseTilePackets(data)
-			default:
-				fmt.Printf("Found marker: %s\n", hex.EncodeToString(buf[:2]))
+			err = header.readSize(data[position:next])
+		case SOT:
+			if !sizFound {
+				return FormatError("missing SIZ marker segment")
 			}
 
-		}
-		if !done {
-			n, err = r.Read(buf[:2])
-			if err != nil {
-				return err
+			next, err = header.readTilePart(data, markerPosition, segment, next)
+		case COM:
+			if len(segment) < 2 {
+				return FormatError("short COM marker segment")
 			}
-		} else {
+
+			text := segment[2:]
+			if binary.BigEndian.Uint16(segment) == 1 {
+				text, _ = ioutil.ReadAll(charmap.ISO8859_15.NewDecoder().Reader(bytes.NewReader(text)))
+			}
+
+			header.Comments = append(header.Comments, string(text))
+		default:
+			if !sizFound {
+				return FormatError("missing SIZ marker segment")
+			}
+
+			err = header.readFunctionalSegment(marker, segment, header.main)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		position = next
+	}
+
+	if !sizFound {
+		return FormatError("missing SIZ marker segment")
+	}
+
+	return nil
+}
+
+// readSize parses the SIZ marker segment (Section A.5.1), starting with its length field.
+func (header *Header) readSize(segment []byte) error {
+	type cSize struct {
+		Ssiz  uint8
+		XRsiz uint8
+		YRsiz uint8
+	}
+
+	reader := bytes.NewReader(segment)
+
+	err := binary.Read(reader, binary.BigEndian, &header.Size)
+	if err != nil {
+		return FormatError("short SIZ marker segment")
+	}
+
+	siz := &header.Size
+	if siz.Csiz == 0 || siz.XTsiz == 0 || siz.YTsiz == 0 || siz.Xsiz <= siz.XOsiz || siz.Ysiz <= siz.YOsiz ||
+		siz.XTOsiz > siz.XOsiz || siz.YTOsiz > siz.YOsiz ||
+		uint64(siz.XTOsiz)+uint64(siz.XTsiz) <= uint64(siz.XOsiz) || uint64(siz.YTOsiz)+uint64(siz.YTsiz) <= uint64(siz.YOsiz) {
+		return FormatError("invalid SIZ marker segment")
+	}
+
+	numTiles := uint64(ceilDiv(siz.Xsiz-siz.XTOsiz, siz.XTsiz)) * uint64(ceilDiv(siz.Ysiz-siz.YTOsiz, siz.YTsiz))
+	if numTiles > 65535 {
+		return FormatError("too many tiles")
+	}
+
+	components := make([]cSize, siz.Csiz)
+
+	err = binary.Read(reader, binary.BigEndian, &components)
+	if err != nil {
+		return FormatError("short SIZ marker segment")
+	}
+
+	header.Components = make([]componentSize, siz.Csiz)
+
+	for i := range components {
+		header.Components[i].BitDepth = (components[i].Ssiz & 0x7F) + 1
+		header.Components[i].Signed = (components[i].Ssiz >> 7) == 1
+		header.Components[i].XRsiz = components[i].XRsiz
+		header.Components[i].YRsiz = components[i].YRsiz
+
+		if header.Components[i].XRsiz == 0 || header.Components[i].YRsiz == 0 {
+			return FormatError("invalid component sub-sampling")
+		}
+		if header.Components[i].BitDepth > 16 {
+			return UnsupportedError("component bit depth greater than 16")
+		}
+
+		header.Components[i].calculateComponentDimensions(&header.Size)
+	}
+
+	header.calculateTileGrids()
+
+	return nil
+}
+
+// readTilePart parses the tile-part header that follows the SOT marker at markerPosition, and appends the tile-part
+// data to that of the tile. It returns the position of the marker following the tile-part.
+func (header *Header) readTilePart(data []byte, markerPosition int, segment []byte, position int) (int, error) {
+	type sotStruct struct {
+		Isot  uint16
+		Psot  uint32
+		TPsot uint8
+		TNsot uint8
+	}
+
+	var sot sotStruct
+	err := binary.Read(bytes.NewReader(segment), binary.BigEndian, &sot)
+	if err != nil {
+		return 0, FormatError("short SOT marker segment")
+	}
+
+	if int(sot.Isot) >= len(header.tiles) {
+		return 0, FormatError("invalid tile index")
+	}
+	tile := header.tiles[sot.Isot]
+
+	// A length of zero means that the tile-part extends to the EOC marker.
+	end := len(data)
+	if sot.Psot != 0 {
+		end = markerPosition + int(sot.Psot)
+		if end < position || end > len(data) {
+			// Truncated codestream, decode what is present
+			end = len(data)
+		}
+	} else if len(data) >= 2 && data[len(data)-2] == Marker && data[len(data)-1] == EOC {
+		end = len(data) - 2
+	}
+
+	if tile.header == nil {
+		tile.header = newTileHeader()
+	}
+	tile.header.index = sot.Isot
+	tile.header.partIndex = sot.TPsot
+	tile.header.partsCount = sot.TNsot
+	header.currentTile = tile.header
+
+	for {
+		if position+2 > end || data[position] != Marker {
+			return 0, FormatError("expected marker in tile-part header")
+		}
+		marker := data[position+1]
+		position += 2
+
+		if marker == SOD {
 			break
 		}
+
+		segment, next, err := readMarkerSegment(data, position)
+		if err != nil {
+			return 0, err
+		}
+
+		err = header.readFunctionalSegment(marker, segment, tile.header)
+		if err != nil {
+			return 0, err
+		}
+
+		position = next
 	}
 
-	tiles := header.transformComponents()
-	//width := header.Size.Xsiz - header.Size.XOsiz
-	//height := header.Size.Ysiz - header.Size.YOsiz
-	//componentsCount := header.Size.Csiz
+	if end < position {
+		end = position
+	}
+	tile.data = append(tile.data, data[position:end]...)
 
-	fmt.Println(tiles)
+	return end, nil
+}
+
+// readFunctionalSegment parses a marker segment that can appear in the main header or a tile-part header, storing the
+// result in target.
+func (header *Header) readFunctionalSegment(marker uint8, segment []byte, target *tileHeader) error {
+	switch marker {
+	case COD:
+		style, err := readCodingStyle(segment, true)
+		if err != nil {
+			return err
+		}
 
-	fmt.Println(header)
-	fmt.Println(header.tiles[0])
-	fmt.Println(header.tiles[0].components[0])
+		if target == header.main {
+			header.COD = *style
+			style = &header.COD
+		}
+		target.COD = style
+	case COC:
+		component, segment, err := header.readComponentIndex(segment)
+		if err != nil {
+			return err
+		}
+
+		style, err := readCodingStyle(segment, false)
+		if err != nil {
+			return err
+		}
+
+		target.COC[component] = style
+	case QCD:
+		quantization, err := readQuantization(segment)
+		if err != nil {
+			return err
+		}
+
+		if target == header.main {
+			header.QCD = *quantization
+			quantization = &header.QCD
+		}
+		target.QCD = quantization
+	case QCC:
+		component, segment, err := header.readComponentIndex(segment)
+		if err != nil {
+			return err
+		}
+
+		quantization, err := readQuantization(segment)
+		if err != nil {
+			return err
+		}
+
+		target.QCC[component] = quantization
+	case RGN:
+		component, segment, err := header.readComponentIndex(segment)
+		if err != nil {
+			return err
+		}
+
+		if len(segment) < 2 {
+			return FormatError("short RGN marker segment")
+		}
+		if segment[0] != 0 {
+			return UnsupportedError("region of interest style")
+		}
+
+		target.RGN[component] = segment[1]
+	case POC:
+		changes, err := header.readProgressionOrderChanges(segment)
+		if err != nil {
+			return err
+		}
+
+		target.POC = append(target.POC, changes...)
+		if target == header.main {
+			header.POC = target.POC
+		}
+	case PPM, PPT:
+		return UnsupportedError("packed packet headers")
+	case TLM, PLM, PLT, CRG, COM:
+		// Informational only, the tile-part and packet lengths are found while decoding.
+	}
 
 	return nil
 }
+
+// readComponentIndex reads the component index at the start of a COC, QCC or RGN marker segment, which is two bytes
+// long when there are more than 256 components. The rest of the segment is returned.
+func (header *Header) readComponentIndex(segment []byte) (uint16, []byte, error) {
+	var component uint16
+
+	if header.Size.Csiz < 257 {
+		if len(segment) < 1 {
+			return 0, nil, FormatError("short marker segment")
+		}
+		component = uint16(segment[0])
+		segment = segment[1:]
+	} else {
+		if len(segment) < 2 {
+			return 0, nil, FormatError("short marker segment")
+		}
+		component = binary.BigEndian.Uint16(segment)
+		segment = segment[2:]
+	}
+
+	if component >= header.Size.Csiz {
+		return 0, nil, FormatError("invalid component index")
+	}
+
+	return component, segment, nil
+}
+
+// readProgressionOrderChanges parses a POC marker segment (Section A.6.6). The component indices are two bytes long
+// when there are more than 256 components, and an end component index of zero stands for the largest index plus one.
+func (header *Header) readProgressionOrderChanges(segment []byte) ([]ProgressionOrderChange, error) {
+	componentLength := 1
+	if header.Size.Csiz >= 257 {
+		componentLength = 2
+	}
+	entryLength := 5 + 2*componentLength
+
+	if len(segment) == 0 || len(segment)%entryLength != 0 {
+		return nil, FormatError("invalid POC marker segment length")
+	}
+
+	readComponent := func(data []byte) uint16 {
+		if componentLength == 1 {
+			return uint16(data[0])
+		}
+
+		return binary.BigEndian.Uint16(data)
+	}
+
+	var changes []ProgressionOrderChange
+	for ; len(segment) > 0; segment = segment[entryLength:] {
+		var change ProgressionOrderChange
+
+		change.ResolutionStart = segment[0]
+		change.ComponentStart = readComponent(segment[1:])
+		entry := segment[1+componentLength:]
+		change.LayerEnd = binary.BigEndian.Uint16(entry)
+		change.ResolutionEnd = entry[2]
+		change.ComponentEnd = readComponent(entry[3:])
+
+		if change.ComponentEnd == 0 {
+			change.ComponentEnd = 256
+			if componentLength == 2 {
+				change.ComponentEnd = 16384
+			}
+		}
+
+		progressionOrder, ok := progressionOrderMap[entry[3+componentLength]]
+		if !ok {
+			return nil, FormatError("invalid progression order in POC marker segment")
+		}
+		change.ProgressionOrder = progressionOrder
+
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// readCodingStyle parses the parameters of a COD marker segment (Section A.6.1) or, when defaults is false, a COC
+// marker segment (Section A.6.2) following the component index.
+func readCodingStyle(segment []byte, defaults bool) (*CodingStyle, error) {
+	var style CodingStyle
+
+	if len(segment) < 1 {
+		return nil, FormatError("short coding style marker segment")
+	}
+
+	scod := segment[0]
+	segment = segment[1:]
+
+	style.EntropyCoder = (scod & 0x1) == 1
+
+	if defaults {
+		if len(segment) < 4 {
+			return nil, FormatError("short COD marker segment")
+		}
+
+		style.SOPMarker = ((scod >> 1) & 0x1) == 1
+		style.EPHMarker = ((scod >> 2) & 0x1) == 1
+
+		progressionOrder, ok := progressionOrderMap[segment[0]]
+		if !ok {
+			return nil, FormatError("invalid progression order")
+		}
+		style.ProgressionOrder = progressionOrder
+		style.NumberOfLayers = binary.BigEndian.Uint16(segment[1:])
+		style.MultipleComponentTransformation = segment[3] == 1
+
+		if style.NumberOfLayers == 0 {
+			return nil, FormatError("invalid number of layers")
+		}
+
+		segment = segment[4:]
+	}
+
+	if len(segment) < 5 {
+		return nil, FormatError("short coding style marker segment")
+	}
+
+	style.NumberOfLevels = segment[0]
+	style.Xcb = segment[1] + 2
+	style.Ycb = segment[2] + 2
+
+	if style.NumberOfLevels > 32 || style.Xcb > 10 || style.Ycb > 10 || style.Xcb+style.Ycb > 12 {
+		return nil, FormatError("invalid coding style parameters")
+	}
+
+	codeBlockStyle := segment[3]
+	style.ArithmeticCodingBypass = (codeBlockStyle & 0x1) == 1
+	style.ResetContextProbabilties = ((codeBlockStyle >> 1) & 0x1) == 1
+	style.TerminateOnCodingPass = ((codeBlockStyle >> 2) & 0x1) == 1
+	style.VerticallyCasualContext = ((codeBlockStyle >> 3) & 0x1) == 1
+	style.PredictableTermination = ((codeBlockStyle >> 4) & 0x1) == 1
+	style.SegmentationSymbols = ((codeBlockStyle >> 5) & 0x1) == 1
+
+	if codeBlockStyle&0x40 != 0 {
+		return nil, UnsupportedError("high throughput block coding")
+	}
+
+	style.ReversableFilter = segment[4] == 1
+	segment = segment[5:]
+
+	if style.EntropyCoder {
+		if len(segment) < int(style.NumberOfLevels)+1 {
+			return nil, FormatError("missing precinct sizes")
+		}
+
+		for r := 0; r <= int(style.NumberOfLevels); r++ {
+			precinctSize := PrecinctSize{PPx: segment[r] & 0xF, PPy: segment[r] >> 4}
+			if r > 0 && (precinctSize.PPx == 0 || precinctSize.PPy == 0) {
+				return nil, FormatError("invalid precinct size")
+			}
+
+			style.PrecinctSizes = append(style.PrecinctSizes, precinctSize)
+		}
+	}
+
+	return &style, nil
+}
+
+// readQuantization parses the parameters of a QCD marker segment (Section A.6.4) or of a QCC marker segment (Section
+// A.6.5) following the component index.
+func readQuantization(segment []byte) (*Quantization, error) {
+	var quantization Quantization
+
+	if len(segment) < 1 {
+		return nil, FormatError("short quantization marker segment")
+	}
+
+	quantization.QuantizationStyle = segment[0] & 0x1F
+	quantization.GuardBits = segment[0] >> 5
+	segment = segment[1:]
+
+	switch quantization.QuantizationStyle {
+	case noQuantization:
+		// Size of SPqcd is uint8, giving only the exponent
+		for _, value := range segment {
+			quantization.SPqcd = append(quantization.SPqcd, QuantizationStepSize{Exponent: uint16(value >> 3)})
+		}
+	case scalarDerivedQuantization, scalarExpoundedQuantization:
+		// Size of SPqcd is uint16
+		for i := 0; i+1 < len(segment); i += 2 {
+			value := binary.BigEndian.Uint16(segment[i:])
+
+			quantization.SPqcd = append(quantization.SPqcd, QuantizationStepSize{Mantissa: value & 0x7FF, Exponent: value >> 11})
+		}
+	default:
+		return nil, FormatError("invalid quantization style")
+	}
+
+	if len(quantization.SPqcd) == 0 {
+		return nil, FormatError("missing quantization step sizes")
+	}
+
+	return &quantization, nil
+}
@@ -1,18 +1,395 @@
 package jpeg2000
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
 func TestJpeg2000(t *testing.T) {
 	path := "tile.j2k"
 
+	if _, err := os.Stat(path); err != nil {
+		t.Skipf("test data not available: %v", err)
+	}
+
 	file, err := os.Open(path)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer file.Close()
 
-	Decode(file)
+	_, err = Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testSample is the pattern encoded in the codestreams in testdata, which were written by
+// github.com/mrjoshuak/go-jpeg2000 v1.5.12. Component c of pixel (x, y) holds testSample(x, y, c), apart from the 16 bit
+// image which holds testSample(x, y, 0)*257 + x*y.
+func testSample(x, y, c int) int {
+	return (x*x/3 + y*5 + x*y/4 + c*70 + (x*7919+y*104729)%17) % 256
+}
+
+// testPixel returns the components of pixel (x, y) of img.
+func testPixel(img image.Image, x, y int) []int {
+	switch img := img.(type) {
+	case *image.Gray:
+		return []int{int(img.GrayAt(x, y).Y)}
+	case *image.Gray16:
+		return []int{int(img.Gray16At(x, y).Y)}
+	case *image.RGBA:
+		c := img.RGBAAt(x, y)
+		return []int{int(c.R), int(c.G), int(c.B), int(c.A)}
+	case *image.NRGBA:
+		c := img.NRGBAAt(x, y)
+		return []int{int(c.R), int(c.G), int(c.B), int(c.A)}
+	}
+
+	return nil
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		filename  string
+		bounds    image.Rectangle
+		imageType string
+		tolerance int
+		// expected returns the value of component c at (x, y)
+		expected func(x, y, c int) int
+	}{
+		// Reversible 5-3 filter
+		{"gray.j2k", image.Rect(0, 0, 37, 29), "*image.Gray", 0, testSample},
+		// Reversible component transform, three quality layers, SOP and EPH markers
+		{"rgb.j2k", image.Rect(0, 0, 33, 27), "*image.RGBA", 0, func(x, y, c int) int {
+			if c == 3 {
+				return 0xff
+			}
+			return testSample(x, y, c)
+		}},
+		// Irreversible 9-7 filter and component transform
+		{"rgb-lossy.j2k", image.Rect(0, 0, 40, 30), "*image.RGBA", 2, func(x, y, c int) int {
+			if c == 3 {
+				return 0xff
+			}
+			return testSample(x, y, c)
+		}},
+		// Four components in 16x16 tiles
+		{"rgba-tiles.j2k", image.Rect(0, 0, 45, 38), "*image.NRGBA", 0, testSample},
+		{"gray16.j2k", image.Rect(0, 0, 23, 19), "*image.Gray16", 0, func(x, y, c int) int {
+			return int(uint16(testSample(x, y, 0)*257 + x*y))
+		}},
+		// 4:2:0 sub-sampling of the second and third components
+		{"subsampled.j2k", image.Rect(0, 0, 31, 25), "*image.RGBA", 0, func(x, y, c int) int {
+			switch c {
+			case 0:
+				return testSample(x, y, 0)
+			case 3:
+				return 0xff
+			}
+			return testSample(x&^1, y&^1, c)
+		}},
+		// Precinct sizes given for each resolution level and four quality layers
+		{"precincts.j2k", image.Rect(0, 0, 50, 41), "*image.Gray", 0, testSample},
+	}
+
+	for _, test := range tests {
+		t.Run(test.filename, func(t *testing.T) {
+			file, err := os.Open(filepath.Join("testdata", test.filename))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			img, err := Decode(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if img.Bounds() != test.bounds {
+				t.Fatalf("expected bounds %v, found %v", test.bounds, img.Bounds())
+			}
+
+			if imageType := fmt.Sprintf("%T", img); imageType != test.imageType {
+				t.Fatalf("expected %s, found %s", test.imageType, imageType)
+			}
+
+			errors := 0
+			for y := test.bounds.Min.Y; y < test.bounds.Max.Y; y++ {
+				for x := test.bounds.Min.X; x < test.bounds.Max.X; x++ {
+					for c, value := range testPixel(img, x, y) {
+						expected := test.expected(x, y, c)
+
+						difference := value - expected
+						if difference < -test.tolerance || difference > test.tolerance {
+							if errors < 10 {
+								t.Errorf("component %d of (%d, %d): expected %d, found %d", c, x, y, expected, value)
+							}
+							errors++
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeHeader(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "rgba-tiles.j2k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var header Header
+	err = header.readCodestream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.GetImageWidth() != 45 || header.GetImageHeight() != 38 {
+		t.Errorf("expected image size 45 x 38, found %d x %d", header.GetImageWidth(), header.GetImageHeight())
+	}
+	if header.GetNomTileWidth() != 16 || header.GetNomTileHeight() != 16 {
+		t.Errorf("expected tile size 16 x 16, found %d x %d", header.GetNomTileWidth(), header.GetNomTileHeight())
+	}
+	if header.GetNumComponents() != 4 {
+		t.Errorf("expected 4 components, found %d", header.GetNumComponents())
+	}
+
+	// The last column and row of tiles are clipped to the image area
+	if len(header.tiles) != 9 {
+		t.Fatalf("expected 9 tiles, found %d", len(header.tiles))
+	}
+	last := header.tiles[8]
+	if last.tx0 != 32 || last.ty0 != 32 || last.tx1 != 45 || last.ty1 != 38 {
+		t.Errorf("unexpected last tile (%d, %d)-(%d, %d)", last.tx0, last.ty0, last.tx1, last.ty1)
+	}
+
+	if !header.COD.ReversableFilter || header.COD.NumberOfLevels != 2 || header.COD.Xcb != 3 || header.COD.Ycb != 3 {
+		t.Errorf("unexpected coding style %+v", header.COD)
+	}
+
+	// DecodeHeader stops at the first tile-part, so the tile data is not needed
+	mainHeaderLength := bytes.Index(data, []byte{Marker, SOT})
+	mainHeader, err := DecodeHeader(bytes.NewReader(data[:mainHeaderLength+2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, found := fmt.Sprintf("%+v %+v", header.Size, header.COD), fmt.Sprintf("%+v %+v", mainHeader.Size, mainHeader.COD)
+	if found != expected {
+		t.Errorf("expected %s from the main header, found %s", expected, found)
+	}
+
+	if _, err := DecodeHeader(bytes.NewReader(data[:mainHeaderLength-1])); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF for a truncated main header, found %v", err)
+	}
+}
+
+func TestDecodeCodestream(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "rgba-tiles.j2k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, err := DecodeCodestream(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.GetNumComponents() != 4 || !header.COD.MultipleComponentTransformation {
+		t.Errorf("unexpected header %+v %+v", header.Size, header.COD)
+	}
+
+	img, err := header.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img.(*image.NRGBA).Pix, expected.(*image.NRGBA).Pix) {
+		t.Error("image decoded from the header differs from Decode")
+	}
+
+	if _, err := DecodeCodestream(bytes.NewReader([]byte("\x00\x00\x00\x0cjP  \r\n\x87\n"))); err == nil {
+		t.Error("expected an error for a JP2 file")
+	}
+}
+
+func TestDecodeOffset(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "rgba-tiles.j2k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Move the image and the tiles together on the reference grid. The offsets are multiples of the code-block size at
+	// the lowest resolution level, so that the tile-parts still hold the same code-blocks.
+	siz := data[4:]
+	binary.BigEndian.PutUint32(siz[4:], 45+32) // Xsiz
+	binary.BigEndian.PutUint32(siz[8:], 38+64) // Ysiz
+	for _, offset := range []int{12, 28} {
+		binary.BigEndian.PutUint32(siz[offset:], 32)   // XOsiz, XTOsiz
+		binary.BigEndian.PutUint32(siz[offset+4:], 64) // YOsiz, YTOsiz
+	}
+
+	var header Header
+	err = header.readCodestream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.GetImageULX() != 32 || header.GetImageULY() != 64 {
+		t.Errorf("expected image offset (32, 64), found (%d, %d)", header.GetImageULX(), header.GetImageULY())
+	}
+	if x, y := header.GetTilingOrigin(); x != 32 || y != 64 {
+		t.Errorf("expected tiling origin (32, 64), found (%d, %d)", x, y)
+	}
+
+	img, err := header.decodeImage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The image origin is the image offset
+	if img.Bounds() != image.Rect(0, 0, 45, 38) {
+		t.Fatalf("expected bounds (0,0)-(45,38), found %v", img.Bounds())
+	}
+	for _, point := range []image.Point{{0, 0}, {17, 5}, {44, 37}} {
+		for c, value := range testPixel(img, point.X, point.Y) {
+			if value != testSample(point.X, point.Y, c) {
+				t.Errorf("component %d of %v: expected %d, found %d", c, point, testSample(point.X, point.Y, c), value)
+			}
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"missing SOC", []byte{0xff, 0x51}},
+		{"missing SIZ", []byte{0xff, 0x4f, 0xff, 0xd9}},
+		{"short SIZ", []byte{0xff, 0x4f, 0xff, 0x51, 0x00, 0x04, 0x00, 0x00}},
+	}
+
+	for _, test := range tests {
+		_, err := Decode(bytes.NewReader(test.data))
+		if err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+
+	// A missing COD marker segment is reported once a tile is decoded
+	data, err := os.ReadFile(filepath.Join("testdata", "gray.j2k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var header Header
+	err = header.readCodestream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.main.COD = nil
+
+	_, err = header.decodeImage(nil)
+	if _, ok := err.(FormatError); !ok {
+		t.Errorf("expected FormatError, found %v", err)
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "rgb.j2k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every prefix either fails cleanly or decodes to an image of the full size
+	for length := 0; length < len(data); length += 5 {
+		img, err := Decode(bytes.NewReader(data[:length]))
+		if err == nil && img.Bounds() != image.Rect(0, 0, 33, 27) {
+			t.Errorf("truncated to %d bytes: unexpected bounds %v", length, img.Bounds())
+		}
+	}
+
+	// Without the EOC marker the last tile-part still decodes completely
+	img, err := Decode(bytes.NewReader(data[:len(data)-2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for c, value := range testPixel(img, 32, 26)[:3] {
+		if value != testSample(32, 26, c) {
+			t.Errorf("component %d: expected %d, found %d", c, testSample(32, 26, c), value)
+		}
+	}
+}
+
+func TestImageDecode(t *testing.T) {
+	tests := []struct {
+		filename string
+		format   string
+		width    int
+		height   int
+	}{
+		{"gray.j2k", "j2k", 37, 29},
+		{"gray16.j2k", "j2k", 23, 19},
+		{"rgb.j2k", "j2k", 33, 27},
+		{"rgba-tiles.j2k", "j2k", 45, 38},
+		{"rgb.jp2", "jp2", 29, 21},
+		{"palette.jp2", "jp2", 37, 29},
+	}
+
+	for _, test := range tests {
+		data, err := os.ReadFile(filepath.Join("testdata", test.filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", test.filename, err)
+		}
+		if format != test.format {
+			t.Errorf("%s: expected format %s, found %s", test.filename, test.format, format)
+		}
+		if config.Width != test.width || config.Height != test.height {
+			t.Errorf("%s: expected %d x %d, found %d x %d", test.filename, test.width, test.height, config.Width,
+				config.Height)
+		}
+
+		// Only the main header of the codestream is read
+		codestream := 0
+		if box := bytes.Index(data, []byte("jp2c")); box >= 0 {
+			codestream = box
+		}
+		mainHeaderEnd := codestream + bytes.Index(data[codestream:], []byte{Marker, SOT}) + 2
+		truncated, _, err := image.DecodeConfig(bytes.NewReader(data[:mainHeaderEnd]))
+		if err != nil {
+			t.Fatalf("%s: %v", test.filename, err)
+		}
+		if truncated != config {
+			t.Errorf("%s: expected %+v from the main header, found %+v", test.filename, config, truncated)
+		}
+
+		img, format, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", test.filename, err)
+		}
+		if format != test.format {
+			t.Errorf("%s: expected format %s, found %s", test.filename, test.format, format)
+		}
+		if img.Bounds() != image.Rect(0, 0, test.width, test.height) {
+			t.Errorf("%s: expected bounds (0,0)-(%d,%d), found %v", test.filename, test.width, test.height, img.Bounds())
+		}
+		if img.ColorModel() != config.ColorModel {
+			t.Errorf("%s: colour model of the configuration does not match the %T image", test.filename, img)
+		}
+	}
 }
@@ -0,0 +1,178 @@
+package jpeg2000
+
+import (
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+)
+
+// DecodeOptions selects the part of an image to decode, so that work is only done for the code-blocks that contribute
+// to it.
+type DecodeOptions struct {
+	// ReduceLevels is the number of resolution levels to discard, each of which halves the width and height of the
+	// decoded image.
+	ReduceLevels int
+	// Region is the area to decode, in the coordinates of the image at full resolution. The bounds of the decoded image
+	// are the region scaled down by ReduceLevels, so need not start at (0, 0). An empty region decodes the whole image.
+	Region image.Rectangle
+	// MaxLayers is the number of quality layers to decode, where 0 decodes all of them.
+	MaxLayers int
+}
+
+// ErrEmptyRegion is returned when the region to decode does not intersect the image.
+var ErrEmptyRegion = errors.New("jpeg2000: region does not intersect the image")
+
+// regionMargin is the number of coefficients each side of the region that are decoded in every sub-band. The synthesis
+// filters reach two coefficients either side of each sample in a sub-band, which grows to less than four over the
+// decomposition levels, so this leaves room for rounding.
+const regionMargin = 8
+
+// decodeWindow is the part of the codestream selected by DecodeOptions.
+type decodeWindow struct {
+	reduce    uint8
+	maxLayers uint32
+
+	// x0, y0, x1 and y1 give the region on the reference grid.
+	x0 uint32
+	y0 uint32
+	x1 uint32
+	y1 uint32
+}
+
+// DecodeWithOptions reads a JPEG 2000 image from r, as Decode does, and decodes the part of it selected by options.
+func DecodeWithOptions(r io.Reader, options *DecodeOptions) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if isJP2(data) {
+		var file File
+
+		err = file.read(data)
+		if err != nil {
+			return nil, err
+		}
+
+		return file.DecodeWithOptions(options)
+	}
+
+	var header Header
+
+	err = header.readCodestream(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return header.decodeImage(options)
+}
+
+// newDecodeWindow resolves the options against the size of the image, where nil options select the whole image.
+func (header *Header) newDecodeWindow(options *DecodeOptions) (*decodeWindow, error) {
+	if options == nil {
+		options = &DecodeOptions{}
+	}
+
+	window := decodeWindow{x0: header.Size.XOsiz, y0: header.Size.YOsiz, x1: header.Size.Xsiz, y1: header.Size.Ysiz}
+
+	if options.ReduceLevels > 32 {
+		return nil, UnsupportedError("reduction by more than the decomposition levels")
+	}
+	if options.ReduceLevels > 0 {
+		window.reduce = uint8(options.ReduceLevels)
+	}
+
+	// Packets have at most 65535 layers
+	window.maxLayers = 1 << 16
+	if options.MaxLayers > 0 && options.MaxLayers < 1<<16 {
+		window.maxLayers = uint32(options.MaxLayers)
+	}
+
+	if !options.Region.Empty() {
+		region := options.Region.Intersect(image.Rect(0, 0, int(header.GetImageWidth()), int(header.GetImageHeight())))
+		if region.Empty() {
+			return nil, ErrEmptyRegion
+		}
+
+		window.x0 = header.Size.XOsiz + uint32(region.Min.X)
+		window.y0 = header.Size.YOsiz + uint32(region.Min.Y)
+		window.x1 = header.Size.XOsiz + uint32(region.Max.X)
+		window.y1 = header.Size.YOsiz + uint32(region.Max.Y)
+	}
+
+	if window.bounds(header).Empty() {
+		return nil, ErrEmptyRegion
+	}
+
+	return &window, nil
+}
+
+// bounds returns the bounds of the decoded image, which are the region on the reference grid scaled down by the
+// reduction, relative to the scaled image offset.
+func (window *decodeWindow) bounds(header *Header) image.Rectangle {
+	x0 := ceilDivPow2(header.Size.XOsiz, window.reduce)
+	y0 := ceilDivPow2(header.Size.YOsiz, window.reduce)
+
+	return image.Rect(int(ceilDivPow2(window.x0, window.reduce)-x0), int(ceilDivPow2(window.y0, window.reduce)-y0),
+		int(ceilDivPow2(window.x1, window.reduce)-x0), int(ceilDivPow2(window.y1, window.reduce)-y0))
+}
+
+// intersectsTile reports whether the region covers part of the tile.
+func (window *decodeWindow) intersectsTile(tile *tile) bool {
+	return tile.tx0 < window.x1 && window.x0 < tile.tx1 && tile.ty0 < window.y1 && window.y0 < tile.ty1
+}
+
+// componentPlane returns an empty plane covering the region of the component at the reduced resolution. The plane
+// starts at the sample that sub-sampled components replicate into the first pixel of the region.
+func (window *decodeWindow) componentPlane(size componentSize) *componentPlane {
+	x0 := maxuint32(ceilDivPow2(size.x0, window.reduce), (window.x0>>window.reduce)/uint32(size.XRsiz))
+	y0 := maxuint32(ceilDivPow2(size.y0, window.reduce), (window.y0>>window.reduce)/uint32(size.YRsiz))
+	x1 := ceilDivPow2(ceilDiv(window.x1, uint32(size.XRsiz)), window.reduce)
+	y1 := ceilDivPow2(ceilDiv(window.y1, uint32(size.YRsiz)), window.reduce)
+
+	plane := &componentPlane{x0: x0, y0: y0, width: x1 - x0, height: y1 - y0}
+	plane.samples = make([]int32, int(plane.width)*int(plane.height))
+
+	return plane
+}
+
+// selectCodeblocks marks the code-blocks of the tile that are not needed for the region at the reduced resolution, so
+// that their data is skipped when the packets are read.
+func (window *decodeWindow) selectCodeblocks(header *Header, tile *tile) error {
+	for c, component := range tile.components {
+		levels := component.codingStyleParameters.NumberOfLevels
+		if window.reduce > levels {
+			return UnsupportedError("reduction by more than the decomposition levels")
+		}
+
+		size := header.Components[c]
+		cx0 := ceilDiv(window.x0, uint32(size.XRsiz))
+		cy0 := ceilDiv(window.y0, uint32(size.YRsiz))
+		cx1 := ceilDiv(window.x1, uint32(size.XRsiz))
+		cy1 := ceilDiv(window.y1, uint32(size.YRsiz))
+
+		for _, subband := range component.subbands {
+			if subband.resolution.resLevel > levels-window.reduce {
+				for _, codeblock := range subband.codeblocks {
+					codeblock.skipped = true
+				}
+				continue
+			}
+
+			offsets := subbandOffsets[subband.subbandType]
+			x0 := subbandCoordinate(cx0, offsets[0], subband.level)
+			y0 := subbandCoordinate(cy0, offsets[1], subband.level)
+			x1 := subbandCoordinate(cx1, offsets[0], subband.level) + regionMargin
+			y1 := subbandCoordinate(cy1, offsets[1], subband.level) + regionMargin
+			x0 -= minuint32(x0, regionMargin)
+			y0 -= minuint32(y0, regionMargin)
+
+			for _, codeblock := range subband.codeblocks {
+				codeblock.skipped = codeblock.tbx1 <= x0 || x1 <= codeblock.tbx0 || codeblock.tby1 <= y0 || y1 <= codeblock.tby0
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,222 @@
+package jpeg2000
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func decodeTestFile(t *testing.T, filename string, options *DecodeOptions) image.Image {
+	data, err := os.ReadFile(filepath.Join("testdata", filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := DecodeWithOptions(bytes.NewReader(data), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return img
+}
+
+// lowpass applies one level of the forward 5-3 transform (Section F.4.2, columns and then rows) to the samples, and
+// returns the LL sub-band.
+func lowpass(samples [][]float64) [][]float64 {
+	height := len(samples)
+	width := len(samples[0])
+
+	column := make([]float64, height)
+	for x := 0; x < width; x++ {
+		for y := range column {
+			column[y] = samples[y][x]
+		}
+		for y, value := range analyse1D(column, 0, true) {
+			samples[y][x] = value
+		}
+	}
+
+	var ll [][]float64
+	for y := 0; y < height; y += 2 {
+		row := analyse1D(samples[y], 0, true)
+
+		var band []float64
+		for x := 0; x < width; x += 2 {
+			band = append(band, row[x])
+		}
+		ll = append(ll, band)
+	}
+
+	return ll
+}
+
+func TestDecodeReduceLevels(t *testing.T) {
+	// The reduced image of a reversible codestream is the LL sub-band of the forward transform of the image
+	samples := make([][]float64, 29)
+	for y := range samples {
+		samples[y] = make([]float64, 37)
+		for x := range samples[y] {
+			samples[y][x] = float64(testSample(x, y, 0) - 128)
+		}
+	}
+
+	for reduce := 1; reduce <= 2; reduce++ {
+		samples = lowpass(samples)
+
+		img := decodeTestFile(t, "gray.j2k", &DecodeOptions{ReduceLevels: reduce})
+
+		bounds := image.Rect(0, 0, len(samples[0]), len(samples))
+		if img.Bounds() != bounds {
+			t.Fatalf("reduced by %d: expected bounds %v, found %v", reduce, bounds, img.Bounds())
+		}
+
+		for y, row := range samples {
+			for x, value := range row {
+				expected := int(value) + 128
+				if expected < 0 {
+					expected = 0
+				} else if expected > 255 {
+					expected = 255
+				}
+
+				if found := testPixel(img, x, y)[0]; found != expected {
+					t.Fatalf("reduced by %d, (%d, %d): expected %d, found %d", reduce, x, y, expected, found)
+				}
+			}
+		}
+	}
+
+	// The sizes of sub-sampled components are reduced with the image
+	img := decodeTestFile(t, "subsampled.j2k", &DecodeOptions{ReduceLevels: 1})
+	if img.Bounds() != image.Rect(0, 0, 16, 13) {
+		t.Errorf("expected bounds (0,0)-(16,13), found %v", img.Bounds())
+	}
+
+	data, err := os.ReadFile(filepath.Join("testdata", "gray.j2k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = DecodeWithOptions(bytes.NewReader(data), &DecodeOptions{ReduceLevels: 3})
+	if _, ok := err.(UnsupportedError); !ok {
+		t.Errorf("expected UnsupportedError when reducing by more than the decomposition levels, found %v", err)
+	}
+}
+
+func TestDecodeRegion(t *testing.T) {
+	tests := []struct {
+		filename string
+		reduce   int
+		region   image.Rectangle
+	}{
+		{"precincts.j2k", 0, image.Rect(20, 10, 31, 25)},
+		{"precincts.j2k", 0, image.Rect(0, 0, 1, 1)},
+		{"precincts.j2k", 0, image.Rect(45, 30, 60, 60)},
+		{"precincts.j2k", 2, image.Rect(17, 9, 40, 30)},
+		{"rgb-lossy.j2k", 0, image.Rect(3, 4, 29, 30)},
+		{"rgba-tiles.j2k", 0, image.Rect(10, 10, 20, 20)},
+		{"rgba-tiles.j2k", 1, image.Rect(14, 15, 35, 38)},
+		{"subsampled.j2k", 0, image.Rect(5, 7, 18, 20)},
+	}
+
+	for _, test := range tests {
+		full := decodeTestFile(t, test.filename, &DecodeOptions{ReduceLevels: test.reduce})
+		img := decodeTestFile(t, test.filename, &DecodeOptions{ReduceLevels: test.reduce, Region: test.region})
+
+		// The region at full resolution, clipped to the image and scaled down by the reduction
+		scale := 1 << test.reduce
+		region := test.region.Intersect(image.Rect(0, 0, full.Bounds().Max.X*scale, full.Bounds().Max.Y*scale))
+		bounds := image.Rect((region.Min.X+scale-1)/scale, (region.Min.Y+scale-1)/scale,
+			(region.Max.X+scale-1)/scale, (region.Max.Y+scale-1)/scale).Intersect(full.Bounds())
+
+		if img.Bounds() != bounds {
+			t.Errorf("%s %v reduced by %d: expected bounds %v, found %v", test.filename, test.region, test.reduce, bounds,
+				img.Bounds())
+			continue
+		}
+
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				expected := testPixel(full, x, y)
+				for c, value := range testPixel(img, x, y) {
+					if value != expected[c] {
+						t.Fatalf("%s %v reduced by %d: component %d of (%d, %d): expected %d, found %d", test.filename,
+							test.region, test.reduce, c, x, y, expected[c], value)
+					}
+				}
+			}
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join("testdata", "rgba-tiles.j2k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the tiles that intersect the region are decoded
+	var header Header
+	err = header.readCodestream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = header.decodeImage(&DecodeOptions{Region: image.Rect(2, 3, 20, 10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tile := range header.tiles {
+		if decoded := tile.data == nil; decoded != (i < 2) {
+			t.Errorf("tile %d: expected decoded %v", i, i < 2)
+		}
+	}
+
+	_, err = DecodeWithOptions(bytes.NewReader(data), &DecodeOptions{Region: image.Rect(45, 0, 50, 10)})
+	if err != ErrEmptyRegion {
+		t.Errorf("expected ErrEmptyRegion, found %v", err)
+	}
+}
+
+func TestDecodeMaxLayers(t *testing.T) {
+	for _, test := range []struct {
+		filename string
+		layers   int
+	}{{"rgb.j2k", 3}, {"precincts.j2k", 4}} {
+		full := decodeTestFile(t, test.filename, nil)
+		bounds := full.Bounds()
+
+		// Each layer brings the image closer to the decoding of all of them, and the last reaches it
+		previous := -1
+		for layers := 1; layers <= test.layers+1; layers++ {
+			img := decodeTestFile(t, test.filename, &DecodeOptions{MaxLayers: layers})
+
+			difference := 0
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					expected := testPixel(full, x, y)
+					for c, value := range testPixel(img, x, y) {
+						if value > expected[c] {
+							difference += value - expected[c]
+						} else {
+							difference += expected[c] - value
+						}
+					}
+				}
+			}
+
+			if layers >= test.layers && difference != 0 {
+				t.Errorf("%s with %d layers: expected no difference, found %d", test.filename, layers, difference)
+			}
+			if layers == 1 && difference == 0 {
+				t.Errorf("%s with 1 layer: expected a difference", test.filename)
+			}
+			if previous >= 0 && difference > previous {
+				t.Errorf("%s with %d layers: difference %d greater than %d with one less", test.filename, layers,
+					difference, previous)
+			}
+
+			previous = difference
+		}
+	}
+}
@@ -1,15 +1,14 @@
 package jpeg2000
 
-import (
-	"fmt"
-	"math"
-)
-
+// packetBuf reads the bits of packet headers, which have a 0 bit stuffed after each 0xFF byte (Section B.10.1).
+// Reading beyond the end of the data returns 0 bits and sets overrun, so that truncated codestreams decode as far as
+// they go.
 type packetBuf struct {
-	position    uint32
-	buffer      uint16
-	bufferSize  uint16
-	skipNextBit bool
+	position   int
+	buffer     byte
+	bufferSize uint8
+	previous   byte
+	overrun    bool
 
 	data []byte
 }
@@ -18,51 +17,64 @@ func newDataBuf(data []byte) *packetBuf {
 	return &packetBuf{data: data}
 }
 
-func (buf *packetBuf) readBits(count uint16) uint16 {
-	for buf.bufferSize < count {
-		b := buf.data[buf.position]
+func (buf *packetBuf) readBit() uint32 {
+	if buf.bufferSize == 0 {
+		var b byte
+		if buf.position < len(buf.data) {
+			b = buf.data[buf.position]
+		} else {
+			buf.overrun = true
+		}
 		buf.position++
 
-		if buf.skipNextBit {
-			buf.buffer = (buf.buffer << 7) | uint16(b)
-			buf.bufferSize += 7
-			buf.skipNextBit = false
+		if buf.previous == 0xff {
+			buf.bufferSize = 7
 		} else {
-			buf.buffer = (buf.buffer << 8) | uint16(b)
-			buf.bufferSize += 8
-		}
-		if b == 0xff {
-			buf.skipNextBit = true
+			buf.bufferSize = 8
 		}
+		buf.buffer = b
+		buf.previous = b
 	}
-	buf.bufferSize -= count
-	return (buf.buffer >> buf.bufferSize) & uint16((1<<count)-1)
+
+	buf.bufferSize--
+	return uint32(buf.buffer>>buf.bufferSize) & 1
+}
+
+func (buf *packetBuf) readBits(count uint32) uint32 {
+	var value uint32
+
+	for i := uint32(0); i < count; i++ {
+		value = (value << 1) | buf.readBit()
+	}
+
+	return value
 }
 
 func (buf *packetBuf) skipMarkerIfEqual(value uint8) bool {
-	if buf.data[buf.position-1] == 0xff && buf.data[buf.position] == value {
-		buf.skipBytes(1)
-		return true
-	} else if buf.data[buf.position] == 0xff && buf.data[buf.position+1] == value {
+	if buf.position+1 < len(buf.data) && buf.data[buf.position] == 0xff && buf.data[buf.position+1] == value {
 		buf.skipBytes(2)
 		return true
 	}
 	return false
 }
 
-func (buf *packetBuf) skipBytes(count uint32) {
+func (buf *packetBuf) skipBytes(count int) {
 	buf.position += count
 }
 
+// alignToByte discards the remaining bits of the current byte, and the following byte if the current one is 0xFF so
+// that it holds only the stuffed bit.
 func (buf *packetBuf) alignToByte() {
 	buf.bufferSize = 0
 
-	if buf.skipNextBit {
+	if buf.previous == 0xff {
 		buf.position++
-		buf.skipNextBit = false
 	}
+	buf.previous = 0
 }
-func (buf *packetBuf) readCodingpasses() uint16 {
+
+func (buf *packetBuf) readCodingpasses() uint32 {
+	// Table B.4 Codewords for the number of coding passes
 	if buf.readBits(1) == 0 {
 		return 1
 	}
@@ -81,346 +93,276 @@ func (buf *packetBuf) readCodingpasses() uint16 {
 	return value + 37
 }
 
-func (header *Header) parseTilePackets(data []byte) uint32 {
+// floorLog2 returns floor(log2(value)) for value > 0.
+func floorLog2(value uint32) uint32 {
+	var result uint32
+
+	for value > 1 {
+		value >>= 1
+		result++
+	}
+
+	return result
+}
+
+// nextSegment adds a new codeword segment to the code-block. The number of passes it can hold depends on the
+// termination of the passes, given in Section D.4.1 and Table D.9.
+func (codeblock *codeblock) nextSegment(style *CodingStyle) *segment {
+	var maxPasses uint32
+
+	switch {
+	case style.TerminateOnCodingPass:
+		maxPasses = 1
+	case style.ArithmeticCodingBypass:
+		if len(codeblock.segments) == 0 {
+			// The first four bit-planes are coded by the arithmetic coder
+			maxPasses = 10
+		} else if previous := codeblock.segments[len(codeblock.segments)-1].maxPasses; previous == 1 || previous == 10 {
+			// Significance propagation and magnitude refinement passes are coded in raw mode
+			maxPasses = 2
+		} else {
+			// Cleanup passes are coded by the arithmetic coder
+			maxPasses = 1
+		}
+	default:
+		// All of the passes that can be coded for a 32 bit-plane code-block
+		maxPasses = 109
+	}
+
+	s := &segment{maxPasses: maxPasses}
+	codeblock.segments = append(codeblock.segments, s)
+
+	return s
+}
+
+// parseTilePackets reads the packets of the tile in the order given by its packet iterator, adding the data of each
+// code-block to its codeword segments (Section B.10). The data of layers from maxLayers on, and of skipped code-blocks,
+// is passed over.
+func (header *Header) parseTilePackets(tile *tile, maxLayers uint32) {
+	data := tile.data
 	dataBuf := newDataBuf(data)
 
-	tileIndex := header.currentTile.index
-	tile := header.tiles[tileIndex]
-	sopMarkerUsed := header.COD.SOPMarker
-	ephMarkerUsed := header.COD.EPHMarker
+	sopMarkerUsed := tile.codingStyleDefaultParameters.SOPMarker
+	ephMarkerUsed := tile.codingStyleDefaultParameters.EPHMarker
 	packetsIterator := tile.packetsIterator
 
-	fmt.Println("parseTilePackets")
+	for dataBuf.position < len(data) {
+		packet := packetsIterator.nextPacket()
+		if packet == nil {
+			break
+		}
 
-	for dataBuf.position < uint32(len(data)) {
-		dataBuf.alignToByte()
-		if sopMarkerUsed && dataBuf.skipMarkerIfEqual(0x91) {
+		layerNumber := uint32(packet.layerNumber)
+		if layerNumber >= maxLayers && tile.progressionOrderChanges == nil &&
+			tile.codingStyleDefaultParameters.ProgressionOrder == LayerResolutionLevelComponentPosition {
+			// The remaining packets are all of discarded layers
+			break
+		}
+
+		if sopMarkerUsed && dataBuf.skipMarkerIfEqual(SOP) {
 			// Skip also marker segment length and packet sequence ID
 			dataBuf.skipBytes(4)
 		}
 
-		packet := packetsIterator.nextPacket()
-
-		if dataBuf.readBits(1) == 0x00 {
-			continue
-		}
-		fmt.Printf("Currently %d out of %d\n", dataBuf.position, len(data))
-		layerNumber := uint8(packet.layerNumber)
-		//var queue = [], codeblock;
 		var queue []*queueItem
 
-		for i := 0; i < len(packet.codeblocks); i++ {
-			var inclusionTree *inclusionTree
-			var zeroBitPlanesTree *tagTree
+		if dataBuf.readBits(1) != 0x00 {
+			for _, part := range packet.precinct.subbands {
+				for _, codeblock := range part.codeblocks {
+					codeblockIndex := (codeblock.cbx - part.cbxMin) + (codeblock.cby-part.cbyMin)*(part.cbxMax-part.cbxMin)
+					style := codeblock.subband.component.codingStyleParameters
 
-			codeblock := packet.codeblocks[i]
-			precinct := codeblock.precinct
-			codeblockColumn := codeblock.cbx - precinct.cbxMin
-			codeblockRow := codeblock.cby - precinct.cbyMin
+					var codeblockIncluded, firstTimeInclusion bool
 
-			var codeblockIncluded, firstTimeInclusion, valueReady bool
+					if codeblock.included {
+						codeblockIncluded = dataBuf.readBits(1) == 0x01
+					} else {
+						// reading inclusion tree
+						codeblockIncluded = part.inclusionTree.decode(dataBuf, codeblockIndex, layerNumber+1)
+						firstTimeInclusion = codeblockIncluded
+					}
 
-			if codeblock.included {
-				codeblockIncluded = dataBuf.readBits(1) == 0x01
-			} else {
-				// reading inclusion tree
-				precinct = codeblock.precinct
+					if !codeblockIncluded {
+						continue
+					}
 
-				if precinct.inclusionTree != nil {
-					inclusionTree = precinct.inclusionTree
-				} else {
-					// building inclusion and zero bit-planes trees
-					width := precinct.cbxMax - precinct.cbxMin + 1
-					height := precinct.cbyMax - precinct.cbyMin + 1
+					if firstTimeInclusion {
+						codeblock.included = true
 
-					inclusionTree = newInclusionTree(width, height, layerNumber)
-					zeroBitPlanesTree = newTagTree(width, height)
+						// Section B.10.5 the number of missing most significant bit-planes is the value of the zero
+						// bit-plane tag tree.
+						threshold := uint32(1)
+						for !part.zeroBitPlanesTree.decode(dataBuf, codeblockIndex, threshold) && !dataBuf.overrun {
+							threshold++
+						}
 
-					precinct.inclusionTree = inclusionTree
-					precinct.zeroBitPlanesTree = zeroBitPlanesTree
-				}
+						codeblock.zeroBitPlanes = uint8(minuint32(threshold-1, 0xff))
+					}
 
-				if inclusionTree.reset(codeblockColumn, codeblockRow, layerNumber) {
-					for true {
-						if dataBuf.readBits(1) == 0x01 {
-							valueReady = !inclusionTree.nextLevel()
-
-							if valueReady {
-								codeblock.included = true
-								codeblockIncluded = true
-								firstTimeInclusion = true
-								break
-							}
-						} else {
-							inclusionTree.incrementValue(layerNumber)
-							break
-						}
+					codingpasses := dataBuf.readCodingpasses()
+					discard := layerNumber >= maxLayers || codeblock.skipped
+					if !discard {
+						codeblock.passes += codingpasses
 					}
-				}
-			}
-			if !codeblockIncluded {
-				continue
-			}
-			if firstTimeInclusion {
-				zeroBitPlanesTree = precinct.zeroBitPlanesTree
-				zeroBitPlanesTree.reset(codeblockColumn, codeblockRow)
 
-				for true {
-					if dataBuf.readBits(1) == 0x01 {
-						valueReady = !zeroBitPlanesTree.nextLevel()
+					for dataBuf.readBits(1) == 0x01 && !dataBuf.overrun {
+						codeblock.Lblock++
+					}
 
-						if valueReady {
-							break
-						}
-					} else {
-						zeroBitPlanesTree.incrementValue()
+					var currentSegment *segment
+					if len(codeblock.segments) > 0 {
+						currentSegment = codeblock.segments[len(codeblock.segments)-1]
+					}
+					if currentSegment == nil || currentSegment.passes == currentSegment.maxPasses {
+						currentSegment = codeblock.nextSegment(style)
 					}
-				}
 
-				codeblock.zeroBitPlanes = zeroBitPlanesTree.value
-			}
-			var codingpasses = dataBuf.readCodingpasses()
-			for dataBuf.readBits(1) == 0x01 {
-				codeblock.Lblock++
-			}
-			codingpassesLog2 := uint16(math.Log2(float64(codingpasses)))
-			// rounding down log2
-			downVal := codingpasses < 1<<codingpassesLog2
-			var bits uint16
-			if downVal {
-				bits = codingpassesLog2 - 1
-			} else {
-				bits = codingpassesLog2
-			}
-			bits += +codeblock.Lblock
+					// Section B.10.7.2 the length of each codeword segment contributed to
+					for codingpasses > 0 {
+						passes := minuint32(currentSegment.maxPasses-currentSegment.passes, codingpasses)
+						bits := codeblock.Lblock + floorLog2(passes)
+						if bits > 32 {
+							dataBuf.overrun = true
+							return
+						}
+
+						codedDataLength := dataBuf.readBits(bits)
+						queue = append(queue, &queueItem{segment: currentSegment, dataLength: codedDataLength, discard: discard})
 
-			fmt.Printf("About to read %d bits\n", bits)
+						currentSegment.passes += passes
+						codingpasses -= passes
 
-			var codedDataLength = dataBuf.readBits(bits)
-			queue = append(queue, &queueItem{codeblock: codeblock, codingPasses: codingpasses, dataLength: codedDataLength})
+						if codingpasses > 0 {
+							currentSegment = codeblock.nextSegment(style)
+						}
+					}
+				}
+			}
 		}
+
 		dataBuf.alignToByte()
 		if ephMarkerUsed {
-			dataBuf.skipMarkerIfEqual(0x92)
+			dataBuf.skipMarkerIfEqual(EPH)
 		}
 
-		fmt.Println(queue)
+		if dataBuf.overrun {
+			return
+		}
 
-		for len(queue) > 0 {
-			var packetItem = queue[0]
-			queue = queue[1:]
-			codeblock := packetItem.codeblock
+		for _, packetItem := range queue {
+			start := dataBuf.position
+			end := start + int(packetItem.dataLength)
+			if end > len(data) || end < start {
+				end = len(data)
+			}
 
-			codeblock.data = append(codeblock.data, &codeblockData{data: data, start: dataBuf.position, end: dataBuf.position + uint32(packetItem.dataLength), codingPasses: packetItem.codingPasses})
+			if !packetItem.discard {
+				packetItem.segment.data = append(packetItem.segment.data, data[start:end]...)
+			}
 
-			dataBuf.position += uint32(packetItem.dataLength)
+			dataBuf.position = end
 		}
 	}
-	return dataBuf.position
 }
 
 type queueItem struct {
-	codeblock    *codeblock
-	codingPasses uint16
-	dataLength   uint16
+	segment    *segment
+	dataLength uint32
+	discard    bool
 }
 
-type level struct {
-	width    uint32
-	height   uint32
-	items    []byte
-	itemsSet []bool
-	index    uint32
+// Section B.10.2 Tag trees
+type tagTreeNode struct {
+	parent *tagTreeNode
+	value  uint32
+	low    uint32
 }
 
-type inclusionTree struct {
-	currentLevel int
-	levels       []*level
+type tagTree struct {
+	nodes []tagTreeNode
 }
 
-func newInclusionTree(width, height uint32, defaultValue byte) *inclusionTree {
-	var inclusionTree inclusionTree
-
-	levelsLength := uint32(math.Log2(float64(maxuint32(width, height)))) + 1
-
-	for i := uint32(0); i < levelsLength; i++ {
-		var level level
-		level.width = width
-		level.height = height
-		level.items = make([]byte, width*height)
-
-		for j := 0; j < len(level.items); j++ {
-			level.items[j] = defaultValue
-		}
-
-		inclusionTree.levels = append(inclusionTree.levels, &level)
-
-		width = uint32(math.Ceil(float64(width) / 2.0))
-		height = uint32(math.Ceil(float64(height) / 2.0))
-	}
-
-	return &inclusionTree
-}
+const tagTreeUnknown = ^uint32(0)
 
-func (tree *inclusionTree) reset(i, j uint32, stopValue byte) bool {
-	currentLevel := 0
+func newTagTree(width, height uint32) *tagTree {
+	var tree tagTree
 
-	for currentLevel < len(tree.levels) {
-		level := tree.levels[currentLevel]
-		index := i + j*level.width
-		level.index = index
-		value := level.items[index]
+	// Count the nodes of each level, from the leaves to the root
+	var levelWidths, levelHeights []uint32
+	total := uint32(0)
+	for {
+		levelWidths = append(levelWidths, width)
+		levelHeights = append(levelHeights, height)
+		total += width * height
 
-		if value == 0xff {
+		if width <= 1 && height <= 1 {
 			break
 		}
 
-		if value > stopValue {
-			tree.currentLevel = currentLevel
-			// already know about this one, propagating the value to top levels
-			tree.propagateValues()
-			return false
-		}
-
-		i >>= 1
-		j >>= 1
-		currentLevel++
+		width = (width + 1) / 2
+		height = (height + 1) / 2
 	}
 
-	tree.currentLevel = currentLevel - 1
-	return true
-}
-
-func (tree *inclusionTree) incrementValue(stopValue byte) {
-	level := tree.levels[tree.currentLevel]
-	level.items[level.index] = stopValue + 1
-	tree.propagateValues()
-}
-
-func (tree *inclusionTree) propagateValues() {
-	levelIndex := tree.currentLevel
-	level := tree.levels[levelIndex]
-	currentValue := level.items[level.index]
-
-	for levelIndex--; levelIndex >= 0; levelIndex-- {
-		level = tree.levels[levelIndex]
-		level.items[level.index] = currentValue
+	tree.nodes = make([]tagTreeNode, total)
+	for i := range tree.nodes {
+		tree.nodes[i].value = tagTreeUnknown
 	}
-}
 
-func (tree *inclusionTree) nextLevel() bool {
-	currentLevel := tree.currentLevel
-	level := tree.levels[currentLevel]
-	value := level.items[level.index]
+	levelStart := uint32(0)
+	for level := 0; level+1 < len(levelWidths); level++ {
+		parentStart := levelStart + levelWidths[level]*levelHeights[level]
 
-	level.items[level.index] = 0xff
-	currentLevel--
+		for j := uint32(0); j < levelHeights[level]; j++ {
+			for i := uint32(0); i < levelWidths[level]; i++ {
+				parent := parentStart + (j/2)*levelWidths[level+1] + i/2
+				tree.nodes[levelStart+j*levelWidths[level]+i].parent = &tree.nodes[parent]
+			}
+		}
 
-	if currentLevel < 0 {
-		return false
+		levelStart = parentStart
 	}
 
-	tree.currentLevel = currentLevel
-	level = tree.levels[currentLevel]
-	level.items[level.index] = value
-	return true
+	return &tree
 }
 
-// Section B.10.2 Tag trees
-type tagTree struct {
-	currentLevel int
-	levels       []*level
+// decode reads the bits of the tag tree needed to find whether the value of the leaf is below threshold, and reports
+// whether it is.
+func (tree *tagTree) decode(buf *packetBuf, leaf uint32, threshold uint32) bool {
+	var stack []*tagTreeNode
 
-	value byte
-}
-
-func newTagTree(width, height uint32) *tagTree {
-	var tagTree tagTree
-
-	levelsLength := uint32(math.Log2(float64(maxuint32(width, height)))) + 1
-	fmt.Printf("newTagTree: levelsLength = %d\n", levelsLength)
-	for i := uint32(0); i < levelsLength; i++ {
-		var level level
-		level.width = width
-		level.height = height
-
-		tagTree.levels = append(tagTree.levels, &level)
-
-		width = uint32(math.Ceil(float64(width) / 2.0))
-		height = uint32(math.Ceil(float64(height) / 2.0))
+	node := &tree.nodes[leaf]
+	for node.parent != nil {
+		stack = append(stack, node)
+		node = node.parent
 	}
 
-	return &tagTree
-}
-
-func (tree *tagTree) reset(i, j uint32) {
-	currentLevel := 0
-	var value byte
-	var level *level
-	fmt.Println("Starting reset()")
-	fmt.Printf("Reset: currentLevel = %d (%d)\n", currentLevel, len(tree.levels))
+	low := uint32(0)
+	for {
+		if low > node.low {
+			node.low = low
+		} else {
+			low = node.low
+		}
 
-	for currentLevel < len(tree.levels) {
-		level = tree.levels[currentLevel]
+		for low < threshold && low < node.value {
+			if buf.readBit() == 1 {
+				node.value = low
+			} else {
+				low++
+			}
 
-		index := i + j*level.width
+			if buf.overrun {
+				return false
+			}
+		}
+		node.low = low
 
-		if index < uint32(len(level.items)) && level.itemsSet[index] {
-			value = level.items[index]
+		if len(stack) == 0 {
 			break
 		}
-
-		level.index = index
-		i >>= 1
-		j >>= 1
-		currentLevel++
-
-		fmt.Printf("Reset: currentLevel = %d\n", currentLevel)
+		node = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
 	}
 
-	fmt.Printf("Reset: currentLevel = %d (%d)\n", currentLevel, len(tree.levels))
-	currentLevel--
-	fmt.Printf("Reset: currentLevel = %d\n", currentLevel)
-	level = tree.levels[currentLevel]
-
-	for i := uint32(0); i <= level.index; i++ {
-		fmt.Printf("Creating item %d with value %d\n", i, value)
-		level.items = append(level.items, 0)
-		level.itemsSet = append(level.itemsSet, false)
-	}
-
-	level.items[level.index] = value
-	level.itemsSet[level.index] = true
-	tree.currentLevel = currentLevel
-	// TODO: No replacement
-	//delete this.value;
-}
-
-func (tree *tagTree) incrementValue() {
-	level := tree.levels[tree.currentLevel]
-	level.items[level.index]++
-}
-
-func (tree *tagTree) nextLevel() bool {
-	currentLevel := tree.currentLevel
-	level := tree.levels[currentLevel]
-	value := level.items[level.index]
-
-	currentLevel--
-	if currentLevel < 0 {
-		tree.value = value
-		return false
-	}
-
-	tree.currentLevel = currentLevel
-	level = tree.levels[currentLevel]
-
-	for i := uint32(0); i <= level.index; i++ {
-		level.items = append(level.items, 0)
-		level.itemsSet = append(level.itemsSet, false)
-	}
-
-	level.items[level.index] = value
-
-	return true
+	return node.value < threshold
 }
@@ -0,0 +1,111 @@
+package jpeg2000
+
+import "testing"
+
+func TestPacketBufBitStuffing(t *testing.T) {
+	// The byte after 0xFF holds only 7 bits, its most significant bit being the stuffed 0
+	buf := newDataBuf([]byte{0xff, 0x7f, 0x80})
+
+	if value := buf.readBits(8); value != 0xff {
+		t.Errorf("expected 0xff, found %#x", value)
+	}
+	if value := buf.readBits(7); value != 0x7f {
+		t.Errorf("expected 0x7f, found %#x", value)
+	}
+	if value := buf.readBits(1); value != 1 {
+		t.Errorf("expected 1, found %d", value)
+	}
+	if buf.overrun {
+		t.Errorf("unexpected overrun")
+	}
+
+	buf.readBits(8)
+	if !buf.overrun {
+		t.Errorf("expected overrun after the end of the data")
+	}
+}
+
+func TestPacketBufAlignToByte(t *testing.T) {
+	// Aligning after a 0xFF byte skips the byte holding the stuffed bit
+	buf := newDataBuf([]byte{0xff, 0x00, 0xaa})
+	buf.readBits(3)
+	buf.alignToByte()
+	if value := buf.readBits(8); value != 0xaa {
+		t.Errorf("expected 0xaa, found %#x", value)
+	}
+
+	buf = newDataBuf([]byte{0x12, 0xaa})
+	buf.readBits(3)
+	buf.alignToByte()
+	if value := buf.readBits(8); value != 0xaa {
+		t.Errorf("expected 0xaa, found %#x", value)
+	}
+}
+
+func TestReadCodingpasses(t *testing.T) {
+	// Table B.4 codewords, padded with zeros to a whole number of bytes. The longer codewords have a stuffed bit
+	// after their first byte.
+	tests := []struct {
+		data     []byte
+		expected uint32
+	}{
+		{[]byte{0x00}, 1},
+		{[]byte{0x80}, 2},
+		{[]byte{0xc0}, 3},
+		{[]byte{0xe0}, 5},
+		{[]byte{0xf0, 0x00}, 6},
+		{[]byte{0xf7, 0x80}, 21},
+		{[]byte{0xff, 0x00}, 36},
+		{[]byte{0xff, 0x40, 0x00}, 37},
+		{[]byte{0xff, 0x7f, 0x80}, 164},
+	}
+
+	for _, test := range tests {
+		buf := newDataBuf(test.data)
+		if value := buf.readCodingpasses(); value != test.expected {
+			t.Errorf("%x: expected %d, found %d", test.data, test.expected, value)
+		}
+	}
+}
+
+func TestTagTree(t *testing.T) {
+	// Section B.10.2 a tag tree of 3 x 2 leaves with the values
+	//   1 3 2
+	//   2 2 1
+	// coded leaf by leaf for a threshold above every value
+	values := []uint32{1, 3, 2, 2, 2, 1}
+	bits := "0111" + "001" + "101" + "01" + "01" + "1"
+
+	data := make([]byte, (len(bits)+7)/8)
+	for i, bit := range bits {
+		if bit == '1' {
+			data[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+
+	tree := newTagTree(3, 2)
+	buf := newDataBuf(data)
+	for leaf, expected := range values {
+		if !tree.decode(buf, uint32(leaf), 4) {
+			t.Fatalf("leaf %d: expected value below 4", leaf)
+		}
+
+		if value := tree.nodes[leaf].value; value != expected {
+			t.Errorf("leaf %d: expected %d, found %d", leaf, expected, value)
+		}
+	}
+
+	if buf.position != len(data) || buf.overrun {
+		t.Errorf("expected %d bytes to be read, found %d", len(data), buf.position)
+	}
+
+	// A threshold at or below the value leaves the leaf undecided
+	tree = newTagTree(1, 1)
+	buf = newDataBuf([]byte{0x00})
+	if tree.decode(buf, 0, 2) {
+		t.Errorf("expected value of at least 2")
+	}
+	if tree.nodes[0].low != 2 {
+		t.Errorf("expected lower bound 2, found %d", tree.nodes[0].low)
+	}
+}
@@ -0,0 +1,449 @@
+package jpeg2000
+
+// Section B.12 Progression order
+
+type packetIterator interface {
+	nextPacket() *packet
+}
+
+type packet struct {
+	layerNumber uint16
+	precinct    *precinctStruct
+}
+
+// ProgressionOrderChange is one progression of a POC marker segment (Section A.6.6), covering the packets of layers
+// below LayerEnd, resolution levels from ResolutionStart to below ResolutionEnd and components from ComponentStart to
+// below ComponentEnd.
+type ProgressionOrderChange struct {
+	ResolutionStart  uint8
+	ComponentStart   uint16
+	LayerEnd         uint16
+	ResolutionEnd    uint8
+	ComponentEnd     uint16
+	ProgressionOrder ProgressionOrder
+}
+
+// progression holds the part of a tile covered by a packet iterator.
+type progression struct {
+	tile  *tile
+	sizes []componentSize
+
+	layerEnd        uint16
+	resolutionStart uint8
+	resolutionEnd   uint8
+	componentStart  uint16
+	componentEnd    uint16
+}
+
+// newProgression covers every packet of the tile.
+func newProgression(header *Header, tile *tile) progression {
+	p := progression{tile: tile, sizes: header.Components}
+
+	p.layerEnd = tile.codingStyleDefaultParameters.NumberOfLayers
+	p.componentEnd = uint16(len(tile.components))
+
+	for _, component := range tile.components {
+		p.resolutionEnd = maxuint8(p.resolutionEnd, component.codingStyleParameters.NumberOfLevels+1)
+	}
+
+	return p
+}
+
+// restrict limits the progression to the packets covered by a progression order change.
+func (p progression) restrict(change ProgressionOrderChange) progression {
+	p.layerEnd = minuint16(p.layerEnd, change.LayerEnd)
+	p.resolutionStart = change.ResolutionStart
+	p.resolutionEnd = minuint8(p.resolutionEnd, change.ResolutionEnd)
+	p.componentStart = change.ComponentStart
+	p.componentEnd = minuint16(p.componentEnd, change.ComponentEnd)
+
+	return p
+}
+
+// take returns the packet of layer l of the precinct, or nil when the packet has already been read. Progression order
+// changes can cover the same packet more than once, but it is only in the codestream the first time.
+func (p *progression) take(precinct *precinctStruct, l uint16) *packet {
+	if l < precinct.layersRead {
+		return nil
+	}
+
+	precinct.layersRead = l + 1
+
+	return &packet{layerNumber: l, precinct: precinct}
+}
+
+// resolution returns resolution level r of component i, or nil when the component has fewer resolution levels.
+func (p *progression) resolution(i uint16, r uint8) *resolution {
+	component := p.tile.components[i]
+	if r > component.codingStyleParameters.NumberOfLevels {
+		return nil
+	}
+
+	return component.resolutions[r]
+}
+
+// precinctAt returns the precinct of resolution level r of component i whose top-left corner is at (x, y) on the
+// reference grid, or nil when no precinct starts there (Section B.12.1.3).
+func (p *progression) precinctAt(i uint16, r uint8, x, y uint32) *precinctStruct {
+	res := p.resolution(i, r)
+	if res == nil || res.trx0 == res.trx1 || res.try0 == res.try1 {
+		return nil
+	}
+
+	parameters := res.precinctParameters
+	levels := p.tile.components[i].codingStyleParameters.NumberOfLevels - r
+	xrsiz := uint64(p.sizes[i].XRsiz) << levels
+	yrsiz := uint64(p.sizes[i].YRsiz) << levels
+
+	// A precinct starts where the precinct grid of the resolution level meets the reference grid, or at the edge of the
+	// tile when the resolution level does not start on the precinct grid
+	xStart := uint64(x)%(xrsiz<<parameters.PPx) == 0 || (x == p.tile.tx0 && res.trx0%(1<<parameters.PPx) != 0)
+	yStart := uint64(y)%(yrsiz<<parameters.PPy) == 0 || (y == p.tile.ty0 && res.try0%(1<<parameters.PPy) != 0)
+	if !xStart || !yStart {
+		return nil
+	}
+
+	px := uint32((uint64(x)+xrsiz-1)/xrsiz)>>parameters.PPx - parameters.precinctX0
+	py := uint32((uint64(y)+yrsiz-1)/yrsiz)>>parameters.PPy - parameters.precinctY0
+	if px >= parameters.numPrecinctsWide || py >= parameters.numPrecinctsHigh {
+		return nil
+	}
+
+	return res.precincts[py*parameters.numPrecinctsWide+px]
+}
+
+// positionStep returns the horizontal and vertical steps between the positions on the reference grid at which a
+// precinct of one of the components from start to below end can begin.
+func (p *progression) positionStep(start, end uint16) (uint64, uint64) {
+	dx := uint64(1) << 63
+	dy := uint64(1) << 63
+
+	for i := start; i < end; i++ {
+		for r := p.resolutionStart; r < p.resolutionEnd; r++ {
+			res := p.resolution(i, r)
+			if res == nil {
+				continue
+			}
+
+			levels := p.tile.components[i].codingStyleParameters.NumberOfLevels - r
+			dx = minuint64(dx, uint64(p.sizes[i].XRsiz)<<(res.precinctParameters.PPx+levels))
+			dy = minuint64(dy, uint64(p.sizes[i].YRsiz)<<(res.precinctParameters.PPy+levels))
+		}
+	}
+
+	return dx, dy
+}
+
+// nextPosition returns the position after value that is a multiple of step.
+func nextPosition(value uint32, step uint64) uint32 {
+	next := (uint64(value)/step + 1) * step
+	if next > 0xffffffff {
+		return 0xffffffff
+	}
+
+	return uint32(next)
+}
+
+type layerResolutionComponentPositionIterator struct {
+	progression
+
+	l uint16
+	r uint8
+	i uint16
+	k uint32
+}
+
+func newLayerResolutionComponentPositionIterator(p progression) *layerResolutionComponentPositionIterator {
+	return &layerResolutionComponentPositionIterator{progression: p, r: p.resolutionStart, i: p.componentStart}
+}
+
+func (iterator *layerResolutionComponentPositionIterator) nextPacket() *packet {
+	// Section B.12.1.1 Layer-resolution-component-position
+	for ; iterator.l < iterator.layerEnd; iterator.l++ {
+		for ; iterator.r < iterator.resolutionEnd; iterator.r++ {
+			for ; iterator.i < iterator.componentEnd; iterator.i++ {
+				resolution := iterator.resolution(iterator.i, iterator.r)
+				if resolution == nil {
+					continue
+				}
+
+				for iterator.k < uint32(len(resolution.precincts)) {
+					precinct := resolution.precincts[iterator.k]
+					iterator.k++
+
+					if packet := iterator.take(precinct, iterator.l); packet != nil {
+						return packet
+					}
+				}
+				iterator.k = 0
+			}
+			iterator.i = iterator.componentStart
+		}
+		iterator.r = iterator.resolutionStart
+	}
+
+	return nil
+}
+
+type resolutionLayerComponentPositionIterator struct {
+	progression
+
+	r uint8
+	l uint16
+	i uint16
+	k uint32
+}
+
+func newResolutionLayerComponentPositionIterator(p progression) *resolutionLayerComponentPositionIterator {
+	return &resolutionLayerComponentPositionIterator{progression: p, r: p.resolutionStart, i: p.componentStart}
+}
+
+func (iterator *resolutionLayerComponentPositionIterator) nextPacket() *packet {
+	// Section B.12.1.2 Resolution level-layer-component-position
+	for ; iterator.r < iterator.resolutionEnd; iterator.r++ {
+		for ; iterator.l < iterator.layerEnd; iterator.l++ {
+			for ; iterator.i < iterator.componentEnd; iterator.i++ {
+				resolution := iterator.resolution(iterator.i, iterator.r)
+				if resolution == nil {
+					continue
+				}
+
+				for iterator.k < uint32(len(resolution.precincts)) {
+					precinct := resolution.precincts[iterator.k]
+					iterator.k++
+
+					if packet := iterator.take(precinct, iterator.l); packet != nil {
+						return packet
+					}
+				}
+				iterator.k = 0
+			}
+			iterator.i = iterator.componentStart
+		}
+		iterator.l = 0
+	}
+
+	return nil
+}
+
+type resolutionPositionComponentLayerIterator struct {
+	progression
+	dx uint64
+	dy uint64
+
+	r uint8
+	y uint32
+	x uint32
+	i uint16
+	l uint16
+}
+
+func newResolutionPositionComponentLayerIterator(p progression) *resolutionPositionComponentLayerIterator {
+	iterator := resolutionPositionComponentLayerIterator{progression: p, r: p.resolutionStart, y: p.tile.ty0, x: p.tile.tx0,
+		i: p.componentStart}
+	iterator.dx, iterator.dy = p.positionStep(p.componentStart, p.componentEnd)
+
+	return &iterator
+}
+
+func (iterator *resolutionPositionComponentLayerIterator) nextPacket() *packet {
+	// Section B.12.1.3 Resolution level-position-component-layer
+	for ; iterator.r < iterator.resolutionEnd; iterator.r++ {
+		for ; iterator.y < iterator.tile.ty1; iterator.y = nextPosition(iterator.y, iterator.dy) {
+			for ; iterator.x < iterator.tile.tx1; iterator.x = nextPosition(iterator.x, iterator.dx) {
+				for ; iterator.i < iterator.componentEnd; iterator.i++ {
+					precinct := iterator.precinctAt(iterator.i, iterator.r, iterator.x, iterator.y)
+					if precinct == nil {
+						continue
+					}
+
+					for iterator.l < iterator.layerEnd {
+						l := iterator.l
+						iterator.l++
+
+						if packet := iterator.take(precinct, l); packet != nil {
+							return packet
+						}
+					}
+					iterator.l = 0
+				}
+				iterator.i = iterator.componentStart
+			}
+			iterator.x = iterator.tile.tx0
+		}
+		iterator.y = iterator.tile.ty0
+	}
+
+	return nil
+}
+
+type positionComponentResolutionLayerIterator struct {
+	progression
+	dx uint64
+	dy uint64
+
+	y uint32
+	x uint32
+	i uint16
+	r uint8
+	l uint16
+}
+
+func newPositionComponentResolutionLayerIterator(p progression) *positionComponentResolutionLayerIterator {
+	iterator := positionComponentResolutionLayerIterator{progression: p, y: p.tile.ty0, x: p.tile.tx0, i: p.componentStart,
+		r: p.resolutionStart}
+	iterator.dx, iterator.dy = p.positionStep(p.componentStart, p.componentEnd)
+
+	return &iterator
+}
+
+func (iterator *positionComponentResolutionLayerIterator) nextPacket() *packet {
+	// Section B.12.1.4 Position-component-resolution level-layer
+	for ; iterator.y < iterator.tile.ty1; iterator.y = nextPosition(iterator.y, iterator.dy) {
+		for ; iterator.x < iterator.tile.tx1; iterator.x = nextPosition(iterator.x, iterator.dx) {
+			for ; iterator.i < iterator.componentEnd; iterator.i++ {
+				for ; iterator.r < iterator.resolutionEnd; iterator.r++ {
+					precinct := iterator.precinctAt(iterator.i, iterator.r, iterator.x, iterator.y)
+					if precinct == nil {
+						continue
+					}
+
+					for iterator.l < iterator.layerEnd {
+						l := iterator.l
+						iterator.l++
+
+						if packet := iterator.take(precinct, l); packet != nil {
+							return packet
+						}
+					}
+					iterator.l = 0
+				}
+				iterator.r = iterator.resolutionStart
+			}
+			iterator.i = iterator.componentStart
+		}
+		iterator.x = iterator.tile.tx0
+	}
+
+	return nil
+}
+
+type componentPositionResolutionLayerIterator struct {
+	progression
+	// dx and dy are the steps between positions of the current component
+	dx uint64
+	dy uint64
+
+	i uint16
+	y uint32
+	x uint32
+	r uint8
+	l uint16
+}
+
+func newComponentPositionResolutionLayerIterator(p progression) *componentPositionResolutionLayerIterator {
+	iterator := componentPositionResolutionLayerIterator{progression: p, i: p.componentStart, y: p.tile.ty0, x: p.tile.tx0,
+		r: p.resolutionStart}
+	if iterator.i < iterator.componentEnd {
+		iterator.dx, iterator.dy = p.positionStep(iterator.i, iterator.i+1)
+	}
+
+	return &iterator
+}
+
+func (iterator *componentPositionResolutionLayerIterator) nextPacket() *packet {
+	// Section B.12.1.5 Component-position-resolution level-layer
+	for iterator.i < iterator.componentEnd {
+		for ; iterator.y < iterator.tile.ty1; iterator.y = nextPosition(iterator.y, iterator.dy) {
+			for ; iterator.x < iterator.tile.tx1; iterator.x = nextPosition(iterator.x, iterator.dx) {
+				for ; iterator.r < iterator.resolutionEnd; iterator.r++ {
+					precinct := iterator.precinctAt(iterator.i, iterator.r, iterator.x, iterator.y)
+					if precinct == nil {
+						continue
+					}
+
+					for iterator.l < iterator.layerEnd {
+						l := iterator.l
+						iterator.l++
+
+						if packet := iterator.take(precinct, l); packet != nil {
+							return packet
+						}
+					}
+					iterator.l = 0
+				}
+				iterator.r = iterator.resolutionStart
+			}
+			iterator.x = iterator.tile.tx0
+		}
+		iterator.y = iterator.tile.ty0
+
+		iterator.i++
+		if iterator.i < iterator.componentEnd {
+			iterator.dx, iterator.dy = iterator.positionStep(iterator.i, iterator.i+1)
+		}
+	}
+
+	return nil
+}
+
+// newProgressionIterator returns the iterator of the packets covered by the progression in the given order.
+func newProgressionIterator(order ProgressionOrder, p progression) packetIterator {
+	switch order {
+	case ResolutionLevelLayerComponentPosition:
+		return newResolutionLayerComponentPositionIterator(p)
+	case ResolutionLevelPositionComponentLayer:
+		return newResolutionPositionComponentLayerIterator(p)
+	case PositionComponentResolutionLevelLayer:
+		return newPositionComponentResolutionLayerIterator(p)
+	case ComponentPositionResolutionLevelLayer:
+		return newComponentPositionResolutionLayerIterator(p)
+	}
+
+	return newLayerResolutionComponentPositionIterator(p)
+}
+
+// progressionOrderChangeIterator gives the packets of each progression order change in turn, followed by any packets
+// that they do not cover in the progression order of the coding style.
+type progressionOrderChangeIterator struct {
+	progression
+	changes []ProgressionOrderChange
+	order   ProgressionOrder
+
+	current packetIterator
+	index   int
+}
+
+func (iterator *progressionOrderChangeIterator) nextPacket() *packet {
+	for {
+		if packet := iterator.current.nextPacket(); packet != nil {
+			return packet
+		}
+
+		switch {
+		case iterator.index < len(iterator.changes):
+			change := iterator.changes[iterator.index]
+			iterator.current = newProgressionIterator(change.ProgressionOrder, iterator.progression.restrict(change))
+		case iterator.index == len(iterator.changes):
+			iterator.current = newProgressionIterator(iterator.order, iterator.progression)
+		default:
+			return nil
+		}
+
+		iterator.index++
+	}
+}
+
+// newPacketIterator returns the iterator giving the order of the packets in the tile, from the progression order
+// changes of the tile when there are any and otherwise from its coding style.
+func newPacketIterator(header *Header, tile *tile) packetIterator {
+	p := newProgression(header, tile)
+	order := tile.codingStyleDefaultParameters.ProgressionOrder
+
+	if len(tile.progressionOrderChanges) == 0 {
+		return newProgressionIterator(order, p)
+	}
+
+	return &progressionOrderChangeIterator{progression: p, changes: tile.progressionOrderChanges, order: order,
+		current: &layerResolutionComponentPositionIterator{}}
+}
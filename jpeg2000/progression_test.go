@@ -0,0 +1,193 @@
+package jpeg2000
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testImageEqual(t *testing.T, name string, img image.Image, expected image.Image) {
+	if img.Bounds() != expected.Bounds() {
+		t.Fatalf("%s: expected bounds %v, found %v", name, expected.Bounds(), img.Bounds())
+	}
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			want := testPixel(expected, x, y)
+			for c, value := range testPixel(img, x, y) {
+				if value != want[c] {
+					t.Fatalf("%s: component %d of (%d, %d): expected %d, found %d", name, c, x, y, want[c], value)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeProgressionOrders(t *testing.T) {
+	// The same 43 x 35 RGB image with four tiles, several precincts per resolution level and three layers, encoded with
+	// each progression order
+	tests := []struct {
+		filename string
+		order    ProgressionOrder
+	}{
+		{"progression-rlcp.j2k", ResolutionLevelLayerComponentPosition},
+		{"progression-rpcl.j2k", ResolutionLevelPositionComponentLayer},
+		{"progression-pcrl.j2k", PositionComponentResolutionLevelLayer},
+		{"progression-cprl.j2k", ComponentPositionResolutionLevelLayer},
+	}
+
+	for _, test := range tests {
+		data, err := os.ReadFile(filepath.Join("testdata", test.filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var header Header
+		err = header.readCodestream(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.COD.ProgressionOrder != test.order {
+			t.Errorf("%s: expected progression order %d, found %d", test.filename, test.order, header.COD.ProgressionOrder)
+		}
+
+		img := decodeTestFile(t, test.filename, nil)
+		if img.Bounds() != image.Rect(0, 0, 43, 35) {
+			t.Fatalf("%s: expected bounds (0,0)-(43,35), found %v", test.filename, img.Bounds())
+		}
+		for y := 0; y < 35; y++ {
+			for x := 0; x < 43; x++ {
+				for c, value := range testPixel(img, x, y)[:3] {
+					if value != testSample(x, y, c) {
+						t.Fatalf("%s: component %d of (%d, %d): expected %d, found %d", test.filename, c, x, y,
+							testSample(x, y, c), value)
+					}
+				}
+			}
+		}
+
+		// The code-blocks are coded in the same way whatever the order of the packets, so limiting the layers or
+		// resolution levels gives the same image
+		if test.order != ResolutionLevelLayerComponentPosition {
+			for _, options := range []*DecodeOptions{{MaxLayers: 1}, {ReduceLevels: 2, MaxLayers: 2}} {
+				testImageEqual(t, test.filename, decodeTestFile(t, test.filename, options),
+					decodeTestFile(t, "progression-rlcp.j2k", options))
+			}
+		}
+	}
+}
+
+func TestDecodeProgressionOrderChange(t *testing.T) {
+	// poc-lrcp.j2k, a 29 x 23 RGB image with three resolution levels and three layers, with its packets reordered to
+	// follow a POC marker segment with two progressions: component-position-resolution level-layer for the first two
+	// layers of components 1 and 2, and then resolution level-layer-component-position for the rest
+	data, err := os.ReadFile(filepath.Join("testdata", "poc.j2k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var header Header
+	err = header.readCodestream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ProgressionOrderChange{
+		{ResolutionStart: 0, ComponentStart: 1, LayerEnd: 2, ResolutionEnd: 3, ComponentEnd: 3,
+			ProgressionOrder: ComponentPositionResolutionLevelLayer},
+		{ResolutionStart: 0, ComponentStart: 0, LayerEnd: 3, ResolutionEnd: 3, ComponentEnd: 256,
+			ProgressionOrder: ResolutionLevelLayerComponentPosition},
+	}
+	if len(header.POC) != len(expected) {
+		t.Fatalf("expected %d progression order changes, found %d", len(expected), len(header.POC))
+	}
+	for i, change := range header.POC {
+		if change != expected[i] {
+			t.Errorf("progression order change %d: expected %+v, found %+v", i, expected[i], change)
+		}
+	}
+
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 23; y++ {
+		for x := 0; x < 29; x++ {
+			for c, value := range testPixel(img, x, y)[:3] {
+				if value != testSample(x, y, c) {
+					t.Fatalf("component %d of (%d, %d): expected %d, found %d", c, x, y, testSample(x, y, c), value)
+				}
+			}
+		}
+	}
+
+	// The packets of the second layer of components 1 and 2 come before some of the first layer, so limiting the layers
+	// gives the same image as the packets in layer-resolution-component-position order
+	for layers := 1; layers <= 3; layers++ {
+		options := &DecodeOptions{MaxLayers: layers}
+		testImageEqual(t, "poc.j2k", decodeTestFile(t, "poc.j2k", options), decodeTestFile(t, "poc-lrcp.j2k", options))
+	}
+}
+
+func TestReadProgressionOrderChanges(t *testing.T) {
+	var header Header
+
+	// Component indices are two bytes long with more than 256 components
+	header.Size.Csiz = 300
+	changes, err := header.readProgressionOrderChanges([]byte{1, 0x01, 0x02, 0, 5, 4, 0, 0, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ProgressionOrderChange{ResolutionStart: 1, ComponentStart: 258, LayerEnd: 5, ResolutionEnd: 4,
+		ComponentEnd: 16384, ProgressionOrder: PositionComponentResolutionLevelLayer}
+	if len(changes) != 1 || changes[0] != expected {
+		t.Errorf("expected %+v, found %+v", expected, changes)
+	}
+
+	header.Size.Csiz = 3
+	for _, segment := range [][]byte{{}, {0, 0, 0, 1, 1, 3}, {0, 0, 0, 1, 1, 3, 5}} {
+		_, err = header.readProgressionOrderChanges(segment)
+		if _, ok := err.(FormatError); !ok {
+			t.Errorf("%v: expected FormatError, found %v", segment, err)
+		}
+	}
+}
+
+func TestProgressionOrderChangePrecedence(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "gray.j2k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var header Header
+	err = header.readCodestream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	main := []ProgressionOrderChange{{LayerEnd: 1, ResolutionEnd: 3, ComponentEnd: 1}}
+	header.main.POC = main
+
+	// Progression order changes in the main header apply to tiles without their own
+	tile := header.tiles[0]
+	err = header.initialiseTile(tile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tile.progressionOrderChanges) != 1 || tile.progressionOrderChanges[0] != main[0] {
+		t.Errorf("expected main header progression order changes, found %+v", tile.progressionOrderChanges)
+	}
+
+	tile.header.POC = []ProgressionOrderChange{{LayerEnd: 1, ResolutionEnd: 1, ComponentEnd: 1,
+		ProgressionOrder: ResolutionLevelLayerComponentPosition}}
+	err = header.initialiseTile(tile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tile.progressionOrderChanges) != 1 || tile.progressionOrderChanges[0].ResolutionEnd != 1 {
+		t.Errorf("expected tile-part progression order changes, found %+v", tile.progressionOrderChanges)
+	}
+}
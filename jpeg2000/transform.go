@@ -1,383 +1,616 @@
 package jpeg2000
 
-/*
-type transformedTile struct {
-	left   uint32
-	top    uint32
-	width  uint32
-	height uint32
-	items  []float64
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// SubbandsGainLog2 is the log2 gain of each sub-band, as used in the nominal dynamic range of Equation E-4.
+var SubbandsGainLog2 = map[string]int{
+	"LL": 0,
+	"HL": 1,
+	"LH": 1,
+	"HH": 2,
 }
 
-type subbandCoefficients struct {
-	width  uint32
-	height uint32
-	items  []float64
+// componentPlane holds the decoded samples of one component of the image.
+type componentPlane struct {
+	x0      uint32
+	y0      uint32
+	width   uint32
+	height  uint32
+	samples []int32
 }
 
-type transform interface {
-	//calculate(coefficients []*subbandCoefficients, width, height uint32)
-	iterate()
+// decodeImage decodes the tiles of the codestream selected by options and assembles the components into an image.
+func (header *Header) decodeImage(options *DecodeOptions) (image.Image, error) {
+	window, err := header.newDecodeWindow(options)
+	if err != nil {
+		return nil, err
+	}
+
+	planes, err := header.decodeComponents(window)
+	if err != nil {
+		return nil, err
+	}
+
+	return header.buildImage(planes, header.Components, window), nil
 }
 
-func (tile *tile) transformTile(header *Header, c uint16) *transformedTile {
-	component := tile.components[c]
-	codingStyleParameters := component.codingStyleParameters
-	quantizationParameters := component.quantizationParameters
-	decompositionLevelsCount := codingStyleParameters.NumberOfLevels
-	spqcds := quantizationParameters.SPqcd                           // SPqcds
-	scalarExpounded := quantizationParameters.QuantizationStyle != 1 // scalarExpounded
-	guardBits := quantizationParameters.GuardBits
-	segmentationSymbolUsed := codingStyleParameters.SegmentationSymbols
-	precision := header.Components[c].BitDepth
+// decodeComponents decodes each tile of the codestream that intersects the window into the planes of the components.
+func (header *Header) decodeComponents(window *decodeWindow) ([]*componentPlane, error) {
+	planes := make([]*componentPlane, len(header.Components))
 
-	reversible := codingStyleParameters.ReversableFilter
-	var transform transform
+	for c, component := range header.Components {
+		plane := window.componentPlane(component)
 
-	if reversible {
-		transform = newReversibleTransform()
-	} else {
-		transform = newIrreversibleTransform()
+		// Tiles missing from the codestream are left at the mid-level of the component
+		if !component.Signed {
+			dc := int32(1) << (component.BitDepth - 1)
+			for i := range plane.samples {
+				plane.samples[i] = dc
+			}
+		}
+
+		planes[c] = plane
 	}
 
-	var subbandCoefficients []*subbandCoefficients
-	b := 0
+	for _, tile := range header.tiles {
+		if tile.header == nil || !window.intersectsTile(tile) {
+			continue
+		}
 
-	for i := uint16(0); i <= uint16(decompositionLevelsCount); i++ {
-		resolution := component.resolutions[i]
+		err := header.decodeTile(tile, planes, window)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-		width := resolution.trx1 - resolution.trx0
-		height := resolution.try1 - resolution.try0
+	return planes, nil
+}
 
-		// Allocate space for the whole sublevel.
-		var coefficients []float64
-		coefficients = make([]float64, width*height)
+// decodeTile decodes the packets of a tile and writes the reconstructed samples into the component planes.
+func (header *Header) decodeTile(tile *tile, planes []*componentPlane, window *decodeWindow) error {
+	err := header.initialiseTile(tile)
+	if err != nil {
+		return err
+	}
 
-		for j := 0; j < len(resolution.subbands); j++ {
-			var mu, epsilon uint16
+	err = header.buildPackets(tile)
+	if err != nil {
+		return err
+	}
 
-			if !scalarExpounded {
-				// formula E-5
-				mu = spqcds[0].Mantissa      //mu
-				epsilon = spqcds[0].Exponent // epsilon
+	err = window.selectCodeblocks(header, tile)
+	if err != nil {
+		return err
+	}
 
-				if i > 0 {
-					epsilon += 1 - i
-				}
-			} else {
-				mu = spqcds[b].Mantissa      //mu
-				epsilon = spqcds[b].Exponent //epsilon
-				b++
-			}
+	header.parseTilePackets(tile, window.maxLayers)
 
-			subband := resolution.subbands[j]
-			gainLog2 := SubbandsGainLog2[subband.subbandType]
+	items := make([][]float64, len(tile.components))
+	resolutions := make([]*resolution, len(tile.components))
+	for c := range tile.components {
+		items[c], resolutions[c], err = header.transformTile(tile, c, window.reduce)
+		if err != nil {
+			return err
+		}
+	}
 
-			// calculate quantization coefficient (Section E.1.1.1)
-			var delta float64
-			if reversible {
-				delta = 1
-			} else {
-				delta = math.Pow(2, precision+gainLog2-epsilon) * (1 + mu/2048)
+	if tile.codingStyleDefaultParameters.MultipleComponentTransformation && len(tile.components) >= 3 {
+		for c := 1; c < 3; c++ {
+			if len(items[c]) != len(items[0]) {
+				return FormatError("component transform of components with different sizes")
 			}
-			mb := guardBits + epsilon - 1
-
-			// In the first resolution level, copyCoefficients will fill the
-			// whole array with coefficients. In the succeeding passes,
-			// copyCoefficients will consecutively fill in the values that belong
-			// to the interleaved positions of the HL, LH, and HH coefficients.
-			// The LL coefficients will then be interleaved in Transform.iterate().
-			copyCoefficients(coefficients, width, height, subband, delta, mb, reversible, segmentationSymbolUsed)
 		}
 
-		subbandCoefficients = append(subbandCoefficients, &subbandCoefficients{width: width, height: height, items: coefficients})
+		inverseComponentTransform(items[0], items[1], items[2], tile.components[0].codingStyleParameters.ReversableFilter)
+	}
+
+	for c := range tile.components {
+		header.storeTileComponent(planes[c], resolutions[c], items[c], header.Components[c])
 	}
 
-	result := transform.calculate(subbandCoefficients, component.tcx0, component.tcy0)
-	return &transformedTile{left: component.tcx0, top: component.tcy0, width: result.width, height: result.height, items: result.items}
+	// Release the code-block data of the tile, as only the decoded samples are needed from here on
+	tile.data = nil
+	tile.components = nil
+
+	return nil
 }
-*/
-type transformedComponent struct {
-	left   uint32
-	top    uint32
-	width  uint32
-	height uint32
-	items  []float64
+
+// storeTileComponent applies the inverse DC level shift of Section G.1.2 to the samples of a tile-component at the
+// resolution level res, and copies those within the component plane into it.
+func (header *Header) storeTileComponent(plane *componentPlane, res *resolution, items []float64, size componentSize) {
+	var dc, minimum, maximum float64
+
+	if size.Signed {
+		minimum = -math.Ldexp(1, int(size.BitDepth)-1)
+		maximum = -minimum - 1
+	} else {
+		dc = math.Ldexp(1, int(size.BitDepth)-1)
+		maximum = math.Ldexp(1, int(size.BitDepth)) - 1
+	}
+
+	width := int(res.trx1) - int(res.trx0)
+
+	// The part of the resolution level within the plane
+	x0 := maxuint32(res.trx0, plane.x0)
+	y0 := maxuint32(res.try0, plane.y0)
+	x1 := minuint32(res.trx1, plane.x0+plane.width)
+	y1 := minuint32(res.try1, plane.y0+plane.height)
+
+	for y := y0; y < y1; y++ {
+		offset := int(y-plane.y0)*int(plane.width) - int(plane.x0)
+		row := int(y-res.try0)*width - int(res.trx0)
+
+		for x := x0; x < x1; x++ {
+			value := math.Floor(items[row+int(x)] + dc + 0.5)
+			if value < minimum {
+				value = minimum
+			} else if value > maximum {
+				value = maximum
+			}
+
+			plane.samples[offset+int(x)] = int32(value)
+		}
+	}
 }
 
-func (header *Header) transformComponents() []*transformedComponent {
-	var resultImages []*transformedComponent
-	/*
-		components := header.Components
-		componentsCount := header.Size.Csiz
+// transformTile decodes the code-blocks of a tile-component, dequantizes them (Section E.1) and applies the inverse
+// discrete wavelet transform (Annex F) up to the resolution level left by the reduction. It returns the samples before
+// the component transform and DC level shift, and the resolution level they belong to.
+func (header *Header) transformTile(tile *tile, c int, reduce uint8) ([]float64, *resolution, error) {
+	component := tile.components[c]
+	codingStyleParameters := component.codingStyleParameters
+	quantizationParameters := component.quantizationParameters
+	precision := int(header.Components[c].BitDepth)
+	reversible := codingStyleParameters.ReversableFilter
+
+	// The sub-bands of the discarded resolution levels are not decoded
+	numResolutions := len(component.resolutions) - int(reduce)
+	coefficients := make([][]float64, 3*numResolutions-2)
 
-		for i := 0; i < len(header.tiles); i++ {
-			tile := header.tiles[i]
-			var transformedTiles []*transformedTile
+	for b, subband := range component.subbands[:len(coefficients)] {
+		stepSize, err := quantizationParameters.stepSize(b, subband.level, codingStyleParameters.NumberOfLevels)
+		if err != nil {
+			return nil, nil, err
+		}
 
-			for c := uint16(0); c < componentsCount; c++ {
-				transformedTiles = append(transformedTiles, tile.transformTile(header, c))
+		// Equation E-2 gives the number of magnitude bits and Equation E-3 the quantization step size
+		mb := int(quantizationParameters.GuardBits) + int(stepSize.Exponent) - 1
+
+		delta := 1.0
+		if !reversible {
+			gainLog2 := SubbandsGainLog2[subband.subbandType]
+			delta = math.Ldexp(1+float64(stepSize.Mantissa)/2048, precision+gainLog2-int(stepSize.Exponent))
+		}
+
+		width := int(subband.tbx1 - subband.tbx0)
+		height := int(subband.tby1 - subband.tby0)
+		if subband.tbx1 < subband.tbx0 || subband.tby1 < subband.tby0 {
+			width, height = 0, 0
+		}
+
+		items := make([]float64, width*height)
+
+		for _, codeblock := range subband.codeblocks {
+			err = codeblock.decodeCoefficients(items, width, mb, delta, component.roiShift, codingStyleParameters)
+			if err != nil {
+				return nil, nil, err
 			}
-			var result transformedComponent
-			var tile0 = transformedTiles[0]
-
-			out := make([]float64, len(tile0.items)*int(componentsCount))
-
-			result.left = tile0.left
-			result.top = tile0.top
-			result.width = tile0.width
-			result.height = tile0.height
-			result.items = out
-
-			// Section G.2.2 Inverse multi component transform
-			var shift, offset uint32
-			var pos, y0, y1, y2 uint32
-
-			if tile.codingStyleDefaultParameters.MultipleComponentTransformation {
-				var y0items, y1items, y2items, y3items []float64
-				fourComponents := componentsCount == 4
-				y0items = transformedTiles[0].items
-				y1items = transformedTiles[1].items
-				y2items = transformedTiles[2].items
-
-				if fourComponents {
-					y3items = transformedTiles[3].items
-				}
+		}
 
-				// HACK: The multiple component transform formulas below assume that
-				// all components have the same precision. With this in mind, we
-				// compute shift and offset only once.
-				shift = components[0].BitDepth - 8
-				offset = (128 << shift) + 0.5
-
-				var component0 = tile.components[0]
-				var alpha01 = componentsCount - 3
-
-				if !component0.codingStyleParameters.ReversableFilter {
-					// inverse irreversible multiple component transform
-					for j := 0; j < len(y0items); j++ {
-						y0 = y0items[j] + offset
-						y1 = y1items[j]
-						y2 = y2items[j]
-						out[pos] = (y0 + 1.402*y2) >> shift
-						pos++
-						out[pos] = (y0 - 0.34413*y1 - 0.71414*y2) >> shift
-						pos++
-						out[pos] = (y0 + 1.772*y1) >> shift
-						pos++
-
-						pos += alpha01
-					}
-				} else {
-					// inverse reversible multiple component transform
-					for j = 0; j < len(y0items); j++ {
-						y0 = y0items[j] + offset
-						y1 = y1items[j]
-						y2 = y2items[j]
-						g := y0 - ((y2 + y1) >> 2)
-
-						out[pos] = (g + y2) >> shift
-						pos++
-						out[pos] = g >> shift
-						pos++
-						out[pos] = (g + y1) >> shift
-						pos++
-
-						pos += alpha01
-					}
-				}
-				if fourComponents {
-					pos = 3
+		coefficients[b] = items
+	}
 
-					for j = 0; j < len(y0items); j++ {
-						out[pos] = (y3items[j] + offset) >> shift
+	items := coefficients[0]
 
-						pos += 4
-					}
-				}
+	for r := 1; r < numResolutions; r++ {
+		items = reconstructResolution(component.resolutions[r-1], component.resolutions[r], items, coefficients[3*r-2:3*r+1], reversible)
+	}
+
+	return items, component.resolutions[numResolutions-1], nil
+}
+
+// decodeCoefficients decodes the coding passes of the code-block and writes the dequantized coefficients into the
+// items of the sub-band, which has the given width.
+func (codeblock *codeblock) decodeCoefficients(items []float64, subbandWidth int, mb int, delta float64, roiShift uint8, style *CodingStyle) error {
+	if codeblock.passes == 0 {
+		return nil
+	}
+
+	numBitPlanes := mb + int(roiShift) - int(codeblock.zeroBitPlanes)
+	if numBitPlanes <= 0 {
+		return nil
+	}
+	if numBitPlanes > 30 {
+		return UnsupportedError("code-block with more than 30 bit-planes")
+	}
+
+	subband := codeblock.subband
+	width := int(codeblock.tbx1 - codeblock.tbx0)
+	height := int(codeblock.tby1 - codeblock.tby0)
+
+	model := newBitModel(width, height, subband.subbandType, style)
+	model.decode(codeblock.segments, codeblock.passes, numBitPlanes, style)
+
+	reversible := style.ReversableFilter
+	offset := int(codeblock.tby0-subband.tby0)*subbandWidth + int(codeblock.tbx0-subband.tbx0)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			magnitude := model.magnitudes[y*width+x]
+			if magnitude == 0 {
+				continue
+			}
+
+			// Section H.1 with the Maxshift method, coefficients of the region of interest are scaled above all others
+			if roiShift > 0 && magnitude>>1 >= 1<<roiShift {
+				magnitude >>= roiShift
+			}
+
+			var value float64
+			if reversible {
+				value = float64(magnitude >> 1)
 			} else {
-				// no multi-component transform
-				for c = 0; c < componentsCount; c++ {
-					items := transformedTiles[c].items
-					shift = components[c].precision - 8
-					offset = (128 << shift) + 0.5
-					pos = c
-					for j = 0; j < len(items); j++ {
-						out[pos] = (items[j] + offset) >> shift
-						pos += componentsCount
-					}
-				}
+				value = float64(magnitude) / 2 * delta
+			}
+
+			if model.isNegative(x, y) {
+				value = -value
 			}
 
-			resultImages = append(resultImages, &result)
+			items[offset+y*subbandWidth+x] = value
 		}
-	*/
-	return resultImages
-}
+	}
 
-/*
-type baseTransform struct {
+	return nil
 }
 
-func (tform *baseTransform) iterate(ll *subbandCoefficients, hl_lh_hh *subbandCoefficients, u0, v0 uint32) *subbandCoefficients {
-	llWidth := ll.width
-	llHeight := ll.height
-	llItems := ll.items
+// bufferPadding is the number of samples each side of a signal needed by the longest (9/7) filter.
+const bufferPadding = 4
+
+// reconstructResolution performs 2D_SR (Section F.3.2), combining the LL sub-band of the lower resolution level with
+// the HL, LH and HH sub-bands to give the samples of the resolution level.
+func reconstructResolution(lower, res *resolution, ll []float64, highBands [][]float64, reversible bool) []float64 {
+	width := int(res.trx1 - res.trx0)
+	height := int(res.try1 - res.try0)
+	if res.trx1 < res.trx0 || res.try1 < res.try0 {
+		return nil
+	}
 
-	width := hl_lh_hh.width
-	height := hl_lh_hh.height
-	items := hl_lh_hh.items
-	var i, j, k, l, u, v int
+	items := make([]float64, width*height)
 
-	// Interleave LL according to Section F.3.3
-	for i = 0; i < llHeight; i++ {
-		l = i * 2 * width
+	type bandLayout struct {
+		items []float64
+		x0    int
+		y0    int
+		width int
+	}
 
-		for j = 0; j < llWidth; j++ {
-			items[l] = llItems[k]
+	// Indexed by the parity of the horizontal and vertical coordinates
+	var bands [2][2]bandLayout
+	bands[0][0] = bandLayout{ll, int(lower.trx0), int(lower.try0), int(lower.trx1 - lower.trx0)}
+	for i, subband := range res.subbands {
+		offsets := subbandOffsets[subband.subbandType]
+		bands[offsets[0]][offsets[1]] = bandLayout{highBands[i], int(subband.tbx0), int(subband.tby0), int(subband.tbx1 - subband.tbx0)}
+	}
 
-			k++
-			l += 2
+	u0 := int(res.trx0)
+	v0 := int(res.try0)
+
+	// Section F.3.3 2D_INTERLEAVE
+	for v := 0; v < height; v++ {
+		y := v0 + v
+		for u := 0; u < width; u++ {
+			x := u0 + u
+			band := &bands[x&1][y&1]
+
+			index := (y/2-band.y0)*band.width + x/2 - band.x0
+			if index >= 0 && index < len(band.items) {
+				items[v*width+u] = band.items[index]
+			}
 		}
 	}
-	// The LL band is not needed anymore.
-	llItems = nil
-	ll.items = nil
 
-	var bufferPadding = 4
-	rowBuffer := make([]float64, width+2*bufferPadding)
+	buffer := make([]float64, maxInt(width, height)+2*bufferPadding)
 
 	// Section F.3.4 HOR_SR
-	if width == 1 {
-		// if width = 1, when u0 even keep items as is, when odd divide by 2
-		if (u0 & 1) != 0 {
-			k = 0
-			for v = 0; v < height; v++ {
-				items[k] *= 0.5
-				k += width
-			}
+	for v := 0; v < height; v++ {
+		reconstruct1D(items[v*width:(v+1)*width], u0, reversible, buffer)
+	}
+
+	// Section F.3.5 VER_SR
+	column := make([]float64, height)
+	for u := 0; u < width; u++ {
+		for v := 0; v < height; v++ {
+			column[v] = items[v*width+u]
 		}
-	} else {
-		k = 0
-		for v = 0; v < height; v++ {
-			rowBuffer.set(items.subarray(k, k+width), bufferPadding)
 
-			this.extend(rowBuffer, bufferPadding, width)
-			this.filter(rowBuffer, bufferPadding, width)
+		reconstruct1D(column, v0, reversible, buffer)
+
+		for v := 0; v < height; v++ {
+			items[v*width+u] = column[v]
+		}
+	}
+
+	return items
+}
 
-			items.set(rowBuffer.subarray(bufferPadding, bufferPadding+width), k)
+func maxInt(x, y int) int {
+	if x > y {
+		return x
+	}
 
-			k += width
+	return y
+}
+
+// reflect gives the index of the sample that is used in place of index i by the periodic symmetric extension of a
+// signal of length n (Section F.3.7).
+func reflect(i, n int) int {
+	period := 2 * (n - 1)
+
+	i %= period
+	if i < 0 {
+		i += period
+	}
+	if i >= n {
+		i = period - i
+	}
+
+	return i
+}
+
+// Section F.3.8.2 lifting parameters of the 9-7 irreversible filter
+const (
+	alpha97 = -1.586134342059924
+	beta97  = -0.052980118572961
+	gamma97 = 0.882911075530934
+	delta97 = 0.443506852043971
+	k97     = 1.230174104914001
+)
+
+// reconstruct1D performs 1D_SR (Section F.3.6) in place on the interleaved samples x, the first of which has the
+// coordinate i0.
+func reconstruct1D(x []float64, i0 int, reversible bool, buffer []float64) {
+	n := len(x)
+
+	if n == 0 {
+		return
+	}
+
+	if n == 1 {
+		// A signal of one sample is unchanged by the filters, apart from the scaling of an odd sample
+		if i0&1 == 1 {
+			x[0] /= 2
 		}
+		return
 	}
 
-	// Accesses to the items array can take long, because it may not fit into
-	// CPU cache and has to be fetched from main memory. Since subsequent
-	// accesses to the items array are not local when reading columns, we
-	// have a cache miss every time. To reduce cache misses, get up to
-	// 'numBuffers' items at a time and store them into the individual
-	// buffers. The colBuffers should be small enough to fit into CPU cache.
-	numBuffers := 16
-	var colBuffers [][]float64
+	y := buffer[:n+2*bufferPadding]
+	copy(y[bufferPadding:], x)
 
-	for i = 0; i < numBuffers; i++ {
-		colBuffers = append(colBuffers, make([]float64, height+2*bufferPadding))
+	for k := 1; k <= bufferPadding; k++ {
+		y[bufferPadding-k] = x[reflect(-k, n)]
+		y[bufferPadding+n-1+k] = x[reflect(n-1+k, n)]
 	}
-	var b int
-	currentBuffer := 0
-	ll = bufferPadding + height
 
-	// Section F.3.5 VER_SR
-	if height == 1 {
-		// if height = 1, when v0 even keep items as is, when odd divide by 2
-		if (v0 & 1) != 0 {
-			for u = 0; u < width; u++ {
-				items[u] *= 0.5
-			}
+	// The coordinate of y[j] is i0 - bufferPadding + j, and bufferPadding is even, so the samples of even coordinates
+	// start at y[even]. Each lifting step is applied to every sample of the given parity that has two neighbours.
+	even := i0 & 1
+	odd := 1 - even
+
+	firstIndex := func(parity int) int {
+		if parity == 0 {
+			return 2
+		}
+		return parity
+	}
+
+	if reversible {
+		// Section F.3.8.1 the 5-3 reversible filter, Equations F-5 and F-6
+		for j := firstIndex(even); j+1 < len(y); j += 2 {
+			y[j] -= math.Floor((y[j-1] + y[j+1] + 2) / 4)
+		}
+		for j := firstIndex(odd); j+1 < len(y); j += 2 {
+			y[j] += math.Floor((y[j-1] + y[j+1]) / 2)
 		}
 	} else {
-		for u = 0; u < width; u++ {
-			// if we ran out of buffers, copy several image columns at once
-			if currentBuffer == 0 {
-				numBuffers = math.Min(width-u, numBuffers)
-
-				k = u
-				for l = bufferPadding; l < ll; l++ {
-					for b = 0; b < numBuffers; b++ {
-						colBuffers[b][l] = items[k+b]
-					}
-
-					k += width
-				}
+		// Section F.3.8.2 the 9-7 irreversible filter, Equation F-7
+		for j := even; j < len(y); j += 2 {
+			y[j] *= k97
+		}
+		for j := odd; j < len(y); j += 2 {
+			y[j] *= 1 / k97
+		}
 
-				currentBuffer = numBuffers
+		lift := func(parity int, factor float64) {
+			for j := firstIndex(parity); j+1 < len(y); j += 2 {
+				y[j] -= factor * (y[j-1] + y[j+1])
 			}
+		}
 
-			currentBuffer--
-			buffer := colBuffers[currentBuffer]
-			this.extend(buffer, bufferPadding, height)
-			this.filter(buffer, bufferPadding, height)
+		lift(even, delta97)
+		lift(odd, gamma97)
+		lift(even, beta97)
+		lift(odd, alpha97)
+	}
 
-			// If this is last buffer in this group of buffers, flush all buffers.
-			if currentBuffer == 0 {
-				k = u - numBuffers + 1
+	copy(x, y[bufferPadding:bufferPadding+n])
+}
+
+// inverseComponentTransform applies the inverse reversible (RCT) or irreversible (ICT) component transform of Annex G
+// to the first three components.
+func inverseComponentTransform(y0items, y1items, y2items []float64, reversible bool) {
+	for i := range y0items {
+		y0 := y0items[i]
+		y1 := y1items[i]
+		y2 := y2items[i]
+
+		if reversible {
+			// Equation G-7
+			g := y0 - math.Floor((y2+y1)/4)
+			y0items[i] = y2 + g
+			y1items[i] = g
+			y2items[i] = y1 + g
+		} else {
+			// Equation G-6
+			y0items[i] = y0 + 1.402*y2
+			y1items[i] = y0 - 0.34413*y1 - 0.71414*y2
+			y2items[i] = y0 + 1.772*y1
+		}
+	}
+}
 
-				for l = bufferPadding; l < ll; l++ {
-					for b = 0; b < numBuffers; b++ {
-						items[k+b] = colBuffers[b][l]
-					}
+// imageLayout returns the number of components, of up to four, that make up the image, and whether it needs 16 bits
+// per sample to hold them.
+func imageLayout(components []componentSize) (int, bool) {
+	numComponents := len(components)
+	if numComponents > 4 {
+		numComponents = 4
+	}
 
-					k += width
-				}
-			}
+	deep := false
+	for c := 0; c < numComponents; c++ {
+		if components[c].BitDepth > 8 {
+			deep = true
 		}
 	}
 
-	return subbandCoefficients{width: width, height: height, items: items}
+	return numComponents, deep
 }
 
-func extendTransform(tform transform, buffer []float64, offset, size int) {
-	// Section F.3.7 extending... using max extension of 4
-	i1 := offset - 1
-	j1 := offset + 1
-	i2 := offset + size - 2
-	j2 := offset + size
+// imageColorModel returns the colour model of the image that buildImage creates from the components.
+func imageColorModel(components []componentSize) color.Model {
+	numComponents, deep := imageLayout(components)
+
+	switch {
+	case numComponents == 1 && deep:
+		return color.Gray16Model
+	case numComponents == 1:
+		return color.GrayModel
+	case numComponents == 3 && deep:
+		return color.RGBA64Model
+	case numComponents == 3:
+		return color.RGBAModel
+	case deep:
+		return color.NRGBA64Model
+	}
+
+	return color.NRGBAModel
+}
 
-	buffer[i1] = buffer[j1]
-	i1--
-	j1++
+// buildImage converts the planes, whose sizes are given by components, into an image covering the window. One plane
+// gives a grey image, two grey and alpha, three RGB and four or more RGB and alpha. Components with more than 8 bits
+// give a 16 bit image, components are scaled to the depth of the image and sub-sampled components are replicated.
+func (header *Header) buildImage(planes []*componentPlane, components []componentSize, window *decodeWindow) image.Image {
+	bounds := window.bounds(header)
+	// The image offset on the reference grid scaled down by the reduction
+	originX := ceilDivPow2(header.Size.XOsiz, window.reduce)
+	originY := ceilDivPow2(header.Size.YOsiz, window.reduce)
 
-	buffer[j2] = buffer[i2]
-	j2++
-	i2--
+	numComponents, deep := imageLayout(components)
+
+	maximum := uint32(0xff)
+	if deep {
+		maximum = 0xffff
+	}
 
-	buffer[i1] = buffer[j1]
-	i1--
-	j1++
+	// sample returns component c at pixel (x, y) of the image, scaled to the range 0 to maximum
+	sample := func(c, x, y int) uint32 {
+		size := components[c]
+		plane := planes[c]
+
+		cx := (uint32(x)+originX)/uint32(size.XRsiz) - plane.x0
+		cy := (uint32(y)+originY)/uint32(size.YRsiz) - plane.y0
+		if int32(cx) < 0 {
+			cx = 0
+		} else if cx >= plane.width {
+			cx = plane.width - 1
+		}
+		if int32(cy) < 0 {
+			cy = 0
+		} else if cy >= plane.height {
+			cy = plane.height - 1
+		}
 
-	buffer[j2] = buffer[i2]
-	j2++
-	i2--
+		value := int64(plane.samples[cy*plane.width+cx])
+		if size.Signed {
+			value += 1 << (size.BitDepth - 1)
+		}
 
-	buffer[i1] = buffer[j1]
-	i1--
-	j1++
+		componentMaximum := int64(1)<<size.BitDepth - 1
 
-	buffer[j2] = buffer[i2]
-	j2++
-	i2--
+		return uint32((value*int64(maximum) + componentMaximum/2) / componentMaximum)
+	}
 
-	buffer[i1] = buffer[j1]
-	buffer[j2] = buffer[i2]
-}
+	switch numComponents {
+	case 1:
+		if deep {
+			img := image.NewGray16(bounds)
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					img.SetGray16(x, y, color.Gray16{Y: uint16(sample(0, x, y))})
+				}
+			}
+			return img
+		}
+
+		img := image.NewGray(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				img.SetGray(x, y, color.Gray{Y: uint8(sample(0, x, y))})
+			}
+		}
+		return img
+	case 3:
+		if deep {
+			img := image.NewRGBA64(bounds)
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					img.SetRGBA64(x, y, color.RGBA64{R: uint16(sample(0, x, y)), G: uint16(sample(1, x, y)), B: uint16(sample(2, x, y)), A: 0xffff})
+				}
+			}
+			return img
+		}
+
+		img := image.NewRGBA(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				img.SetRGBA(x, y, color.RGBA{R: uint8(sample(0, x, y)), G: uint8(sample(1, x, y)), B: uint8(sample(2, x, y)), A: 0xff})
+			}
+		}
+		return img
+	}
+
+	// Two components are grey and alpha, four are RGB and alpha
+	channels := func(x, y int) (uint32, uint32, uint32, uint32) {
+		if numComponents == 2 {
+			grey := sample(0, x, y)
+			return grey, grey, grey, sample(1, x, y)
+		}
 
-func calculateTransform(tform transform, subbands []*subbandCoefficients, u0, v0 uint32) *subbandCoefficients {
-	ll := subbands[0]
+		return sample(0, x, y), sample(1, x, y), sample(2, x, y), sample(3, x, y)
+	}
 
-	for i := 1; i < len(subbands); i++ {
-		ll = iterateTransform(tform, ll, subbands[i], u0, v0)
+	if deep {
+		img := image.NewNRGBA64(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r, g, b, a := channels(x, y)
+				img.SetNRGBA64(x, y, color.NRGBA64{R: uint16(r), G: uint16(g), B: uint16(b), A: uint16(a)})
+			}
+		}
+		return img
 	}
 
-	return ll
+	img := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := channels(x, y)
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: uint8(a)})
+		}
+	}
+	return img
 }
-*/
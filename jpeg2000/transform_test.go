@@ -0,0 +1,133 @@
+package jpeg2000
+
+import (
+	"math"
+	"testing"
+)
+
+// analyse1D is the forward transform 1D_SA (Section F.4.8) of the samples x, the first of which has the coordinate i0,
+// so that reconstruct1D can be checked against it.
+func analyse1D(x []float64, i0 int, reversible bool) []float64 {
+	n := len(x)
+	y := make([]float64, n)
+	copy(y, x)
+
+	if n == 1 {
+		if i0&1 == 1 {
+			y[0] *= 2
+		}
+		return y
+	}
+
+	// The samples at odd coordinates are updated from their neighbours at even coordinates and the other way round
+	at := func(j int) float64 {
+		return y[reflect(j, n)]
+	}
+	lift := func(parity int, update func(left, right float64) float64) {
+		values := make([]float64, n)
+		copy(values, y)
+		for j := 0; j < n; j++ {
+			if (i0+j)&1 == parity {
+				values[j] += update(at(j-1), at(j+1))
+			}
+		}
+		copy(y, values)
+	}
+
+	if reversible {
+		lift(1, func(left, right float64) float64 { return -math.Floor((left + right) / 2) })
+		lift(0, func(left, right float64) float64 { return math.Floor((left + right + 2) / 4) })
+		return y
+	}
+
+	for _, step := range []struct {
+		parity int
+		factor float64
+	}{{1, alpha97}, {0, beta97}, {1, gamma97}, {0, delta97}} {
+		factor := step.factor
+		lift(step.parity, func(left, right float64) float64 { return factor * (left + right) })
+	}
+	for j := range y {
+		if (i0+j)&1 == 0 {
+			y[j] /= k97
+		} else {
+			y[j] *= k97
+		}
+	}
+
+	return y
+}
+
+func TestReconstruct1D(t *testing.T) {
+	buffer := make([]float64, 32+2*bufferPadding)
+
+	for _, reversible := range []bool{true, false} {
+		for n := 1; n <= 12; n++ {
+			for i0 := 0; i0 < 2; i0++ {
+				signal := make([]float64, n)
+				for i := range signal {
+					signal[i] = float64((i*37 + i0*11) % 23)
+				}
+
+				x := analyse1D(signal, i0, reversible)
+				reconstruct1D(x, i0, reversible, buffer)
+
+				for i := range signal {
+					difference := math.Abs(x[i] - signal[i])
+					if (reversible && difference != 0) || difference > 1e-9 {
+						t.Errorf("reversible %v, length %d, offset %d, sample %d: expected %g, found %g", reversible, n,
+							i0, i, signal[i], x[i])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestReflect(t *testing.T) {
+	// Periodic symmetric extension of ABCDE gives ...EDCB|ABCDE|DCBA...
+	expected := map[int]int{-4: 4, -3: 3, -2: 2, -1: 1, 0: 0, 4: 4, 5: 3, 6: 2, 7: 1, 8: 0, 9: 1}
+
+	for i, index := range expected {
+		if found := reflect(i, 5); found != index {
+			t.Errorf("index %d: expected %d, found %d", i, index, found)
+		}
+	}
+}
+
+func TestInverseComponentTransform(t *testing.T) {
+	red := []float64{0, 255, 12, 200, 90}
+	green := []float64{0, 255, 250, 13, 90}
+	blue := []float64{0, 255, 3, 140, 10}
+
+	// Equation G-2 the forward reversible component transform
+	y0 := make([]float64, len(red))
+	y1 := make([]float64, len(red))
+	y2 := make([]float64, len(red))
+	for i := range red {
+		y0[i] = math.Floor((red[i] + 2*green[i] + blue[i]) / 4)
+		y1[i] = blue[i] - green[i]
+		y2[i] = red[i] - green[i]
+	}
+
+	inverseComponentTransform(y0, y1, y2, true)
+	for i := range red {
+		if y0[i] != red[i] || y1[i] != green[i] || y2[i] != blue[i] {
+			t.Errorf("sample %d: expected (%g, %g, %g), found (%g, %g, %g)", i, red[i], green[i], blue[i], y0[i], y1[i], y2[i])
+		}
+	}
+
+	// Equation G-5 the forward irreversible component transform
+	for i := range red {
+		y0[i] = 0.299*red[i] + 0.587*green[i] + 0.114*blue[i]
+		y1[i] = -0.16875*red[i] - 0.33126*green[i] + 0.5*blue[i]
+		y2[i] = 0.5*red[i] - 0.41869*green[i] - 0.08131*blue[i]
+	}
+
+	inverseComponentTransform(y0, y1, y2, false)
+	for i := range red {
+		if math.Abs(y0[i]-red[i]) > 0.01 || math.Abs(y1[i]-green[i]) > 0.01 || math.Abs(y2[i]-blue[i]) > 0.01 {
+			t.Errorf("sample %d: expected (%g, %g, %g), found (%g, %g, %g)", i, red[i], green[i], blue[i], y0[i], y1[i], y2[i])
+		}
+	}
+}
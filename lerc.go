@@ -0,0 +1,964 @@
+package gobio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// Additional compression applied to each LERC blob, recorded in the second value of LercParameters.
+const (
+	lercAdditionalNone    = 0
+	lercAdditionalDeflate = 1
+	lercAdditionalZstd    = 2
+)
+
+// LERCCompression decodes sections compressed with Esri's Limited Error Raster Compression (compression 34887), as
+// written by GDAL. Each section holds a Lerc2 blob, optionally compressed again with Deflate or Zstandard. Versions 2
+// to 6 are supported, except for the lossless floating point coding added in version 6. Pixels which are marked as
+// invalid in the blob are returned as 0.
+type LERCCompression struct {
+	additionalCompression uint32
+
+	// byteOrder is the byte order of the TIFF file, used for the decompressed samples
+	byteOrder binary.ByteOrder
+}
+
+func newLERCCompression(dataAccess *baseDataAccess) (*LERCCompression, error) {
+	compression := &LERCCompression{byteOrder: dataAccess.tiffFile.header.Endian}
+
+	if parameters, ok := dataAccess.ifd.GetLongTag(LercParameters); ok && len(parameters.Data) >= 2 {
+		compression.additionalCompression = parameters.Data[1]
+	}
+
+	if compression.additionalCompression > lercAdditionalZstd {
+		return nil, &FormatError{msg: fmt.Sprintf("Unsupported LERC additional compression %d", compression.additionalCompression)}
+	}
+
+	return compression, nil
+}
+
+// Decompress decodes the LERC blob in r, returning the samples in the byte order of the file.
+func (compression *LERCCompression) Decompress(r io.Reader) ([]byte, error) {
+	var data []byte
+	var err error
+
+	switch compression.additionalCompression {
+	case lercAdditionalDeflate:
+		data, err = (&DeflateCompression{}).Decompress(r)
+	case lercAdditionalZstd:
+		data, err = (&ZstdCompression{}).Decompress(r)
+	default:
+		data, err = ioutil.ReadAll(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := decodeLERC2(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return blob.bytes(compression.byteOrder), nil
+}
+
+// lercDataType is the type of the values stored in a LERC blob.
+type lercDataType int32
+
+const (
+	lercChar lercDataType = iota
+	lercByte
+	lercShort
+	lercUShort
+	lercInt
+	lercUInt
+	lercFloat
+	lercDouble
+)
+
+var lercDataTypeSizes = [...]int{1, 1, 2, 2, 4, 4, 4, 8}
+
+// Image encoding modes, only used for 8-bit data.
+const (
+	lercTiles        = 0
+	lercDeltaHuffman = 1
+	lercHuffman      = 2
+)
+
+// lercBlob is a decoded Lerc2 blob.
+type lercBlob struct {
+	version        int32
+	rows           int
+	columns        int
+	dimensions     int
+	numValidPixels int
+	microBlockSize int
+	dataType       lercDataType
+	maxZError      float64
+	zMin           float64
+	zMax           float64
+
+	// zMaxes is the maximum of each dimension (version 4 onwards)
+	zMaxes []float64
+
+	// When passNoData is set, noData is used in place of noDataOriginal for invalid values of individual dimensions
+	// (version 6 onwards)
+	passNoData     bool
+	noData         float64
+	noDataOriginal float64
+
+	// valid records whether each pixel holds data, or is nil when all pixels are valid
+	valid []bool
+
+	// values holds dimensions values for each pixel
+	values []float64
+}
+
+func (blob *lercBlob) isValid(pixel int) bool {
+	return blob.valid == nil || blob.valid[pixel]
+}
+
+// lercReader reads the little endian values of a LERC blob.
+type lercReader struct {
+	data []byte
+	pos  int
+}
+
+func (reader *lercReader) read(n int) ([]byte, error) {
+	if n < 0 || reader.pos+n > len(reader.data) {
+		return nil, &FormatError{msg: fmt.Sprintf("LERC blob is truncated, %d bytes needed at offset %d", n, reader.pos)}
+	}
+
+	data := reader.data[reader.pos : reader.pos+n]
+	reader.pos += n
+
+	return data, nil
+}
+
+func (reader *lercReader) readByte() (byte, error) {
+	data, err := reader.read(1)
+	if err != nil {
+		return 0, err
+	}
+
+	return data[0], nil
+}
+
+func (reader *lercReader) readInt32() (int, error) {
+	data, err := reader.read(4)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(int32(binary.LittleEndian.Uint32(data))), nil
+}
+
+func (reader *lercReader) readFloat64() (float64, error) {
+	data, err := reader.read(8)
+	if err != nil {
+		return 0, err
+	}
+
+	return math.Float64frombits(binary.LittleEndian.Uint64(data)), nil
+}
+
+// readValue reads a single value of type dataType.
+func (reader *lercReader) readValue(dataType lercDataType) (float64, error) {
+	data, err := reader.read(lercDataTypeSizes[dataType])
+	if err != nil {
+		return 0, err
+	}
+
+	switch dataType {
+	case lercChar:
+		return float64(int8(data[0])), nil
+	case lercByte:
+		return float64(data[0]), nil
+	case lercShort:
+		return float64(int16(binary.LittleEndian.Uint16(data))), nil
+	case lercUShort:
+		return float64(binary.LittleEndian.Uint16(data)), nil
+	case lercInt:
+		return float64(int32(binary.LittleEndian.Uint32(data))), nil
+	case lercUInt:
+		return float64(binary.LittleEndian.Uint32(data)), nil
+	case lercFloat:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(data))), nil
+	default:
+		return math.Float64frombits(binary.LittleEndian.Uint64(data)), nil
+	}
+}
+
+// decodeLERC2 decodes a Lerc2 blob, as described in https://github.com/Esri/lerc.
+func decodeLERC2(data []byte) (*lercBlob, error) {
+	reader := &lercReader{data: data}
+
+	blob, err := reader.readHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	blob.values = make([]float64, blob.rows*blob.columns*blob.dimensions)
+
+	if err := reader.readMask(blob); err != nil {
+		return nil, err
+	}
+
+	if err := reader.readValues(blob); err != nil {
+		return nil, err
+	}
+
+	if blob.passNoData && blob.noData != blob.noDataOriginal {
+		for index, value := range blob.values {
+			if value == blob.noData && blob.isValid(index/blob.dimensions) {
+				blob.values[index] = blob.noDataOriginal
+			}
+		}
+	}
+
+	return blob, nil
+}
+
+// readValues reads the values of the valid pixels, which are coded in one of several ways depending on the data.
+func (reader *lercReader) readValues(blob *lercBlob) error {
+	var err error
+
+	if blob.numValidPixels == 0 {
+		return nil
+	}
+
+	if blob.zMin == blob.zMax {
+		blob.fill(func(dimension int) float64 { return blob.zMin })
+		return nil
+	}
+
+	if blob.version >= 4 {
+		zMins := make([]float64, blob.dimensions)
+		blob.zMaxes = make([]float64, blob.dimensions)
+
+		for _, values := range [][]float64{zMins, blob.zMaxes} {
+			for dimension := range values {
+				if values[dimension], err = reader.readValue(blob.dataType); err != nil {
+					return err
+				}
+			}
+		}
+
+		constant := true
+		for dimension := range zMins {
+			constant = constant && zMins[dimension] == blob.zMaxes[dimension]
+		}
+
+		if constant {
+			blob.fill(func(dimension int) float64 { return zMins[dimension] })
+			return nil
+		}
+	}
+
+	oneSweep, err := reader.readByte()
+	if err != nil {
+		return err
+	}
+
+	if oneSweep != 0 {
+		return reader.readOneSweep(blob)
+	}
+
+	// From version 6, lossless floating point data can be split into byte planes which are predicted and Huffman
+	// coded, which isn't supported
+	if blob.version >= 6 && (blob.dataType == lercFloat || blob.dataType == lercDouble) && blob.maxZError == 0 {
+		mode, err := reader.readByte()
+		if err != nil {
+			return err
+		}
+
+		if mode != lercTiles {
+			return &FormatError{msg: fmt.Sprintf("Unsupported LERC image encoding mode %d for lossless floating point data", mode)}
+		}
+	}
+
+	// Huffman coding is only used for lossless 8-bit data
+	if blob.version > 1 && (blob.dataType == lercChar || blob.dataType == lercByte) && blob.maxZError == 0.5 {
+		mode, err := reader.readByte()
+		if err != nil {
+			return err
+		}
+
+		switch mode {
+		case lercTiles:
+		case lercDeltaHuffman, lercHuffman:
+			return reader.readHuffman(blob, mode == lercDeltaHuffman)
+		default:
+			return &FormatError{msg: fmt.Sprintf("Unsupported LERC image encoding mode %d", mode)}
+		}
+	}
+
+	return reader.readTiles(blob)
+}
+
+func (reader *lercReader) readHeader() (*lercBlob, error) {
+	keyword, err := reader.read(6)
+	if err != nil {
+		return nil, err
+	}
+	if string(keyword) != "Lerc2 " {
+		return nil, &FormatError{msg: "Data is not a Lerc2 blob"}
+	}
+
+	version, err := reader.readInt32()
+	if err != nil {
+		return nil, err
+	}
+	if version < 2 || version > 6 {
+		return nil, &FormatError{msg: fmt.Sprintf("Unsupported Lerc2 version %d", version)}
+	}
+
+	blob := &lercBlob{version: int32(version), dimensions: 1}
+
+	// The checksum covers the rest of the blob, and isn't checked
+	if version >= 3 {
+		if _, err := reader.read(4); err != nil {
+			return nil, err
+		}
+	}
+
+	numInts := 6
+	if version >= 6 {
+		// nBlobsMore is added, for multiple blobs in sequence
+		numInts = 8
+	} else if version >= 4 {
+		numInts = 7
+	}
+
+	ints := make([]int, numInts)
+	for index := range ints {
+		if ints[index], err = reader.readInt32(); err != nil {
+			return nil, err
+		}
+	}
+
+	blob.rows, blob.columns = ints[0], ints[1]
+	if version >= 4 {
+		blob.dimensions = ints[2]
+		ints = ints[1:]
+	}
+	blob.numValidPixels, blob.microBlockSize, blob.dataType = ints[2], ints[3], lercDataType(ints[5])
+
+	doubles := []*float64{&blob.maxZError, &blob.zMin, &blob.zMax}
+
+	if version >= 6 {
+		flags, err := reader.read(4)
+		if err != nil {
+			return nil, err
+		}
+
+		blob.passNoData = flags[0] != 0
+		doubles = append(doubles, &blob.noData, &blob.noDataOriginal)
+	}
+
+	for _, value := range doubles {
+		if *value, err = reader.readFloat64(); err != nil {
+			return nil, err
+		}
+	}
+
+	if blob.rows <= 0 || blob.columns <= 0 || blob.dimensions <= 0 || blob.microBlockSize <= 0 ||
+		blob.numValidPixels < 0 || blob.numValidPixels > blob.rows*blob.columns {
+		return nil, &FormatError{msg: fmt.Sprintf("Invalid Lerc2 header: %d x %d x %d, %d valid pixels, micro block size %d",
+			blob.rows, blob.columns, blob.dimensions, blob.numValidPixels, blob.microBlockSize)}
+	}
+	if blob.dataType < lercChar || blob.dataType > lercDouble {
+		return nil, &FormatError{msg: fmt.Sprintf("Invalid Lerc2 data type %d", blob.dataType)}
+	}
+
+	return blob, nil
+}
+
+// readMask reads the run length encoded bit mask of valid pixels.
+func (reader *lercReader) readMask(blob *lercBlob) error {
+	numBytes, err := reader.readInt32()
+	if err != nil {
+		return err
+	}
+
+	numPixels := blob.rows * blob.columns
+	if blob.numValidPixels == 0 || blob.numValidPixels == numPixels {
+		if numBytes != 0 {
+			return &FormatError{msg: "Unexpected LERC mask"}
+		}
+
+		if blob.numValidPixels == 0 {
+			blob.valid = make([]bool, numPixels)
+		}
+
+		return nil
+	}
+
+	if numBytes <= 0 {
+		return &FormatError{msg: "LERC mask is missing"}
+	}
+
+	compressed, err := reader.read(numBytes)
+	if err != nil {
+		return err
+	}
+
+	mask, err := lercDecodeRLE(compressed, (numPixels+7)/8)
+	if err != nil {
+		return err
+	}
+
+	blob.valid = make([]bool, numPixels)
+	for pixel := range blob.valid {
+		blob.valid[pixel] = mask[pixel>>3]&(0x80>>uint(pixel&7)) != 0
+	}
+
+	return nil
+}
+
+// lercDecodeRLE decodes the run length encoding of the mask, which is a sequence of 16-bit counts each followed
+// by count literal bytes (count > 0) or a single byte repeated -count times, ending with a count of -32768.
+func lercDecodeRLE(data []byte, size int) ([]byte, error) {
+	reader := &lercReader{data: data}
+	decoded := make([]byte, 0, size)
+
+	for {
+		countData, err := reader.read(2)
+		if err != nil {
+			return nil, err
+		}
+
+		count := int(int16(binary.LittleEndian.Uint16(countData)))
+		if count == -32768 {
+			break
+		}
+
+		if count > 0 {
+			literal, err := reader.read(count)
+			if err != nil {
+				return nil, err
+			}
+
+			decoded = append(decoded, literal...)
+		} else {
+			value, err := reader.readByte()
+			if err != nil {
+				return nil, err
+			}
+
+			for ; count < 0; count++ {
+				decoded = append(decoded, value)
+			}
+		}
+
+		if len(decoded) > size {
+			return nil, &FormatError{msg: "LERC mask is larger than the image"}
+		}
+	}
+
+	if len(decoded) != size {
+		return nil, &FormatError{msg: fmt.Sprintf("LERC mask contains %d bytes, expected %d", len(decoded), size)}
+	}
+
+	return decoded, nil
+}
+
+// fill sets every valid pixel to a constant value for each dimension.
+func (blob *lercBlob) fill(value func(dimension int) float64) {
+	for pixel := 0; pixel < blob.rows*blob.columns; pixel++ {
+		if blob.isValid(pixel) {
+			for dimension := 0; dimension < blob.dimensions; dimension++ {
+				blob.values[pixel*blob.dimensions+dimension] = value(dimension)
+			}
+		}
+	}
+}
+
+// readOneSweep reads the values of all valid pixels, stored without compression.
+func (reader *lercReader) readOneSweep(blob *lercBlob) error {
+	for pixel := 0; pixel < blob.rows*blob.columns; pixel++ {
+		if !blob.isValid(pixel) {
+			continue
+		}
+
+		for dimension := 0; dimension < blob.dimensions; dimension++ {
+			value, err := reader.readValue(blob.dataType)
+			if err != nil {
+				return err
+			}
+
+			blob.values[pixel*blob.dimensions+dimension] = value
+		}
+	}
+
+	return nil
+}
+
+// readTiles reads the values of each micro block in turn. Each dimension of a block is stored separately.
+func (reader *lercReader) readTiles(blob *lercBlob) error {
+	for row := 0; row < blob.rows; row += blob.microBlockSize {
+		for column := 0; column < blob.columns; column += blob.microBlockSize {
+			endRow, endColumn := row+blob.microBlockSize, column+blob.microBlockSize
+			if endRow > blob.rows {
+				endRow = blob.rows
+			}
+			if endColumn > blob.columns {
+				endColumn = blob.columns
+			}
+
+			for dimension := 0; dimension < blob.dimensions; dimension++ {
+				if err := reader.readTile(blob, row, endRow, column, endColumn, dimension); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// tileValues calls set for each valid pixel of the tile, in order, with the index of the value for dimension.
+func (blob *lercBlob) tileValues(row, endRow, column, endColumn, dimension int, set func(index int) error) error {
+	for y := row; y < endRow; y++ {
+		for x := column; x < endColumn; x++ {
+			pixel := y*blob.columns + x
+
+			if blob.isValid(pixel) {
+				if err := set(pixel*blob.dimensions + dimension); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (reader *lercReader) readTile(blob *lercBlob, row, endRow, column, endColumn, dimension int) error {
+	flag, err := reader.readByte()
+	if err != nil {
+		return err
+	}
+
+	// Part of the column is stored as an integrity check, in bits 2-5 before version 5 and bits 3-5 (with one less bit
+	// of the column) afterwards. From version 5, bit 2 records that the values are differences from the previous
+	// dimension.
+	difference := false
+	if blob.version >= 5 {
+		difference = flag&4 != 0
+
+		if int(flag>>3&7) != (column>>4)&7 || (difference && dimension == 0) {
+			return &FormatError{msg: fmt.Sprintf("LERC micro block at (%d, %d) is corrupt", column, row)}
+		}
+	} else if int(flag>>2&15) != (column>>3)&15 {
+		return &FormatError{msg: fmt.Sprintf("LERC micro block at (%d, %d) is corrupt", column, row)}
+	}
+
+	// base returns the value that the decoded value is relative to
+	base := func(index int) float64 {
+		if difference {
+			return blob.values[index-1]
+		}
+
+		return 0
+	}
+
+	switch flag & 3 {
+	case 0:
+		// Stored without compression
+		if difference {
+			return &FormatError{msg: fmt.Sprintf("LERC micro block at (%d, %d) is corrupt", column, row)}
+		}
+
+		return blob.tileValues(row, endRow, column, endColumn, dimension, func(index int) error {
+			blob.values[index], err = reader.readValue(blob.dataType)
+			return err
+		})
+	case 2:
+		// All zero
+		return blob.tileValues(row, endRow, column, endColumn, dimension, func(index int) error {
+			blob.values[index] = base(index)
+			return nil
+		})
+	}
+
+	// The offset can be stored in a smaller type than the data, recorded in bits 6 and 7. Differences of types
+	// smaller than 32 bits are stored as 32-bit integers, as they can be negative.
+	dataType := blob.dataType
+	if difference && dataType < lercInt {
+		dataType = lercInt
+	}
+
+	offsetType := lercOffsetType(dataType, int(flag>>6))
+	if offsetType < lercChar || offsetType > lercDouble {
+		return &FormatError{msg: fmt.Sprintf("Invalid LERC offset type %d for data type %d", flag>>6, blob.dataType)}
+	}
+
+	offset, err := reader.readValue(offsetType)
+	if err != nil {
+		return err
+	}
+
+	if flag&3 == 3 {
+		// Constant
+		return blob.tileValues(row, endRow, column, endColumn, dimension, func(index int) error {
+			blob.values[index] = blob.convert(base(index) + offset)
+			return nil
+		})
+	}
+
+	quantised, err := reader.readBitStuffed((endRow-row)*(endColumn-column), blob.version)
+	if err != nil {
+		return err
+	}
+
+	zMax := blob.zMax
+	if blob.zMaxes != nil && blob.dimensions > 1 {
+		zMax = blob.zMaxes[dimension]
+	}
+
+	scale := 2 * blob.maxZError
+	next := 0
+
+	return blob.tileValues(row, endRow, column, endColumn, dimension, func(index int) error {
+		if next >= len(quantised) {
+			return &FormatError{msg: fmt.Sprintf("LERC micro block at (%d, %d) has too few values", column, row)}
+		}
+
+		blob.values[index] = blob.convert(math.Min(base(index)+offset+float64(quantised[next])*scale, zMax))
+		next++
+
+		return nil
+	})
+}
+
+// lercOffsetType returns the type used to store the offset of a micro block of dataType.
+func lercOffsetType(dataType lercDataType, code int) lercDataType {
+	switch dataType {
+	case lercShort, lercInt:
+		return dataType - lercDataType(code)
+	case lercUShort, lercUInt:
+		return dataType - lercDataType(2*code)
+	case lercFloat:
+		return [...]lercDataType{lercFloat, lercShort, lercByte, -1}[code]
+	case lercDouble:
+		if code == 0 {
+			return lercDouble
+		}
+
+		return lercDataType(int(lercDouble) - 2*code + 1)
+	default:
+		return dataType
+	}
+}
+
+// convert rounds a dequantised value as the value would be when stored as the data type of the blob.
+func (blob *lercBlob) convert(value float64) float64 {
+	switch blob.dataType {
+	case lercFloat:
+		return float64(float32(value))
+	case lercDouble:
+		return value
+	default:
+		return math.Trunc(value)
+	}
+}
+
+// readBitStuffed reads up to maxElements unsigned integers packed with a fixed number of bits, optionally as indices
+// into a lookup table.
+func (reader *lercReader) readBitStuffed(maxElements int, version int32) ([]uint32, error) {
+	header, err := reader.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	// Bits 6 and 7 record the size of the element count
+	countSize := 4
+	if header>>6 != 0 {
+		countSize = 3 - int(header>>6)
+	}
+	useLookup := header&(1<<5) != 0
+	numBits := int(header & 31)
+
+	countData, err := reader.read(countSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int
+	for index := countSize - 1; index >= 0; index-- {
+		count = count<<8 | int(countData[index])
+	}
+
+	if count > maxElements {
+		return nil, &FormatError{msg: fmt.Sprintf("LERC bit stuffed data contains %d elements, at most %d expected", count, maxElements)}
+	}
+
+	if !useLookup {
+		return reader.unstuff(count, numBits, version)
+	}
+
+	numLookup, err := reader.readByte()
+	if err != nil {
+		return nil, err
+	}
+	if numLookup == 0 {
+		return nil, &FormatError{msg: "Invalid LERC lookup table size"}
+	}
+
+	// The lookup table excludes the first entry, which is always 0
+	lookup, err := reader.unstuff(int(numLookup)-1, numBits, version)
+	if err != nil {
+		return nil, err
+	}
+	lookup = append([]uint32{0}, lookup...)
+
+	indexBits := 0
+	for (int(numLookup)-1)>>uint(indexBits) != 0 {
+		indexBits++
+	}
+
+	values, err := reader.unstuff(count, indexBits, version)
+	if err != nil {
+		return nil, err
+	}
+
+	for index, value := range values {
+		if int(value) >= len(lookup) {
+			return nil, &FormatError{msg: "Invalid LERC lookup table index"}
+		}
+
+		values[index] = lookup[value]
+	}
+
+	return values, nil
+}
+
+// unstuff reads count values of numBits bits. From version 3 the values are packed from the least significant bit of
+// each byte. Version 2 packs them from the most significant bit of little endian 32-bit words, with the bytes of the
+// final partial word shifted to the top of the word.
+func (reader *lercReader) unstuff(count, numBits int, version int32) ([]uint32, error) {
+	values := make([]uint32, count)
+	if count == 0 || numBits == 0 {
+		return values, nil
+	}
+	if numBits > 32 {
+		return nil, &FormatError{msg: fmt.Sprintf("Invalid LERC bit stuffing with %d bits", numBits)}
+	}
+
+	data, err := reader.read((count*numBits + 7) / 8)
+	if err != nil {
+		return nil, err
+	}
+
+	if version >= 3 {
+		for index := range values {
+			for bit := 0; bit < numBits; bit++ {
+				position := index*numBits + bit
+				values[index] |= uint32(data[position>>3]>>uint(position&7)&1) << uint(bit)
+			}
+		}
+
+		return values, nil
+	}
+
+	words := make([]uint32, (len(data)+3)/4)
+	for index := range words {
+		var word [4]byte
+		numBytes := copy(word[:], data[index*4:])
+
+		words[index] = binary.LittleEndian.Uint32(word[:]) << uint(8*(4-numBytes))
+	}
+
+	for index := range values {
+		for bit := 0; bit < numBits; bit++ {
+			position := index*numBits + bit
+			values[index] = values[index]<<1 | words[position>>5]>>uint(31-position&31)&1
+		}
+	}
+
+	return values, nil
+}
+
+// readHuffman reads 8-bit values coded with a Huffman code table, optionally as differences from the previous value
+// in the row (or the value above at the start of a row).
+func (reader *lercReader) readHuffman(blob *lercBlob, delta bool) error {
+	codes, maxLength, err := reader.readHuffmanCodes(blob.version)
+	if err != nil {
+		return err
+	}
+
+	// Values are read from the most significant bit of little endian 32-bit words
+	data := reader.data[reader.pos:]
+	bitPos := 0
+	bit := func(position int) uint64 {
+		byteIndex := position>>5<<2 + 3 - (position&31)>>3
+		if byteIndex >= len(data) {
+			return 0
+		}
+
+		return uint64(data[byteIndex]>>uint(7-position&7)) & 1
+	}
+
+	decode := func() (int, error) {
+		var code uint64
+		for length := 1; length <= maxLength; length++ {
+			code = code<<1 | bit(bitPos+length-1)
+
+			if value, ok := codes[uint64(length)<<32|code]; ok {
+				bitPos += length
+				return value, nil
+			}
+		}
+
+		return 0, &FormatError{msg: "Invalid LERC Huffman code"}
+	}
+
+	// Char data is offset so that the codes are all positive
+	offset := 0
+	if blob.dataType == lercChar {
+		offset = 128
+	}
+
+	for dimension := 0; dimension < blob.dimensions; dimension++ {
+		var previous int
+
+		for pixel := 0; pixel < blob.rows*blob.columns; pixel++ {
+			if !blob.isValid(pixel) {
+				continue
+			}
+
+			code, err := decode()
+			if err != nil {
+				return err
+			}
+
+			value := code - offset
+			if delta {
+				x, y := pixel%blob.columns, pixel/blob.columns
+
+				if x > 0 && blob.isValid(pixel-1) {
+					value += previous
+				} else if y > 0 && blob.isValid(pixel-blob.columns) {
+					value += int(blob.values[(pixel-blob.columns)*blob.dimensions+dimension])
+				} else {
+					value += previous
+				}
+			}
+
+			// Wrap around as the 8-bit data type would
+			if blob.dataType == lercChar {
+				value = int(int8(value))
+			} else {
+				value = int(uint8(value))
+			}
+
+			blob.values[pixel*blob.dimensions+dimension] = float64(value)
+			previous = value
+		}
+	}
+
+	return nil
+}
+
+// readHuffmanCodes reads the Huffman code table, returning a map from the length and code (length<<32 | code) to the
+// value.
+func (reader *lercReader) readHuffmanCodes(version int32) (map[uint64]int, int, error) {
+	var header [4]int
+	for index := range header {
+		var err error
+		if header[index], err = reader.readInt32(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	size, first, last := header[1], header[2], header[3]
+	if header[0] < 2 || size <= 0 || size > 1<<16 || first < 0 || first >= last || last > 2*size {
+		return nil, 0, &FormatError{msg: fmt.Sprintf("Invalid LERC Huffman code table %v", header)}
+	}
+
+	lengths, err := reader.readBitStuffed(last-first, version)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(lengths) != last-first {
+		return nil, 0, &FormatError{msg: "LERC Huffman code table is incomplete"}
+	}
+
+	// The codes are packed from the most significant bit of little endian 32-bit words
+	totalBits := 0
+	for _, length := range lengths {
+		if length > 32 {
+			return nil, 0, &FormatError{msg: fmt.Sprintf("Invalid LERC Huffman code length %d", length)}
+		}
+		totalBits += int(length)
+	}
+
+	data, err := reader.read((totalBits + 31) / 32 * 4)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	codes := make(map[uint64]int)
+	maxLength := 0
+	position := 0
+	for index, length := range lengths {
+		if length == 0 {
+			continue
+		}
+
+		var code uint64
+		for bit := 0; bit < int(length); bit++ {
+			byteIndex := position>>5<<2 + 3 - (position&31)>>3
+			code = code<<1 | uint64(data[byteIndex]>>uint(7-position&7))&1
+			position++
+		}
+
+		// Indices wrap around the histogram, so that runs of values either side of 0 are contiguous
+		value := first + index
+		if value >= size {
+			value -= size
+		}
+
+		codes[uint64(length)<<32|code] = value
+		if int(length) > maxLength {
+			maxLength = int(length)
+		}
+	}
+
+	return codes, maxLength, nil
+}
+
+// bytes returns the values of the blob as bytes in the specified byte order.
+func (blob *lercBlob) bytes(byteOrder binary.ByteOrder) []byte {
+	size := lercDataTypeSizes[blob.dataType]
+	data := make([]byte, len(blob.values)*size)
+
+	for index, value := range blob.values {
+		dst := data[index*size:]
+
+		switch blob.dataType {
+		case lercChar:
+			dst[0] = byte(int8(value))
+		case lercByte:
+			dst[0] = byte(value)
+		case lercShort:
+			byteOrder.PutUint16(dst, uint16(int16(value)))
+		case lercUShort:
+			byteOrder.PutUint16(dst, uint16(value))
+		case lercInt:
+			byteOrder.PutUint32(dst, uint32(int32(value)))
+		case lercUInt:
+			byteOrder.PutUint32(dst, uint32(value))
+		case lercFloat:
+			byteOrder.PutUint32(dst, math.Float32bits(float32(value)))
+		case lercDouble:
+			byteOrder.PutUint64(dst, math.Float64bits(value))
+		}
+	}
+
+	return data
+}
@@ -0,0 +1,728 @@
+package gobio
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"math"
+	"testing"
+
+	"github.com/AlanRace/go-bio/test/tifftest"
+	"github.com/klauspost/compress/zstd"
+)
+
+// lercEncoder writes Lerc2 blobs for the tests. It exercises each of the ways of coding the values, rather than
+// choosing the smallest.
+type lercEncoder struct {
+	version        int32
+	dataType       lercDataType
+	dimensions     int
+	maxZError      float64
+	microBlockSize int
+
+	// huffmanMode is used for lossless 8-bit data
+	huffmanMode byte
+	oneSweep    bool
+
+	// noData, when set, is stored in place of noDataOriginal (version 6 onwards)
+	noData *[2]float64
+}
+
+type lercWriter struct {
+	bytes.Buffer
+}
+
+func (writer *lercWriter) writeInt32(value int) {
+	binary.Write(writer, binary.LittleEndian, int32(value))
+}
+
+func (writer *lercWriter) writeValue(dataType lercDataType, value float64) {
+	switch dataType {
+	case lercChar:
+		writer.WriteByte(byte(int8(value)))
+	case lercByte:
+		writer.WriteByte(byte(value))
+	case lercShort:
+		binary.Write(writer, binary.LittleEndian, int16(value))
+	case lercUShort:
+		binary.Write(writer, binary.LittleEndian, uint16(value))
+	case lercInt:
+		binary.Write(writer, binary.LittleEndian, int32(value))
+	case lercUInt:
+		binary.Write(writer, binary.LittleEndian, uint32(value))
+	case lercFloat:
+		binary.Write(writer, binary.LittleEndian, float32(value))
+	case lercDouble:
+		binary.Write(writer, binary.LittleEndian, value)
+	}
+}
+
+// writeBits packs values of numBits bits, in the order used by version.
+func (writer *lercWriter) writeBits(values []uint32, numBits int, version int32) {
+	data := make([]byte, (len(values)*numBits+7)/8)
+
+	if version >= 3 {
+		for index, value := range values {
+			for bit := 0; bit < numBits; bit++ {
+				position := index*numBits + bit
+				data[position>>3] |= byte(value>>uint(bit)&1) << uint(position&7)
+			}
+		}
+	} else {
+		words := make([]uint32, (len(data)+3)/4)
+		for index, value := range values {
+			for bit := 0; bit < numBits; bit++ {
+				position := index*numBits + bit
+				words[position>>5] |= (value >> uint(numBits-1-bit) & 1) << uint(31-position&31)
+			}
+		}
+
+		// The bytes of the final partial word are taken from the top of the word
+		var wordBytes [4]byte
+		for index, word := range words {
+			numBytes := len(data) - index*4
+			if numBytes > 4 {
+				numBytes = 4
+			}
+
+			binary.LittleEndian.PutUint32(wordBytes[:], word>>uint(8*(4-numBytes)))
+			copy(data[index*4:], wordBytes[:numBytes])
+		}
+	}
+
+	writer.Write(data)
+}
+
+// writeBitStuffed writes values with a lookup table when useLookup is set.
+func (writer *lercWriter) writeBitStuffed(values []uint32, useLookup bool, version int32) {
+	bitsFor := func(max uint32) int {
+		bits := 0
+		for max>>uint(bits) != 0 {
+			bits++
+		}
+		return bits
+	}
+
+	var lookup []uint32
+	indices := values
+	if useLookup {
+		indexOf := map[uint32]uint32{0: 0}
+		lookup = []uint32{0}
+		indices = make([]uint32, len(values))
+
+		for index, value := range values {
+			if _, ok := indexOf[value]; !ok {
+				indexOf[value] = uint32(len(lookup))
+				lookup = append(lookup, value)
+			}
+
+			indices[index] = indexOf[value]
+		}
+	}
+
+	var max uint32
+	for _, value := range append(lookup, values...) {
+		if value > max {
+			max = value
+		}
+	}
+	numBits := bitsFor(max)
+
+	header := byte(numBits)
+	if useLookup {
+		header |= 1 << 5
+	}
+
+	switch {
+	case len(values) < 256:
+		writer.WriteByte(header | 2<<6)
+		writer.WriteByte(byte(len(values)))
+	case len(values) < 65536:
+		writer.WriteByte(header | 1<<6)
+		binary.Write(writer, binary.LittleEndian, uint16(len(values)))
+	default:
+		writer.WriteByte(header)
+		binary.Write(writer, binary.LittleEndian, uint32(len(values)))
+	}
+
+	if useLookup {
+		writer.WriteByte(byte(len(lookup)))
+		writer.writeBits(lookup[1:], numBits, version)
+		writer.writeBits(indices, bitsFor(uint32(len(lookup)-1)), version)
+	} else {
+		writer.writeBits(values, numBits, version)
+	}
+}
+
+// encode creates a blob from values, which holds dimensions values for each pixel. valid may be nil when all pixels
+// are valid.
+func (encoder *lercEncoder) encode(rows, columns int, values []float64, valid []bool) []byte {
+	numValid := 0
+	zMins := make([]float64, encoder.dimensions)
+	zMaxes := make([]float64, encoder.dimensions)
+	for dimension := range zMins {
+		zMins[dimension], zMaxes[dimension] = math.Inf(1), math.Inf(-1)
+	}
+
+	for pixel := 0; pixel < rows*columns; pixel++ {
+		if valid != nil && !valid[pixel] {
+			continue
+		}
+
+		numValid++
+		for dimension := range zMins {
+			zMins[dimension] = math.Min(zMins[dimension], values[pixel*encoder.dimensions+dimension])
+			zMaxes[dimension] = math.Max(zMaxes[dimension], values[pixel*encoder.dimensions+dimension])
+		}
+	}
+
+	zMin, zMax := 0.0, 0.0
+	if numValid > 0 {
+		zMin, zMax = zMins[0], zMaxes[0]
+		for dimension := range zMins {
+			zMin, zMax = math.Min(zMin, zMins[dimension]), math.Max(zMax, zMaxes[dimension])
+		}
+	}
+
+	var body lercWriter
+
+	// Mask, stored as literal runs
+	if numValid == 0 || numValid == rows*columns {
+		body.writeInt32(0)
+	} else {
+		mask := make([]byte, (rows*columns+7)/8)
+		for pixel, isValid := range valid {
+			if isValid {
+				mask[pixel>>3] |= 0x80 >> uint(pixel&7)
+			}
+		}
+
+		var rle lercWriter
+		binary.Write(&rle, binary.LittleEndian, int16(len(mask)-1))
+		rle.Write(mask[:len(mask)-1])
+		// Finish with a repeated run of 1
+		binary.Write(&rle, binary.LittleEndian, int16(-1))
+		rle.WriteByte(mask[len(mask)-1])
+		binary.Write(&rle, binary.LittleEndian, int16(-32768))
+
+		body.writeInt32(rle.Len())
+		body.Write(rle.Bytes())
+	}
+
+	if numValid > 0 && zMin != zMax {
+		if encoder.version >= 4 {
+			for _, values := range [][]float64{zMins, zMaxes} {
+				for _, value := range values {
+					body.writeValue(encoder.dataType, value)
+				}
+			}
+		}
+
+		encoder.encodeValues(&body, rows, columns, values, valid)
+	}
+
+	var blob lercWriter
+	blob.WriteString("Lerc2 ")
+	blob.writeInt32(int(encoder.version))
+	if encoder.version >= 3 {
+		// The checksum isn't checked when decoding
+		blob.writeInt32(0)
+	}
+
+	headerSize := 6 + 4 + 4*6 + 8*3
+	if encoder.version >= 3 {
+		headerSize += 4
+	}
+	if encoder.version >= 4 {
+		headerSize += 4
+	}
+	if encoder.version >= 6 {
+		headerSize += 4 + 4 + 8*2
+	}
+
+	blob.writeInt32(rows)
+	blob.writeInt32(columns)
+	if encoder.version >= 4 {
+		blob.writeInt32(encoder.dimensions)
+	}
+	blob.writeInt32(numValid)
+	blob.writeInt32(encoder.microBlockSize)
+	blob.writeInt32(headerSize + body.Len())
+	blob.writeInt32(int(encoder.dataType))
+	if encoder.version >= 6 {
+		blob.writeInt32(0)
+
+		if encoder.noData != nil {
+			blob.Write([]byte{1, 0, 0, 0})
+		} else {
+			blob.Write([]byte{0, 0, 0, 0})
+		}
+	}
+
+	doubles := []float64{encoder.maxZError, zMin, zMax}
+	if encoder.version >= 6 {
+		if encoder.noData != nil {
+			doubles = append(doubles, encoder.noData[0], encoder.noData[1])
+		} else {
+			doubles = append(doubles, 0, 0)
+		}
+	}
+	binary.Write(&blob, binary.LittleEndian, doubles)
+
+	blob.Write(body.Bytes())
+
+	return blob.Bytes()
+}
+
+func (encoder *lercEncoder) encodeValues(body *lercWriter, rows, columns int, values []float64, valid []bool) {
+	isValid := func(pixel int) bool { return valid == nil || valid[pixel] }
+
+	if encoder.oneSweep {
+		body.WriteByte(1)
+
+		for pixel := 0; pixel < rows*columns; pixel++ {
+			if isValid(pixel) {
+				for dimension := 0; dimension < encoder.dimensions; dimension++ {
+					body.writeValue(encoder.dataType, values[pixel*encoder.dimensions+dimension])
+				}
+			}
+		}
+
+		return
+	}
+	body.WriteByte(0)
+
+	if encoder.version >= 6 && (encoder.dataType == lercFloat || encoder.dataType == lercDouble) && encoder.maxZError == 0 {
+		body.WriteByte(lercTiles)
+	}
+
+	if (encoder.dataType == lercChar || encoder.dataType == lercByte) && encoder.maxZError == 0.5 {
+		body.WriteByte(encoder.huffmanMode)
+
+		if encoder.huffmanMode != lercTiles {
+			encoder.encodeHuffman(body, rows, columns, values, isValid)
+			return
+		}
+	}
+
+	blockIndex := 0
+	for row := 0; row < rows; row += encoder.microBlockSize {
+		for column := 0; column < columns; column += encoder.microBlockSize {
+			for dimension := 0; dimension < encoder.dimensions; dimension++ {
+				var blockValues []float64
+				for y := row; y < row+encoder.microBlockSize && y < rows; y++ {
+					for x := column; x < column+encoder.microBlockSize && x < columns; x++ {
+						if isValid(y*columns + x) {
+							blockValues = append(blockValues, values[(y*columns+x)*encoder.dimensions+dimension])
+						}
+					}
+				}
+
+				encoder.encodeBlock(body, column, blockIndex, blockValues)
+				blockIndex++
+			}
+		}
+	}
+}
+
+func (encoder *lercEncoder) encodeBlock(body *lercWriter, column, blockIndex int, values []float64) {
+	flag := byte((column>>3)&15) << 2
+	if encoder.version >= 5 {
+		flag = byte((column>>4)&7) << 3
+	}
+
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, value := range values {
+		min, max = math.Min(min, value), math.Max(max, value)
+	}
+
+	switch {
+	case len(values) == 0 || (min == 0 && max == 0):
+		body.WriteByte(flag | 2)
+	case blockIndex%5 == 4 || encoder.maxZError == 0:
+		body.WriteByte(flag)
+		for _, value := range values {
+			body.writeValue(encoder.dataType, value)
+		}
+	case min == max:
+		body.WriteByte(flag | 3)
+		body.writeValue(encoder.dataType, min)
+	default:
+		body.WriteByte(flag | 1)
+		body.writeValue(encoder.dataType, min)
+
+		quantised := make([]uint32, len(values))
+		for index, value := range values {
+			quantised[index] = uint32(math.Round((value - min) / (2 * encoder.maxZError)))
+		}
+
+		body.writeBitStuffed(quantised, blockIndex%2 == 1, encoder.version)
+	}
+}
+
+func (encoder *lercEncoder) encodeHuffman(body *lercWriter, rows, columns int, values []float64, isValid func(int) bool) {
+	offset := 0
+	if encoder.dataType == lercChar {
+		offset = 128
+	}
+
+	// Each code is the value (with the previous value subtracted for delta coding) as a byte
+	var symbols []int
+	used := make(map[int]bool)
+	for dimension := 0; dimension < encoder.dimensions; dimension++ {
+		previous := 0
+
+		for pixel := 0; pixel < rows*columns; pixel++ {
+			if !isValid(pixel) {
+				continue
+			}
+
+			value := int(values[pixel*encoder.dimensions+dimension])
+			symbol := value
+			if encoder.huffmanMode == lercDeltaHuffman {
+				x, y := pixel%columns, pixel/columns
+
+				if x > 0 && isValid(pixel-1) {
+					symbol -= previous
+				} else if y > 0 && isValid(pixel-columns) {
+					symbol -= int(values[(pixel-columns)*encoder.dimensions+dimension])
+				} else {
+					symbol -= previous
+				}
+			}
+			symbol = int(uint8(symbol + offset))
+
+			symbols = append(symbols, symbol)
+			used[symbol] = true
+			previous = value
+		}
+	}
+
+	// The range of codes wraps around when both small and large symbols are used
+	first, last := 256, -1
+	for symbol := range used {
+		if symbol < first {
+			first = symbol
+		}
+		if symbol > last {
+			last = symbol
+		}
+	}
+	if used[0] && used[255] {
+		first, last = 255, 256
+		for symbol := range used {
+			if symbol >= 128 && symbol < first {
+				first = symbol
+			}
+			if symbol < 128 && symbol+256 > last {
+				last = symbol + 256
+			}
+		}
+	}
+
+	// The first symbol has a 1 bit code and the others 1 followed by a fixed number of bits
+	bits := 0
+	for (len(used)-1)>>uint(bits) != 0 {
+		bits++
+	}
+
+	lengths := make([]uint32, last+1-first)
+	codes := make(map[int][2]uint32)
+	for index := range lengths {
+		symbol := (first + index) % 256
+		if !used[symbol] {
+			continue
+		}
+
+		if len(codes) == 0 {
+			codes[symbol] = [2]uint32{1, 0}
+		} else {
+			codes[symbol] = [2]uint32{uint32(1 + bits), 1<<uint(bits) | uint32(len(codes)-1)}
+		}
+		lengths[index] = codes[symbol][0]
+	}
+
+	body.writeInt32(2)
+	body.writeInt32(256)
+	body.writeInt32(first)
+	body.writeInt32(last + 1)
+	body.writeBitStuffed(lengths, false, encoder.version)
+
+	writeCodes := func(codeList [][2]uint32) {
+		var words []uint32
+		position := 0
+		for _, code := range codeList {
+			for bit := 0; bit < int(code[0]); bit++ {
+				if position>>5 == len(words) {
+					words = append(words, 0)
+				}
+
+				words[position>>5] |= (code[1] >> (code[0] - 1 - uint32(bit)) & 1) << uint(31-position&31)
+				position++
+			}
+		}
+
+		binary.Write(body, binary.LittleEndian, words)
+	}
+
+	var tableCodes [][2]uint32
+	for index := range lengths {
+		if code, ok := codes[(first+index)%256]; ok {
+			tableCodes = append(tableCodes, code)
+		}
+	}
+	writeCodes(tableCodes)
+
+	var dataCodes [][2]uint32
+	for _, symbol := range symbols {
+		dataCodes = append(dataCodes, codes[symbol])
+	}
+	writeCodes(dataCodes)
+}
+
+// newLERCValues creates values with smooth areas, constant and zero blocks and noise.
+func newLERCValues(rows, columns, dimensions int, dataType lercDataType) []float64 {
+	values := make([]float64, rows*columns*dimensions)
+
+	for y := 0; y < rows; y++ {
+		for x := 0; x < columns; x++ {
+			for dimension := 0; dimension < dimensions; dimension++ {
+				var value float64
+				switch {
+				case y < 8 && x < 8:
+					value = 0
+				case y < 8 && x < 16:
+					value = 7
+				case y >= 16:
+					value = float64((x*37 + y*91 + dimension*13) % 200)
+				default:
+					value = float64(x*3 + y*2 + dimension)
+				}
+
+				switch dataType {
+				case lercChar:
+					value -= 100
+				case lercShort, lercInt:
+					value = value*13 - 1000
+				case lercUShort, lercUInt:
+					value *= 300
+				case lercFloat:
+					value = float64(float32(value*0.37 - 3))
+				case lercDouble:
+					value = value*0.37 - 3
+				}
+
+				values[(y*columns+x)*dimensions+dimension] = value
+			}
+		}
+	}
+
+	return values
+}
+
+func TestDecodeLERC2(t *testing.T) {
+	const rows, columns = 27, 37
+
+	valid := make([]bool, rows*columns)
+	for pixel := range valid {
+		valid[pixel] = pixel%7 != 3
+	}
+
+	tests := []struct {
+		name    string
+		encoder lercEncoder
+		valid   []bool
+	}{
+		{"Byte Tiles", lercEncoder{dataType: lercByte, maxZError: 0.5}, nil},
+		{"Byte DeltaHuffman", lercEncoder{dataType: lercByte, maxZError: 0.5, huffmanMode: lercDeltaHuffman}, nil},
+		{"Byte Huffman Mask", lercEncoder{dataType: lercByte, maxZError: 0.5, huffmanMode: lercHuffman}, valid},
+		{"Char DeltaHuffman", lercEncoder{dataType: lercChar, maxZError: 0.5, huffmanMode: lercDeltaHuffman}, valid},
+		{"Short", lercEncoder{dataType: lercShort, maxZError: 0.5}, nil},
+		{"UShort Mask", lercEncoder{dataType: lercUShort, maxZError: 0.5}, valid},
+		{"Int", lercEncoder{dataType: lercInt, maxZError: 0.5}, nil},
+		{"UInt OneSweep", lercEncoder{dataType: lercUInt, maxZError: 0.5, oneSweep: true}, valid},
+		{"Float Lossless", lercEncoder{dataType: lercFloat}, nil},
+		{"Double", lercEncoder{dataType: lercDouble, maxZError: 0.001}, valid},
+		{"Byte Dimensions", lercEncoder{dataType: lercByte, maxZError: 0.5, dimensions: 3}, nil},
+		{"Byte Huffman Dimensions", lercEncoder{dataType: lercByte, maxZError: 0.5, dimensions: 2, huffmanMode: lercDeltaHuffman}, valid},
+	}
+
+	for _, test := range tests {
+		for version := int32(2); version <= 6; version++ {
+			encoder := test.encoder
+			encoder.version = version
+			encoder.microBlockSize = 8
+			if encoder.dimensions == 0 {
+				encoder.dimensions = 1
+			}
+
+			// Multiple dimensions were added in version 4
+			if encoder.dimensions > 1 && version < 4 {
+				continue
+			}
+
+			values := newLERCValues(rows, columns, encoder.dimensions, encoder.dataType)
+			blob, err := decodeLERC2(encoder.encode(rows, columns, values, test.valid))
+			if err != nil {
+				t.Fatalf("%s version %d: %v", test.name, version, err)
+			}
+
+			for pixel := 0; pixel < rows*columns; pixel++ {
+				for dimension := 0; dimension < encoder.dimensions; dimension++ {
+					index := pixel*encoder.dimensions + dimension
+
+					want := values[index]
+					if test.valid != nil && !test.valid[pixel] {
+						want = 0
+					}
+
+					if math.Abs(blob.values[index]-want) > encoder.maxZError+1e-6 {
+						t.Fatalf("%s version %d: value %d = %v, want %v", test.name, version, index, blob.values[index], want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeLERC2Constant(t *testing.T) {
+	const rows, columns = 5, 6
+
+	encoder := lercEncoder{version: 4, dataType: lercShort, dimensions: 2, maxZError: 0.5, microBlockSize: 8}
+
+	// Each dimension is constant, but they differ
+	values := make([]float64, rows*columns*2)
+	for index := range values {
+		values[index] = float64(index%2*10 - 3)
+	}
+
+	blob, err := decodeLERC2(encoder.encode(rows, columns, values, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index, value := range blob.values {
+		if value != values[index] {
+			t.Fatalf("value %d = %v, want %v", index, value, values[index])
+		}
+	}
+
+	// Only invalid pixels
+	blob, err = decodeLERC2(encoder.encode(rows, columns, values, make([]bool, rows*columns)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index, value := range blob.values {
+		if value != 0 {
+			t.Fatalf("value %d = %v, want 0", index, value)
+		}
+	}
+}
+
+func TestDecodeLERC2NoData(t *testing.T) {
+	const rows, columns = 9, 10
+
+	encoder := lercEncoder{version: 6, dataType: lercFloat, dimensions: 2, maxZError: 0.01, microBlockSize: 8, noData: &[2]float64{-1, -9999}}
+
+	values := newLERCValues(rows, columns, 2, lercFloat)
+	for index := range values {
+		values[index] = math.Abs(values[index])
+		if index%11 == 0 {
+			values[index] = -1
+		}
+	}
+
+	blob, err := decodeLERC2(encoder.encode(rows, columns, values, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index, value := range blob.values {
+		want := values[index]
+		if want == -1 {
+			want = -9999
+		}
+
+		if math.Abs(value-want) > 0.01+1e-6 {
+			t.Fatalf("value %d = %v, want %v", index, value, want)
+		}
+	}
+}
+
+func TestDecodeLERC2Invalid(t *testing.T) {
+	encoder := lercEncoder{version: 3, dataType: lercShort, dimensions: 1, maxZError: 0.5, microBlockSize: 8}
+	blob := encoder.encode(20, 20, newLERCValues(20, 20, 1, lercShort), nil)
+
+	if _, err := decodeLERC2(blob[:len(blob)-5]); err == nil {
+		t.Error("expected an error for a truncated blob")
+	}
+
+	if _, err := decodeLERC2(append([]byte("Lerc1 "), blob[6:]...)); err == nil {
+		t.Error("expected an error for an unsupported blob")
+	}
+}
+
+func TestLERCCompression(t *testing.T) {
+	const width, height, rowsPerStrip = 21, 19, 10
+
+	values := newLERCValues(height, width, 1, lercUShort)
+
+	encoder := lercEncoder{version: 4, dataType: lercUShort, dimensions: 1, maxZError: 0.5, microBlockSize: 8}
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for _, additional := range []uint32{lercAdditionalNone, lercAdditionalDeflate, lercAdditionalZstd} {
+			var strips [][]byte
+			for row := 0; row < height; row += rowsPerStrip {
+				rows := rowsPerStrip
+				if row+rows > height {
+					rows = height - row
+				}
+
+				strip := encoder.encode(rows, width, values[row*width:(row+rows)*width], nil)
+
+				switch additional {
+				case lercAdditionalDeflate:
+					var buf bytes.Buffer
+					writer := zlib.NewWriter(&buf)
+					writer.Write(strip)
+					writer.Close()
+					strip = buf.Bytes()
+				case lercAdditionalZstd:
+					zstdEncoder, err := zstd.NewWriter(nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					strip = zstdEncoder.EncodeAll(strip, nil)
+				}
+
+				strips = append(strips, strip)
+			}
+
+			builder := tifftest.New(order, false)
+			builder.AddDirectory().
+				Add(uint16(ImageWidth), tifftest.Long, []uint32{width}).
+				Add(uint16(ImageLength), tifftest.Long, []uint32{height}).
+				Add(uint16(BitsPerSample), tifftest.Short, []uint16{16}).
+				Add(uint16(Compression), tifftest.Short, []uint16{uint16(LERC)}).
+				Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(BlackIsZero)}).
+				Add(uint16(RowsPerStrip), tifftest.Long, []uint32{rowsPerStrip}).
+				Add(uint16(LercParameters), tifftest.Long, []uint32{4, additional}).
+				Strips(strips...)
+			data := builder.Bytes()
+
+			img, err := openBytes(t, data).GetIFD(0).GetImage()
+			if err != nil {
+				t.Fatalf("additional compression %d: %v", additional, err)
+			}
+
+			gray := img.(*image.Gray16)
+			for index, value := range values {
+				if got := gray.Gray16At(index%width, index/width).Y; got != uint16(value) {
+					t.Fatalf("%v additional compression %d: pixel %d = %d, want %v", order, additional, index, got, value)
+				}
+			}
+		}
+	}
+}
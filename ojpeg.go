@@ -0,0 +1,351 @@
+package gobio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+)
+
+// sectionDecompressor is implemented by compression methods which need to know the dimensions of the section being
+// decompressed, as they are not recorded in the compressed data.
+type sectionDecompressor interface {
+	decompressSection(r io.Reader, width, height int) (image.Image, error)
+}
+
+// OJPEGCompression decodes old-style JPEG compressed sections (compression 6). Each section holds only the entropy
+// coded data of a JPEG scan, with the tables stored either in a JPEG stream referenced by JPEGInterchangeFormat or
+// in the JPEGQTables, JPEGDCTables and JPEGACTables tags. A complete JPEG stream is rebuilt for each section.
+type OJPEGCompression struct {
+	// tables holds the DQT, DHT and DRI segments written before the frame header of each section
+	tables []byte
+
+	frameMarker byte
+	components  []ojpegComponent
+
+	adobeMarker     bool
+	colourTransform byte
+}
+
+// ojpegComponent describes a component in the frame and scan headers.
+type ojpegComponent struct {
+	id                 byte
+	horizontalSampling byte
+	verticalSampling   byte
+	quantisationTable  byte
+	dcTable            byte
+	acTable            byte
+}
+
+func newOJPEGCompression(dataAccess *baseDataAccess) (*OJPEGCompression, error) {
+	compression := &OJPEGCompression{frameMarker: 0xc0}
+	compression.SetPhotometricInterpretation(dataAccess.photometricInterpretation)
+
+	if dataAccess.HasTag(JPEGQTables) {
+		return compression, compression.readTablesFromTags(dataAccess)
+	}
+
+	if dataAccess.HasTag(JPEGInterchangeFormat) {
+		offset := int64(dataAccess.ifd.GetLongTagValue(JPEGInterchangeFormat))
+
+		length := dataAccess.tiffFile.size - offset
+		if dataAccess.HasTag(JPEGInterchangeFormatLength) {
+			length = int64(dataAccess.ifd.GetLongTagValue(JPEGInterchangeFormatLength))
+		}
+
+		interchange, err := dataAccess.readAt(offset, length)
+		if err != nil {
+			return nil, err
+		}
+
+		return compression, compression.readTablesFromStream(interchange)
+	}
+
+	return nil, &FormatError{msg: "OJPEG compressed data has neither JPEGQTables nor JPEGInterchangeFormat"}
+}
+
+// readTablesFromTags builds the tables and frame from the JPEGProc, JPEGQTables, JPEGDCTables and JPEGACTables tags.
+func (compression *OJPEGCompression) readTablesFromTags(dataAccess *baseDataAccess) error {
+	if dataAccess.HasTag(JPEGProc) {
+		proc, err := dataAccess.ifd.GetShortTagValue(JPEGProc)
+		if err != nil {
+			return err
+		}
+
+		// 14 is lossless, which image/jpeg can't decode
+		if proc != 1 {
+			return &FormatError{msg: fmt.Sprintf("Unsupported JPEGProc %d", proc)}
+		}
+	}
+
+	qTables, err := ojpegTableOffsets(dataAccess, JPEGQTables)
+	if err != nil {
+		return err
+	}
+	dcTables, err := ojpegTableOffsets(dataAccess, JPEGDCTables)
+	if err != nil {
+		return err
+	}
+	acTables, err := ojpegTableOffsets(dataAccess, JPEGACTables)
+	if err != nil {
+		return err
+	}
+
+	var tables bytes.Buffer
+
+	for index, offset := range qTables {
+		table, err := dataAccess.readAt(offset, 64)
+		if err != nil {
+			return err
+		}
+
+		tables.Write([]byte{0xff, 0xdb, 0x00, 67, byte(index)})
+		tables.Write(table)
+	}
+
+	for class, offsets := range [][]int64{dcTables, acTables} {
+		for index, offset := range offsets {
+			counts, err := dataAccess.readAt(offset, 16)
+			if err != nil {
+				return err
+			}
+
+			numValues := 0
+			for _, count := range counts {
+				numValues += int(count)
+			}
+
+			values, err := dataAccess.readAt(offset+16, int64(numValues))
+			if err != nil {
+				return err
+			}
+
+			tables.Write([]byte{0xff, 0xc4})
+			binary.Write(&tables, binary.BigEndian, uint16(2+1+16+numValues))
+			tables.WriteByte(byte(class<<4 | index))
+			tables.Write(counts)
+			tables.Write(values)
+		}
+	}
+
+	if dataAccess.HasTag(JPEGRestartInterval) {
+		restartInterval, err := dataAccess.ifd.GetShortTagValue(JPEGRestartInterval)
+		if err != nil {
+			return err
+		}
+
+		tables.Write([]byte{0xff, 0xdd, 0x00, 0x04, byte(restartInterval >> 8), byte(restartInterval)})
+	}
+
+	compression.tables = tables.Bytes()
+
+	// Chroma is subsampled in YCbCr images, which is recorded as the sampling factors of the luma component
+	horizontalSampling, verticalSampling := byte(1), byte(1)
+	if dataAccess.photometricInterpretation == YCbCr {
+		parameters, err := dataAccess.ifd.getYCbCrParameters()
+		if err != nil {
+			return err
+		}
+
+		horizontalSampling, verticalSampling = byte(parameters.subsampleH), byte(parameters.subsampleV)
+	}
+
+	// Components share the last table when there are fewer tables than components
+	tableIndex := func(component, numTables int) byte {
+		if component >= numTables {
+			return byte(numTables - 1)
+		}
+
+		return byte(component)
+	}
+
+	for component := 0; component < int(dataAccess.samplesPerPixel); component++ {
+		ojpegComponent := ojpegComponent{
+			id:                 byte(component + 1),
+			horizontalSampling: 1,
+			verticalSampling:   1,
+			quantisationTable:  tableIndex(component, len(qTables)),
+			dcTable:            tableIndex(component, len(dcTables)),
+			acTable:            tableIndex(component, len(acTables)),
+		}
+
+		if component == 0 {
+			ojpegComponent.horizontalSampling, ojpegComponent.verticalSampling = horizontalSampling, verticalSampling
+		}
+
+		compression.components = append(compression.components, ojpegComponent)
+	}
+
+	return nil
+}
+
+// ojpegTableOffsets returns the offsets of the tables recorded in the tag. JPEG allows at most 4 tables of each type.
+func ojpegTableOffsets(dataAccess *baseDataAccess, tagID TagID) ([]int64, error) {
+	tag, ok := dataAccess.ifd.GetLongTag(tagID)
+	if !ok || len(tag.Data) == 0 {
+		return nil, &FormatError{msg: fmt.Sprintf("OJPEG compressed data is missing %s", tagNameMap[tagID])}
+	}
+	if len(tag.Data) > 4 {
+		return nil, &FormatError{msg: fmt.Sprintf("%s contains %d tables, at most 4 are supported", tagNameMap[tagID], len(tag.Data))}
+	}
+
+	offsets := make([]int64, len(tag.Data))
+	for index, offset := range tag.Data {
+		offsets[index] = int64(offset)
+	}
+
+	return offsets, nil
+}
+
+// readTablesFromStream extracts the tables, frame and scan details from the JPEG stream referenced by
+// JPEGInterchangeFormat.
+func (compression *OJPEGCompression) readTablesFromStream(stream []byte) error {
+	if !bytes.HasPrefix(stream, jpegSOI) {
+		return &FormatError{msg: "JPEGInterchangeFormat does not reference a JPEG stream"}
+	}
+
+	var tables bytes.Buffer
+
+	for offset := 2; offset+4 <= len(stream); {
+		if stream[offset] != 0xff {
+			return &FormatError{msg: fmt.Sprintf("Expected JPEG marker at offset %d of JPEGInterchangeFormat", offset)}
+		}
+
+		marker := stream[offset+1]
+		if marker == 0xff {
+			// Fill byte
+			offset++
+			continue
+		}
+
+		end := offset + 2 + int(binary.BigEndian.Uint16(stream[offset+2:]))
+		if end > len(stream) {
+			return &FormatError{msg: "JPEGInterchangeFormat is truncated"}
+		}
+		segment := stream[offset+4 : end]
+
+		switch {
+		case marker == 0xdb || marker == 0xc4 || marker == 0xdd:
+			tables.Write(stream[offset:end])
+		case marker >= 0xc0 && marker <= 0xc2:
+			if len(segment) < 6 || len(segment) < 6+3*int(segment[5]) {
+				return &FormatError{msg: "Invalid frame header in JPEGInterchangeFormat"}
+			}
+
+			compression.frameMarker = marker
+			compression.components = nil
+			for component := 0; component < int(segment[5]); component++ {
+				details := segment[6+3*component:]
+
+				compression.components = append(compression.components, ojpegComponent{
+					id:                 details[0],
+					horizontalSampling: details[1] >> 4,
+					verticalSampling:   details[1] & 0x0f,
+					quantisationTable:  details[2],
+				})
+			}
+		case marker == 0xda:
+			if len(segment) < 1 || len(segment) < 1+2*int(segment[0]) {
+				return &FormatError{msg: "Invalid scan header in JPEGInterchangeFormat"}
+			}
+
+			for scanComponent := 0; scanComponent < int(segment[0]); scanComponent++ {
+				details := segment[1+2*scanComponent:]
+
+				for index := range compression.components {
+					if compression.components[index].id == details[0] {
+						compression.components[index].dcTable = details[1] >> 4
+						compression.components[index].acTable = details[1] & 0x0f
+					}
+				}
+			}
+
+			compression.tables = tables.Bytes()
+
+			if len(compression.components) == 0 {
+				return &FormatError{msg: "No frame header found in JPEGInterchangeFormat"}
+			}
+
+			return nil
+		}
+
+		offset = end
+	}
+
+	return &FormatError{msg: "No scan found in JPEGInterchangeFormat"}
+}
+
+// Decompress decodes a section which contains a complete JPEG stream. Sections containing only entropy coded data
+// need the dimensions of the section, which are supplied by Section.GetImage.
+func (compression *OJPEGCompression) Decompress(r io.Reader) (image.Image, error) {
+	return compression.decompressSection(r, 0, 0)
+}
+
+func (compression *OJPEGCompression) decompressSection(r io.Reader, width, height int) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var stream bytes.Buffer
+	stream.Grow(len(compression.tables) + len(data) + 64)
+
+	stream.Write(jpegSOI)
+	if compression.adobeMarker {
+		writeAdobeMarker(&stream, compression.colourTransform)
+	}
+	stream.Write(compression.tables)
+
+	// Some writers store a complete JPEG stream in each section
+	if bytes.HasPrefix(data, jpegSOI) {
+		stream.Write(data[2:])
+
+		return jpeg.Decode(&stream)
+	}
+
+	if width <= 0 || height <= 0 {
+		return nil, &FormatError{msg: "Unable to decode OJPEG data without the dimensions of the section"}
+	}
+
+	numComponents := len(compression.components)
+
+	stream.Write([]byte{0xff, compression.frameMarker})
+	binary.Write(&stream, binary.BigEndian, uint16(8+3*numComponents))
+	stream.WriteByte(8)
+	binary.Write(&stream, binary.BigEndian, uint16(height))
+	binary.Write(&stream, binary.BigEndian, uint16(width))
+	stream.WriteByte(byte(numComponents))
+	for _, component := range compression.components {
+		stream.Write([]byte{component.id, component.horizontalSampling<<4 | component.verticalSampling, component.quantisationTable})
+	}
+
+	stream.Write([]byte{0xff, 0xda})
+	binary.Write(&stream, binary.BigEndian, uint16(6+2*numComponents))
+	stream.WriteByte(byte(numComponents))
+	for _, component := range compression.components {
+		stream.Write([]byte{component.id, component.dcTable<<4 | component.acTable})
+	}
+	stream.Write([]byte{0, 63, 0})
+
+	stream.Write(data)
+	if !bytes.HasSuffix(data, jpegEOI) {
+		stream.Write(jpegEOI)
+	}
+
+	return jpeg.Decode(&stream)
+}
+
+// SetPhotometricInterpretation describes the colour space of the components, see JPEGCompression.
+func (compression *OJPEGCompression) SetPhotometricInterpretation(interpretation PhotometricInterpretationID) {
+	switch interpretation {
+	case RGB:
+		compression.adobeMarker, compression.colourTransform = true, 0
+	case YCbCr:
+		compression.adobeMarker, compression.colourTransform = true, 1
+	default:
+		compression.adobeMarker = false
+	}
+}
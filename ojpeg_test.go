@@ -0,0 +1,199 @@
+package gobio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/AlanRace/go-bio/test/tifftest"
+)
+
+// ojpegParts separates a baseline JPEG stream written by image/jpeg into the quantisation and Huffman tables, as
+// stored in JPEGQTables, JPEGDCTables and JPEGACTables, and the entropy coded data of the scan.
+func ojpegParts(t *testing.T, data []byte) (qTables, dcTables, acTables [][]byte, scan []byte) {
+	t.Helper()
+
+	for offset := 2; offset < len(data); {
+		marker := data[offset+1]
+		end := offset + 2 + int(binary.BigEndian.Uint16(data[offset+2:]))
+		segment := data[offset+4 : end]
+
+		switch marker {
+		case 0xdb:
+			for ; len(segment) > 0; segment = segment[65:] {
+				qTables = append(qTables, segment[1:65])
+			}
+		case 0xc4:
+			for len(segment) > 0 {
+				numValues := 0
+				for _, count := range segment[1:17] {
+					numValues += int(count)
+				}
+
+				table := segment[1 : 17+numValues]
+				if segment[0]>>4 == 0 {
+					dcTables = append(dcTables, table)
+				} else {
+					acTables = append(acTables, table)
+				}
+
+				segment = segment[17+numValues:]
+			}
+		case 0xda:
+			// Exclude the EOI marker
+			return qTables, dcTables, acTables, data[end : len(data)-2]
+		}
+
+		offset = end
+	}
+
+	t.Fatal("no scan found in JPEG stream")
+	return
+}
+
+// newOJPEGStrip encodes a strip with a gradient and returns the decoded strip along with the JPEG stream.
+func newOJPEGStrip(t *testing.T, width, height, seed int) (image.Image, []byte) {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 8), G: uint8(y*8 + seed*30), B: uint8(seed * 40), A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return decoded, buf.Bytes()
+}
+
+func newOJPEGDirectory(builder *tifftest.Builder, width, height, rowsPerStrip int) *tifftest.Directory {
+	return builder.AddDirectory().
+		Add(uint16(ImageWidth), tifftest.Long, []uint32{uint32(width)}).
+		Add(uint16(ImageLength), tifftest.Long, []uint32{uint32(height)}).
+		Add(uint16(BitsPerSample), tifftest.Short, []uint16{8, 8, 8}).
+		Add(uint16(Compression), tifftest.Short, []uint16{uint16(OJPEG)}).
+		Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(YCbCr)}).
+		Add(uint16(SamplesPerPixel), tifftest.Short, []uint16{3}).
+		Add(uint16(RowsPerStrip), tifftest.Long, []uint32{uint32(rowsPerStrip)})
+}
+
+func checkOJPEGStrip(t *testing.T, ifd *ImageFileDirectory, index int, want image.Image) {
+	t.Helper()
+
+	img, err := ifd.GetSection(uint32(index)).GetImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Bounds() != want.Bounds() {
+		t.Fatalf("strip %d: got bounds %v, want %v", index, img.Bounds(), want.Bounds())
+	}
+
+	for y := 0; y < want.Bounds().Dy(); y++ {
+		for x := 0; x < want.Bounds().Dx(); x++ {
+			wantColour := color.RGBAModel.Convert(want.At(x, y))
+			if got := color.RGBAModel.Convert(img.At(x, y)); got != wantColour {
+				t.Fatalf("strip %d pixel (%d, %d): got %v, want %v", index, x, y, got, wantColour)
+			}
+		}
+	}
+}
+
+func TestOJPEGTables(t *testing.T) {
+	const width, rowsPerStrip = 24, 16
+
+	// The final strip only contains the remaining rows
+	heights := []int{rowsPerStrip, 8}
+
+	var strips [][]byte
+	var want []image.Image
+	var qTables, dcTables, acTables [][]byte
+	for seed, height := range heights {
+		decoded, data := newOJPEGStrip(t, width, height, seed)
+
+		var scan []byte
+		qTables, dcTables, acTables, scan = ojpegParts(t, data)
+
+		strips = append(strips, scan)
+		want = append(want, decoded)
+	}
+
+	builder := tifftest.New(binary.BigEndian, false)
+	newOJPEGDirectory(builder, width, rowsPerStrip+8, rowsPerStrip).
+		Add(uint16(JPEGProc), tifftest.Short, []uint16{1}).
+		Data(uint16(JPEGQTables), qTables...).
+		Data(uint16(JPEGDCTables), dcTables...).
+		Data(uint16(JPEGACTables), acTables...).
+		Strips(strips...)
+	data := builder.Bytes()
+
+	ifd := openBytes(t, data).GetIFD(0)
+	for index, wantStrip := range want {
+		checkOJPEGStrip(t, ifd, index, wantStrip)
+	}
+
+	if _, err := ifd.GetImage(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOJPEGInterchangeFormat(t *testing.T) {
+	const width, height = 24, 16
+
+	decoded, stream := newOJPEGStrip(t, width, height, 1)
+	_, _, _, scan := ojpegParts(t, stream)
+
+	builder := tifftest.New(binary.LittleEndian, false)
+	newOJPEGDirectory(builder, width, height, height).
+		Data(uint16(JPEGInterchangeFormat), stream).
+		Add(uint16(JPEGInterchangeFormatLength), tifftest.Long, []uint32{uint32(len(stream))}).
+		Strips(scan)
+	data := builder.Bytes()
+
+	checkOJPEGStrip(t, openBytes(t, data).GetIFD(0), 0, decoded)
+
+	// Some writers store the complete stream as the strip
+	builder = tifftest.New(binary.LittleEndian, false)
+	newOJPEGDirectory(builder, width, height, height).
+		Data(uint16(JPEGInterchangeFormat), stream).
+		Strips(stream)
+	data = builder.Bytes()
+
+	checkOJPEGStrip(t, openBytes(t, data).GetIFD(0), 0, decoded)
+}
+
+func TestOJPEGLossless(t *testing.T) {
+	_, stream := newOJPEGStrip(t, 8, 8, 0)
+	qTables, dcTables, acTables, scan := ojpegParts(t, stream)
+
+	builder := tifftest.New(binary.LittleEndian, false)
+	newOJPEGDirectory(builder, 8, 8, 8).
+		Add(uint16(JPEGProc), tifftest.Short, []uint16{14}).
+		Data(uint16(JPEGQTables), qTables...).
+		Data(uint16(JPEGDCTables), dcTables...).
+		Data(uint16(JPEGACTables), acTables...).
+		Strips(scan)
+	data := builder.Bytes()
+
+	// Lossless JPEG is valid but not supported, so the file opens and reading the image fails
+	tiffFile, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{Strict: true,
+		WarningHandler: func(error) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tiffFile.GetIFD(0).GetImage(); err == nil {
+		t.Error("expected an error for lossless JPEGProc")
+	}
+}
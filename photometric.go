@@ -0,0 +1,383 @@
+package gobio
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	tiffimage "github.com/AlanRace/go-bio/image"
+	tiffcolor "github.com/AlanRace/go-bio/image/color"
+)
+
+// invertGray converts a WhiteIsZero image, decoded as if it were BlackIsZero, so that 0 is black.
+func invertGray(img image.Image) (image.Image, error) {
+	var pix []uint8
+
+	switch img := img.(type) {
+	case *image.Gray:
+		pix = img.Pix
+	case *image.Gray16:
+		pix = img.Pix
+	default:
+		return nil, &FormatError{msg: fmt.Sprintf("[GetImage>WhiteIsZero] Unable to invert %T", img)}
+	}
+
+	// Inverting each byte of a big endian value inverts the value
+	for i := range pix {
+		pix[i] = ^pix[i]
+	}
+
+	return img, nil
+}
+
+// decodePaletted creates an image from uncompressed indices into the ColorMap.
+func (dataAccess *baseDataAccess) decodePaletted(data []byte, rect image.Rectangle) (image.Image, error) {
+	palette, err := dataAccess.palette()
+	if err != nil {
+		return nil, err
+	}
+
+	bitsPerSample := int(dataAccess.bitsPerSample[0])
+
+	palettedImage := image.NewPaletted(rect, palette)
+	if bitsPerSample == 8 {
+		copy(palettedImage.Pix, data)
+	} else {
+		unpackSamples(palettedImage.Pix, data, rect.Dx(), rect.Dy(), bitsPerSample)
+	}
+
+	return palettedImage, nil
+}
+
+// palette returns the colours of the ColorMap, which has an entry for each possible index.
+func (dataAccess *baseDataAccess) palette() (color.Palette, error) {
+	bitsPerSample := int(dataAccess.bitsPerSample[0])
+	if bitsPerSample != 1 && bitsPerSample != 2 && bitsPerSample != 4 && bitsPerSample != 8 {
+		return nil, &FormatError{msg: fmt.Sprintf("[GetImage>PaletteColour] Unsupported BitsPerSample: %v", dataAccess.bitsPerSample)}
+	}
+
+	colorMapTag, ok := dataAccess.ifd.GetShortTag(ColorMap)
+	if !ok {
+		return nil, &FormatError{msg: "[GetImage>PaletteColour] ColorMap tag appears to be missing"}
+	}
+
+	// The ColorMap holds all of the red values, followed by all of the green and then all of the blue
+	numColours := 1 << bitsPerSample
+	if len(colorMapTag.Data) != 3*numColours {
+		return nil, &FormatError{msg: fmt.Sprintf("[GetImage>PaletteColour] ColorMap has %d values, expected %d", len(colorMapTag.Data), 3*numColours)}
+	}
+
+	palette := make(color.Palette, numColours)
+	for i := range palette {
+		palette[i] = color.RGBA64{R: colorMapTag.Data[i], G: colorMapTag.Data[numColours+i], B: colorMapTag.Data[2*numColours+i], A: 0xffff}
+	}
+
+	return palette, nil
+}
+
+// decodeCMYK creates an image from uncompressed 8-bit CMYK data.
+func (dataAccess *baseDataAccess) decodeCMYK(data []byte, rect image.Rectangle) (image.Image, error) {
+	if dataAccess.HasTag(InkSet) {
+		inkSet, err := dataAccess.ifd.GetShortTagValue(InkSet)
+		if err != nil {
+			return nil, err
+		}
+
+		// InkSet 2 describes inks other than cyan, magenta, yellow and black
+		if inkSet != 1 {
+			return nil, &FormatError{msg: fmt.Sprintf("[GetImage>CMYK] Unsupported InkSet: %d", inkSet)}
+		}
+	}
+
+	if dataAccess.samplesPerPixel != 4 || dataAccess.BitsPerPixel() != 32 {
+		return nil, &FormatError{msg: fmt.Sprintf("[GetImage>CMYK] Unsupported BitsPerSample %v with %d SamplesPerPixel", dataAccess.bitsPerSample, dataAccess.samplesPerPixel)}
+	}
+
+	cmykImage := image.NewCMYK(rect)
+	copy(cmykImage.Pix, data)
+
+	return cmykImage, nil
+}
+
+// yCbCrSubsampleRatios maps the YCbCrSubSampling of the chroma samples horizontally and vertically to the equivalent
+// image.YCbCrSubsampleRatio.
+var yCbCrSubsampleRatios = map[[2]int]image.YCbCrSubsampleRatio{
+	{1, 1}: image.YCbCrSubsampleRatio444,
+	{2, 1}: image.YCbCrSubsampleRatio422,
+	{2, 2}: image.YCbCrSubsampleRatio420,
+	{1, 2}: image.YCbCrSubsampleRatio440,
+	{4, 1}: image.YCbCrSubsampleRatio411,
+	{4, 2}: image.YCbCrSubsampleRatio410,
+}
+
+// yCbCrParameters describes how YCbCr data is stored and how it should be converted to RGB.
+type yCbCrParameters struct {
+	// subsampleH and subsampleV are the number of luma samples for each chroma sample horizontally and vertically.
+	subsampleH, subsampleV int
+	// cosited is true when the chroma samples are at the same position as the first luma sample they cover, rather
+	// than centred on the luma samples.
+	cosited bool
+
+	lumaRed, lumaGreen, lumaBlue float64
+	referenceBlackWhite          [6]float64
+}
+
+var defaultReferenceBlackWhite = [6]float64{0, 255, 128, 255, 128, 255}
+
+// getYCbCrParameters reads the YCbCr tags, using the defaults from the TIFF specification for those not present.
+func (ifd *ImageFileDirectory) getYCbCrParameters() (yCbCrParameters, error) {
+	parameters := yCbCrParameters{
+		subsampleH: 2, subsampleV: 2,
+		lumaRed: 0.299, lumaGreen: 0.587, lumaBlue: 0.114,
+		referenceBlackWhite: defaultReferenceBlackWhite,
+	}
+
+	if subsamplingTag, ok := ifd.GetShortTag(YCbCrSubSampling); ok {
+		if len(subsamplingTag.Data) != 2 {
+			return parameters, &FormatError{msg: fmt.Sprintf("YCbCrSubSampling has %d values, expected 2", len(subsamplingTag.Data))}
+		}
+
+		parameters.subsampleH, parameters.subsampleV = int(subsamplingTag.Data[0]), int(subsamplingTag.Data[1])
+	}
+	if _, ok := yCbCrSubsampleRatios[[2]int{parameters.subsampleH, parameters.subsampleV}]; !ok {
+		return parameters, &FormatError{msg: fmt.Sprintf("Unsupported YCbCrSubSampling %d, %d", parameters.subsampleH, parameters.subsampleV)}
+	}
+
+	if ifd.HasTag(YCbCrPositioning) {
+		positioning, err := ifd.GetShortTagValue(YCbCrPositioning)
+		if err != nil {
+			return parameters, err
+		}
+
+		switch positioning {
+		case 1:
+		case 2:
+			parameters.cosited = true
+		default:
+			return parameters, &FormatError{msg: fmt.Sprintf("Unknown YCbCrPositioning %d", positioning)}
+		}
+	}
+
+	if coefficientsTag, ok := ifd.GetRationalTag(YCbCrCoefficients); ok {
+		if len(coefficientsTag.Data) != 3 {
+			return parameters, &FormatError{msg: fmt.Sprintf("YCbCrCoefficients has %d values, expected 3", len(coefficientsTag.Data))}
+		}
+
+		parameters.lumaRed = coefficientsTag.Data[0].Value()
+		parameters.lumaGreen = coefficientsTag.Data[1].Value()
+		parameters.lumaBlue = coefficientsTag.Data[2].Value()
+	}
+
+	if referenceTag, ok := ifd.GetRationalTag(ReferenceBlackWhite); ok {
+		if len(referenceTag.Data) != 6 {
+			return parameters, &FormatError{msg: fmt.Sprintf("ReferenceBlackWhite has %d values, expected 6", len(referenceTag.Data))}
+		}
+
+		for i := range parameters.referenceBlackWhite {
+			parameters.referenceBlackWhite[i] = referenceTag.Data[i].Value()
+		}
+	}
+
+	return parameters, nil
+}
+
+// matchesJFIF returns whether the conversion to RGB is the same as that used by image.YCbCr.
+func (parameters *yCbCrParameters) matchesJFIF() bool {
+	return parameters.lumaRed == 0.299 && parameters.lumaGreen == 0.587 && parameters.lumaBlue == 0.114 &&
+		parameters.referenceBlackWhite == defaultReferenceBlackWhite
+}
+
+// toRGB converts a YCbCr colour to RGB, using the coefficients and reference black and white of the image.
+func (parameters *yCbCrParameters) toRGB(y, cb, cr float64) tiffcolor.RGB {
+	ref := parameters.referenceBlackWhite
+
+	y = (y - ref[0]) * 255 / (ref[1] - ref[0])
+	cb = (cb - ref[2]) * 127 / (ref[3] - ref[2])
+	cr = (cr - ref[4]) * 127 / (ref[5] - ref[4])
+
+	r := y + cr*(2-2*parameters.lumaRed)
+	b := y + cb*(2-2*parameters.lumaBlue)
+	g := (y - parameters.lumaBlue*b - parameters.lumaRed*r) / parameters.lumaGreen
+
+	return tiffcolor.RGB{R: clampToUint8(r), G: clampToUint8(g), B: clampToUint8(b)}
+}
+
+func clampToUint8(value float64) uint8 {
+	if value <= 0 {
+		return 0
+	}
+	if value >= 255 {
+		return 255
+	}
+
+	return uint8(math.Round(value))
+}
+
+// decodeYCbCr creates an image from uncompressed 8-bit YCbCr data. Subsampled data is stored in units covering
+// subsampleH x subsampleV pixels, each holding the luma samples of the pixels followed by the Cb and Cr samples.
+// Where the data can be represented by image.YCbCr it is returned directly, otherwise it is converted to RGB.
+func (dataAccess *baseDataAccess) decodeYCbCr(section *Section) (image.Image, error) {
+	if dataAccess.samplesPerPixel != 3 || dataAccess.BitsPerPixel() != 24 {
+		return nil, &FormatError{msg: fmt.Sprintf("[GetImage>YCbCr] Unsupported BitsPerSample %v with %d SamplesPerPixel", dataAccess.bitsPerSample, dataAccess.samplesPerPixel)}
+	}
+
+	parameters, err := dataAccess.ifd.getYCbCrParameters()
+	if err != nil {
+		return nil, err
+	}
+
+	subsampleH, subsampleV := parameters.subsampleH, parameters.subsampleV
+	if dataAccess.planarConfiguration == PlanarConfigurationPlanar && (subsampleH != 1 || subsampleV != 1) {
+		return nil, &FormatError{msg: "[GetImage>YCbCr] Unsupported subsampling of planar data"}
+	}
+
+	data, err := dataAccess.GetData(section)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rows are stored at the full width of the section, padded to a whole number of units
+	sectionWidth, _ := dataAccess.ifd.GetSectionDimensions()
+	unitsAcross := (int(sectionWidth) + subsampleH - 1) / subsampleH
+	unitSize := subsampleH*subsampleV + 2
+	unitsDown := len(data) / (unitsAcross * unitSize)
+
+	width := unitsAcross * subsampleH
+	height := unitsDown * subsampleV
+	if height < int(section.Height) {
+		return nil, &FormatError{msg: fmt.Sprintf("Insufficient data for section %d: found %d bytes, expected at least %d", section.Index, len(data), (int(section.Height)+subsampleV-1)/subsampleV*unitsAcross*unitSize)}
+	}
+
+	ycbcrImage := image.NewYCbCr(image.Rect(0, 0, width, height), yCbCrSubsampleRatios[[2]int{subsampleH, subsampleV}])
+	for unitY := 0; unitY < unitsDown; unitY++ {
+		for unitX := 0; unitX < unitsAcross; unitX++ {
+			unit := data[(unitY*unitsAcross+unitX)*unitSize:]
+
+			for y := 0; y < subsampleV; y++ {
+				copy(ycbcrImage.Y[(unitY*subsampleV+y)*ycbcrImage.YStride+unitX*subsampleH:], unit[y*subsampleH:(y+1)*subsampleH])
+			}
+
+			chromaOffset := unitY*ycbcrImage.CStride + unitX
+			ycbcrImage.Cb[chromaOffset] = unit[subsampleH*subsampleV]
+			ycbcrImage.Cr[chromaOffset] = unit[subsampleH*subsampleV+1]
+		}
+	}
+
+	// image.YCbCr replicates each chroma sample across the pixels it covers, which matches centred chroma
+	if parameters.matchesJFIF() && (!parameters.cosited || (subsampleH == 1 && subsampleV == 1)) {
+		return ycbcrImage, nil
+	}
+
+	rgbImage := tiffimage.NewRGB(ycbcrImage.Rect)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var cb, cr float64
+
+			if parameters.cosited {
+				cb, cr = interpolateChroma(ycbcrImage, x, y, subsampleH, subsampleV, unitsAcross, unitsDown)
+			} else {
+				offset := ycbcrImage.COffset(x, y)
+				cb, cr = float64(ycbcrImage.Cb[offset]), float64(ycbcrImage.Cr[offset])
+			}
+
+			rgbImage.SetRGB(x, y, parameters.toRGB(float64(ycbcrImage.Y[ycbcrImage.YOffset(x, y)]), cb, cr))
+		}
+	}
+
+	return rgbImage, nil
+}
+
+// interpolateChroma returns the chroma at pixel (x, y) of cosited chroma samples, which are positioned on the first
+// pixel covered by each sample, by bilinear interpolation of the surrounding samples.
+func interpolateChroma(img *image.YCbCr, x, y, subsampleH, subsampleV, unitsAcross, unitsDown int) (float64, float64) {
+	chromaX0, chromaY0 := x/subsampleH, y/subsampleV
+	chromaX1, chromaY1 := chromaX0+1, chromaY0+1
+	if chromaX1 >= unitsAcross {
+		chromaX1 = chromaX0
+	}
+	if chromaY1 >= unitsDown {
+		chromaY1 = chromaY0
+	}
+
+	weightX := float64(x%subsampleH) / float64(subsampleH)
+	weightY := float64(y%subsampleV) / float64(subsampleV)
+
+	interpolate := func(plane []uint8) float64 {
+		top := float64(plane[chromaY0*img.CStride+chromaX0])*(1-weightX) + float64(plane[chromaY0*img.CStride+chromaX1])*weightX
+		bottom := float64(plane[chromaY1*img.CStride+chromaX0])*(1-weightX) + float64(plane[chromaY1*img.CStride+chromaX1])*weightX
+
+		return top*(1-weightY) + bottom*weightY
+	}
+
+	return interpolate(img.Cb), interpolate(img.Cr)
+}
+
+// decodeCIELab creates an RGB image from uncompressed 8 or 16-bit CIELab data. L* is stored unsigned, scaled to the
+// range of the sample, and a* and b* are signed. Colours are converted using a D50 white point, as used by ICC
+// profiles.
+func (dataAccess *baseDataAccess) decodeCIELab(data []byte, rect image.Rectangle) (image.Image, error) {
+	bitsPerSample := dataAccess.bitsPerSample[0]
+	if dataAccess.samplesPerPixel != 3 || (bitsPerSample != 8 && bitsPerSample != 16) || dataAccess.BitsPerPixel() != 3*uint32(bitsPerSample) {
+		return nil, &FormatError{msg: fmt.Sprintf("[GetImage>CIELab] Unsupported BitsPerSample %v with %d SamplesPerPixel", dataAccess.bitsPerSample, dataAccess.samplesPerPixel)}
+	}
+
+	endian := dataAccess.tiffFile.header.Endian
+	rgbImage := tiffimage.NewRGB(rect)
+
+	for pixel := 0; pixel < rect.Dx()*rect.Dy(); pixel++ {
+		var l, a, b float64
+
+		if bitsPerSample == 8 {
+			l = float64(data[pixel*3]) * 100 / 255
+			a = float64(int8(data[pixel*3+1]))
+			b = float64(int8(data[pixel*3+2]))
+		} else {
+			l = float64(endian.Uint16(data[pixel*6:])) * 100 / 65535
+			a = float64(int16(endian.Uint16(data[pixel*6+2:]))) / 256
+			b = float64(int16(endian.Uint16(data[pixel*6+4:]))) / 256
+		}
+
+		rgbImage.SetRGB(pixel%rect.Dx(), pixel/rect.Dx(), labToRGB(l, a, b))
+	}
+
+	return rgbImage, nil
+}
+
+// labToRGB converts a CIELab colour (D50) to sRGB.
+func labToRGB(l, a, b float64) tiffcolor.RGB {
+	// D50 reference white
+	const whiteX, whiteY, whiteZ = 0.96422, 1.0, 0.82521
+
+	fy := (l + 16) / 116
+	fx := fy + a/500
+	fz := fy - b/200
+
+	labInverse := func(t float64) float64 {
+		if t > 6.0/29 {
+			return t * t * t
+		}
+
+		return 3 * (6.0 / 29) * (6.0 / 29) * (t - 4.0/29)
+	}
+
+	x := whiteX * labInverse(fx)
+	y := whiteY * labInverse(fy)
+	z := whiteZ * labInverse(fz)
+
+	// XYZ (D50) to linear sRGB, using the Bradford adaptation to D65
+	red := 3.1338561*x - 1.6168667*y - 0.4906146*z
+	green := -0.9787684*x + 1.9161415*y + 0.0334540*z
+	blue := 0.0719453*x - 0.2289914*y + 1.4052427*z
+
+	return tiffcolor.RGB{R: clampToUint8(255 * sRGBCompand(red)), G: clampToUint8(255 * sRGBCompand(green)), B: clampToUint8(255 * sRGBCompand(blue))}
+}
+
+// sRGBCompand applies the sRGB transfer function to a linear value.
+func sRGBCompand(value float64) float64 {
+	if value <= 0.0031308 {
+		return 12.92 * value
+	}
+
+	return 1.055*math.Pow(value, 1/2.4) - 0.055
+}
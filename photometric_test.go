@@ -0,0 +1,285 @@
+package gobio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"reflect"
+	"testing"
+
+	tiffimage "github.com/AlanRace/go-bio/image"
+	tiffcolor "github.com/AlanRace/go-bio/image/color"
+	"github.com/AlanRace/go-bio/test/tifftest"
+)
+
+// decodePhotometric creates a single strip image from data and returns the image of the strip. addTags can add any
+// tags needed to describe the data.
+func decodePhotometric(t *testing.T, order binary.ByteOrder, width, height int, photometric PhotometricInterpretationID, bitsPerSample []uint16, data []byte, addTags func(*tifftest.Directory)) image.Image {
+	t.Helper()
+
+	builder := tifftest.New(order, false)
+	directory := builder.AddDirectory().
+		Add(uint16(ImageWidth), tifftest.Long, []uint32{uint32(width)}).
+		Add(uint16(ImageLength), tifftest.Long, []uint32{uint32(height)}).
+		Add(uint16(BitsPerSample), tifftest.Short, bitsPerSample).
+		Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(photometric)}).
+		Add(uint16(SamplesPerPixel), tifftest.Short, []uint16{uint16(len(bitsPerSample))}).
+		Add(uint16(RowsPerStrip), tifftest.Long, []uint32{uint32(height)}).
+		Strips(data)
+	if addTags != nil {
+		addTags(directory)
+	}
+	tiffData := builder.Bytes()
+
+	tiffFile, err := OpenReaderWithOptions(bytes.NewReader(tiffData), int64(len(tiffData)), OpenOptions{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := tiffFile.GetIFD(0).GetSection(0).GetImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return img
+}
+
+func TestWhiteIsZero(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 3, 1))
+	copy(gray.Pix, []uint8{255, 128, 0})
+
+	img := decodePhotometric(t, binary.LittleEndian, 3, 1, WhiteIsZero, []uint16{8}, []byte{0, 127, 255}, nil)
+	if !reflect.DeepEqual(img, gray) {
+		t.Errorf("8-bit: got %v, want %v", img, gray)
+	}
+
+	gray16 := image.NewGray16(image.Rect(0, 0, 2, 1))
+	copy(gray16.Pix, []uint8{0xff, 0xfe, 0x00, 0x00})
+
+	img = decodePhotometric(t, binary.LittleEndian, 2, 1, WhiteIsZero, []uint16{16}, []byte{0x01, 0x00, 0xff, 0xff}, nil)
+	if !reflect.DeepEqual(img, gray16) {
+		t.Errorf("16-bit: got %v, want %v", img, gray16)
+	}
+}
+
+func TestPalette(t *testing.T) {
+	colorMap := make([]uint16, 3*256)
+	colorMap[1], colorMap[256+1], colorMap[512+1] = 0xffff, 0x8000, 0
+	colorMap[255], colorMap[256+255], colorMap[512+255] = 0, 0, 0xffff
+
+	img := decodePhotometric(t, binary.BigEndian, 3, 1, PaletteColour, []uint16{8}, []byte{1, 255, 0}, func(directory *tifftest.Directory) {
+		directory.Add(uint16(ColorMap), tifftest.Short, colorMap)
+	})
+
+	paletted, ok := img.(*image.Paletted)
+	if !ok {
+		t.Fatalf("expected *image.Paletted, got %T", img)
+	}
+
+	want := []color.Color{
+		color.RGBA64{R: 0xffff, G: 0x8000, A: 0xffff},
+		color.RGBA64{B: 0xffff, A: 0xffff},
+		color.RGBA64{A: 0xffff},
+	}
+	for x, c := range want {
+		if paletted.At(x, 0) != c {
+			t.Errorf("pixel %d: got %v, want %v", x, paletted.At(x, 0), c)
+		}
+	}
+}
+
+func TestCMYK(t *testing.T) {
+	data := []byte{0, 0, 0, 0, 255, 0, 0, 0, 10, 20, 30, 40}
+
+	want := image.NewCMYK(image.Rect(0, 0, 3, 1))
+	copy(want.Pix, data)
+
+	img := decodePhotometric(t, binary.LittleEndian, 3, 1, CMYK, []uint16{8, 8, 8, 8}, data, func(directory *tifftest.Directory) {
+		directory.Add(uint16(InkSet), tifftest.Short, []uint16{1})
+	})
+	if !reflect.DeepEqual(img, want) {
+		t.Errorf("got %v, want %v", img, want)
+	}
+
+	if CMYK.String() != "CMYK (5)" {
+		t.Errorf("unexpected name %q", CMYK.String())
+	}
+}
+
+func TestYCbCr(t *testing.T) {
+	t.Run("Subsampled", func(t *testing.T) {
+		// 4:2:2 with a width of 3, so each row is padded to 2 units of 2 pixels
+		data := []byte{
+			10, 20, 100, 110, 30, 40, 120, 130,
+			50, 60, 140, 150, 70, 80, 160, 170,
+		}
+
+		full := image.NewYCbCr(image.Rect(0, 0, 4, 2), image.YCbCrSubsampleRatio422)
+		copy(full.Y, []uint8{10, 20, 30, 40, 50, 60, 70, 80})
+		copy(full.Cb, []uint8{100, 120, 140, 160})
+		copy(full.Cr, []uint8{110, 130, 150, 170})
+		want := full.SubImage(image.Rect(0, 0, 3, 2))
+
+		img := decodePhotometric(t, binary.LittleEndian, 3, 2, YCbCr, []uint16{8, 8, 8}, data, func(directory *tifftest.Directory) {
+			directory.Add(uint16(YCbCrSubSampling), tifftest.Short, []uint16{2, 1})
+		})
+		if !reflect.DeepEqual(img, want) {
+			t.Errorf("got %v, want %v", img, want)
+		}
+	})
+
+	t.Run("Cosited", func(t *testing.T) {
+		const y = 100
+		data := []byte{y, y, 128, 128, y, y, 128, 168}
+
+		// Pixel 1 lies half way between the two chroma samples, pixel 3 is beyond the last sample
+		jfif := func(cr float64) tiffcolor.RGB {
+			return tiffcolor.RGB{R: uint8(math.Round(y + 1.402*(cr-128))), G: uint8(math.Round(y - 0.714136*(cr-128))), B: y}
+		}
+		want := []tiffcolor.RGB{jfif(128), jfif(148), jfif(168), jfif(168)}
+
+		img := decodePhotometric(t, binary.LittleEndian, 4, 1, YCbCr, []uint16{8, 8, 8}, data, func(directory *tifftest.Directory) {
+			directory.Add(uint16(YCbCrSubSampling), tifftest.Short, []uint16{2, 1})
+			directory.Add(uint16(YCbCrPositioning), tifftest.Short, []uint16{2})
+		})
+
+		rgbImage, ok := img.(*tiffimage.RGB)
+		if !ok {
+			t.Fatalf("expected *image.RGB, got %T", img)
+		}
+		for x, c := range want {
+			if got := rgbImage.RGBAt(x, 0); !closeRGB(got, c, 1) {
+				t.Errorf("pixel %d: got %v, want %v", x, got, c)
+			}
+		}
+	})
+
+	t.Run("ReferenceBlackWhite", func(t *testing.T) {
+		data := []byte{16, 128, 128, 235, 128, 128}
+
+		img := decodePhotometric(t, binary.BigEndian, 2, 1, YCbCr, []uint16{8, 8, 8}, data, func(directory *tifftest.Directory) {
+			directory.Add(uint16(YCbCrSubSampling), tifftest.Short, []uint16{1, 1})
+			directory.Add(uint16(ReferenceBlackWhite), tifftest.Rational, []uint32{16, 1, 235, 1, 128, 1, 240, 1, 128, 1, 240, 1})
+		})
+
+		rgbImage, ok := img.(*tiffimage.RGB)
+		if !ok {
+			t.Fatalf("expected *image.RGB, got %T", img)
+		}
+		if got := rgbImage.RGBAt(0, 0); got != (tiffcolor.RGB{}) {
+			t.Errorf("expected black, got %v", got)
+		}
+		if got := rgbImage.RGBAt(1, 0); got != (tiffcolor.RGB{R: 255, G: 255, B: 255}) {
+			t.Errorf("expected white, got %v", got)
+		}
+	})
+}
+
+func TestCIELab(t *testing.T) {
+	white := tiffcolor.RGB{R: 255, G: 255, B: 255}
+	red := tiffcolor.RGB{R: 255}
+
+	// 8-bit a* and b* are signed, so 81 and 70 give the D50 Lab coordinates of sRGB red
+	img := decodePhotometric(t, binary.LittleEndian, 3, 1, CIELab, []uint16{8, 8, 8}, []byte{255, 0, 0, 0, 0, 0, 138, 81, 70}, nil)
+
+	rgbImage, ok := img.(*tiffimage.RGB)
+	if !ok {
+		t.Fatalf("expected *image.RGB, got %T", img)
+	}
+	for x, c := range []tiffcolor.RGB{white, {}, red} {
+		if got := rgbImage.RGBAt(x, 0); !closeRGB(got, c, 6) {
+			t.Errorf("8-bit pixel %d: got %v, want %v", x, got, c)
+		}
+	}
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		data := make([]byte, 12)
+		order.PutUint16(data[0:], 0xffff)
+		order.PutUint16(data[6:], 0x8aa3)
+		order.PutUint16(data[8:], uint16(int16(81*256)))
+		order.PutUint16(data[10:], uint16(int16(70*256)))
+
+		img := decodePhotometric(t, order, 2, 1, CIELab, []uint16{16, 16, 16}, data, nil)
+		rgbImage := img.(*tiffimage.RGB)
+
+		for x, c := range []tiffcolor.RGB{white, red} {
+			if got := rgbImage.RGBAt(x, 0); !closeRGB(got, c, 6) {
+				t.Errorf("%v 16-bit pixel %d: got %v, want %v", order, x, got, c)
+			}
+		}
+	}
+}
+
+func closeRGB(a, b tiffcolor.RGB, tolerance int) bool {
+	near := func(x, y uint8) bool {
+		difference := int(x) - int(y)
+		return difference <= tolerance && difference >= -tolerance
+	}
+
+	return near(a.R, b.R) && near(a.G, b.G) && near(a.B, b.B)
+}
+
+func TestExtraSamples(t *testing.T) {
+	rect := image.Rect(0, 0, 2, 1)
+
+	rgba := image.NewRGBA(rect)
+	copy(rgba.Pix, []uint8{10, 20, 30, 40, 50, 60, 70, 80})
+
+	nrgba := image.NewNRGBA(rect)
+	copy(nrgba.Pix, rgba.Pix)
+
+	grayAlpha := image.NewNRGBA(rect)
+	copy(grayAlpha.Pix, []uint8{10, 10, 10, 20, 30, 30, 30, 40})
+
+	nrgba64 := image.NewNRGBA64(rect)
+	copy(nrgba64.Pix, []uint8{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10})
+
+	// The 16-bit values of nrgba64 stored little endian
+	littleEndian16 := []uint8{0x02, 0x01, 0x04, 0x03, 0x06, 0x05, 0x08, 0x07, 0x0a, 0x09, 0x0c, 0x0b, 0x0e, 0x0d, 0x10, 0x0f}
+
+	multiChannel := tiffimage.NewMultiChannel(rect, 4, 1, 3)
+	copy(multiChannel.Pix, rgba.Pix)
+
+	fiveChannel := tiffimage.NewMultiChannel(rect, 5, 1, 3)
+	copy(fiveChannel.Pix, []uint8{1, 2, 3, 4, 5, 6, 7, 8, 9, 10})
+
+	tests := []struct {
+		name          string
+		photometric   PhotometricInterpretationID
+		bitsPerSample []uint16
+		extraSamples  []uint16
+		data          []byte
+		want          image.Image
+	}{
+		{"AssociatedAlpha", RGB, []uint16{8, 8, 8, 8}, []uint16{1}, rgba.Pix, rgba},
+		{"UnassociatedAlpha", RGB, []uint16{8, 8, 8, 8}, []uint16{2}, rgba.Pix, nrgba},
+		{"UnassociatedAlpha16", RGB, []uint16{16, 16, 16, 16}, []uint16{2}, littleEndian16, nrgba64},
+		{"GrayAlpha", BlackIsZero, []uint16{8, 8}, []uint16{2}, []byte{10, 20, 30, 40}, grayAlpha},
+		{"Unspecified", RGB, []uint16{8, 8, 8, 8}, []uint16{0}, rgba.Pix, multiChannel},
+		{"MissingExtraSamples", RGB, []uint16{8, 8, 8, 8}, nil, rgba.Pix, multiChannel},
+		{"AlphaAndUnspecified", RGB, []uint16{8, 8, 8, 8, 8}, []uint16{2, 0}, fiveChannel.Pix, fiveChannel},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img := decodePhotometric(t, binary.LittleEndian, rect.Dx(), rect.Dy(), test.photometric, test.bitsPerSample, test.data, func(directory *tifftest.Directory) {
+				if test.extraSamples != nil {
+					directory.Add(uint16(ExtraSamples), tifftest.Short, test.extraSamples)
+				}
+			})
+
+			if !reflect.DeepEqual(img, test.want) {
+				t.Errorf("got %v, want %v", img, test.want)
+			}
+		})
+	}
+
+	if got := multiChannel.At(1, 0); got != (color.RGBA64{R: 50 * 0x101, G: 60 * 0x101, B: 70 * 0x101, A: 0xffff}) {
+		t.Errorf("unexpected colour %v", got)
+	}
+	if got := multiChannel.ChannelAt(1, 0, 3); got != 80*0x101 {
+		t.Errorf("unexpected extra channel value %d", got)
+	}
+}
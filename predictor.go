@@ -0,0 +1,109 @@
+package gobio
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// reversePredictor undoes the differencing applied to each row of uncompressed data before it was compressed. The
+// data holds samplesPerPixel samples for each pixel, starting with the sample firstSample, which must all be the same
+// size.
+func (dataAccess *baseDataAccess) reversePredictor(data []byte, firstSample, samplesPerPixel int) error {
+	if !dataAccess.HasTag(Predictor) || dataAccess.GetPredictor() == PredictorNone {
+		return nil
+	}
+
+	if len(dataAccess.bitsPerSample) < firstSample+samplesPerPixel {
+		return &FormatError{msg: fmt.Sprintf("BitsPerSample %v does not describe all %d samples", dataAccess.bitsPerSample, dataAccess.samplesPerPixel)}
+	}
+
+	bitsPerSample := int(dataAccess.bitsPerSample[firstSample])
+	for _, bits := range dataAccess.bitsPerSample[firstSample : firstSample+samplesPerPixel] {
+		if int(bits) != bitsPerSample {
+			return &FormatError{msg: fmt.Sprintf("Unable to apply %s to samples of different sizes %v", predictorNameMap[dataAccess.GetPredictor()], dataAccess.bitsPerSample)}
+		}
+	}
+
+	// Rows are stored at the full width of the section, including any padding
+	width, _ := dataAccess.ifd.GetSectionDimensions()
+	rowSize := int(width) * samplesPerPixel * bitsPerSample / 8
+	if rowSize == 0 {
+		return nil
+	}
+
+	endian := dataAccess.tiffFile.header.Endian
+
+	switch dataAccess.GetPredictor() {
+	case PredictorHorizontal:
+		if bitsPerSample != 8 && bitsPerSample != 16 && bitsPerSample != 32 && bitsPerSample != 64 {
+			return &FormatError{msg: fmt.Sprintf("Unsupported BitsPerSample %v for %s", dataAccess.bitsPerSample, predictorNameMap[PredictorHorizontal])}
+		}
+
+		for row := 0; row+rowSize <= len(data); row += rowSize {
+			horizontalAccumulate(data[row:row+rowSize], samplesPerPixel, bitsPerSample/8, endian)
+		}
+	case PredictorFloatingPoint:
+		if bitsPerSample != 16 && bitsPerSample != 24 && bitsPerSample != 32 && bitsPerSample != 64 {
+			return &FormatError{msg: fmt.Sprintf("Unsupported BitsPerSample %v for %s", dataAccess.bitsPerSample, predictorNameMap[PredictorFloatingPoint])}
+		}
+
+		rowBuffer := make([]byte, rowSize)
+		for row := 0; row+rowSize <= len(data); row += rowSize {
+			floatingPointAccumulate(data[row:row+rowSize], rowBuffer, samplesPerPixel, bitsPerSample/8, endian)
+		}
+	default:
+		predictor, _ := dataAccess.ifd.GetShortTagValue(Predictor)
+		return &FormatError{msg: fmt.Sprintf("Unsupported Predictor %d", predictor)}
+	}
+
+	return nil
+}
+
+// horizontalAccumulate reverses horizontal differencing on a row of samples of bytesPerSample bytes, stored with the
+// byte order of the file. Each sample is stored as the difference from the same sample of the previous pixel.
+func horizontalAccumulate(row []byte, samplesPerPixel, bytesPerSample int, endian binary.ByteOrder) {
+	stride := samplesPerPixel * bytesPerSample
+
+	switch bytesPerSample {
+	case 1:
+		for i := stride; i < len(row); i++ {
+			row[i] += row[i-stride]
+		}
+	case 2:
+		for i := stride; i < len(row); i += 2 {
+			endian.PutUint16(row[i:], endian.Uint16(row[i:])+endian.Uint16(row[i-stride:]))
+		}
+	case 4:
+		for i := stride; i < len(row); i += 4 {
+			endian.PutUint32(row[i:], endian.Uint32(row[i:])+endian.Uint32(row[i-stride:]))
+		}
+	case 8:
+		for i := stride; i < len(row); i += 8 {
+			endian.PutUint64(row[i:], endian.Uint64(row[i:])+endian.Uint64(row[i-stride:]))
+		}
+	}
+}
+
+// floatingPointAccumulate reverses the floating point predictor on a row of samples. The bytes of the samples are
+// stored in planes, starting with the most significant byte of every sample, and each byte is differenced with the
+// same byte of the previous pixel. The samples are restored in the byte order of the file. buffer must be the same
+// size as row.
+func floatingPointAccumulate(row []byte, buffer []byte, samplesPerPixel, bytesPerSample int, endian binary.ByteOrder) {
+	for i := samplesPerPixel; i < len(row); i++ {
+		row[i] += row[i-samplesPerPixel]
+	}
+
+	copy(buffer, row)
+
+	numSamples := len(row) / bytesPerSample
+	for sample := 0; sample < numSamples; sample++ {
+		for b := 0; b < bytesPerSample; b++ {
+			// Byte plane b holds the bth most significant byte of each sample
+			if endian == binary.BigEndian {
+				row[sample*bytesPerSample+b] = buffer[b*numSamples+sample]
+			} else {
+				row[sample*bytesPerSample+bytesPerSample-1-b] = buffer[b*numSamples+sample]
+			}
+		}
+	}
+}
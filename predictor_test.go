@@ -0,0 +1,163 @@
+package gobio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	tiffimage "github.com/AlanRace/go-bio/image"
+	"github.com/AlanRace/go-bio/test/tifftest"
+)
+
+// horizontalDifference applies the horizontal predictor to each row of data, the reverse of horizontalAccumulate.
+func horizontalDifference(data []byte, rowSize, samplesPerPixel, bytesPerSample int, endian binary.ByteOrder) {
+	stride := samplesPerPixel * bytesPerSample
+
+	for row := 0; row < len(data); row += rowSize {
+		for i := row + rowSize - bytesPerSample; i >= row+stride; i -= bytesPerSample {
+			switch bytesPerSample {
+			case 1:
+				data[i] -= data[i-stride]
+			case 2:
+				endian.PutUint16(data[i:], endian.Uint16(data[i:])-endian.Uint16(data[i-stride:]))
+			case 4:
+				endian.PutUint32(data[i:], endian.Uint32(data[i:])-endian.Uint32(data[i-stride:]))
+			case 8:
+				endian.PutUint64(data[i:], endian.Uint64(data[i:])-endian.Uint64(data[i-stride:]))
+			}
+		}
+	}
+}
+
+// floatingPointDifference applies the floating point predictor to each row of data, the reverse of
+// floatingPointAccumulate.
+func floatingPointDifference(data []byte, rowSize, samplesPerPixel, bytesPerSample int, endian binary.ByteOrder) {
+	numSamples := rowSize / bytesPerSample
+	buffer := make([]byte, rowSize)
+
+	for row := 0; row < len(data); row += rowSize {
+		for sample := 0; sample < numSamples; sample++ {
+			for b := 0; b < bytesPerSample; b++ {
+				if endian == binary.BigEndian {
+					buffer[b*numSamples+sample] = data[row+sample*bytesPerSample+b]
+				} else {
+					buffer[b*numSamples+sample] = data[row+sample*bytesPerSample+bytesPerSample-1-b]
+				}
+			}
+		}
+
+		for i := rowSize - 1; i >= samplesPerPixel; i-- {
+			buffer[i] -= buffer[i-samplesPerPixel]
+		}
+
+		copy(data[row:], buffer)
+	}
+}
+
+func TestPredictor(t *testing.T) {
+	const width, height = 5, 3
+	rect := image.Rect(0, 0, width, height)
+
+	gray := image.NewGray(rect)
+	gray16 := image.NewGray16(rect)
+	gray32 := tiffimage.NewGray32(rect)
+	grayFloat32 := tiffimage.NewGrayFloat32(rect)
+	grayFloat64 := tiffimage.NewGrayFloat64(rect)
+	rgb := tiffimage.NewRGB(rect)
+	for i := 0; i < width*height; i++ {
+		gray.Pix[i] = uint8(i * 37)
+		gray16.SetGray16(i%width, i/width, color.Gray16{Y: uint16(i * 4099)})
+		gray32.Pix[i] = uint32(i) * 0x10203041
+		grayFloat32.Pix[i] = float32(i)*1.25 - 3
+		grayFloat64.Pix[i] = float64(i)*1e10 - 7.5
+		rgb.Pix[i*3], rgb.Pix[i*3+1], rgb.Pix[i*3+2] = uint8(i*11), uint8(255-i*3), uint8(i*i)
+	}
+	grayFloat32.MaxValue = grayFloat32.Pix[width*height-1]
+	grayFloat64.MaxValue = grayFloat64.Pix[width*height-1]
+
+	tests := []struct {
+		name            string
+		predictor       PredictorID
+		photometric     PhotometricInterpretationID
+		samplesPerPixel int
+		bitsPerSample   int
+		sampleFormat    SampleFormatID
+		values          interface{}
+		want            image.Image
+	}{
+		{"Horizontal8", PredictorHorizontal, BlackIsZero, 1, 8, SampleFormatUInt, gray.Pix, gray},
+		{"HorizontalRGB8", PredictorHorizontal, RGB, 3, 8, SampleFormatUInt, rgb.Pix, rgb},
+		{"Horizontal16", PredictorHorizontal, BlackIsZero, 1, 16, SampleFormatUInt, gray16Values(gray16), gray16},
+		{"Horizontal32", PredictorHorizontal, BlackIsZero, 1, 32, SampleFormatUInt, gray32.Pix, gray32},
+		{"HorizontalFloat64", PredictorHorizontal, BlackIsZero, 1, 64, SampleFormatFloat, grayFloat64.Pix, grayFloat64},
+		{"FloatingPoint32", PredictorFloatingPoint, BlackIsZero, 1, 32, SampleFormatFloat, grayFloat32.Pix, grayFloat32},
+		{"FloatingPoint64", PredictorFloatingPoint, BlackIsZero, 1, 64, SampleFormatFloat, grayFloat64.Pix, grayFloat64},
+	}
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for _, test := range tests {
+			t.Run(order.String()+"/"+test.name, func(t *testing.T) {
+				var buf bytes.Buffer
+				binary.Write(&buf, order, test.values)
+				data := buf.Bytes()
+
+				bytesPerSample := test.bitsPerSample / 8
+				rowSize := width * test.samplesPerPixel * bytesPerSample
+				if test.predictor == PredictorHorizontal {
+					horizontalDifference(data, rowSize, test.samplesPerPixel, bytesPerSample, order)
+				} else {
+					floatingPointDifference(data, rowSize, test.samplesPerPixel, bytesPerSample, order)
+				}
+
+				bitsPerSample := make([]uint16, test.samplesPerPixel)
+				sampleFormat := make([]uint16, test.samplesPerPixel)
+				for i := range bitsPerSample {
+					bitsPerSample[i] = uint16(test.bitsPerSample)
+					sampleFormat[i] = uint16(test.sampleFormat)
+				}
+
+				// Two rows per strip, so that the second strip is a single row
+				builder := tifftest.New(order, false)
+				builder.AddDirectory().
+					Add(uint16(ImageWidth), tifftest.Long, []uint32{width}).
+					Add(uint16(ImageLength), tifftest.Long, []uint32{height}).
+					Add(uint16(BitsPerSample), tifftest.Short, bitsPerSample).
+					Add(uint16(Compression), tifftest.Short, []uint16{uint16(AdobeDeflate)}).
+					Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(test.photometric)}).
+					Add(uint16(SamplesPerPixel), tifftest.Short, []uint16{uint16(test.samplesPerPixel)}).
+					Add(uint16(RowsPerStrip), tifftest.Long, []uint32{2}).
+					Add(uint16(Predictor), tifftest.Short, []uint16{uint16(test.predictor)}).
+					Add(uint16(SampleFormat), tifftest.Short, sampleFormat).
+					Strips(deflate(data[:2*rowSize]), deflate(data[2*rowSize:]))
+				tiffData := builder.Bytes()
+
+				tiffFile, err := OpenReaderWithOptions(bytes.NewReader(tiffData), int64(len(tiffData)), OpenOptions{Strict: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				img, err := tiffFile.GetIFD(0).GetImage()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !reflect.DeepEqual(img, test.want) {
+					t.Errorf("got %v, want %v", img, test.want)
+				}
+			})
+		}
+	}
+}
+
+// gray16Values returns the values of img in native form, ready to be written with binary.Write.
+func gray16Values(img *image.Gray16) []uint16 {
+	values := make([]uint16, len(img.Pix)/2)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(img.Pix[i*2:])
+	}
+
+	return values
+}
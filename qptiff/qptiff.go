@@ -3,6 +3,7 @@ package qptiff
 import (
 	"encoding/xml"
 	"fmt"
+	"io"
 	"strings"
 
 	tiff "github.com/AlanRace/go-bio"
@@ -82,9 +83,44 @@ type FormatError struct {
 
 func (e *FormatError) Error() string { return e.msg }
 
+// Open opens the qptiff file at the specified path.
 func Open(path string) (*File, error) {
+	return OpenWithOptions(path, tiff.OpenOptions{})
+}
+
+// OpenWithOptions opens the qptiff file at the specified path, parsing it as described by options.
+func OpenWithOptions(path string, options tiff.OpenOptions) (*File, error) {
+	tiffFile, err := tiff.OpenWithOptions(path, options)
+	if err != nil {
+		return nil, err
+	}
+
+	qptiffFile, err := newFile(tiffFile)
+	if err != nil {
+		tiffFile.Close()
+		return nil, err
+	}
+
+	return qptiffFile, nil
+}
+
+// OpenReader parses qptiff data available through r, which is size bytes long.
+func OpenReader(r io.ReaderAt, size int64) (*File, error) {
+	return OpenReaderWithOptions(r, size, tiff.OpenOptions{})
+}
+
+// OpenReaderWithOptions parses qptiff data available through r, which is size bytes long, as described by options.
+func OpenReaderWithOptions(r io.ReaderAt, size int64, options tiff.OpenOptions) (*File, error) {
+	tiffFile, err := tiff.OpenReaderWithOptions(r, size, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFile(tiffFile)
+}
+
+func newFile(tiffFile *tiff.File) (*File, error) {
 	var qptiffFile File
-	tiffFile, err := tiff.Open(path)
 
 	qptiffFile.File = *tiffFile
 	qptiffFile.FilterMap = make(map[string]*Filter)
@@ -148,7 +184,7 @@ func Open(path string) (*File, error) {
 		fo.Close()*/
 	}
 
-	return &qptiffFile, err
+	return &qptiffFile, nil
 }
 
 /** https://github.com/openmicroscopy/bioformats/blob/develop/components/formats-gpl/src/loci/formats/in/VectraReader.java
@@ -1,16 +1,26 @@
 package qptiff
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"image/png"
 	"log"
-	"testing"
 	"os"
-	"image/png"
+	"testing"
+
+	tiff "github.com/AlanRace/go-bio"
+	"github.com/AlanRace/go-bio/test/tifftest"
 )
 
 func TestLoad(t *testing.T) {
 	filename := "C:\\Work\\PuffPiece\\kidney msi-if-imc_Scan1.qptiff"
 
+	if _, err := os.Stat(filename); err != nil {
+		t.Skipf("test data not available: %v", err)
+	}
+
 	qptiffFile, err := Open(filename)
 	if err != nil {
 		log.Fatal(err)
@@ -40,7 +50,7 @@ func TestLoad(t *testing.T) {
 	img.Pix = data*/
 
 	dapi := qptiffFile.FilterMap["DAPI"]
-	
+
 	for _, ifd := range dapi.IFDList {
 		fmt.Println(ifd.GetImageDimensions())
 		fmt.Println(ifd.GetResolution())
@@ -82,3 +92,88 @@ func TestLoad(t *testing.T) {
 	png.Encode(f, img)
 	f.Close()
 }
+
+// newQPTIFFData creates a qptiff file with a DAPI image, thumbnail and reduced resolution image. modify, if not nil,
+// is called with each directory.
+func newQPTIFFData(modify func(index int, directory *tifftest.Directory)) []byte {
+	descriptions := []string{
+		"<PerkinElmer-QPI-ImageDescription><DescriptionVersion>2</DescriptionVersion><ImageType>FullResolution</ImageType><Name>DAPI</Name></PerkinElmer-QPI-ImageDescription>",
+		"<PerkinElmer-QPI-ImageDescription><DescriptionVersion>2</DescriptionVersion><ImageType>Thumbnail</ImageType></PerkinElmer-QPI-ImageDescription>",
+		"<PerkinElmer-QPI-ImageDescription><DescriptionVersion>2</DescriptionVersion><ImageType>ReducedResolution</ImageType><Name>DAPI</Name></PerkinElmer-QPI-ImageDescription>",
+	}
+	widths := []uint32{32, 8, 16}
+
+	builder := tifftest.New(binary.LittleEndian, false)
+	for index, description := range descriptions {
+		directory := builder.AddDirectory().
+			Add(uint16(tiff.ImageWidth), tifftest.Long, []uint32{widths[index]}).
+			Add(uint16(tiff.ImageLength), tifftest.Long, []uint32{widths[index]}).
+			Add(uint16(tiff.BitsPerSample), tifftest.Short, []uint16{8}).
+			Add(uint16(tiff.PhotometricInterpretation), tifftest.Short, []uint16{1}).
+			Add(uint16(tiff.SamplesPerPixel), tifftest.Short, []uint16{1}).
+			Add(uint16(tiff.RowsPerStrip), tifftest.Long, []uint32{widths[index]}).
+			Add(uint16(tiff.ImageDescription), tifftest.ASCII, description).
+			Strips(make([]byte, widths[index]*widths[index]))
+
+		if modify != nil {
+			modify(index, directory)
+		}
+	}
+
+	return builder.Bytes()
+}
+
+func TestOpenReader(t *testing.T) {
+	data := newQPTIFFData(nil)
+
+	qptiffFile, err := OpenReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qptiffFile.Close()
+
+	if len(qptiffFile.FilterList) != 1 || qptiffFile.FilterList[0] != "DAPI" {
+		t.Fatalf("expected single DAPI filter, found %v", qptiffFile.FilterList)
+	}
+
+	if qptiffFile.Thumbnail == nil {
+		t.Fatal("expected thumbnail to be found")
+	}
+
+	img, err := qptiffFile.Thumbnail.GetImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 8 {
+		t.Errorf("expected thumbnail width of 8, found %d", img.Bounds().Dx())
+	}
+}
+
+func TestOpenReaderWithOptions(t *testing.T) {
+	data := newQPTIFFData(func(index int, directory *tifftest.Directory) {
+		if index == 2 {
+			directory.Remove(uint16(tiff.StripByteCounts))
+		}
+	})
+
+	_, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), tiff.OpenOptions{Strict: true})
+	if !errors.Is(err, tiff.ErrMissingTag) {
+		t.Errorf("expected ErrMissingTag in strict mode, found %v", err)
+	}
+
+	var warnings []error
+	qptiffFile, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), tiff.OpenOptions{
+		WarningHandler: func(warning error) { warnings = append(warnings, warning) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qptiffFile.Close()
+
+	if len(warnings) != 1 || !errors.Is(warnings[0], tiff.ErrMissingTag) {
+		t.Errorf("expected an ErrMissingTag warning, found %v", warnings)
+	}
+	if len(qptiffFile.Diagnostics()) != 1 {
+		t.Errorf("expected 1 diagnostic, found %v", qptiffFile.Diagnostics())
+	}
+}
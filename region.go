@@ -0,0 +1,297 @@
+package gobio
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"runtime"
+	"sync"
+
+	tiffimage "github.com/AlanRace/go-bio/image"
+)
+
+// GetImage returns the full image described by the ImageFileDirectory. For large (e.g. whole slide) images consider
+// using ReadRegion to access only the area required.
+func (ifd *ImageFileDirectory) GetImage() (image.Image, error) {
+	width, height := ifd.GetImageDimensions()
+
+	return ifd.ReadRegion(image.Rect(0, 0, int(width), int(height)))
+}
+
+// ReadRegion returns the region rect of the image, decoding and stitching together all of the sections (tiles or strips)
+// which overlap the region. The returned image has the same bounds as rect and, where possible, the same type as the
+// decoded sections. Any part of rect which lies outside of the image is left as zero.
+func (ifd *ImageFileDirectory) ReadRegion(rect image.Rectangle) (image.Image, error) {
+	if rect.Empty() {
+		return nil, fmt.Errorf("can't read empty region %v", rect)
+	}
+	if unavailable, ok := ifd.dataAccess.(*unavailableDataAccess); ok {
+		return nil, unavailable.err
+	}
+
+	width, height := ifd.GetImageDimensions()
+	imageBounds := image.Rect(0, 0, int(width), int(height))
+
+	sections := ifd.sectionsInRegion(rect.Intersect(imageBounds))
+	if len(sections) == 0 {
+		// The region is entirely outside of the image, but decode a section to determine the type of image to return
+		section := ifd.GetSection(0)
+		if section == nil {
+			return nil, &FormatError{msg: "Image does not contain any sections"}
+		}
+
+		sectionImg, err := section.GetImage()
+		if err != nil {
+			return nil, err
+		}
+
+		return newImageLike(sectionImg, rect), nil
+	}
+
+	// Decode the first section to determine the type of the output image
+	firstImg, err := sections[0].GetImage()
+	if err != nil {
+		return nil, err
+	}
+
+	dst := newImageLike(firstImg, rect)
+	compositeSection(dst, firstImg, sections[0].Bounds())
+
+	// The remaining sections can be decoded in parallel as each writes to a separate part of dst
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var firstErr error
+
+	tokens := make(chan struct{}, runtime.NumCPU())
+
+	for _, section := range sections[1:] {
+		wg.Add(1)
+		tokens <- struct{}{}
+
+		go func(section *Section) {
+			defer wg.Done()
+			defer func() { <-tokens }()
+
+			sectionImg, err := section.GetImage()
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+
+			compositeSection(dst, sectionImg, section.Bounds())
+		}(section)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return dst, nil
+}
+
+// sectionsInRegion returns all sections which overlap rect, which must lie within the image.
+func (ifd *ImageFileDirectory) sectionsInRegion(rect image.Rectangle) []*Section {
+	var sections []*Section
+
+	if rect.Empty() {
+		return sections
+	}
+
+	sectionWidth, sectionHeight := ifd.GetSectionDimensions()
+	gridX, _ := ifd.GetSectionGrid()
+
+	for y := rect.Min.Y / int(sectionHeight); y <= (rect.Max.Y-1)/int(sectionHeight); y++ {
+		for x := rect.Min.X / int(sectionWidth); x <= (rect.Max.X-1)/int(sectionWidth); x++ {
+			section := ifd.GetSection(uint32(y)*gridX + uint32(x))
+
+			if section != nil {
+				sections = append(sections, section)
+			}
+		}
+	}
+
+	return sections
+}
+
+// newImageLike creates a new, empty, image with bounds r which can hold the pixels of img without loss.
+func newImageLike(img image.Image, r image.Rectangle) draw.Image {
+	switch src := img.(type) {
+	case *image.Gray:
+		return image.NewGray(r)
+	case *image.Gray16:
+		return image.NewGray16(r)
+	case *image.RGBA:
+		return image.NewRGBA(r)
+	case *image.RGBA64:
+		return image.NewRGBA64(r)
+	case *image.NRGBA:
+		return image.NewNRGBA(r)
+	case *image.NRGBA64:
+		return image.NewNRGBA64(r)
+	case *image.CMYK:
+		return image.NewCMYK(r)
+	case *image.Paletted:
+		return image.NewPaletted(r, src.Palette)
+	case *tiffimage.RGB:
+		return tiffimage.NewRGB(r)
+	case *tiffimage.Gray32:
+		return tiffimage.NewGray32(r)
+	case *tiffimage.MultiChannel:
+		return tiffimage.NewMultiChannel(r, src.Channels, src.BytesPerChannel, src.ColourChannels)
+	case *tiffimage.GrayInt16:
+		return tiffimage.NewGrayInt16(r)
+	case *tiffimage.GrayInt32:
+		return tiffimage.NewGrayInt32(r)
+	case *tiffimage.GrayFloat32:
+		dst := tiffimage.NewGrayFloat32(r)
+		dst.MaxValue = src.MaxValue
+		return dst
+	case *tiffimage.GrayFloat64:
+		dst := tiffimage.NewGrayFloat64(r)
+		dst.MaxValue = src.MaxValue
+		return dst
+	case *image.YCbCr:
+		// YCbCr images can't be drawn into, so convert to RGB
+		return tiffimage.NewRGB(r)
+	default:
+		return image.NewRGBA64(r)
+	}
+}
+
+// compositeSection copies src into dst at the location described by bounds (in image coordinates). Where src and dst are
+// the same type the pixel data is copied directly, otherwise the colours are converted using draw.Draw.
+func compositeSection(dst draw.Image, src image.Image, bounds image.Rectangle) {
+	r := bounds.Intersect(dst.Bounds())
+	if r.Empty() {
+		return
+	}
+
+	// Location in src corresponding to r.Min
+	sp := src.Bounds().Min.Add(r.Min.Sub(bounds.Min))
+
+	switch dst := dst.(type) {
+	case *image.Gray:
+		if src, ok := src.(*image.Gray); ok {
+			copyRows(dst.Pix, dst.Stride, dst.PixOffset(r.Min.X, r.Min.Y), src.Pix, src.Stride, src.PixOffset(sp.X, sp.Y), r.Dx(), r.Dy())
+			return
+		}
+	case *image.Gray16:
+		if src, ok := src.(*image.Gray16); ok {
+			copyRows(dst.Pix, dst.Stride, dst.PixOffset(r.Min.X, r.Min.Y), src.Pix, src.Stride, src.PixOffset(sp.X, sp.Y), r.Dx()*2, r.Dy())
+			return
+		}
+	case *image.RGBA:
+		if src, ok := src.(*image.RGBA); ok {
+			copyRows(dst.Pix, dst.Stride, dst.PixOffset(r.Min.X, r.Min.Y), src.Pix, src.Stride, src.PixOffset(sp.X, sp.Y), r.Dx()*4, r.Dy())
+			return
+		}
+	case *image.RGBA64:
+		if src, ok := src.(*image.RGBA64); ok {
+			copyRows(dst.Pix, dst.Stride, dst.PixOffset(r.Min.X, r.Min.Y), src.Pix, src.Stride, src.PixOffset(sp.X, sp.Y), r.Dx()*8, r.Dy())
+			return
+		}
+	case *image.NRGBA:
+		if src, ok := src.(*image.NRGBA); ok {
+			copyRows(dst.Pix, dst.Stride, dst.PixOffset(r.Min.X, r.Min.Y), src.Pix, src.Stride, src.PixOffset(sp.X, sp.Y), r.Dx()*4, r.Dy())
+			return
+		}
+	case *image.NRGBA64:
+		if src, ok := src.(*image.NRGBA64); ok {
+			copyRows(dst.Pix, dst.Stride, dst.PixOffset(r.Min.X, r.Min.Y), src.Pix, src.Stride, src.PixOffset(sp.X, sp.Y), r.Dx()*8, r.Dy())
+			return
+		}
+	case *image.CMYK:
+		if src, ok := src.(*image.CMYK); ok {
+			copyRows(dst.Pix, dst.Stride, dst.PixOffset(r.Min.X, r.Min.Y), src.Pix, src.Stride, src.PixOffset(sp.X, sp.Y), r.Dx()*4, r.Dy())
+			return
+		}
+	case *image.Paletted:
+		if src, ok := src.(*image.Paletted); ok {
+			copyRows(dst.Pix, dst.Stride, dst.PixOffset(r.Min.X, r.Min.Y), src.Pix, src.Stride, src.PixOffset(sp.X, sp.Y), r.Dx(), r.Dy())
+			return
+		}
+	case *tiffimage.RGB:
+		if src, ok := src.(*tiffimage.RGB); ok {
+			copyRows(dst.Pix, dst.Stride, dst.PixOffset(r.Min.X, r.Min.Y), src.Pix, src.Stride, src.PixOffset(sp.X, sp.Y), r.Dx()*3, r.Dy())
+			return
+		}
+	case *tiffimage.MultiChannel:
+		if src, ok := src.(*tiffimage.MultiChannel); ok && src.Channels == dst.Channels && src.BytesPerChannel == dst.BytesPerChannel {
+			copyRows(dst.Pix, dst.Stride, dst.PixOffset(r.Min.X, r.Min.Y), src.Pix, src.Stride, src.PixOffset(sp.X, sp.Y), r.Dx()*dst.Channels*dst.BytesPerChannel, r.Dy())
+			return
+		}
+	case *tiffimage.Gray32:
+		if src, ok := src.(*tiffimage.Gray32); ok {
+			for y := 0; y < r.Dy(); y++ {
+				dstOffset := dst.PixOffset(r.Min.X, r.Min.Y+y)
+				srcOffset := src.PixOffset(sp.X, sp.Y+y)
+				copy(dst.Pix[dstOffset:dstOffset+r.Dx()], src.Pix[srcOffset:srcOffset+r.Dx()])
+			}
+			return
+		}
+	case *tiffimage.GrayInt16:
+		if src, ok := src.(*tiffimage.GrayInt16); ok {
+			for y := 0; y < r.Dy(); y++ {
+				dstOffset := dst.PixOffset(r.Min.X, r.Min.Y+y)
+				srcOffset := src.PixOffset(sp.X, sp.Y+y)
+				copy(dst.Pix[dstOffset:dstOffset+r.Dx()], src.Pix[srcOffset:srcOffset+r.Dx()])
+			}
+			return
+		}
+	case *tiffimage.GrayInt32:
+		if src, ok := src.(*tiffimage.GrayInt32); ok {
+			for y := 0; y < r.Dy(); y++ {
+				dstOffset := dst.PixOffset(r.Min.X, r.Min.Y+y)
+				srcOffset := src.PixOffset(sp.X, sp.Y+y)
+				copy(dst.Pix[dstOffset:dstOffset+r.Dx()], src.Pix[srcOffset:srcOffset+r.Dx()])
+			}
+			return
+		}
+	case *tiffimage.GrayFloat64:
+		if src, ok := src.(*tiffimage.GrayFloat64); ok {
+			for y := 0; y < r.Dy(); y++ {
+				dstOffset := dst.PixOffset(r.Min.X, r.Min.Y+y)
+				srcOffset := src.PixOffset(sp.X, sp.Y+y)
+				copy(dst.Pix[dstOffset:dstOffset+r.Dx()], src.Pix[srcOffset:srcOffset+r.Dx()])
+			}
+
+			if src.MaxValue > dst.MaxValue {
+				dst.MaxValue = src.MaxValue
+			}
+			return
+		}
+	case *tiffimage.GrayFloat32:
+		if src, ok := src.(*tiffimage.GrayFloat32); ok {
+			for y := 0; y < r.Dy(); y++ {
+				dstOffset := dst.PixOffset(r.Min.X, r.Min.Y+y)
+				srcOffset := src.PixOffset(sp.X, sp.Y+y)
+				copy(dst.Pix[dstOffset:dstOffset+r.Dx()], src.Pix[srcOffset:srcOffset+r.Dx()])
+			}
+
+			if src.MaxValue > dst.MaxValue {
+				dst.MaxValue = src.MaxValue
+			}
+			return
+		}
+	}
+
+	draw.Draw(dst, r, src, sp, draw.Src)
+}
+
+// copyRows copies rows of rowBytes bytes between two pixel buffers with different strides.
+func copyRows(dst []uint8, dstStride, dstOffset int, src []uint8, srcStride, srcOffset int, rowBytes, rows int) {
+	for y := 0; y < rows; y++ {
+		copy(dst[dstOffset:dstOffset+rowBytes], src[srcOffset:srcOffset+rowBytes])
+
+		dstOffset += dstStride
+		srcOffset += srcStride
+	}
+}
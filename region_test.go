@@ -0,0 +1,210 @@
+package gobio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+
+	tiffimage "github.com/AlanRace/go-bio/image"
+	"github.com/AlanRace/go-bio/test/tifftest"
+)
+
+// newTiledTIFF creates an uncompressed image with samplesPerPixel 8-bit samples, stored as tiles. Tiles on the right
+// and bottom edges are padded to the full tile size, as required by the TIFF specification.
+func newTiledTIFF(width, height, tileWidth, tileHeight, samplesPerPixel int, photometric PhotometricInterpretationID) ([]byte, []byte) {
+	pix := make([]byte, width*height*samplesPerPixel)
+	for i := range pix {
+		pix[i] = byte(i*3 + i/width)
+	}
+
+	var tiles [][]byte
+	for tileY := 0; tileY < height; tileY += tileHeight {
+		for tileX := 0; tileX < width; tileX += tileWidth {
+			tile := make([]byte, tileWidth*tileHeight*samplesPerPixel)
+
+			for y := 0; y < tileHeight && tileY+y < height; y++ {
+				for x := 0; x < tileWidth && tileX+x < width; x++ {
+					src := ((tileY+y)*width + tileX + x) * samplesPerPixel
+					dst := (y*tileWidth + x) * samplesPerPixel
+					copy(tile[dst:dst+samplesPerPixel], pix[src:src+samplesPerPixel])
+				}
+			}
+
+			tiles = append(tiles, tile)
+		}
+	}
+
+	bitsPerSample := make([]uint16, samplesPerPixel)
+	for i := range bitsPerSample {
+		bitsPerSample[i] = 8
+	}
+
+	builder := tifftest.New(binary.LittleEndian, false)
+	builder.AddDirectory().
+		Add(uint16(ImageWidth), tifftest.Long, []uint32{uint32(width)}).
+		Add(uint16(ImageLength), tifftest.Long, []uint32{uint32(height)}).
+		Add(uint16(BitsPerSample), tifftest.Short, bitsPerSample).
+		Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(photometric)}).
+		Add(uint16(SamplesPerPixel), tifftest.Short, []uint16{uint16(samplesPerPixel)}).
+		Add(uint16(TileWidth), tifftest.Long, []uint32{uint32(tileWidth)}).
+		Add(uint16(TileLength), tifftest.Long, []uint32{uint32(tileHeight)}).
+		Tiles(tiles...)
+
+	return builder.Bytes(), pix
+}
+
+func openBytes(t *testing.T, data []byte) *File {
+	t.Helper()
+
+	tiffFile, err := OpenReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tiffFile
+}
+
+func TestEdgeTileDimensions(t *testing.T) {
+	data, pix := newTiledTIFF(20, 12, 16, 16, 1, BlackIsZero)
+	ifd := openBytes(t, data).GetIFD(0)
+
+	section := ifd.GetSection(1)
+	if section.Width != 4 || section.Height != 12 {
+		t.Fatalf("expected edge tile to be 4 x 12, found %d x %d", section.Width, section.Height)
+	}
+	if section.Bounds() != image.Rect(16, 0, 20, 12) {
+		t.Errorf("unexpected bounds for edge tile: %v", section.Bounds())
+	}
+
+	img, err := section.GetImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Bounds() != image.Rect(0, 0, 4, 12) {
+		t.Fatalf("expected edge tile image to be cropped to 4 x 12, found %v", img.Bounds())
+	}
+
+	gray := img.(*image.Gray)
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 4; x++ {
+			if gray.GrayAt(x, y).Y != pix[y*20+16+x] {
+				t.Fatalf("pixel (%d, %d) of edge tile is incorrect", x, y)
+			}
+		}
+	}
+
+	if ifd.GetSection(2) != nil {
+		t.Error("expected nil for section beyond the end of the grid")
+	}
+}
+
+func TestReadRegion(t *testing.T) {
+	const width, height = 37, 29
+
+	tiled, pix := newTiledTIFF(width, height, 16, 16, 1, BlackIsZero)
+	stripped, _ := newGrayStripTIFF(binary.LittleEndian, false, width, height, 5)
+	// newGrayStripTIFF uses a different pattern, so regenerate the expected pixels
+	stripPix := make([]byte, width*height)
+	for i := range stripPix {
+		stripPix[i] = byte(i)
+	}
+
+	regions := []image.Rectangle{
+		image.Rect(0, 0, width, height),
+		image.Rect(3, 4, 20, 18),
+		image.Rect(15, 15, 17, 17),
+		image.Rect(30, 20, 45, 35),
+		image.Rect(-5, -5, 5, 5),
+		image.Rect(100, 100, 110, 105),
+	}
+
+	files := []struct {
+		name string
+		data []byte
+		pix  []byte
+	}{
+		{"Tiled", tiled, pix},
+		{"Stripped", stripped, stripPix},
+	}
+
+	for _, file := range files {
+		ifd := openBytes(t, file.data).GetIFD(0)
+
+		for _, region := range regions {
+			img, err := ifd.ReadRegion(region)
+			if err != nil {
+				t.Fatalf("%s %v: %v", file.name, region, err)
+			}
+
+			gray, ok := img.(*image.Gray)
+			if !ok {
+				t.Fatalf("%s %v: expected *image.Gray, found %T", file.name, region, img)
+			}
+			if gray.Bounds() != region {
+				t.Fatalf("%s: expected bounds %v, found %v", file.name, region, gray.Bounds())
+			}
+
+			for y := region.Min.Y; y < region.Max.Y; y++ {
+				for x := region.Min.X; x < region.Max.X; x++ {
+					var expected byte
+					if x >= 0 && y >= 0 && x < width && y < height {
+						expected = file.pix[y*width+x]
+					}
+
+					if gray.GrayAt(x, y).Y != expected {
+						t.Fatalf("%s %v: pixel (%d, %d) = %d, expected %d", file.name, region, x, y, gray.GrayAt(x, y).Y, expected)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestReadRegionRGB(t *testing.T) {
+	const width, height = 21, 18
+
+	data, pix := newTiledTIFF(width, height, 16, 16, 3, RGB)
+	ifd := openBytes(t, data).GetIFD(0)
+
+	img, err := ifd.GetImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rgb, ok := img.(*tiffimage.RGB)
+	if !ok {
+		t.Fatalf("expected *image.RGB, found %T", img)
+	}
+
+	for y := 0; y < height; y++ {
+		if !bytes.Equal(rgb.Pix[y*rgb.Stride:y*rgb.Stride+width*3], pix[y*width*3:(y+1)*width*3]) {
+			t.Fatalf("row %d is incorrect", y)
+		}
+	}
+}
+
+func TestReadRegionMultiChannel(t *testing.T) {
+	const width, height = 21, 18
+
+	// Without ExtraSamples, the fourth sample is unspecified
+	data, pix := newTiledTIFF(width, height, 16, 16, 4, RGB)
+	ifd := openBytes(t, data).GetIFD(0)
+
+	img, err := ifd.GetImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	multiChannel, ok := img.(*tiffimage.MultiChannel)
+	if !ok {
+		t.Fatalf("expected *image.MultiChannel, found %T", img)
+	}
+
+	for y := 0; y < height; y++ {
+		if !bytes.Equal(multiChannel.Pix[y*multiChannel.Stride:y*multiChannel.Stride+width*4], pix[y*width*4:(y+1)*width*4]) {
+			t.Fatalf("row %d is incorrect", y)
+		}
+	}
+}
@@ -1,11 +1,12 @@
 package svs
 
 import (
-	"bufio"
+	"encoding/binary"
 	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
 	"io"
-	"io/ioutil"
-	"os"
 	"strconv"
 	"strings"
 
@@ -14,44 +15,129 @@ import (
 )
 
 const (
-	//YCbCrJPEG tiff.CompressionID = 33003
-	//RGBJPEG   tiff.CompressionID = 33005
+	// YCbCrJPEG2000 is Aperio's JPEG 2000 compression, with the components of each codestream holding Y, Cb and Cr.
+	YCbCrJPEG2000 tiff.CompressionID = 33003
+	// RGBJPEG2000 is Aperio's JPEG 2000 compression, with the components of each codestream holding R, G and B.
+	RGBJPEG2000 tiff.CompressionID = 33005
 
 	ImageDepth tiff.TagID = 32997
 )
 
-// LZWCompression performs LZW decompression of data.
-type RGBJPEGCompression struct {
+// JPEG2000Compression decodes Aperio JPEG 2000 compressed sections, each of which is a complete J2K codestream.
+type JPEG2000Compression struct {
+	// ycbcr is set when the components are Y, Cb and Cr. They are converted to RGB unless the codestream applies its
+	// own component transform, in which case the decoder already returns RGB
+	ycbcr bool
 }
 
-// Decompress decompresses an io.Reader using the LZW algorithm.
-func (*RGBJPEGCompression) Decompress(r io.Reader) ([]byte, error) {
-	// todo:output
-
-	toOutput, _ := ioutil.ReadAll(r)
+// Decompress decodes the codestream in r. YCbCr data is converted to RGB.
+func (compression *JPEG2000Compression) Decompress(r io.Reader) (image.Image, error) {
+	header, err := jpeg2000.DecodeCodestream(r)
+	if err != nil {
+		return nil, err
+	}
 
-	// open output file
-	fo, err := os.Create("tile.j2k")
+	img, err := header.Decode()
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	// Grey images (with or without alpha) have no chroma to convert
+	if compression.ycbcr && !header.COD.MultipleComponentTransformation && header.GetNumComponents() >= 3 {
+		return ycbcrToRGB(img), nil
+	}
+
+	return img, nil
+}
+
+// SetPhotometricInterpretation has no effect, as the colour space is determined by the compression.
+func (*JPEG2000Compression) SetPhotometricInterpretation(interpretation tiff.PhotometricInterpretationID) {
+}
+
+// ycbcrToRGB converts an image with Y, Cb and Cr stored in the red, green and blue channels to RGB. Images with 8-bit
+// channels are converted to NRGBA and those with deeper channels to NRGBA64, so that precision isn't lost. NRGBA and
+// NRGBA64 images are converted in place.
+func ycbcrToRGB(img image.Image) image.Image {
+	switch img := img.(type) {
+	case *image.NRGBA:
+		ycbcrToRGB8(img, false)
+		return img
+	case *image.RGBA:
+		rgb := &image.NRGBA{Pix: img.Pix, Stride: img.Stride, Rect: img.Rect}
+		ycbcrToRGB8(rgb, true)
+		return rgb
+	case *image.NRGBA64:
+		ycbcrToRGB16(img, false)
+		return img
+	case *image.RGBA64:
+		rgb := &image.NRGBA64{Pix: img.Pix, Stride: img.Stride, Rect: img.Rect}
+		ycbcrToRGB16(rgb, true)
+		return rgb
+	default:
+		rgb := image.NewNRGBA64(img.Bounds())
+		draw.Draw(rgb, rgb.Rect, img, rgb.Rect.Min, draw.Src)
+		ycbcrToRGB16(rgb, false)
+		return rgb
 	}
-	// close fo on exit and check for its returned error
-	defer func() {
-		if err := fo.Close(); err != nil {
-			panic(err)
+}
+
+// ycbcrToRGB8 converts the pixels of rgb from YCbCr to RGB, first removing premultiplied alpha if required.
+func ycbcrToRGB8(rgb *image.NRGBA, premultiplied bool) {
+	width := rgb.Rect.Dx()
+	for y := 0; y < rgb.Rect.Dy(); y++ {
+		row := rgb.Pix[y*rgb.Stride : y*rgb.Stride+width*4]
+
+		for x := 0; x < len(row); x += 4 {
+			pixel := row[x : x+4 : x+4]
+
+			if premultiplied && pixel[3] != 0 && pixel[3] != 0xff {
+				for c := 0; c < 3; c++ {
+					pixel[c] = uint8(uint32(pixel[c]) * 0xff / uint32(pixel[3]))
+				}
+			}
+
+			pixel[0], pixel[1], pixel[2] = color.YCbCrToRGB(pixel[0], pixel[1], pixel[2])
 		}
-	}()
-	// make a write buffer
-	w := bufio.NewWriter(fo)
-	// write a chunk
-	if _, err := w.Write(toOutput); err != nil {
-		panic(err)
 	}
-	w.Flush()
+}
+
+// ycbcrToRGB16 converts the pixels of rgb from YCbCr to RGB, first removing premultiplied alpha if required. The
+// coefficients are those of color.YCbCrToRGB, applied to 16-bit samples.
+func ycbcrToRGB16(rgb *image.NRGBA64, premultiplied bool) {
+	width := rgb.Rect.Dx()
+	for y := 0; y < rgb.Rect.Dy(); y++ {
+		row := rgb.Pix[y*rgb.Stride : y*rgb.Stride+width*8]
+
+		for x := 0; x < len(row); x += 8 {
+			pixel := row[x : x+8 : x+8]
 
-	jpeg2000.Decode(r)
+			var samples [4]int64
+			for c := range samples {
+				samples[c] = int64(binary.BigEndian.Uint16(pixel[c*2:]))
+			}
+
+			if premultiplied && samples[3] != 0 && samples[3] != 0xffff {
+				for c := 0; c < 3; c++ {
+					samples[c] = samples[c] * 0xffff / samples[3]
+				}
+			}
 
-	return ioutil.ReadAll(r)
+			luma, cb, cr := samples[0]<<16+1<<15, samples[1]-0x8000, samples[2]-0x8000
+			r := (luma + 91881*cr) >> 16
+			g := (luma - 22554*cb - 46802*cr) >> 16
+			b := (luma + 116130*cb) >> 16
+
+			for c, value := range []int64{r, g, b} {
+				if value < 0 {
+					value = 0
+				} else if value > 0xffff {
+					value = 0xffff
+				}
+
+				binary.BigEndian.PutUint16(pixel[c*2:], uint16(value))
+			}
+		}
+	}
 }
 
 type File struct {
@@ -60,18 +146,53 @@ type File struct {
 
 func init() {
 	tiff.AddTag(ImageDepth, "ImageDepth")
-	//tiff.AddCompression(RGBJPEG, "JPEG (Aperio RGB)", func(dataAccess tiff.TagAccess) (tiff.CompressionMethod, error) {
-	//	return &RGBJPEGCompression{}, nil
-	//})
+	tiff.AddCompression(YCbCrJPEG2000, "JPEG 2000 (Aperio YCbCr)", func(dataAccess tiff.TagAccess) (tiff.CompressionMethod, error) {
+		return &JPEG2000Compression{ycbcr: true}, nil
+	})
+	tiff.AddCompression(RGBJPEG2000, "JPEG 2000 (Aperio RGB)", func(dataAccess tiff.TagAccess) (tiff.CompressionMethod, error) {
+		return &JPEG2000Compression{}, nil
+	})
 }
 
+// Open opens the SVS file at the specified path.
 func Open(path string) (*File, error) {
-	var svsFile File
-	tiffFile, err := tiff.Open(path)
+	return OpenWithOptions(path, tiff.OpenOptions{})
+}
+
+// OpenWithOptions opens the SVS file at the specified path, parsing it as described by options.
+func OpenWithOptions(path string, options tiff.OpenOptions) (*File, error) {
+	tiffFile, err := tiff.OpenWithOptions(path, options)
+	if err != nil {
+		return nil, err
+	}
+
+	svsFile, err := newFile(tiffFile)
 	if err != nil {
+		tiffFile.Close()
 		return nil, err
 	}
 
+	return svsFile, nil
+}
+
+// OpenReader parses SVS data available through r, which is size bytes long.
+func OpenReader(r io.ReaderAt, size int64) (*File, error) {
+	return OpenReaderWithOptions(r, size, tiff.OpenOptions{})
+}
+
+// OpenReaderWithOptions parses SVS data available through r, which is size bytes long, as described by options.
+func OpenReaderWithOptions(r io.ReaderAt, size int64, options tiff.OpenOptions) (*File, error) {
+	tiffFile, err := tiff.OpenReaderWithOptions(r, size, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFile(tiffFile)
+}
+
+func newFile(tiffFile *tiff.File) (*File, error) {
+	var svsFile File
+
 	svsFile.File = *tiffFile
 
 	mainIFD := svsFile.IFDList[0]
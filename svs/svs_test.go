@@ -1,15 +1,31 @@
 package svs
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
 	"log"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+
+	tiff "github.com/AlanRace/go-bio"
+	"github.com/AlanRace/go-bio/jpeg2000"
+	"github.com/AlanRace/go-bio/test/tifftest"
 )
 
 func TestLoad(t *testing.T) {
 	filename := "X:\\Alan\\AnnotationTransfer\\VINCEN_PDAC_CRUK_66 - 2018-11-13 14.07.36 GM_small.svs"
 
+	if _, err := os.Stat(filename); err != nil {
+		t.Skipf("test data not available: %v", err)
+	}
+
 	svsFile, err := Open(filename)
 	if err != nil {
 		log.Fatal(err)
@@ -25,3 +41,304 @@ func TestLoad(t *testing.T) {
 
 	fmt.Println(hex.EncodeToString(data))
 }
+
+// newSVSData creates an SVS file with a main image, thumbnail and reduced resolution images. modify, if not nil, is
+// called with each directory.
+func newSVSData(modify func(index int, directory *tifftest.Directory)) []byte {
+	builder := tifftest.New(binary.LittleEndian, false)
+
+	// Main image, thumbnail and then the reduced resolution images
+	widths := []uint32{64, 16, 32, 16, 8}
+	for index, width := range widths {
+		directory := builder.AddDirectory().
+			Add(uint16(tiff.ImageWidth), tifftest.Long, []uint32{width}).
+			Add(uint16(tiff.ImageLength), tifftest.Long, []uint32{width}).
+			Add(uint16(tiff.BitsPerSample), tifftest.Short, []uint16{8}).
+			Add(uint16(tiff.PhotometricInterpretation), tifftest.Short, []uint16{1}).
+			Add(uint16(tiff.SamplesPerPixel), tifftest.Short, []uint16{1}).
+			Add(uint16(tiff.RowsPerStrip), tifftest.Long, []uint32{width}).
+			Strips(make([]byte, width*width))
+
+		if index == 0 {
+			directory.Add(uint16(tiff.ImageDescription), tifftest.ASCII, "Aperio Image Library v10.0.50\r\n64x64 [0,0 64x64] (64x64) RAW|AppMag = 20|MPP = 0.5|Date = 01/01/20")
+		}
+		if modify != nil {
+			modify(index, directory)
+		}
+	}
+
+	return builder.Bytes()
+}
+
+func TestOpenReader(t *testing.T) {
+	data := newSVSData(nil)
+
+	svsFile, err := OpenReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svsFile.Close()
+
+	x, y, unit, err := svsFile.GetReducedImage(0).GetResolution()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if unit != tiff.Centimeter {
+		t.Errorf("expected unit Centimeter, found %v", unit)
+	}
+	if x < 0.49e-4 || x > 0.51e-4 || y < 0.49e-4 || y > 0.51e-4 {
+		t.Errorf("expected pixel size of 0.5 um, found %g x %g cm", x, y)
+	}
+
+	width, _ := svsFile.GetReducedImage(4).GetImageDimensions()
+	if width != 16 {
+		t.Errorf("expected lowest resolution image to have width 16, found %d", width)
+	}
+}
+
+func TestOpenReaderWithOptions(t *testing.T) {
+	data := newSVSData(func(index int, directory *tifftest.Directory) {
+		if index == 1 {
+			directory.Remove(uint16(tiff.StripByteCounts))
+		}
+	})
+
+	_, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), tiff.OpenOptions{Strict: true})
+	if !errors.Is(err, tiff.ErrMissingTag) {
+		t.Errorf("expected ErrMissingTag in strict mode, found %v", err)
+	}
+
+	var warnings []error
+	svsFile, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), tiff.OpenOptions{
+		WarningHandler: func(warning error) { warnings = append(warnings, warning) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svsFile.Close()
+
+	if len(warnings) != 1 || !errors.Is(warnings[0], tiff.ErrMissingTag) {
+		t.Errorf("expected an ErrMissingTag warning, found %v", warnings)
+	}
+	if len(svsFile.Diagnostics()) != 1 {
+		t.Errorf("expected 1 diagnostic, found %v", svsFile.Diagnostics())
+	}
+}
+
+func TestJPEG2000Compression(t *testing.T) {
+	for _, id := range []tiff.CompressionID{YCbCrJPEG2000, RGBJPEG2000} {
+		builder := tifftest.New(binary.LittleEndian, false)
+		builder.AddDirectory().
+			Add(uint16(tiff.ImageWidth), tifftest.Long, []uint32{16}).
+			Add(uint16(tiff.ImageLength), tifftest.Long, []uint32{16}).
+			Add(uint16(tiff.BitsPerSample), tifftest.Short, []uint16{8, 8, 8}).
+			Add(uint16(tiff.Compression), tifftest.Short, []uint16{uint16(id)}).
+			Add(uint16(tiff.PhotometricInterpretation), tifftest.Short, []uint16{uint16(tiff.RGB)}).
+			Add(uint16(tiff.SamplesPerPixel), tifftest.Short, []uint16{3}).
+			Add(uint16(tiff.TileWidth), tifftest.Long, []uint32{16}).
+			Add(uint16(tiff.TileLength), tifftest.Long, []uint32{16}).
+			Tiles([]byte{0xff, 0x4f, 0xff, 0xd9})
+		data := builder.Bytes()
+
+		tiffFile, err := tiff.OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), tiff.OpenOptions{Strict: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ifd := tiffFile.GetIFD(0)
+		if compression, err := ifd.GetCompression(); err != nil || compression != id {
+			t.Errorf("expected compression %v, found %v (%v)", id, compression, err)
+		}
+
+		// The codestream has no image header, so can't be decoded
+		if _, err := ifd.GetSection(0).GetImage(); err == nil {
+			t.Errorf("%v: expected an error for an empty codestream", id)
+		}
+	}
+}
+
+// newJPEG2000TIFF returns a tiff file with a single tile, holding the J2K codestream stored in the jpeg2000 test data.
+func newJPEG2000TIFF(t *testing.T, id tiff.CompressionID, filename string) (*tiff.File, []byte) {
+	t.Helper()
+
+	codestream, err := os.ReadFile(filepath.Join("..", "jpeg2000", "testdata", filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, err := jpeg2000.DecodeHeader(bytes.NewReader(codestream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	width, height := header.Size.Xsiz-header.Size.XOsiz, header.Size.Ysiz-header.Size.YOsiz
+
+	builder := tifftest.New(binary.LittleEndian, false)
+	builder.AddDirectory().
+		Add(uint16(tiff.ImageWidth), tifftest.Long, []uint32{width}).
+		Add(uint16(tiff.ImageLength), tifftest.Long, []uint32{height}).
+		Add(uint16(tiff.BitsPerSample), tifftest.Short, []uint16{8, 8, 8}).
+		Add(uint16(tiff.Compression), tifftest.Short, []uint16{uint16(id)}).
+		Add(uint16(tiff.PhotometricInterpretation), tifftest.Short, []uint16{uint16(tiff.RGB)}).
+		Add(uint16(tiff.SamplesPerPixel), tifftest.Short, []uint16{3}).
+		Add(uint16(tiff.TileWidth), tifftest.Long, []uint32{width}).
+		Add(uint16(tiff.TileLength), tifftest.Long, []uint32{height}).
+		Tiles(codestream)
+	data := builder.Bytes()
+
+	tiffFile, err := tiff.OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), tiff.OpenOptions{
+		WarningHandler: func(error) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tiffFile, codestream
+}
+
+func TestJPEG2000Decompress(t *testing.T) {
+	// rgb.j2k records the irreversible component transform, so the decoder returns RGB whichever compression is used
+	for _, id := range []tiff.CompressionID{YCbCrJPEG2000, RGBJPEG2000} {
+		tiffFile, _ := newJPEG2000TIFF(t, id, "rgb.j2k")
+
+		img, err := tiffFile.GetIFD(0).GetSection(0).GetImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds() != image.Rect(0, 0, 33, 27) {
+			t.Fatalf("%v: expected bounds (0,0)-(33,27), found %v", id, img.Bounds())
+		}
+
+		for y := 0; y < 27; y++ {
+			for x := 0; x < 33; x++ {
+				r, g, b, _ := img.At(x, y).RGBA()
+				for c, value := range []uint32{r >> 8, g >> 8, b >> 8} {
+					// The same sample formula as the jpeg2000 test data
+					want := (x*x/3 + y*5 + x*y/4 + c*70 + (x*7919+y*104729)%17) % 256
+					if int(value) != want {
+						t.Fatalf("%v: component %d of (%d, %d): expected %d, found %d", id, c, x, y, want, value)
+					}
+				}
+			}
+		}
+	}
+
+	// subsampled.j2k has no component transform, so Aperio YCbCr data is converted after decoding
+	for _, id := range []tiff.CompressionID{YCbCrJPEG2000, RGBJPEG2000} {
+		tiffFile, codestream := newJPEG2000TIFF(t, id, "subsampled.j2k")
+
+		img, err := tiffFile.GetIFD(0).GetSection(0).GetImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		components, err := jpeg2000.Decode(bytes.NewReader(codestream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds() != components.Bounds() {
+			t.Fatalf("%v: expected bounds %v, found %v", id, components.Bounds(), img.Bounds())
+		}
+
+		bounds := img.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := color.NRGBAModel.Convert(components.At(x, y)).(color.NRGBA)
+				want := c
+				if id == YCbCrJPEG2000 {
+					want.R, want.G, want.B = color.YCbCrToRGB(c.R, c.G, c.B)
+				}
+
+				if got := color.NRGBAModel.Convert(img.At(x, y)); got != want {
+					t.Fatalf("%v: pixel (%d, %d) = %v, want %v", id, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestYCbCrToRGB(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	colours := []color.YCbCr{{Y: 200, Cb: 128, Cr: 128}, {Y: 81, Cb: 90, Cr: 240}, {Y: 145, Cb: 54, Cr: 34}}
+	for x, c := range colours {
+		img.SetNRGBA(x, 0, color.NRGBA{R: c.Y, G: c.Cb, B: c.Cr, A: 255})
+	}
+
+	rgb := ycbcrToRGB(img).(*image.NRGBA)
+	for x, c := range colours {
+		r, g, b := color.YCbCrToRGB(c.Y, c.Cb, c.Cr)
+		if got := rgb.NRGBAAt(x, 0); got != (color.NRGBA{R: r, G: g, B: b, A: 255}) {
+			t.Errorf("pixel %d = %v, want %v", x, got, color.NRGBA{R: r, G: g, B: b, A: 255})
+		}
+	}
+
+	// Premultiplied images are converted to non-premultiplied RGB
+	premultiplied := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	premultiplied.SetRGBA(0, 0, color.RGBA{R: 200, G: 128, B: 128, A: 255})
+	premultiplied.SetRGBA(1, 0, color.RGBA{R: 100, G: 64, B: 64, A: 128})
+
+	rgb = ycbcrToRGB(premultiplied).(*image.NRGBA)
+	r, g, b := color.YCbCrToRGB(200, 128, 128)
+	if got := rgb.NRGBAAt(0, 0); got != (color.NRGBA{R: r, G: g, B: b, A: 255}) {
+		t.Errorf("opaque pixel = %v, want %v", got, color.NRGBA{R: r, G: g, B: b, A: 255})
+	}
+	r, g, b = color.YCbCrToRGB(199, 127, 127)
+	if got := rgb.NRGBAAt(1, 0); got != (color.NRGBA{R: r, G: g, B: b, A: 128}) {
+		t.Errorf("translucent pixel = %v, want %v", got, color.NRGBA{R: r, G: g, B: b, A: 128})
+	}
+}
+
+func TestYCbCrToRGB16(t *testing.T) {
+	colours := []color.YCbCr{{Y: 200, Cb: 128, Cr: 128}, {Y: 81, Cb: 90, Cr: 240}, {Y: 145, Cb: 54, Cr: 34}}
+
+	img := image.NewRGBA64(image.Rect(0, 0, len(colours)+1, 1))
+	for x, c := range colours {
+		img.SetRGBA64(x, 0, color.RGBA64{R: uint16(c.Y) * 0x101, G: uint16(c.Cb) * 0x101, B: uint16(c.Cr) * 0x101, A: 0xffff})
+	}
+	// Grey keeps all 16 bits
+	img.SetRGBA64(len(colours), 0, color.RGBA64{R: 0x1234, G: 0x8000, B: 0x8000, A: 0xffff})
+
+	rgb, ok := ycbcrToRGB(img).(*image.NRGBA64)
+	if !ok {
+		t.Fatalf("expected NRGBA64, found %T", ycbcrToRGB(img))
+	}
+
+	// The result agrees with the 8-bit conversion to within rounding
+	for x, c := range colours {
+		r, g, b := color.YCbCrToRGB(c.Y, c.Cb, c.Cr)
+		got := rgb.NRGBA64At(x, 0)
+
+		for index, pair := range [][2]int{{int(got.R), int(r)}, {int(got.G), int(g)}, {int(got.B), int(b)}} {
+			if difference := pair[0]/0x101 - pair[1]; difference < -1 || difference > 1 {
+				t.Errorf("pixel %d channel %d = %#04x, want about %#02x", x, index, pair[0], pair[1])
+			}
+		}
+	}
+
+	if got := rgb.NRGBA64At(len(colours), 0); got != (color.NRGBA64{R: 0x1234, G: 0x1234, B: 0x1234, A: 0xffff}) {
+		t.Errorf("grey pixel = %v, want 0x1234", got)
+	}
+}
+
+func TestJPEG2000DecompressGray(t *testing.T) {
+	// Single component codestreams are returned as decoded, without any colour conversion
+	for _, filename := range []string{"gray.j2k", "gray16.j2k"} {
+		codestream, err := os.ReadFile(filepath.Join("..", "jpeg2000", "testdata", filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, err := jpeg2000.Decode(bytes.NewReader(codestream))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		compression := &JPEG2000Compression{ycbcr: true}
+		img, err := compression.Decompress(bytes.NewReader(codestream))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(img, expected) {
+			t.Errorf("%s: expected %T as decoded, found %T", filename, expected, img)
+		}
+	}
+}
@@ -1,10 +1,13 @@
 package gobio
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"math"
 	"sort"
 	"strconv"
+	"strings"
 )
 
 // TagID captures the ID of common Tiff tags
@@ -82,6 +85,7 @@ const (
 	BadFaxLines                 TagID = 326
 	CleanFaxData                TagID = 327
 	ConsecutiveBadFaxLines      TagID = 328
+	SubIFDs                     TagID = 330
 	InkSet                      TagID = 332
 	ExtraSamples                TagID = 338
 	SampleFormat                TagID = 339
@@ -109,6 +113,7 @@ const (
 	StoNits            TagID = 37439
 	GDALMetadata       TagID = 42112
 	PrintImageMatching TagID = 50341
+	LercParameters     TagID = 50674
 
 	// Probable NDPI specific
 	//XOffsetFromSlideCentre TagID = 65422
@@ -157,6 +162,7 @@ var tagIDMap = map[uint16]TagID{
 	326: BadFaxLines,
 	327: CleanFaxData,
 	328: ConsecutiveBadFaxLines,
+	330: SubIFDs,
 	332: InkSet,
 	338: ExtraSamples,
 	339: SampleFormat,
@@ -184,6 +190,7 @@ var tagIDMap = map[uint16]TagID{
 	37439: StoNits,
 	42112: GDALMetadata,
 	50341: PrintImageMatching,
+	50674: LercParameters,
 
 	// NDPI specific?
 	//65422: XOffsetFromSlideCentre,
@@ -248,6 +255,7 @@ var tagNameMap = map[TagID]string{
 	BadFaxLines:                 "BadFaxLines",
 	CleanFaxData:                "CleanFaxData",
 	ConsecutiveBadFaxLines:      "ConsecutiveBadFaxLines",
+	SubIFDs:                     "SubIFDs",
 	HalftoneHints:               "HalftoneHints",
 
 	Matteing:           "Matteing",
@@ -259,6 +267,7 @@ var tagNameMap = map[TagID]string{
 	StoNits:            "StoNits",
 	GDALMetadata:       "GDALMetadata",
 	PrintImageMatching: "PrintImageMatching",
+	LercParameters:     "LercParameters",
 
 	// NDPI Specific?
 	//XOffsetFromSlideCentre: "XOffsetFromSlideCentre",
@@ -302,6 +311,7 @@ const (
 	SRational DataTypeID = 10
 	Float     DataTypeID = 11
 	Double    DataTypeID = 12
+	IFD       DataTypeID = 13
 
 	Long8  DataTypeID = 16
 	SLong8 DataTypeID = 17
@@ -321,6 +331,7 @@ var dataTypeMap = map[uint16]DataTypeID{
 	10: SRational,
 	11: Float,
 	12: Double,
+	13: IFD,
 
 	16: Long8,
 	17: SLong8,
@@ -340,6 +351,7 @@ var dataTypeNameMap = map[DataTypeID]string{
 	SRational: "SRational",
 	Float:     "Float",
 	Double:    "Double",
+	IFD:       "IFD",
 
 	Long8:  "Long8",
 	SLong8: "SLong8",
@@ -358,6 +370,35 @@ func DataTypeNameFromID(typeID uint16) string {
 	return dataTypeNameMap[DataTypeID(typeID)]
 }
 
+var dataTypeSizeMap = map[DataTypeID]int{
+	Byte:      1,
+	ASCII:     1,
+	Short:     2,
+	Long:      4,
+	Rational:  8,
+	SByte:     1,
+	Undefined: 1,
+	SShort:    2,
+	SLong:     4,
+	SRational: 8,
+	Float:     4,
+	Double:    8,
+	IFD:       4,
+
+	Long8:  8,
+	SLong8: 8,
+	IFD8:   8,
+}
+
+// Size returns the number of bytes used to store a single value of the data type, or 0 if the type is unknown.
+func (typeID DataTypeID) Size() int {
+	return dataTypeSizeMap[typeID]
+}
+
+func (typeID DataTypeID) String() string {
+	return dataTypeNameMap[typeID]
+}
+
 type CompressionID uint16
 
 const (
@@ -373,6 +414,9 @@ const (
 	JBIGBW       CompressionID = 9
 	JBIGColour   CompressionID = 10
 	PackBits     CompressionID = 32773
+	LERC         CompressionID = 34887
+	Zstd         CompressionID = 50000
+	WebP         CompressionID = 50001
 )
 
 func (compressionID CompressionID) String() string {
@@ -400,6 +444,7 @@ var photometricInterpretationNameMap = map[PhotometricInterpretationID]string{
 	RGB:              "RGB",
 	PaletteColour:    "PaletteColour",
 	TransparencyMask: "TransparencyMask",
+	CMYK:             "CMYK",
 	YCbCr:            "YCbCr",
 	CIELab:           "CIELab",
 	ICCLab:           "ICCLab",
@@ -463,6 +508,92 @@ var predictorTypeMap = map[uint16]PredictorID{
 	3: PredictorFloatingPoint,
 }
 
+// PlanarConfigurationID describes how the samples of each pixel are stored.
+type PlanarConfigurationID uint16
+
+const (
+	// PlanarConfigurationChunky stores the samples of each pixel together (e.g. RGBRGBRGB).
+	PlanarConfigurationChunky PlanarConfigurationID = 1
+	// PlanarConfigurationPlanar stores each sample in a separate plane (e.g. RRR GGG BBB), with separate strips or
+	// tiles for each plane.
+	PlanarConfigurationPlanar PlanarConfigurationID = 2
+)
+
+var planarConfigurationNameMap = map[PlanarConfigurationID]string{
+	PlanarConfigurationChunky: "Chunky",
+	PlanarConfigurationPlanar: "Planar",
+}
+
+var planarConfigurationTypeMap = map[uint16]PlanarConfigurationID{
+	1: PlanarConfigurationChunky,
+	2: PlanarConfigurationPlanar,
+}
+
+func (planarConfigurationID PlanarConfigurationID) String() string {
+	return planarConfigurationNameMap[planarConfigurationID] + " (" + strconv.Itoa(int(planarConfigurationID)) + ")"
+}
+
+// ExtraSampleID describes the meaning of a sample beyond those needed for the PhotometricInterpretation.
+type ExtraSampleID uint16
+
+const (
+	ExtraSampleUnspecified ExtraSampleID = 0
+	// ExtraSampleAssociatedAlpha is alpha which has already been multiplied into the colour samples (premultiplied).
+	ExtraSampleAssociatedAlpha ExtraSampleID = 1
+	// ExtraSampleUnassociatedAlpha is alpha which has not been applied to the colour samples.
+	ExtraSampleUnassociatedAlpha ExtraSampleID = 2
+)
+
+var extraSampleNameMap = map[ExtraSampleID]string{
+	ExtraSampleUnspecified:       "Unspecified",
+	ExtraSampleAssociatedAlpha:   "AssociatedAlpha",
+	ExtraSampleUnassociatedAlpha: "UnassociatedAlpha",
+}
+
+var extraSampleTypeMap = map[uint16]ExtraSampleID{
+	0: ExtraSampleUnspecified,
+	1: ExtraSampleAssociatedAlpha,
+	2: ExtraSampleUnassociatedAlpha,
+}
+
+func (extraSampleID ExtraSampleID) String() string {
+	return extraSampleNameMap[extraSampleID] + " (" + strconv.Itoa(int(extraSampleID)) + ")"
+}
+
+// SampleFormatID describes how the bits of each sample should be interpreted.
+type SampleFormatID uint16
+
+const (
+	SampleFormatUInt         SampleFormatID = 1
+	SampleFormatInt          SampleFormatID = 2
+	SampleFormatFloat        SampleFormatID = 3
+	SampleFormatUndefined    SampleFormatID = 4
+	SampleFormatComplexInt   SampleFormatID = 5
+	SampleFormatComplexFloat SampleFormatID = 6
+)
+
+var sampleFormatNameMap = map[SampleFormatID]string{
+	SampleFormatUInt:         "UnsignedInteger",
+	SampleFormatInt:          "SignedInteger",
+	SampleFormatFloat:        "IEEEFloatingPoint",
+	SampleFormatUndefined:    "Undefined",
+	SampleFormatComplexInt:   "ComplexSignedInteger",
+	SampleFormatComplexFloat: "ComplexIEEEFloatingPoint",
+}
+
+var sampleFormatTypeMap = map[uint16]SampleFormatID{
+	1: SampleFormatUInt,
+	2: SampleFormatInt,
+	3: SampleFormatFloat,
+	4: SampleFormatUndefined,
+	5: SampleFormatComplexInt,
+	6: SampleFormatComplexFloat,
+}
+
+func (sampleFormatID SampleFormatID) String() string {
+	return sampleFormatNameMap[sampleFormatID] + " (" + strconv.Itoa(int(sampleFormatID)) + ")"
+}
+
 type Tag interface {
 	TagID() TagID
 	String() string
@@ -705,6 +836,10 @@ func (tag RationalTag) NumItems() int {
 }
 
 func (tag RationalTag) String() string {
+	if len(tag.Data) == 0 {
+		return fmt.Sprintf("%s (%d): %s", tagNameMap[tag.ID], tag.ID, tag.ValueAsString())
+	}
+
 	return fmt.Sprintf("%s (%d): %s (%f)", tagNameMap[tag.ID], tag.ID, tag.ValueAsString(), tag.Data[0].Value())
 }
 
@@ -759,3 +894,298 @@ func (tag Long8Tag) String() string {
 func (tag Long8Tag) ValueAsString() string {
 	return fmt.Sprint(tag.Data)
 }
+
+type SByteTag struct {
+	ID       TagID
+	DataType DataTypeID
+
+	Data []int8
+}
+
+func (tag SByteTag) TagID() TagID {
+	return tag.ID
+}
+
+// NumItems returns the number of items stored in the tag (length of array)
+func (tag SByteTag) NumItems() int {
+	return len(tag.Data)
+}
+
+func (tag SByteTag) String() string {
+	return fmt.Sprintf("%s (%d): %s", tagNameMap[tag.ID], tag.ID, tag.ValueAsString())
+}
+
+func (tag SByteTag) ValueAsString() string {
+	return fmt.Sprint(tag.Data)
+}
+
+type SShortTag struct {
+	ID       TagID
+	DataType DataTypeID
+
+	Data []int16
+}
+
+func (tag SShortTag) TagID() TagID {
+	return tag.ID
+}
+
+// NumItems returns the number of items stored in the tag (length of array)
+func (tag SShortTag) NumItems() int {
+	return len(tag.Data)
+}
+
+func (tag SShortTag) String() string {
+	return fmt.Sprintf("%s (%d): %s", tagNameMap[tag.ID], tag.ID, tag.ValueAsString())
+}
+
+func (tag SShortTag) ValueAsString() string {
+	return fmt.Sprint(tag.Data)
+}
+
+type SLongTag struct {
+	ID       TagID
+	DataType DataTypeID
+
+	Data []int32
+}
+
+func (tag SLongTag) TagID() TagID {
+	return tag.ID
+}
+
+// NumItems returns the number of items stored in the tag (length of array)
+func (tag SLongTag) NumItems() int {
+	return len(tag.Data)
+}
+
+func (tag SLongTag) String() string {
+	return fmt.Sprintf("%s (%d): %s", tagNameMap[tag.ID], tag.ID, tag.ValueAsString())
+}
+
+func (tag SLongTag) ValueAsString() string {
+	return fmt.Sprint(tag.Data)
+}
+
+// SRationalNumber is a signed fraction, stored as two SLONGs.
+type SRationalNumber struct {
+	Numerator   int32
+	Denominator int32
+}
+
+func (rational *SRationalNumber) Value() float64 {
+	return float64(rational.Numerator) / float64(rational.Denominator)
+}
+
+type SRationalTag struct {
+	ID       TagID
+	DataType DataTypeID
+
+	Data []SRationalNumber
+}
+
+func (tag SRationalTag) TagID() TagID {
+	return tag.ID
+}
+
+// NumItems returns the number of items stored in the tag (length of array)
+func (tag SRationalTag) NumItems() int {
+	return len(tag.Data)
+}
+
+func (tag SRationalTag) String() string {
+	return fmt.Sprintf("%s (%d): %s", tagNameMap[tag.ID], tag.ID, tag.ValueAsString())
+}
+
+func (tag SRationalTag) ValueAsString() string {
+	return fmt.Sprint(tag.Data)
+}
+
+type FloatTag struct {
+	ID       TagID
+	DataType DataTypeID
+
+	Data []float32
+}
+
+func (tag FloatTag) TagID() TagID {
+	return tag.ID
+}
+
+// NumItems returns the number of items stored in the tag (length of array)
+func (tag FloatTag) NumItems() int {
+	return len(tag.Data)
+}
+
+func (tag FloatTag) String() string {
+	return fmt.Sprintf("%s (%d): %s", tagNameMap[tag.ID], tag.ID, tag.ValueAsString())
+}
+
+func (tag FloatTag) ValueAsString() string {
+	return fmt.Sprint(tag.Data)
+}
+
+type DoubleTag struct {
+	ID       TagID
+	DataType DataTypeID
+
+	Data []float64
+}
+
+func (tag DoubleTag) TagID() TagID {
+	return tag.ID
+}
+
+// NumItems returns the number of items stored in the tag (length of array)
+func (tag DoubleTag) NumItems() int {
+	return len(tag.Data)
+}
+
+func (tag DoubleTag) String() string {
+	return fmt.Sprintf("%s (%d): %s", tagNameMap[tag.ID], tag.ID, tag.ValueAsString())
+}
+
+func (tag DoubleTag) ValueAsString() string {
+	return fmt.Sprint(tag.Data)
+}
+
+// IFDTag holds offsets to child image file directories (e.g. SubIFDs) in a classic tiff file.
+type IFDTag struct {
+	ID       TagID
+	DataType DataTypeID
+
+	Data []uint32
+}
+
+func (tag IFDTag) TagID() TagID {
+	return tag.ID
+}
+
+// NumItems returns the number of items stored in the tag (length of array)
+func (tag IFDTag) NumItems() int {
+	return len(tag.Data)
+}
+
+func (tag IFDTag) String() string {
+	return fmt.Sprintf("%s (%d): %s", tagNameMap[tag.ID], tag.ID, tag.ValueAsString())
+}
+
+func (tag IFDTag) ValueAsString() string {
+	return fmt.Sprint(tag.Data)
+}
+
+type SLong8Tag struct {
+	ID       TagID
+	DataType DataTypeID
+
+	Data []int64
+}
+
+func (tag SLong8Tag) TagID() TagID {
+	return tag.ID
+}
+
+// NumItems returns the number of items stored in the tag (length of array)
+func (tag SLong8Tag) NumItems() int {
+	return len(tag.Data)
+}
+
+func (tag SLong8Tag) String() string {
+	return fmt.Sprintf("%s (%d): %s", tagNameMap[tag.ID], tag.ID, tag.ValueAsString())
+}
+
+func (tag SLong8Tag) ValueAsString() string {
+	return fmt.Sprint(tag.Data)
+}
+
+// IFD8Tag holds offsets to child image file directories (e.g. SubIFDs) in a BigTIFF file.
+type IFD8Tag struct {
+	ID       TagID
+	DataType DataTypeID
+
+	Data []uint64
+}
+
+func (tag IFD8Tag) TagID() TagID {
+	return tag.ID
+}
+
+// NumItems returns the number of items stored in the tag (length of array)
+func (tag IFD8Tag) NumItems() int {
+	return len(tag.Data)
+}
+
+func (tag IFD8Tag) String() string {
+	return fmt.Sprintf("%s (%d): %s", tagNameMap[tag.ID], tag.ID, tag.ValueAsString())
+}
+
+func (tag IFD8Tag) ValueAsString() string {
+	return fmt.Sprint(tag.Data)
+}
+
+// decodeTag creates a Tag of the appropriate type from the raw bytes of the values, as stored in the file.
+func decodeTag(tagID TagID, dataType DataTypeID, endian binary.ByteOrder, data []byte) (Tag, error) {
+	var tag Tag
+	var values interface{}
+
+	count := 0
+	if dataType.Size() > 0 {
+		count = len(data) / dataType.Size()
+	}
+
+	switch dataType {
+	case Byte, Undefined:
+		return &ByteTag{ID: tagID, DataType: dataType, Data: data}, nil
+	case ASCII:
+		// The NUL terminating the (last) string is not kept
+		return &ASCIITag{ID: tagID, DataType: dataType, Data: strings.TrimRight(string(data), "\x00")}, nil
+	case Short:
+		shortTag := &ShortTag{ID: tagID, DataType: dataType, Data: make([]uint16, count)}
+		tag, values = shortTag, shortTag.Data
+	case Long:
+		longTag := &LongTag{ID: tagID, DataType: dataType, Data: make([]uint32, count)}
+		tag, values = longTag, longTag.Data
+	case Rational:
+		rationalTag := &RationalTag{ID: tagID, DataType: dataType, Data: make([]RationalNumber, count)}
+		tag, values = rationalTag, rationalTag.Data
+	case SByte:
+		sbyteTag := &SByteTag{ID: tagID, DataType: dataType, Data: make([]int8, count)}
+		tag, values = sbyteTag, sbyteTag.Data
+	case SShort:
+		sshortTag := &SShortTag{ID: tagID, DataType: dataType, Data: make([]int16, count)}
+		tag, values = sshortTag, sshortTag.Data
+	case SLong:
+		slongTag := &SLongTag{ID: tagID, DataType: dataType, Data: make([]int32, count)}
+		tag, values = slongTag, slongTag.Data
+	case SRational:
+		srationalTag := &SRationalTag{ID: tagID, DataType: dataType, Data: make([]SRationalNumber, count)}
+		tag, values = srationalTag, srationalTag.Data
+	case Float:
+		floatTag := &FloatTag{ID: tagID, DataType: dataType, Data: make([]float32, count)}
+		tag, values = floatTag, floatTag.Data
+	case Double:
+		doubleTag := &DoubleTag{ID: tagID, DataType: dataType, Data: make([]float64, count)}
+		tag, values = doubleTag, doubleTag.Data
+	case IFD:
+		ifdTag := &IFDTag{ID: tagID, DataType: dataType, Data: make([]uint32, count)}
+		tag, values = ifdTag, ifdTag.Data
+	case Long8:
+		long8Tag := &Long8Tag{ID: tagID, DataType: dataType, Data: make([]uint64, count)}
+		tag, values = long8Tag, long8Tag.Data
+	case SLong8:
+		slong8Tag := &SLong8Tag{ID: tagID, DataType: dataType, Data: make([]int64, count)}
+		tag, values = slong8Tag, slong8Tag.Data
+	case IFD8:
+		ifd8Tag := &IFD8Tag{ID: tagID, DataType: dataType, Data: make([]uint64, count)}
+		tag, values = ifd8Tag, ifd8Tag.Data
+	default:
+		return nil, &FormatError{msg: fmt.Sprintf("Unknown tag type %d for tag %s (%d)", dataType, tagID.String(), tagID)}
+	}
+
+	err := binary.Read(bytes.NewReader(data[:count*dataType.Size()]), endian, values)
+	if err != nil {
+		return nil, err
+	}
+
+	return tag, nil
+}
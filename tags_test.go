@@ -0,0 +1,204 @@
+package gobio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/AlanRace/go-bio/test/tifftest"
+)
+
+// openWithTags creates a 1 x 1 greyscale image with the additional tags added by addTags and returns its
+// ImageFileDirectory.
+func openWithTags(t *testing.T, order binary.ByteOrder, bigTIFF bool, addTags func(*tifftest.Directory)) *ImageFileDirectory {
+	builder := tifftest.New(order, bigTIFF)
+	directory := builder.AddDirectory().
+		Add(uint16(ImageWidth), tifftest.Long, []uint32{1}).
+		Add(uint16(ImageLength), tifftest.Long, []uint32{1}).
+		Add(uint16(BitsPerSample), tifftest.Short, []uint16{8}).
+		Add(uint16(PhotometricInterpretation), tifftest.Short, []uint16{uint16(BlackIsZero)}).
+		Add(uint16(SamplesPerPixel), tifftest.Short, []uint16{1}).
+		Add(uint16(RowsPerStrip), tifftest.Long, []uint32{1}).
+		Strips([]byte{0})
+	addTags(directory)
+	data := builder.Bytes()
+
+	tiffFile, err := OpenReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tiffFile.GetIFD(0)
+}
+
+func TestFieldTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagID    TagID
+		dataType uint16
+		values   interface{}
+		bigOnly  bool
+		want     Tag
+	}{
+		{"Byte", Thresholding, tifftest.Byte, []uint8{1, 2, 3, 4},
+			false, &ByteTag{ID: Thresholding, DataType: Byte, Data: []uint8{1, 2, 3, 4}}},
+		{"ByteOffset", Thresholding, tifftest.Byte, []uint8{1, 2, 3, 4, 5, 6, 7, 8, 9},
+			false, &ByteTag{ID: Thresholding, DataType: Byte, Data: []uint8{1, 2, 3, 4, 5, 6, 7, 8, 9}}},
+		{"Undefined", PrintImageMatching, tifftest.Undefined, []uint8{0xff, 0},
+			false, &ByteTag{ID: PrintImageMatching, DataType: Undefined, Data: []uint8{0xff, 0}}},
+		{"ASCIIShort", Make, tifftest.ASCII, "ab",
+			false, &ASCIITag{ID: Make, DataType: ASCII, Data: "ab"}},
+		{"ASCIIFourBytes", Make, tifftest.ASCII, "abc",
+			false, &ASCIITag{ID: Make, DataType: ASCII, Data: "abc"}},
+		{"ASCIIEightBytes", Make, tifftest.ASCII, "abcdefg",
+			false, &ASCIITag{ID: Make, DataType: ASCII, Data: "abcdefg"}},
+		{"ASCIIOffset", Make, tifftest.ASCII, "Aperio Image Library",
+			false, &ASCIITag{ID: Make, DataType: ASCII, Data: "Aperio Image Library"}},
+		{"Short", Orientation, tifftest.Short, []uint16{0x0102},
+			false, &ShortTag{ID: Orientation, DataType: Short, Data: []uint16{0x0102}}},
+		{"ShortPair", Orientation, tifftest.Short, []uint16{0x0102, 0x0304},
+			false, &ShortTag{ID: Orientation, DataType: Short, Data: []uint16{0x0102, 0x0304}}},
+		{"ShortThree", Orientation, tifftest.Short, []uint16{0x0102, 0x0304, 0x0506},
+			false, &ShortTag{ID: Orientation, DataType: Short, Data: []uint16{0x0102, 0x0304, 0x0506}}},
+		{"ShortFour", Orientation, tifftest.Short, []uint16{1, 2, 3, 4},
+			false, &ShortTag{ID: Orientation, DataType: Short, Data: []uint16{1, 2, 3, 4}}},
+		{"ShortOffset", Orientation, tifftest.Short, []uint16{1, 2, 3, 4, 5},
+			false, &ShortTag{ID: Orientation, DataType: Short, Data: []uint16{1, 2, 3, 4, 5}}},
+		{"Long", PageNumber, tifftest.Long, []uint32{0x01020304},
+			false, &LongTag{ID: PageNumber, DataType: Long, Data: []uint32{0x01020304}}},
+		{"LongPair", PageNumber, tifftest.Long, []uint32{0x01020304, 0x05060708},
+			false, &LongTag{ID: PageNumber, DataType: Long, Data: []uint32{0x01020304, 0x05060708}}},
+		{"LongOffset", PageNumber, tifftest.Long, []uint32{1, 2, 3},
+			false, &LongTag{ID: PageNumber, DataType: Long, Data: []uint32{1, 2, 3}}},
+		{"Rational", XResolution, tifftest.Rational, []uint32{72, 1},
+			false, &RationalTag{ID: XResolution, DataType: Rational, Data: []RationalNumber{{72, 1}}}},
+		{"RationalOffset", XResolution, tifftest.Rational, []uint32{72, 1, 300, 7},
+			false, &RationalTag{ID: XResolution, DataType: Rational, Data: []RationalNumber{{72, 1}, {300, 7}}}},
+		{"Long8", TileDepth, tifftest.Long8, []uint64{0x0102030405060708},
+			true, &Long8Tag{ID: TileDepth, DataType: Long8, Data: []uint64{0x0102030405060708}}},
+		{"Long8Offset", TileDepth, tifftest.Long8, []uint64{1, 1 << 40},
+			true, &Long8Tag{ID: TileDepth, DataType: Long8, Data: []uint64{1, 1 << 40}}},
+		{"SByte", MinSampleValue, tifftest.SByte, []int8{-1, 2, -3},
+			false, &SByteTag{ID: MinSampleValue, DataType: SByte, Data: []int8{-1, 2, -3}}},
+		{"SByteOffset", MinSampleValue, tifftest.SByte, []int8{-1, 2, -3, 4, -5, 6, -7, 8, -9},
+			false, &SByteTag{ID: MinSampleValue, DataType: SByte, Data: []int8{-1, 2, -3, 4, -5, 6, -7, 8, -9}}},
+		{"SShort", SMinSampleValue, tifftest.SShort, []int16{-300},
+			false, &SShortTag{ID: SMinSampleValue, DataType: SShort, Data: []int16{-300}}},
+		{"SShortOffset", SMinSampleValue, tifftest.SShort, []int16{-300, 2, -32768, 32767, 5},
+			false, &SShortTag{ID: SMinSampleValue, DataType: SShort, Data: []int16{-300, 2, -32768, 32767, 5}}},
+		{"SLong", SMaxSampleValue, tifftest.SLong, []int32{-70000},
+			false, &SLongTag{ID: SMaxSampleValue, DataType: SLong, Data: []int32{-70000}}},
+		{"SLongOffset", SMaxSampleValue, tifftest.SLong, []int32{-70000, 70000, -1},
+			false, &SLongTag{ID: SMaxSampleValue, DataType: SLong, Data: []int32{-70000, 70000, -1}}},
+		{"SRational", XPosition, tifftest.SRational, []int32{-1, 3, 5, -7},
+			false, &SRationalTag{ID: XPosition, DataType: SRational, Data: []SRationalNumber{{-1, 3}, {5, -7}}}},
+		{"Float", YPosition, tifftest.Float, []float32{1.5},
+			false, &FloatTag{ID: YPosition, DataType: Float, Data: []float32{1.5}}},
+		{"FloatOffset", YPosition, tifftest.Float, []float32{1.5, -2.25, 1e10},
+			false, &FloatTag{ID: YPosition, DataType: Float, Data: []float32{1.5, -2.25, 1e10}}},
+		{"Double", MaxSampleValue, tifftest.Double, []float64{-0.125},
+			false, &DoubleTag{ID: MaxSampleValue, DataType: Double, Data: []float64{-0.125}}},
+		{"DoubleOffset", MaxSampleValue, tifftest.Double, []float64{-0.125, 3.5e100},
+			false, &DoubleTag{ID: MaxSampleValue, DataType: Double, Data: []float64{-0.125, 3.5e100}}},
+		{"IFD", ExifIFD, tifftest.IFD, []uint32{1234},
+			false, &IFDTag{ID: ExifIFD, DataType: IFD, Data: []uint32{1234}}},
+		{"IFDOffset", ExifIFD, tifftest.IFD, []uint32{1234, 5678, 9},
+			false, &IFDTag{ID: ExifIFD, DataType: IFD, Data: []uint32{1234, 5678, 9}}},
+		{"SLong8", ImageDepth, tifftest.SLong8, []int64{-1 << 40},
+			true, &SLong8Tag{ID: ImageDepth, DataType: SLong8, Data: []int64{-1 << 40}}},
+		{"SLong8Offset", ImageDepth, tifftest.SLong8, []int64{-1 << 40, 1 << 50},
+			true, &SLong8Tag{ID: ImageDepth, DataType: SLong8, Data: []int64{-1 << 40, 1 << 50}}},
+		{"IFD8", ExifIFD, tifftest.IFD8, []uint64{1 << 33},
+			true, &IFD8Tag{ID: ExifIFD, DataType: IFD8, Data: []uint64{1 << 33}}},
+		{"IFD8Offset", ExifIFD, tifftest.IFD8, []uint64{1 << 33, 16},
+			true, &IFD8Tag{ID: ExifIFD, DataType: IFD8, Data: []uint64{1 << 33, 16}}},
+	}
+
+	files := []struct {
+		name    string
+		order   binary.ByteOrder
+		bigTIFF bool
+	}{
+		{"LittleEndian", binary.LittleEndian, false},
+		{"BigEndian", binary.BigEndian, false},
+		{"BigTIFFLittleEndian", binary.LittleEndian, true},
+		{"BigTIFFBigEndian", binary.BigEndian, true},
+	}
+
+	for _, file := range files {
+		for _, test := range tests {
+			if test.bigOnly && !file.bigTIFF {
+				continue
+			}
+
+			t.Run(file.name+"/"+test.name, func(t *testing.T) {
+				ifd := openWithTags(t, file.order, file.bigTIFF, func(directory *tifftest.Directory) {
+					directory.Add(uint16(test.tagID), test.dataType, test.values)
+				})
+
+				got := ifd.GetTag(test.tagID)
+				if !reflect.DeepEqual(got, test.want) {
+					t.Errorf("got %v, want %v", got, test.want)
+				}
+			})
+		}
+	}
+}
+
+func TestTypedTagGetters(t *testing.T) {
+	ifd := openWithTags(t, binary.LittleEndian, false, func(directory *tifftest.Directory) {
+		directory.
+			Add(uint16(SMinSampleValue), tifftest.SShort, []int16{-5}).
+			Add(uint16(XPosition), tifftest.SRational, []int32{-1, 4}).
+			Add(uint16(YPosition), tifftest.Double, []float64{2.5})
+	})
+
+	sshortTag, ok := ifd.GetSShortTag(SMinSampleValue)
+	if !ok || sshortTag.Data[0] != -5 {
+		t.Errorf("GetSShortTag returned %v, %v", sshortTag, ok)
+	}
+
+	srationalTag, ok := ifd.GetSRationalTag(XPosition)
+	if !ok || srationalTag.Data[0].Value() != -0.25 {
+		t.Errorf("GetSRationalTag returned %v, %v", srationalTag, ok)
+	}
+
+	doubleTag, ok := ifd.GetDoubleTag(YPosition)
+	if !ok || doubleTag.ValueAsString() != "[2.5]" {
+		t.Errorf("GetDoubleTag returned %v, %v", doubleTag, ok)
+	}
+
+	if _, ok := ifd.GetFloatTag(YPosition); ok {
+		t.Error("GetFloatTag should fail for a tag stored as Double")
+	}
+	if _, ok := ifd.GetSLongTag(ImageDepth); ok {
+		t.Error("GetSLongTag should fail for a missing tag")
+	}
+}
+
+func TestTagDataPastEndOfFile(t *testing.T) {
+	valueField := make([]byte, 4)
+	binary.LittleEndian.PutUint32(valueField, 0xfffffff0)
+
+	builder := tifftest.New(binary.LittleEndian, false)
+	builder.AddDirectory().
+		Add(uint16(ImageWidth), tifftest.Long, []uint32{1}).
+		Add(uint16(ImageLength), tifftest.Long, []uint32{1}).
+		AddRaw(uint16(ImageDescription), tifftest.ASCII, 100, valueField)
+	data := builder.Bytes()
+
+	_, err := OpenReaderWithOptions(bytes.NewReader(data), int64(len(data)), OpenOptions{Strict: true})
+
+	var parseError *ParseError
+	if !errors.As(err, &parseError) {
+		t.Fatalf("expected ParseError for tag data beyond the end of the file, got %v", err)
+	}
+	if parseError.IFD != 0 || parseError.Tag != ImageDescription || parseError.Offset != 0xfffffff0 {
+		t.Errorf("unexpected location in error: %v", parseError)
+	}
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("expected ErrTruncated, got %v", parseError.Err)
+	}
+}
@@ -0,0 +1,376 @@
+// Package tifftest builds small TIFF and BigTIFF files in memory for use in tests.
+package tifftest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Field types as defined in the TIFF and BigTIFF specifications.
+const (
+	Byte      uint16 = 1
+	ASCII     uint16 = 2
+	Short     uint16 = 3
+	Long      uint16 = 4
+	Rational  uint16 = 5
+	SByte     uint16 = 6
+	Undefined uint16 = 7
+	SShort    uint16 = 8
+	SLong     uint16 = 9
+	SRational uint16 = 10
+	Float     uint16 = 11
+	Double    uint16 = 12
+	IFD       uint16 = 13
+	Long8     uint16 = 16
+	SLong8    uint16 = 17
+	IFD8      uint16 = 18
+)
+
+var typeSizes = map[uint16]int{
+	Byte: 1, ASCII: 1, Short: 2, Long: 4, Rational: 8, SByte: 1, Undefined: 1, SShort: 2,
+	SLong: 4, SRational: 8, Float: 4, Double: 8, IFD: 4, Long8: 8, SLong8: 8, IFD8: 8,
+}
+
+// Tag identifiers used by the helpers in this package.
+const (
+	tagStripOffsets    uint16 = 273
+	tagStripByteCounts uint16 = 279
+	tagTileOffsets     uint16 = 324
+	tagTileByteCounts  uint16 = 325
+	tagSubIFDs         uint16 = 330
+)
+
+type entry struct {
+	tag      uint16
+	dataType uint16
+	count    uint64
+	data     []byte
+
+	// raw, when set, is written directly into the value/offset field of the entry
+	raw []byte
+
+	// blobs, when set, are written to the file and their offsets become the values of the entry
+	blobs [][]byte
+}
+
+// Directory describes a single image file directory to be written.
+type Directory struct {
+	order   binary.ByteOrder
+	entries map[uint16]*entry
+
+	chunks     [][]byte
+	chunkTags  [2]uint16
+	chunkType  uint16
+	chunkCount int
+	subIFDs    []*Directory
+	removed    []uint16
+}
+
+// Builder accumulates directories and writes them out as a TIFF file.
+type Builder struct {
+	order   binary.ByteOrder
+	bigTIFF bool
+
+	directories []*Directory
+}
+
+// New creates a Builder writing classic TIFF (bigTIFF == false) or BigTIFF with the supplied byte order.
+func New(order binary.ByteOrder, bigTIFF bool) *Builder {
+	return &Builder{order: order, bigTIFF: bigTIFF}
+}
+
+// NewDirectory creates a directory which is not part of the main IFD chain (e.g. to be used as a SubIFD).
+func (builder *Builder) NewDirectory() *Directory {
+	return &Directory{order: builder.order, entries: make(map[uint16]*entry)}
+}
+
+// AddDirectory appends a new directory to the main IFD chain.
+func (builder *Builder) AddDirectory() *Directory {
+	directory := builder.NewDirectory()
+	builder.directories = append(builder.directories, directory)
+
+	return directory
+}
+
+// Add stores a tag with the supplied values. values must be a string (ASCII) or a slice of fixed size values which
+// is encoded with the byte order of the file. For rational types supply pairs of numerator and denominator.
+func (directory *Directory) Add(tag uint16, dataType uint16, values interface{}) *Directory {
+	var buf bytes.Buffer
+	var count uint64
+
+	if str, ok := values.(string); ok {
+		buf.WriteString(str)
+		buf.WriteByte(0)
+		count = uint64(buf.Len())
+	} else {
+		err := binary.Write(&buf, directory.order, values)
+		if err != nil {
+			panic(fmt.Sprintf("tifftest: can't encode %T: %v", values, err))
+		}
+
+		count = uint64(buf.Len() / typeSizes[dataType])
+	}
+
+	directory.entries[tag] = &entry{tag: tag, dataType: dataType, count: count, data: buf.Bytes()}
+
+	return directory
+}
+
+// AddRaw stores a tag whose value/offset field is written exactly as supplied, which allows malformed entries
+// (e.g. offsets beyond the end of the file) to be created.
+func (directory *Directory) AddRaw(tag uint16, dataType uint16, count uint64, valueField []byte) *Directory {
+	directory.entries[tag] = &entry{tag: tag, dataType: dataType, count: count, raw: valueField}
+
+	return directory
+}
+
+// Data stores each of blobs in the file and records their offsets as the values of tag (e.g. JPEGQTables or
+// JPEGInterchangeFormat).
+func (directory *Directory) Data(tag uint16, blobs ...[]byte) *Directory {
+	directory.entries[tag] = &entry{tag: tag, blobs: blobs}
+
+	return directory
+}
+
+// Remove deletes a previously added tag, including those filled in by Strips and Tiles.
+func (directory *Directory) Remove(tag uint16) *Directory {
+	delete(directory.entries, tag)
+	directory.removed = append(directory.removed, tag)
+
+	return directory
+}
+
+// Strips stores the supplied data as strips, filling in StripOffsets and StripByteCounts when written.
+func (directory *Directory) Strips(strips ...[]byte) *Directory {
+	directory.chunks = strips
+	directory.chunkTags = [2]uint16{tagStripOffsets, tagStripByteCounts}
+
+	return directory
+}
+
+// Tiles stores the supplied data as tiles, filling in TileOffsets and TileByteCounts when written.
+func (directory *Directory) Tiles(tiles ...[]byte) *Directory {
+	directory.chunks = tiles
+	directory.chunkTags = [2]uint16{tagTileOffsets, tagTileByteCounts}
+
+	return directory
+}
+
+// ChunkType sets the data type used to write the offsets and byte counts of the strips or tiles, which otherwise
+// are LONG (LONG8 in BigTIFF).
+func (directory *Directory) ChunkType(dataType uint16) *Directory {
+	directory.chunkType = dataType
+
+	return directory
+}
+
+// SubIFDs stores the supplied directories as SubIFDs of this directory.
+func (directory *Directory) SubIFDs(children ...*Directory) *Directory {
+	directory.subIFDs = children
+
+	return directory
+}
+
+type writer struct {
+	bytes.Buffer
+
+	order   binary.ByteOrder
+	bigTIFF bool
+}
+
+func (w *writer) align() {
+	if w.Len()%2 == 1 {
+		w.WriteByte(0)
+	}
+}
+
+func (w *writer) putOffset(value uint64) {
+	if w.bigTIFF {
+		binary.Write(w, w.order, value)
+	} else {
+		binary.Write(w, w.order, uint32(value))
+	}
+}
+
+func (w *writer) patchOffset(position int, value uint64) {
+	if w.bigTIFF {
+		w.order.PutUint64(w.Bytes()[position:], value)
+	} else {
+		w.order.PutUint32(w.Bytes()[position:], uint32(value))
+	}
+}
+
+// Bytes lays out all directories and returns the encoded file.
+func (builder *Builder) Bytes() []byte {
+	w := &writer{order: builder.order, bigTIFF: builder.bigTIFF}
+
+	if builder.order == binary.BigEndian {
+		w.WriteString("MM")
+	} else {
+		w.WriteString("II")
+	}
+
+	if builder.bigTIFF {
+		binary.Write(w, builder.order, uint16(0x2b))
+		binary.Write(w, builder.order, uint16(8))
+		binary.Write(w, builder.order, uint16(0))
+	} else {
+		binary.Write(w, builder.order, uint16(0x2a))
+	}
+
+	nextOffsetPosition := w.Len()
+	w.putOffset(0)
+
+	for _, directory := range builder.directories {
+		offset, nextPosition := directory.write(w)
+
+		w.patchOffset(nextOffsetPosition, offset)
+		nextOffsetPosition = nextPosition
+	}
+
+	return w.Bytes()
+}
+
+// write outputs the image data, any SubIFDs and then the directory itself, returning the offset of the directory and
+// the position of its next IFD offset field.
+func (directory *Directory) write(w *writer) (uint64, int) {
+	entries := make(map[uint16]*entry)
+	for tag, e := range directory.entries {
+		entries[tag] = e
+	}
+
+	offsetType, offsetSize := Long, 4
+	if w.bigTIFF {
+		offsetType, offsetSize = Long8, 8
+	}
+
+	if directory.chunks != nil {
+		chunkType := offsetType
+		if directory.chunkType != 0 {
+			chunkType = directory.chunkType
+		}
+
+		offsets := make([]uint64, len(directory.chunks))
+		counts := make([]uint64, len(directory.chunks))
+
+		for index, chunk := range directory.chunks {
+			w.align()
+			offsets[index] = uint64(w.Len())
+			counts[index] = uint64(len(chunk))
+			w.Write(chunk)
+		}
+
+		entries[directory.chunkTags[0]] = offsetEntry(directory.chunkTags[0], chunkType, offsets, w)
+		entries[directory.chunkTags[1]] = offsetEntry(directory.chunkTags[1], chunkType, counts, w)
+	}
+
+	for _, tag := range directory.removed {
+		if _, added := directory.entries[tag]; !added {
+			delete(entries, tag)
+		}
+	}
+
+	for tag, e := range entries {
+		if e.blobs == nil {
+			continue
+		}
+
+		offsets := make([]uint64, len(e.blobs))
+		for index, blob := range e.blobs {
+			w.align()
+			offsets[index] = uint64(w.Len())
+			w.Write(blob)
+		}
+
+		entries[tag] = offsetEntry(tag, offsetType, offsets, w)
+	}
+
+	if directory.subIFDs != nil {
+		offsets := make([]uint64, len(directory.subIFDs))
+
+		for index, child := range directory.subIFDs {
+			offsets[index], _ = child.write(w)
+		}
+
+		subIFDType := IFD
+		if w.bigTIFF {
+			subIFDType = IFD8
+		}
+		entries[tagSubIFDs] = offsetEntry(tagSubIFDs, subIFDType, offsets, w)
+	}
+
+	var tags []int
+	for tag := range entries {
+		tags = append(tags, int(tag))
+	}
+	sort.Ints(tags)
+
+	// Write out any values which don't fit in the entry
+	valueOffsets := make(map[uint16]uint64)
+	for _, tag := range tags {
+		e := entries[uint16(tag)]
+
+		if e.raw == nil && len(e.data) > offsetSize {
+			w.align()
+			valueOffsets[e.tag] = uint64(w.Len())
+			w.Write(e.data)
+		}
+	}
+
+	w.align()
+	ifdOffset := uint64(w.Len())
+
+	if w.bigTIFF {
+		binary.Write(w, w.order, uint64(len(tags)))
+	} else {
+		binary.Write(w, w.order, uint16(len(tags)))
+	}
+
+	for _, tag := range tags {
+		e := entries[uint16(tag)]
+
+		binary.Write(w, w.order, e.tag)
+		binary.Write(w, w.order, e.dataType)
+		w.putOffset(e.count)
+
+		field := make([]byte, offsetSize)
+		if e.raw != nil {
+			copy(field, e.raw)
+		} else if offset, ok := valueOffsets[e.tag]; ok {
+			if w.bigTIFF {
+				w.order.PutUint64(field, offset)
+			} else {
+				w.order.PutUint32(field, uint32(offset))
+			}
+		} else {
+			copy(field, e.data)
+		}
+		w.Write(field)
+	}
+
+	nextPosition := w.Len()
+	w.putOffset(0)
+
+	return ifdOffset, nextPosition
+}
+
+func offsetEntry(tag uint16, dataType uint16, values []uint64, w *writer) *entry {
+	var buf bytes.Buffer
+
+	switch dataType {
+	case Long8, IFD8:
+		binary.Write(&buf, w.order, values)
+	case Short:
+		for _, value := range values {
+			binary.Write(&buf, w.order, uint16(value))
+		}
+	default:
+		for _, value := range values {
+			binary.Write(&buf, w.order, uint32(value))
+		}
+	}
+
+	return &entry{tag: tag, dataType: dataType, count: uint64(len(values)), data: buf.Bytes()}
+}
@@ -2,8 +2,8 @@ package gobio
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
-	"log"
 )
 
 //type ImageFileHeader struct {
@@ -22,6 +22,7 @@ import (
 //	tiffFile   *File
 //	dataAccess DataAccess
 //}
+
 func readIFDOffset(reader io.Reader, endian binary.ByteOrder) (int64, error) {
 	var offset uint32
 	err := binary.Read(reader, endian, &offset)
@@ -32,28 +33,37 @@ func readIFDOffset(reader io.Reader, endian binary.ByteOrder) (int64, error) {
 	return int64(offset), nil
 }
 
-func readIFD(seeker io.ReadSeeker, endian binary.ByteOrder, offset int64) (*ImageFileDirectory, error) {
+func readIFD(tiffFile *File, seeker io.ReadSeeker, index int, offset int64) (*ImageFileDirectory, error) {
 	var ifd ImageFileDirectory
 	var err error
 
 	var numTags uint16
 	var nextOffset uint32
 
-	ifd.getStripOffsets = getStripOffsets
-	ifd.getTileOffsets = getTileOffsets
+	endian := tiffFile.header.Endian
+
+	ifd.tiffFile = tiffFile
+	ifd.index = index
 
-	seeker.Seek(offset, io.SeekStart)
+	_, err = seeker.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
 	err = binary.Read(seeker, endian, &numTags)
 	if err != nil {
 		return nil, err
 	}
 	ifd.NumTags = uint64(numTags)
 
-	err = processTags(&ifd, seeker, endian)
+	err = processTags(&ifd, seeker, endian, offset+2)
 	if err != nil {
 		return nil, err
 	}
 
+	_, err = seeker.Seek(offset+2+int64(numTags)*12, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
 	err = binary.Read(seeker, endian, &nextOffset)
 	if err != nil {
 		return nil, err
@@ -71,8 +81,8 @@ type tagData struct {
 	DataOffset uint32 /* The byte offset to the data items  */
 }
 
-//func (ifd *ImageFileDirectory) processTags() error {
-func processTags(ifd *ImageFileDirectory, seeker io.ReadSeeker, endian binary.ByteOrder) error {
+// processTags reads the tag entries of the IFD, which start at entriesOffset.
+func processTags(ifd *ImageFileDirectory, seeker io.ReadSeeker, endian binary.ByteOrder, entriesOffset int64) error {
 	var err error
 	var tags []tagData
 	tags = make([]tagData, ifd.NumTags)
@@ -82,273 +92,186 @@ func processTags(ifd *ImageFileDirectory, seeker io.ReadSeeker, endian binary.By
 		return err
 	}
 
-	for _, tag := range tags {
-		tagName := TagNameFromID(tag.TagID) //tagNameMap[tagIDMap[tag.TagID]]
-
-		if tagName == "" {
-			log.Printf("Unknown tag id %d\n", tag.TagID)
-		} else {
-			dataType := DataTypeFromID(tag.DataType)
-
-			//fmt.Println(tagName + ": " + dataTypeNameMap[dataType])
+	for index, tag := range tags {
+		entryOffset := entriesOffset + int64(index)*12
 
-			switch dataType {
-			case Byte, Undefined:
-				byteTag := processByteTag(seeker, endian, &tag)
-
-				ifd.PutTag(byteTag)
-			case ASCII:
-				asciiTag := processASCIITag(seeker, endian, &tag)
-
-				ifd.PutTag(asciiTag)
-			case Short:
-				shortTag := processShortTag(seeker, endian, &tag)
-
-				ifd.PutTag(shortTag)
-			case Long:
-				longTag := processLongTag(seeker, endian, &tag)
-
-				ifd.PutTag(longTag)
-			case Rational:
-				rationalTag := processRationalTag(seeker, endian, &tag)
-
-				ifd.PutTag(rationalTag)
-			default:
-				log.Printf("Unknown tag type %s\n", DataTypeNameFromID(tag.DataType))
-			}
+		err = processTag(ifd, tag.TagID, tag.DataType, entryOffset, func() (int64, []byte, error) {
+			return readTagData(seeker, endian, &tag)
+		})
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func processByteTag(seeker io.ReadSeeker, endian binary.ByteOrder, tagData *tagData) *ByteTag {
-	var tag ByteTag
+// readTagData returns the raw bytes of the values of a tag and the offset they were read from, or -1 if they were
+// stored in the entry. Values which fit in 4 bytes are stored in place of the offset, otherwise they are read from the
+// offset.
+func readTagData(seeker io.ReadSeeker, endian binary.ByteOrder, tagData *tagData) (int64, []byte, error) {
+	size := uint64(tagData.DataCount) * uint64(DataTypeFromID(tagData.DataType).Size())
 
-	tag.ID = TagFromID(tagData.TagID)
-	tag.DataType = DataTypeFromID(tagData.DataType)
+	if size <= 4 {
+		data := make([]byte, 4)
+		endian.PutUint32(data, tagData.DataOffset)
 
-	if tagData.DataCount <= 4 {
-		a := make([]byte, 4)
-		endian.PutUint32(a, tagData.DataOffset)
-
-		tag.Data = a[:tagData.DataCount]
-	} else {
-		tag.Data = make([]byte, tagData.DataCount)
-
-		// TODO: Do something with the error
-		startLocation, _ := seeker.Seek(0, io.SeekCurrent)
-		seeker.Seek(int64(tagData.DataOffset), io.SeekStart)
-		binary.Read(seeker, endian, &tag.Data)
-		// TODO: Do something with the error
-		seeker.Seek(startLocation, io.SeekStart)
+		return -1, data[:size], nil
 	}
 
-	return &tag
-}
-
-func processASCIITag(seeker io.ReadSeeker, endian binary.ByteOrder, tagData *tagData) *ASCIITag {
-	var tag ASCIITag
+	data, err := readTagDataAt(seeker, int64(tagData.DataOffset), size)
 
-	tag.ID = TagFromID(tagData.TagID)
-	tag.DataType = DataTypeFromID(tagData.DataType)
-
-	data := make([]byte, tagData.DataCount)
-
-	if tagData.DataCount <= 4 {
-		log.Printf("NOT IMPLEMENTED processASCIITag: %v\n", tagData)
-		//tag.data[0] = tagData.DataOffset
-	} else {
-		// TODO: Do something with the error
-		startLocation, _ := seeker.Seek(0, io.SeekCurrent)
-		seeker.Seek(int64(tagData.DataOffset), io.SeekStart)
-		binary.Read(seeker, endian, &data)
-		// TODO: Do something with the error
-		seeker.Seek(startLocation, io.SeekStart)
-
-		tag.Data = string(data)
-	}
-
-	return &tag
+	return int64(tagData.DataOffset), data, err
 }
 
-func processShortTag(seeker io.ReadSeeker, endian binary.ByteOrder, tagData *tagData) *ShortTag {
-	var tag ShortTag
-
-	tag.ID = TagFromID(tagData.TagID)
-	tag.DataType = DataTypeFromID(tagData.DataType)
-
-	tag.Data = make([]uint16, tagData.DataCount)
-
-	if tagData.DataCount > 2 {
-		// TODO: Do something with the error
-		startLocation, _ := seeker.Seek(0, io.SeekCurrent)
-		seeker.Seek(int64(tagData.DataOffset), io.SeekStart)
-		binary.Read(seeker, endian, &tag.Data)
-		// TODO: Do something with the error
-		seeker.Seek(startLocation, io.SeekStart)
-	} else {
-		if endian == binary.BigEndian {
-			tag.Data[0] = uint16(tagData.DataOffset >> 16)
-
-			if tagData.DataCount == 2 {
-				tag.Data[1] = uint16(tagData.DataOffset & 0xffff)
-			}
-		} else {
-			tag.Data[0] = uint16(tagData.DataOffset & 0xffff)
-
-			if tagData.DataCount == 2 {
-				tag.Data[1] = uint16(tagData.DataOffset >> 16)
-			}
-		}
+// processTag decodes a single tag entry, found at entryOffset, and adds it to the IFD. Tags with an unrecognised ID
+// (e.g. private vendor tags) are reported as warnings but kept, decoded according to their data type. Tags with an
+// unknown data type are reported and skipped, as are tags whose values can't be read unless parsing in strict mode.
+// readData is called to fetch the raw bytes of the values.
+func processTag(ifd *ImageFileDirectory, rawTagID uint16, rawDataType uint16, entryOffset int64, readData func() (int64, []byte, error)) error {
+	tagID := TagID(rawTagID)
 
-		//fmt.Printf("Processing tag %v -> %v (%v) (%v)\n", tag.TagID, tagData.DataOffset, tag.data[0])
+	if TagNameFromID(rawTagID) == "" {
+		ifd.warn(tagID, entryOffset, ErrUnknownTag)
 	}
 
-	return &tag
-}
-
-func processRationalTag(seeker io.ReadSeeker, endian binary.ByteOrder, tagData *tagData) *RationalTag {
-	var tag RationalTag
+	dataType := DataTypeFromID(rawDataType)
+	if dataType.Size() == 0 {
+		ifd.warn(tagID, entryOffset, fmt.Errorf("%w %d", ErrUnknownDataType, rawDataType))
+		return nil
+	}
 
-	tag.ID = TagFromID(tagData.TagID)
-	tag.DataType = DataTypeFromID(tagData.DataType)
+	dataOffset, data, err := readData()
+	if dataOffset < 0 {
+		dataOffset = entryOffset
+	}
+	if err != nil {
+		// Skip the tag if recovering
+		return ifd.violation(tagID, dataOffset, err)
+	}
 
-	tag.Data = make([]RationalNumber, tagData.DataCount)
+	decodedTag, err := decodeTag(tagID, dataType, ifd.tiffFile.header.Endian, data)
+	if err != nil {
+		return newParseError(ifd.index, tagID, dataOffset, err)
+	}
 
-	// TODO: Do something with the error
-	startLocation, _ := seeker.Seek(0, io.SeekCurrent)
-	seeker.Seek(int64(tagData.DataOffset), io.SeekStart)
-	binary.Read(seeker, endian, &tag.Data)
-	// TODO: Do something with the error
-	seeker.Seek(startLocation, io.SeekStart)
+	ifd.PutTag(decodedTag)
 
-	return &tag
+	return nil
 }
 
-func processLongTag(seeker io.ReadSeeker, endian binary.ByteOrder, tagData *tagData) *LongTag {
-	var tag LongTag
-
-	tag.ID = TagFromID(tagData.TagID)
-	tag.DataType = DataTypeFromID(tagData.DataType)
-
-	tag.Data = make([]uint32, tagData.DataCount)
-
-	if tagData.DataCount == 1 {
-		tag.Data[0] = tagData.DataOffset
-	} else {
-		// TODO: Do something with the error
-		startLocation, _ := seeker.Seek(0, io.SeekCurrent)
-		seeker.Seek(int64(tagData.DataOffset), io.SeekStart)
-		binary.Read(seeker, endian, &tag.Data)
-		// TODO: Do something with the error
-		seeker.Seek(startLocation, io.SeekStart)
+// readTagDataAt reads size bytes from offset, leaving the seeker at its current position.
+func readTagDataAt(seeker io.ReadSeeker, offset int64, size uint64) ([]byte, error) {
+	startLocation, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
 	}
 
-	return &tag
-}
+	// Make sure that a corrupt count can't cause a huge allocation
+	endLocation, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 || size > uint64(endLocation) || offset > endLocation-int64(size) {
+		return nil, fmt.Errorf("%w: %d bytes of tag data", ErrTruncated, size)
+	}
 
-func getStripOffsets(ifd *ImageFileDirectory) ([]int64, []int64, error) {
-	var offsets, counts []int64
+	data := make([]byte, size)
 
-	offsetsTag := ifd.Tags[StripOffsets]
-	byteCountsTag := ifd.Tags[StripByteCounts]
+	_, err = seeker.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.ReadFull(seeker, data)
+	if err != nil {
+		return nil, err
+	}
 
-	offsetsLongTag, ok := offsetsTag.(*LongTag)
-	if ok {
-		for index := range offsetsLongTag.Data {
-			offsets = append(offsets, int64(offsetsLongTag.Data[index]))
-		}
-	} else {
-		// Try ShortTag
-		offsetsShortTag, ok := offsetsTag.(*ShortTag)
-
-		if ok {
-			for index := range offsetsShortTag.Data {
-				offsets = append(offsets, int64(offsetsShortTag.Data[index]))
-			}
-		} else {
-			return nil, nil, &FormatError{msg: "Data stored as strip, but can't convert StripOffsets to LongTag or ShortTag"}
-		}
+	_, err = seeker.Seek(startLocation, io.SeekStart)
+	if err != nil {
+		return nil, err
 	}
 
-	bytesCountsLongTag, ok := byteCountsTag.(*LongTag)
-	if ok {
-		for index := range bytesCountsLongTag.Data {
-			counts = append(counts, int64(bytesCountsLongTag.Data[index]))
-		}
-	} else {
-		// Try ShortTag
-		bytesCountsShortTag, ok := byteCountsTag.(*ShortTag)
+	return data, nil
+}
 
-		if ok {
+// getStripOffsets returns the offsets and byte counts of the strips.
+func (ifd *ImageFileDirectory) getStripOffsets() ([]int64, []int64, error) {
+	offsets, err := sectionValues(StripOffsets, ifd.Tags[StripOffsets])
+	if err != nil {
+		return nil, nil, err
+	}
 
-			for index := range bytesCountsShortTag.Data {
-				counts = append(counts, int64(bytesCountsShortTag.Data[index]))
-			}
-		} else {
-			return nil, nil, &FormatError{msg: "Data stored as strips, but can't convert StripByteCounts to LongTag or ShortTag"}
-		}
+	counts, err := sectionValues(StripByteCounts, ifd.Tags[StripByteCounts])
+	if err != nil {
+		return nil, nil, err
 	}
 
 	return offsets, counts, nil
 }
 
-func getTileOffsets(ifd *ImageFileDirectory) ([]int64, []int64, error) {
-	var offsets, counts []int64
-
+// getTileOffsets returns the offsets and byte counts of the tiles. Some writers record these in StripOffsets and
+// StripByteCounts, which are used when the tile tags are missing unless in strict mode.
+func (ifd *ImageFileDirectory) getTileOffsets() ([]int64, []int64, error) {
 	offsetsTag := ifd.Tags[TileOffsets]
 	byteCountsTag := ifd.Tags[TileByteCounts]
 
 	if offsetsTag == nil {
-		log.Println("Data stored as tiles, but TileOffsets appear to be missing, trying to use StripOffsets")
+		err := ifd.violation(TileOffsets, -1, fmt.Errorf("%w: data stored as tiles, but TileOffsets appear to be missing, trying to use StripOffsets", ErrMissingTag))
+		if err != nil {
+			return nil, nil, err
+		}
 
 		offsetsTag = ifd.Tags[StripOffsets]
 	}
 	if byteCountsTag == nil {
-		log.Println("Data stored as tiles, but TileByteCounts appear to be missing, trying to use StripByteCounts")
+		err := ifd.violation(TileByteCounts, -1, fmt.Errorf("%w: data stored as tiles, but TileByteCounts appear to be missing, trying to use StripByteCounts", ErrMissingTag))
+		if err != nil {
+			return nil, nil, err
+		}
 
 		byteCountsTag = ifd.Tags[StripByteCounts]
 	}
 
-	tileOffsetsLongTag, ok := offsetsTag.(*LongTag)
-	if ok {
-		for index := range tileOffsetsLongTag.Data {
-			offsets = append(offsets, int64(tileOffsetsLongTag.Data[index]))
-		}
-	} else {
-		// Try ShortTag
-		tileOffsetsShortTag, ok := offsetsTag.(*ShortTag)
-
-		if ok {
-			for index := range tileOffsetsShortTag.Data {
-				offsets = append(offsets, int64(tileOffsetsShortTag.Data[index]))
-			}
-		} else {
-			return nil, nil, &FormatError{msg: "Data stored as tiles, but can't convert TileOffsets to LongTag or ShortTag"}
-		}
+	offsets, err := sectionValues(TileOffsets, offsetsTag)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	tileBytesCountsLongTag, ok := byteCountsTag.(*LongTag)
-	if ok {
-		for index := range tileBytesCountsLongTag.Data {
-			counts = append(counts, int64(tileBytesCountsLongTag.Data[index]))
-		}
-	} else {
-		// Try ShortTag
-		tileBytesCountsShortTag, ok := byteCountsTag.(*ShortTag)
+	counts, err := sectionValues(TileByteCounts, byteCountsTag)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		if ok {
+	return offsets, counts, nil
+}
 
-			for index := range tileBytesCountsShortTag.Data {
-				counts = append(counts, int64(tileBytesCountsShortTag.Data[index]))
-			}
-		} else {
-			return nil, nil, &FormatError{msg: "Data stored as tiles, but can't convert TileByteCounts to LongTag or ShortTag"}
+// sectionValues converts the values of an offsets or byte counts tag to int64. The tags are SHORT or LONG, or also
+// LONG8 in BigTIFF files. A missing tag or one of any other type violates the specification, and the image data
+// can't be located.
+func sectionValues(tagID TagID, tag Tag) ([]int64, error) {
+	var values []int64
+
+	switch tag := tag.(type) {
+	case nil:
+		return nil, fmt.Errorf("%w: %s", ErrMissingTag, tagID)
+	case *ShortTag:
+		for _, value := range tag.Data {
+			values = append(values, int64(value))
+		}
+	case *LongTag:
+		for _, value := range tag.Data {
+			values = append(values, int64(value))
 		}
+	case *Long8Tag:
+		for _, value := range tag.Data {
+			values = append(values, int64(value))
+		}
+	default:
+		return nil, fmt.Errorf("%w: can't convert %s to an unsigned integer", ErrInvalidTagValue, tagID)
 	}
 
-	return offsets, counts, nil
+	return values, nil
 }
 
 //type File struct {
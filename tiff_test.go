@@ -18,6 +18,10 @@ func TestLoad(t *testing.T) {
 	//filename := "C:\\Work\\AZ\\Stephanie\\S.Ling H&E 5_09_b.tif"
 	filename := "D:\\AZ\\Gemcitabine\\GEMTAB_Scan1.qptiff"
 
+	if _, err := os.Stat(filename); err != nil {
+		t.Skipf("test data not available: %v", err)
+	}
+
 	tiffFile, err := Open(filename)
 	if err != nil {
 		log.Fatal(err)
@@ -46,13 +50,13 @@ func TestLoad(t *testing.T) {
 	fmt.Println(pi.String())
 
 	section := ifd.GetSectionAt(0, 0)
-	data, err := section.GetRGBAData()
+	sectionImg, err := section.GetImage()
 	if err != nil {
 		panic(err)
 	}
 
 	img := image.NewRGBA(image.Rect(0, 0, int(section.Width), int(section.Height)))
-	img.Pix = data
+	draw.Draw(img, img.Bounds(), sectionImg, image.Point{0, 0}, draw.Src)
 
 	f, err := os.Create("section.png")
 	if err != nil {
@@ -78,21 +82,12 @@ func TestLoad(t *testing.T) {
 			section := ifd.GetSection(y*gridX + x)
 
 			fmt.Printf("Found section dimensions (%d, %d): %d x %d\n", x, y, int(section.Width), int(section.Height))
-			sectionImage := image.NewRGBA(image.Rect(0, 0, int(section.Width), int(section.Height)))
-
-			rgbaData, err := section.GetRGBAData()
+			sectionImage, err := section.GetImage()
 			if err != nil {
 				log.Println(err)
 				continue
 			}
 
-			if len(rgbaData) < int(section.Width)*int(section.Height)*4 {
-				fmt.Printf("[Skipping]==> %d\n", len(rgbaData))
-				continue
-			}
-
-			sectionImage.Pix = rgbaData
-
 			draw.Draw(img, image.Rect(curX, curY, curX+int(section.Width), curY+int(section.Height)), sectionImage, image.Point{0, 0}, draw.Src)
 			//data = append(data, rgbaData...)
 
@@ -101,8 +96,6 @@ func TestLoad(t *testing.T) {
 		curY += int(section.Height)
 	}
 
-	fmt.Printf("Loaded data with length %d\n", len(data))
-
 	//img.Pix = data
 
 	f, err = os.Create("full.png")